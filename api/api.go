@@ -0,0 +1,9 @@
+// Package api contains the OpenAPI document of the HTTP API.
+package api
+
+import _ "embed"
+
+// Spec is the OpenAPI document, requests are validated against it.
+//
+//go:embed swagger.yml
+var Spec []byte
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermartctl"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+)
+
+func main() {
+	// Get the same configuration as the server does - the command follows the flags
+	cfg, err := config.Get()
+	if err != nil {
+		log.Fatalf("failed to get configuration : %s", err.Error())
+	}
+
+	// Create and initialize the control application
+	application, err := ctl.New(cfg, os.Stdout)
+	if err != nil {
+		log.Fatalf("failed to initialize gophermartctl : %s", err.Error())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// Run the command
+	err = application.Run(ctx, flag.Args())
+
+	stop()
+	application.Close()
+
+	if err != nil {
+		log.Fatalf("failed to run command : %s", err.Error())
+	}
+}
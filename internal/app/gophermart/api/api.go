@@ -1,54 +1,87 @@
 package api
 
 import (
+    "bufio"
+    "bytes"
+    "encoding/json"
     "errors"
     "io"
-    "log/slog"
+    "mime"
     "net/http"
+    "strconv"
+    "strings"
+    "time"
 
+    "github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/audit"
     "github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
     "github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/promotion"
     "github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
     "github.com/RomanAgaltsev/ya_gophermart/internal/config"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/logger"
     "github.com/RomanAgaltsev/ya_gophermart/internal/model"
     "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
     orderpkg "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/order"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/timezone"
 
+    "github.com/go-chi/chi/v5"
     "github.com/go-chi/render"
 )
 
 const (
     contentTypeJSON = "application/json"
 
+    maxBatchBodySize = 1 << 20
+
     argError = "error"
 
     msgNewJWTToken       = "new JWT token"
     msgUserRegistration  = "user registration"
     msgUserLogin         = "user login"
+    msgPasswordChange    = "user password change"
+    msgTimezoneChange    = "user time zone change"
+    msgUserExport        = "user data export"
+    msgUserDeletion      = "user deletion"
     msgOrderNumberUpload = "order number upload"
+    msgOrderBatchUpload  = "order batch upload"
     msgOrderList         = "get orders list"
-    msgNewUserBalance    = "new user balance"
     msgUserBalance       = "user balance request"
+    msgUserTier          = "user tier request"
     msgWithdraw          = "withdraw request"
     msgUserWithdrawals   = "user withdrawals request"
+    msgTransfer          = "transfer request"
+    msgUserTransfers     = "user transfers request"
+    msgUserStatement     = "user statement request"
+    msgReversal          = "withdrawal reversal request"
+    msgPromotionRules    = "promotion rules request"
+    msgPromotionCreate   = "promotion rule creation"
+    msgPromotionUpdate   = "promotion rule update"
+    msgPromotionDelete   = "promotion rule deletion"
+    msgAuditLog          = "audit log request"
+    msgAuditVerify       = "audit log verification"
 )
 
 // Handler handles all HTTP requests.
 type Handler struct {
     cfg *config.Config
 
-    userService    user.Service
-    orderService   order.Service
-    balanceService balance.Service
+    userService      user.Service
+    orderService     order.Service
+    balanceService   balance.Service
+    promotionService promotion.Service
+    auditService     audit.Service
 }
 
 // NewHandler is a Handler constructor.
-func NewHandler(cfg *config.Config, userService user.Service, orderService order.Service, balanceService balance.Service) *Handler {
+func NewHandler(cfg *config.Config, userService user.Service, orderService order.Service, balanceService balance.Service, promotionService promotion.Service, auditService audit.Service) *Handler {
     return &Handler{
-        cfg:            cfg,
-        userService:    userService,
-        orderService:   orderService,
-        balanceService: balanceService,
+        cfg:              cfg,
+        userService:      userService,
+        orderService:     orderService,
+        balanceService:   balanceService,
+        promotionService: promotionService,
+        auditService:     auditService,
     }
 }
 
@@ -57,7 +90,7 @@ func (h *Handler) UserRegistrion(w http.ResponseWriter, r *http.Request) {
     // Get user from request
     var usr model.User
     if err := render.Bind(r, &usr); err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        problem.Write(w, r, problem.Validation(err))
         return
     }
 
@@ -65,87 +98,211 @@ func (h *Handler) UserRegistrion(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
 
     // Register user
-    err := h.userService.Register(ctx, &usr)
-    if err != nil && !errors.Is(err, user.ErrLoginIsAlreadyTaken) {
-        // There is an error, but not a conflict
-        slog.Info(msgUserRegistration, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+    if err := h.userService.Register(ctx, &usr); err != nil {
+        problem.Write(w, r, RegistrationProblem(ctx, err))
+        return
+    }
+
+    // Set a cookie with new JWT token
+    if p := SetSessionCookie(w, h.cfg, &usr); p != nil {
+        problem.Write(w, r, p)
         return
     }
 
-    if errors.Is(err, user.ErrLoginIsAlreadyTaken) {
-        // There is a conflict
-        slog.Info(msgUserRegistration, argError, err.Error())
-        _ = render.Render(w, r, ErrLoginIsAlreadyTaken)
+    w.WriteHeader(http.StatusOK)
+}
+
+// UserLogin handles user login request.
+func (h *Handler) UserLogin(w http.ResponseWriter, r *http.Request) {
+    // Get user from request
+    var usr model.User
+    if err := render.Bind(r, &usr); err != nil {
+        problem.Write(w, r, problem.Validation(err))
         return
     }
 
-    // Create a balance for the user
-    err = h.balanceService.Create(ctx, &usr)
+    // Get context from request
+    ctx := r.Context()
+
+    // Login user
+    if err := h.userService.Login(ctx, &usr); err != nil {
+        problem.Write(w, r, LoginProblem(ctx, err))
+        return
+    }
+
+    // Set a cookie with new JWT token
+    if p := SetSessionCookie(w, h.cfg, &usr); p != nil {
+        problem.Write(w, r, p)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+}
+
+// UserPasswordChange handles user password change request.
+// All other user sessions are revoked, the current one gets a new token.
+func (h *Handler) UserPasswordChange(w http.ResponseWriter, r *http.Request) {
+    // Get password change from request
+    var change model.PasswordChange
+    if err := render.Bind(r, &change); err != nil {
+        problem.Write(w, r, problem.Validation(err))
+        return
+    }
+
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
     if err != nil {
-        slog.Info(msgNewUserBalance, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
-    // Generate JWT token
-    ja := auth.NewAuth(h.cfg.SecretKey)
-    _, tokenString, err := auth.NewJWTToken(ja, usr.Login)
+    // Change password
+    err = h.userService.ChangePassword(ctx, usr, &change)
+    if errors.Is(err, user.ErrWrongLoginPassword) {
+        logger.FromContext(r.Context()).Info(msgPasswordChange, argError, err.Error())
+        problem.Write(w, r, ErrWrongLoginPassword)
+        return
+    }
     if err != nil {
-        // Something has gone wrong
-        slog.Info(msgNewJWTToken, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+        problem.Write(w, r, problem.Internal(msgPasswordChange, err))
         return
     }
 
-    // Set a cookie with generated JWT token
-    http.SetCookie(w, auth.NewCookieWithDefaults(tokenString))
+    // Set a cookie with JWT token of the new session
+    if p := SetSessionCookie(w, h.cfg, usr); p != nil {
+        problem.Write(w, r, p)
+        return
+    }
 
     w.WriteHeader(http.StatusOK)
 }
 
-// UserLogin handles user login request.
-func (h *Handler) UserLogin(w http.ResponseWriter, r *http.Request) {
-    // Get user from request
-    var usr model.User
-    if err := render.Bind(r, &usr); err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+// UserTimezoneChange handles user time zone preference change request.
+// Times are rendered in the chosen time zone, unless a request asks for another one.
+func (h *Handler) UserTimezoneChange(w http.ResponseWriter, r *http.Request) {
+    // Get time zone preference from request
+    var preference model.TimezonePreference
+    if err := render.Bind(r, &preference); err != nil {
+        problem.Write(w, r, problem.Validation(err))
         return
     }
 
     // Get context from request
     ctx := r.Context()
 
-    // Login user
-    err := h.userService.Login(ctx, &usr)
-    if err != nil && !errors.Is(err, user.ErrWrongLoginPassword) {
-        // There is an error, but not with the login/password pair
-        slog.Info(msgUserLogin, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+    if err != nil {
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
-    if errors.Is(err, user.ErrWrongLoginPassword) {
-        // There is a problem with login/password
-        slog.Info(msgUserLogin, argError, err.Error())
-        _ = render.Render(w, r, ErrWrongLoginPassword)
+    // Set time zone with user service
+    err = h.userService.SetTimezone(ctx, usr, preference.Timezone)
+    if errors.Is(err, user.ErrSessionRevoked) {
+        problem.Write(w, r, problem.ErrUnauthorized)
+        return
+    }
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgTimezoneChange, err))
         return
     }
 
-    // Generate JWT token
-    ja := auth.NewAuth(h.cfg.SecretKey)
-    _, tokenString, _ := auth.NewJWTToken(ja, usr.Login)
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusOK)
+
+    // Render the preference to response
+    if err := render.Render(w, r, &preference); err != nil {
+        logger.FromContext(r.Context()).Info(msgTimezoneChange, argError, err.Error())
+    }
+}
+
+// UserDataExport handles user data export request.
+// Users are offered to export the data before the account deletion.
+func (h *Handler) UserDataExport(w http.ResponseWriter, r *http.Request) {
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
     if err != nil {
-        // Something has gone wrong
-        slog.Info(msgNewJWTToken, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
-    // Set a cookie with generated JWT token
-    http.SetCookie(w, auth.NewCookieWithDefaults(tokenString))
+    export := &model.UserExport{
+        Login:      usr.Login,
+        ExportedAt: time.Now().UTC(),
+    }
 
+    // Collect user data with the services
+    export.Balance, err = h.balanceService.Get(ctx, usr)
+    if err == nil {
+        export.Orders, err = h.orderService.UserOrders(ctx, usr)
+    }
+    if err == nil {
+        export.Withdrawals, err = h.balanceService.Withdrawals(ctx, usr)
+    }
+    if err == nil {
+        export.Transfers, err = h.balanceService.Transfers(ctx, usr)
+    }
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgUserExport, err))
+        return
+    }
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
     w.WriteHeader(http.StatusOK)
+
+    // Render user data to response
+    if err := render.Render(w, r, export); err != nil {
+        logger.FromContext(r.Context()).Info(msgUserExport, argError, err.Error())
+    }
+}
+
+// UserDelete handles user account deletion request.
+// User data is anonymized or deleted according to the retention policy.
+func (h *Handler) UserDelete(w http.ResponseWriter, r *http.Request) {
+    // Get account deletion from request
+    var deletion model.AccountDeletion
+    if err := render.Bind(r, &deletion); err != nil {
+        problem.Write(w, r, problem.Validation(err))
+        return
+    }
+
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+    if err != nil {
+        problem.Write(w, r, problem.ErrUnauthorized)
+        return
+    }
+
+    // Delete user
+    err = h.userService.Delete(ctx, usr, deletion.Password)
+    if errors.Is(err, user.ErrWrongLoginPassword) {
+        logger.FromContext(r.Context()).Info(msgUserDeletion, argError, err.Error())
+        problem.Write(w, r, ErrWrongLoginPassword)
+        return
+    }
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgUserDeletion, err))
+        return
+    }
+
+    // Remove the cookie with JWT token
+    cookie := auth.NewCookie("", h.cfg.TLSEnabled())
+    cookie.MaxAge = -1
+    http.SetCookie(w, cookie)
+
+    w.WriteHeader(http.StatusNoContent)
 }
 
 // OrderNumberUpload handles order number upload request.
@@ -158,13 +315,13 @@ func (h *Handler) OrderNumberUpload(w http.ResponseWriter, r *http.Request) {
 
     // Check if the order number is empty
     if orderNumber == "" {
-        _ = render.Render(w, r, ErrBadRequest)
+        problem.Write(w, r, ErrBadRequest)
         return
     }
 
     // Check if the order number is valid with Luhn algorithm
     if !orderpkg.IsNumberValid(orderNumber) {
-        _ = render.Render(w, r, ErrInvalidOrderNumber)
+        problem.Write(w, r, ErrInvalidOrderNumber)
         return
     }
 
@@ -174,7 +331,7 @@ func (h *Handler) OrderNumberUpload(w http.ResponseWriter, r *http.Request) {
     // Get user from request
     usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
     if err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
@@ -186,28 +343,98 @@ func (h *Handler) OrderNumberUpload(w http.ResponseWriter, r *http.Request) {
 
     // Create order with order service
     err = h.orderService.Create(ctx, &ordr)
-    if err != nil && !errors.Is(err, order.ErrOrderUploadedByThisLogin) && !errors.Is(err, order.ErrOrderUploadedByAnotherLogin) {
-        // There is an error, but not a conflict
-        slog.Info(msgOrderNumberUpload, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+    if errors.Is(err, order.ErrOrderUploadedByThisLogin) {
+        // The order has already been uploaded by the user
+        logger.FromContext(r.Context()).Info(msgOrderNumberUpload, argError, err.Error())
+        w.WriteHeader(http.StatusOK)
         return
     }
 
-    if errors.Is(err, order.ErrOrderUploadedByThisLogin) {
-        // There is a conflict
-        slog.Info(msgOrderNumberUpload, argError, err.Error())
-        _ = render.Render(w, r, ErrOrderUploadedByThisLogin)
+    if err != nil {
+        problem.Write(w, r, OrderUploadProblem(ctx, err))
         return
     }
 
-    if errors.Is(err, order.ErrOrderUploadedByAnotherLogin) {
-        // There is a conflict
-        slog.Info(msgOrderNumberUpload, argError, err.Error())
-        _ = render.Render(w, r, ErrOrderUploadedByAnotherLogin)
+    w.WriteHeader(http.StatusAccepted)
+}
+
+// OrderBatchUpload handles order numbers batch upload request.
+// Numbers are accepted as a JSON array of strings or as a newline-delimited text.
+func (h *Handler) OrderBatchUpload(w http.ResponseWriter, r *http.Request) {
+    // Read order numbers from request body
+    rBody, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+    defer func() { _ = r.Body.Close() }()
+    if err != nil {
+        problem.Write(w, r, ErrBatchTooLarge)
         return
     }
 
-    w.WriteHeader(http.StatusAccepted)
+    orderNumbers, err := parseOrderNumbers(r.Header.Get("Content-Type"), rBody)
+    if err != nil {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+    if err != nil {
+        problem.Write(w, r, problem.ErrUnauthorized)
+        return
+    }
+
+    // Create orders with order service
+    results, err := h.orderService.CreateBatch(ctx, usr, orderNumbers)
+    if errors.Is(err, order.ErrEmptyBatch) {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    if errors.Is(err, order.ErrBatchTooLarge) {
+        problem.Write(w, r, ErrBatchTooLarge)
+        return
+    }
+
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgOrderBatchUpload, err))
+        return
+    }
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusOK)
+
+    // Render upload results to response
+    if err := render.Render(w, r, results); err != nil {
+        logger.FromContext(r.Context()).Info(msgOrderBatchUpload, argError, err.Error())
+    }
+}
+
+// parseOrderNumbers parses order numbers from JSON array or newline-delimited text.
+func parseOrderNumbers(contentType string, body []byte) ([]string, error) {
+    mediaType, _, _ := mime.ParseMediaType(contentType)
+
+    if mediaType == contentTypeJSON {
+        var orderNumbers []string
+        if err := json.Unmarshal(body, &orderNumbers); err != nil {
+            return nil, err
+        }
+        return orderNumbers, nil
+    }
+
+    var orderNumbers []string
+
+    scanner := bufio.NewScanner(bytes.NewReader(body))
+    for scanner.Scan() {
+        // Skip empty lines
+        if orderNumber := strings.TrimSpace(scanner.Text()); orderNumber != "" {
+            orderNumbers = append(orderNumbers, orderNumber)
+        }
+    }
+
+    return orderNumbers, scanner.Err()
 }
 
 // OrderListRequest handles order list request.
@@ -218,21 +445,20 @@ func (h *Handler) OrderListRequest(w http.ResponseWriter, r *http.Request) {
     // Get user from request
     usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
     if err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
     // Get a list of user orders with order service
     orders, err := h.orderService.UserOrders(ctx, usr)
     if err != nil {
-        slog.Info(msgOrderList, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+        problem.Write(w, r, OrderListProblem(err))
         return
     }
 
     // Check if there is something to return
     if len(orders) == 0 {
-        _ = render.Render(w, r, ErrNoOrders)
+        w.WriteHeader(http.StatusNoContent)
         return
     }
 
@@ -242,8 +468,7 @@ func (h *Handler) OrderListRequest(w http.ResponseWriter, r *http.Request) {
 
     // Render the list of orders to response
     if err := render.Render(w, r, orders); err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
-        return
+        logger.FromContext(r.Context()).Info(msgOrderList, argError, err.Error())
     }
 }
 
@@ -255,15 +480,14 @@ func (h *Handler) UserBalanceRequest(w http.ResponseWriter, r *http.Request) {
     // Get user from request
     usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
     if err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
     // Get user balance with balance service
     userBalance, err := h.balanceService.Get(ctx, usr)
     if err != nil {
-        slog.Info(msgUserBalance, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+        problem.Write(w, r, BalanceProblem(err))
         return
     }
 
@@ -273,9 +497,37 @@ func (h *Handler) UserBalanceRequest(w http.ResponseWriter, r *http.Request) {
 
     // Render user balance to response
     if err := render.Render(w, r, userBalance); err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        logger.FromContext(r.Context()).Info(msgUserBalance, argError, err.Error())
+    }
+}
+
+// UserTierRequest handles user loyalty tier request.
+func (h *Handler) UserTierRequest(w http.ResponseWriter, r *http.Request) {
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+    if err != nil {
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
+
+    // Get user tier with balance service
+    tierStatus, err := h.balanceService.Tier(ctx, usr)
+    if err != nil {
+        problem.Write(w, r, TierProblem(err))
+        return
+    }
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusOK)
+
+    // Render user tier to response
+    if err := render.Render(w, r, tierStatus); err != nil {
+        logger.FromContext(r.Context()).Info(msgUserTier, argError, err.Error())
+    }
 }
 
 // WithdrawRequest handles withdraw from user balance request.
@@ -283,13 +535,13 @@ func (h *Handler) WithdrawRequest(w http.ResponseWriter, r *http.Request) {
     // Get withdraw from request
     var withdrawal model.Withdrawal
     if err := render.Bind(r, &withdrawal); err != nil {
-        _ = render.Render(w, r, ErrBadRequest)
+        problem.Write(w, r, problem.Validation(err))
         return
     }
 
     // Check if order number is valid with Luhn algorithm
     if !orderpkg.IsNumberValid(withdrawal.OrderNumber) {
-        _ = render.Render(w, r, ErrInvalidOrderNumber)
+        problem.Write(w, r, ErrInvalidOrderNumber)
         return
     }
 
@@ -299,23 +551,14 @@ func (h *Handler) WithdrawRequest(w http.ResponseWriter, r *http.Request) {
     // Get user from request
     usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
     if err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
     // Register withdraw from user balance with balance service
     err = h.balanceService.Withdraw(ctx, usr, withdrawal.OrderNumber, withdrawal.Sum)
-    if err != nil && !errors.Is(err, balance.ErrNotEnoughBalance) {
-        // There is an error, but not with balance
-        slog.Info(msgWithdraw, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
-        return
-    }
-
-    if errors.Is(err, balance.ErrNotEnoughBalance) {
-        // There is a problem with balance - not enough to withdraw the sum
-        slog.Info(msgWithdraw, argError, err.Error())
-        _ = render.Render(w, r, ErrNotEnoughBalance)
+    if err != nil {
+        problem.Write(w, r, WithdrawProblem(ctx, err))
         return
     }
 
@@ -330,22 +573,20 @@ func (h *Handler) WithdrawalsInformationRequest(w http.ResponseWriter, r *http.R
     // Get user from request
     usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
     if err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        problem.Write(w, r, problem.ErrUnauthorized)
         return
     }
 
     // Get a list of user withdrawals
     withdrawals, err := h.balanceService.Withdrawals(ctx, usr)
     if err != nil {
-        // There is an error, but not with withdrawals
-        slog.Info(msgUserWithdrawals, argError, err.Error())
-        _ = render.Render(w, r, ServerErrorRenderer(err))
+        problem.Write(w, r, WithdrawalsProblem(err))
         return
     }
 
     // Check if there is something to return
     if len(withdrawals) == 0 {
-        _ = render.Render(w, r, ErrNoWithdrawals)
+        w.WriteHeader(http.StatusNoContent)
         return
     }
 
@@ -355,7 +596,350 @@ func (h *Handler) WithdrawalsInformationRequest(w http.ResponseWriter, r *http.R
 
     // Render the list of user withdrawals to the response
     if err := render.Render(w, r, withdrawals); err != nil {
-        _ = render.Render(w, r, ErrorRenderer(err))
+        logger.FromContext(r.Context()).Info(msgUserWithdrawals, argError, err.Error())
+    }
+}
+
+// TransferRequest handles transfer of points to another user request.
+func (h *Handler) TransferRequest(w http.ResponseWriter, r *http.Request) {
+    // Get transfer from request
+    var transfer model.Transfer
+    if err := render.Bind(r, &transfer); err != nil {
+        problem.Write(w, r, problem.Validation(err))
         return
     }
+
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+    if err != nil {
+        problem.Write(w, r, problem.ErrUnauthorized)
+        return
+    }
+
+    // Transfer points with balance service
+    created, err := h.balanceService.Transfer(ctx, usr, transfer.Recipient, transfer.Sum)
+    if err != nil {
+        problem.Write(w, r, TransferProblem(err))
+        return
+    }
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusOK)
+
+    // Render the transfer to response
+    if err := render.Render(w, r, created); err != nil {
+        logger.FromContext(r.Context()).Info(msgTransfer, argError, err.Error())
+    }
+}
+
+// TransfersInformationRequest handles list of user transfers request.
+func (h *Handler) TransfersInformationRequest(w http.ResponseWriter, r *http.Request) {
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+    if err != nil {
+        problem.Write(w, r, problem.ErrUnauthorized)
+        return
+    }
+
+    // Get a list of user transfers
+    transfers, err := h.balanceService.Transfers(ctx, usr)
+    if err != nil {
+        problem.Write(w, r, TransfersProblem(err))
+        return
+    }
+
+    // Check if there is something to return
+    if len(transfers) == 0 {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusOK)
+
+    // Render the list of user transfers to the response
+    if err := render.Render(w, r, transfers); err != nil {
+        logger.FromContext(r.Context()).Info(msgUserTransfers, argError, err.Error())
+    }
+}
+
+// StatementRequest handles user account statement request.
+// The statement is streamed to response in CSV or JSON Lines format, without loading it in memory.
+func (h *Handler) StatementRequest(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+
+    // Times of the statement are in the requested time zone
+    loc := timezone.FromContext(r.Context())
+
+    // Get the statement period from request
+    from, to, err := parseStatementPeriod(query.Get("from"), query.Get("to"), time.Now(), loc)
+    if err != nil {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    // Get the statement writer of the requested format
+    sw, err := newStatementWriter(w, query.Get("format"), loc)
+    if err != nil {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    // Get context from request
+    ctx := r.Context()
+
+    // Get user from request
+    usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+    if err != nil {
+        problem.Write(w, r, problem.ErrUnauthorized)
+        return
+    }
+
+    // Write statement entries with balance service
+    err = h.balanceService.Statement(ctx, usr, from, to, sw.Write)
+    if err != nil && !sw.started {
+        // Nothing has been written yet, so the error can be returned
+        problem.Write(w, r, problem.Internal(msgUserStatement, err))
+        return
+    }
+
+    if err != nil {
+        // The response has been started, it can only be cut off
+        logger.FromContext(r.Context()).Info(msgUserStatement, argError, err.Error())
+        panic(http.ErrAbortHandler)
+    }
+
+    if err := sw.Close(); err != nil {
+        logger.FromContext(r.Context()).Info(msgUserStatement, argError, err.Error())
+    }
+}
+
+// WithdrawalReversal handles partner request to reverse the withdrawal made for the order.
+func (h *Handler) WithdrawalReversal(w http.ResponseWriter, r *http.Request) {
+    // Get order number from URL
+    orderNumber := chi.URLParam(r, "number")
+    if !orderpkg.IsNumberValid(orderNumber) {
+        problem.Write(w, r, ErrInvalidOrderNumber)
+        return
+    }
+
+    // Get reversal from request
+    var reversal model.Reversal
+    if err := render.Bind(r, &reversal); err != nil {
+        problem.Write(w, r, problem.Validation(err))
+        return
+    }
+
+    // Reverse the withdrawal with balance service
+    withdrawal, err := h.balanceService.ReverseWithdrawal(r.Context(), orderNumber, reversal.Reason)
+    if errors.Is(err, balance.ErrWithdrawalNotFound) {
+        problem.Write(w, r, ErrWithdrawalNotFound)
+        return
+    }
+
+    if errors.Is(err, balance.ErrWithdrawalAlreadyReversed) {
+        problem.Write(w, r, ErrWithdrawalAlreadyReversed)
+        return
+    }
+
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgReversal, err))
+        return
+    }
+
+    logger.FromContext(r.Context()).Info(msgReversal, "order", orderNumber, "reason", reversal.Reason)
+
+    // Render the reversed withdrawal to response
+    if err := render.Render(w, r, withdrawal); err != nil {
+        logger.FromContext(r.Context()).Info(msgReversal, argError, err.Error())
+    }
+}
+
+// PromotionRulesRequest handles the request of all promotion rules.
+func (h *Handler) PromotionRulesRequest(w http.ResponseWriter, r *http.Request) {
+    // Get a list of promotion rules
+    rules, err := h.promotionService.Rules(r.Context())
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgPromotionRules, err))
+        return
+    }
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusOK)
+
+    // Render the list of promotion rules to the response
+    if err := render.Render(w, r, rules); err != nil {
+        logger.FromContext(r.Context()).Info(msgPromotionRules, argError, err.Error())
+    }
+}
+
+// PromotionRuleCreate handles promotion rule creation request.
+func (h *Handler) PromotionRuleCreate(w http.ResponseWriter, r *http.Request) {
+    // Get rule from request
+    var rule model.PromotionRule
+    if err := render.Bind(r, &rule); err != nil {
+        problem.Write(w, r, problem.Validation(err))
+        return
+    }
+
+    // Create the rule with promotion service
+    created, err := h.promotionService.CreateRule(r.Context(), &rule)
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgPromotionCreate, err))
+        return
+    }
+
+    logger.FromContext(r.Context()).Info(msgPromotionCreate, "id", created.ID, "name", created.Name)
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusCreated)
+
+    // Render the created rule to the response
+    if err := render.Render(w, r, created); err != nil {
+        logger.FromContext(r.Context()).Info(msgPromotionCreate, argError, err.Error())
+    }
+}
+
+// PromotionRuleUpdate handles promotion rule update request.
+func (h *Handler) PromotionRuleUpdate(w http.ResponseWriter, r *http.Request) {
+    // Get rule ID from URL
+    id, err := promotionRuleID(r)
+    if err != nil {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    // Get rule from request
+    var rule model.PromotionRule
+    if err := render.Bind(r, &rule); err != nil {
+        problem.Write(w, r, problem.Validation(err))
+        return
+    }
+    rule.ID = id
+
+    // Update the rule with promotion service
+    updated, err := h.promotionService.UpdateRule(r.Context(), &rule)
+    if errors.Is(err, promotion.ErrRuleNotFound) {
+        problem.Write(w, r, ErrRuleNotFound)
+        return
+    }
+
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgPromotionUpdate, err))
+        return
+    }
+
+    logger.FromContext(r.Context()).Info(msgPromotionUpdate, "id", updated.ID, "name", updated.Name)
+
+    // Render the updated rule to the response
+    if err := render.Render(w, r, updated); err != nil {
+        logger.FromContext(r.Context()).Info(msgPromotionUpdate, argError, err.Error())
+    }
+}
+
+// PromotionRuleDelete handles promotion rule deletion request.
+func (h *Handler) PromotionRuleDelete(w http.ResponseWriter, r *http.Request) {
+    // Get rule ID from URL
+    id, err := promotionRuleID(r)
+    if err != nil {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    // Delete the rule with promotion service
+    err = h.promotionService.DeleteRule(r.Context(), id)
+    if errors.Is(err, promotion.ErrRuleNotFound) {
+        problem.Write(w, r, ErrRuleNotFound)
+        return
+    }
+
+    if errors.Is(err, promotion.ErrRuleInUse) {
+        problem.Write(w, r, ErrRuleInUse)
+        return
+    }
+
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgPromotionDelete, err))
+        return
+    }
+
+    logger.FromContext(r.Context()).Info(msgPromotionDelete, "id", id)
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// promotionRuleID returns promotion rule ID from URL.
+func promotionRuleID(r *http.Request) (int32, error) {
+    id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 32)
+    if err != nil {
+        return 0, err
+    }
+
+    return int32(id), nil
+}
+
+// AuditLogRequest handles the request of audit log entries.
+func (h *Handler) AuditLogRequest(w http.ResponseWriter, r *http.Request) {
+    // Get filter from request
+    filter, err := parseAuditFilter(r.URL.Query(), timezone.FromContext(r.Context()))
+    if err != nil {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    // Get audit log entries
+    entries, err := h.auditService.Entries(r.Context(), filter)
+    if errors.Is(err, audit.ErrWrongLimit) {
+        problem.Write(w, r, problem.BadRequest(err))
+        return
+    }
+
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgAuditLog, err))
+        return
+    }
+
+    // Check if there is something to return
+    if len(entries) == 0 {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    // Set header
+    w.Header().Set("Content-type", contentTypeJSON)
+    w.WriteHeader(http.StatusOK)
+
+    // Render the entries to the response
+    if err := render.Render(w, r, entries); err != nil {
+        logger.FromContext(r.Context()).Info(msgAuditLog, argError, err.Error())
+    }
+}
+
+// AuditVerifyRequest handles the request of audit log hash chain verification.
+func (h *Handler) AuditVerifyRequest(w http.ResponseWriter, r *http.Request) {
+    // Verify the whole audit log
+    verification, err := h.auditService.Verify(r.Context())
+    if err != nil {
+        problem.Write(w, r, problem.Internal(msgAuditVerify, err))
+        return
+    }
+
+    if !verification.Valid {
+        logger.FromContext(r.Context()).Warn(msgAuditVerify, "broken_id", verification.BrokenID)
+    }
+
+    // Render the verification result to the response
+    if err := render.Render(w, r, verification); err != nil {
+        logger.FromContext(r.Context()).Info(msgAuditVerify, argError, err.Error())
+    }
 }
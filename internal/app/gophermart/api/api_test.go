@@ -5,21 +5,33 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"regexp"
 	"time"
 
+	openapispec "github.com/RomanAgaltsev/ya_gophermart/api"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/api"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/audit"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/promotion"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	auditMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/audit"
 	balanceMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/balance"
 	orderMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/order"
+	promotionMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/promotion"
 	userMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/user"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	auditpkg "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/openapi"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/timezone"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -53,8 +65,19 @@ var _ = Describe("Handler", func() {
 		balanceCtrl       *gomock.Controller
 		balanceRepository *balanceMocks.MockRepository
 
+		promotionService    promotion.Service
+		promotionCtrl       *gomock.Controller
+		promotionRepository *promotionMocks.MockRepository
+
+		auditService    audit.Service
+		auditCtrl       *gomock.Controller
+		auditRepository *auditMocks.MockRepository
+
 		handler *api.Handler
 
+		// Handlers are validated against the OpenAPI document, responses too
+		validator *openapi.Validator
+
 		endpoint string
 
 		usr      *model.User
@@ -92,6 +115,10 @@ var _ = Describe("Handler", func() {
 		userRepository = userMocks.NewMockRepository(userCtrl)
 		Expect(userRepository).ShouldNot(BeNil())
 
+		// Units of work run the function with the same mocked repository
+		userRepository.EXPECT().WithinTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, f func(ctx context.Context) error) error { return f(ctx) }).AnyTimes()
+
 		userService, err = user.NewService(userRepository, cfg)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(userService).ShouldNot(BeNil())
@@ -114,15 +141,44 @@ var _ = Describe("Handler", func() {
 		balanceRepository = balanceMocks.NewMockRepository(balanceCtrl)
 		Expect(balanceRepository).ShouldNot(BeNil())
 
-		balanceService, err = balance.NewService(context.Background(), balanceRepository, cfg, false)
+		balanceService, err = balance.NewService(balanceRepository, cfg)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(balanceService).ShouldNot(BeNil())
 
+		// Promotion service and repository
+		promotionCtrl = gomock.NewController(GinkgoT())
+		Expect(promotionCtrl).ShouldNot(BeNil())
+
+		promotionRepository = promotionMocks.NewMockRepository(promotionCtrl)
+		Expect(promotionRepository).ShouldNot(BeNil())
+
+		promotionService, err = promotion.NewService(promotionRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(promotionService).ShouldNot(BeNil())
+
+		// Audit service and repository
+		auditCtrl = gomock.NewController(GinkgoT())
+		Expect(auditCtrl).ShouldNot(BeNil())
+
+		auditRepository = auditMocks.NewMockRepository(auditCtrl)
+		Expect(auditRepository).ShouldNot(BeNil())
+
+		auditService, err = audit.NewService(auditRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auditService).ShouldNot(BeNil())
+
 		// Handler
-		handler = api.NewHandler(cfg, userService, orderService, balanceService)
+		handler = api.NewHandler(cfg, userService, orderService, balanceService, promotionService, auditService)
 		Expect(handler).ShouldNot(BeNil())
+
+		validator, err = openapi.NewValidator(openapispec.Spec, true)
+		Expect(err).NotTo(HaveOccurred())
 	})
 
+	validated := func(h http.HandlerFunc) http.HandlerFunc {
+		return validator.Middleware(h).ServeHTTP
+	}
+
 	AfterEach(func() {
 		server.Close()
 	})
@@ -130,7 +186,7 @@ var _ = Describe("Handler", func() {
 	Context("Receiving request at the /api/user/register endpoint", func() {
 		BeforeEach(func() {
 			endpoint = "/api/user/register"
-			server.AppendHandlers(handler.UserRegistrion)
+			server.AppendHandlers(validated(handler.UserRegistrion))
 		})
 
 		When("the method is POST, content type is right and payload is right", func() {
@@ -144,7 +200,7 @@ var _ = Describe("Handler", func() {
 				Expect(err).ShouldNot(HaveOccurred())
 
 				userRepository.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(nil).Times(1)
-				balanceRepository.EXPECT().CreateBalance(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				userRepository.EXPECT().CreateBalance(gomock.Any(), gomock.Any()).Return(nil).Times(1)
 			})
 
 			It("returns status 'OK' (200) and a cookie", func() {
@@ -158,6 +214,32 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		When("the balance of the new user cannot be created", func() {
+			BeforeEach(func() {
+				usr = &model.User{
+					Login:    "user",
+					Password: "password",
+				}
+
+				usrBytes, err = json.Marshal(usr)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				// The user and the balance are created in one unit of work, which fails as a whole
+				userRepository.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				userRepository.EXPECT().CreateBalance(gomock.Any(), gomock.Any()).Return(errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500) and no cookie", func() {
+				resp, err := http.Post(server.URL()+endpoint, ContentTypeJSON, bytes.NewReader(usrBytes))
+
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+
+				cookie := resp.Header.Get("Set-Cookie")
+				Expect(cookie).To(BeEmpty())
+			})
+		})
+
 		When("the method is POST, content type is right but payload is wrong", func() {
 			BeforeEach(func() {
 				usr = &model.User{
@@ -169,11 +251,18 @@ var _ = Describe("Handler", func() {
 				Expect(err).ShouldNot(HaveOccurred())
 			})
 
-			It("returns status 'Bad request' (400) and no cookie", func() {
+			It("returns status 'Bad request' (400) with the invalid field and no cookie", func() {
 				resp, err := http.Post(server.URL()+endpoint, ContentTypeJSON, bytes.NewReader(usrBytes))
 
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+				Expect(resp.Header.Get("Content-Type")).Should(Equal(problem.ContentType))
+
+				var details problem.Problem
+				err = json.NewDecoder(resp.Body).Decode(&details)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(details.Code).To(Equal(problem.CodeValidationFailed))
+				Expect(details.Errors).To(ConsistOf(problem.FieldError{Field: "password", Message: "is a required field"}))
 
 				cookie := resp.Header.Get("Set-Cookie")
 				Expect(cookie).To(BeEmpty())
@@ -251,7 +340,7 @@ var _ = Describe("Handler", func() {
 	Context("Receiving request at the /api/user/login endpoint", func() {
 		BeforeEach(func() {
 			endpoint = "/api/user/login"
-			server.AppendHandlers(handler.UserLogin)
+			server.AppendHandlers(validated(handler.UserLogin))
 		})
 
 		When("the method is POST, content type is right and payload is right", func() {
@@ -274,6 +363,7 @@ var _ = Describe("Handler", func() {
 				Expect(err).ShouldNot(HaveOccurred())
 
 				userRepository.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(expectUsr, nil).Times(1)
+				userRepository.EXPECT().AppendAudit(gomock.Any(), "user", model.AuditUserLogin).Return(nil).Times(1)
 			})
 
 			It("returns status 'OK' (200) and a cookie", func() {
@@ -287,6 +377,34 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		When("the login cannot be written to the audit log", func() {
+			BeforeEach(func() {
+				usr = &model.User{
+					Login:    "user",
+					Password: "password",
+				}
+
+				hash, err := auth.HashPassword("password")
+				Expect(err).ShouldNot(HaveOccurred())
+
+				usrBytes, err = json.Marshal(usr)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				userRepository.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(&model.User{Login: "user", Password: hash}, nil).Times(1)
+				userRepository.EXPECT().AppendAudit(gomock.Any(), "user", model.AuditUserLogin).Return(errors.New("audit log is unavailable")).Times(1)
+			})
+
+			It("still returns status 'OK' (200) and a cookie", func() {
+				resp, err := http.Post(server.URL()+endpoint, ContentTypeJSON, bytes.NewReader(usrBytes))
+
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+
+				cookie := resp.Header.Get("Set-Cookie")
+				Expect(cookie).NotTo(BeEmpty())
+			})
+		})
+
 		When("the method is POST, content type is right but payload is wrong", func() {
 			BeforeEach(func() {
 				usr = &model.User{
@@ -351,6 +469,7 @@ var _ = Describe("Handler", func() {
 				Expect(err).ShouldNot(HaveOccurred())
 
 				userRepository.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(expectUsr, nil).Times(1)
+				userRepository.EXPECT().AppendAudit(gomock.Any(), "user", model.AuditUserLoginFailed).Return(nil).Times(1)
 			})
 
 			It("returns status 'Unauthorized' (401)", func() {
@@ -387,11 +506,28 @@ var _ = Describe("Handler", func() {
 		})
 	})
 
-	Context("Receiving request at the /api/user/orders endpoint", func() {
+	Context("Receiving request at the /api/user/password endpoint", func() {
+		var change model.PasswordChange
+
+		send := func() *http.Response {
+			changeBytes, err := json.Marshal(change)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request, err := http.NewRequest(http.MethodPut, server.URL()+endpoint, bytes.NewReader(changeBytes))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request.Header.Set("Content-Type", ContentTypeJSON)
+			request.AddCookie(cookie)
+
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			return response
+		}
+
 		BeforeEach(func() {
-			endpoint = "/api/user/register"
-			server.RouteToHandler("POST", endpoint, handler.OrderNumberUpload)
-			server.RouteToHandler("GET", endpoint, handler.OrderListRequest)
+			endpoint = "/api/user/password"
+			server.AppendHandlers(validated(handler.UserPasswordChange))
 
 			secretKey = "secret"
 			login = "user"
@@ -399,229 +535,146 @@ var _ = Describe("Handler", func() {
 			ja = auth.NewAuth(secretKey)
 			Expect(ja).ShouldNot(BeNil())
 
-			_, tokenString, err = auth.NewJWTToken(ja, login)
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{ID: 7, Login: login, SessionVersion: 2})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(tokenString).NotTo(BeEmpty())
 
 			cookie = auth.NewCookieWithDefaults(tokenString)
+
+			hash, err := auth.HashPassword("password")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			userRepository.EXPECT().GetUser(gomock.Any(), login).
+				Return(&model.User{ID: 7, Login: login, Password: hash, SessionVersion: 2}, nil).AnyTimes()
 		})
 
-		// POST
-		When("the method is POST, everything is right but the order has been already created by this user", func() {
+		When("the current password is right", func() {
 			BeforeEach(func() {
-				orderNumber = "12345678903"
-
-				expectOrder := &model.Order{
-					Login:      login,
-					Number:     orderNumber,
-					Status:     "NEW",
-					Accrual:    0,
-					UploadedAt: time.Now(),
-				}
+				change = model.PasswordChange{CurrentPassword: "password", NewPassword: "new password"}
 
-				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, repository.ErrConflict).Times(1)
+				// Another password change has been made concurrently
+				userRepository.EXPECT().UpdateUserPassword(gomock.Any(), login, gomock.Any()).Return(int32(4), nil).Times(1)
 			})
 
-			It("returns status 'OK' (200)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
-				Expect(err).ShouldNot(HaveOccurred())
+			It("returns status 'OK' (200) and a cookie of the new session", func() {
+				response := send()
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
 
-				request.Header.Set("Content-Type", ContentTypeText)
-				request.AddCookie(cookie)
+				cookies := response.Cookies()
+				Expect(cookies).To(HaveLen(1))
 
-				response, err := http.DefaultClient.Do(request)
+				usr, err := auth.UserFromToken(cookies[0].Value, secretKey)
 				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+				Expect(usr.ID).To(Equal(int32(7)))
+				Expect(usr.SessionVersion).To(Equal(int32(4)))
 			})
 		})
 
-		When("the method is POST, everything is right and order doesn`t exist", func() {
+		When("the current password is wrong", func() {
 			BeforeEach(func() {
-				orderNumber = "12345678903"
-
-				expectOrder := &model.Order{
-					Login:      login,
-					Number:     orderNumber,
-					Status:     "NEW",
-					Accrual:    0,
-					UploadedAt: time.Now(),
-				}
-
-				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, nil).Times(1)
+				change = model.PasswordChange{CurrentPassword: "wrong password", NewPassword: "new password"}
 			})
 
-			It("returns status 'Accepted' (202)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
-				Expect(err).ShouldNot(HaveOccurred())
-
-				request.Header.Set("Content-Type", ContentTypeText)
-				request.AddCookie(cookie)
-
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusAccepted))
+			It("returns status 'Unauthorized' (401)", func() {
+				response := send()
+				Expect(response.StatusCode).Should(Equal(http.StatusUnauthorized))
 			})
 		})
 
-		When("the method is POST and order number is empty", func() {
+		When("the new password is the same", func() {
 			BeforeEach(func() {
-				orderNumber = ""
+				change = model.PasswordChange{CurrentPassword: "password", NewPassword: "password"}
 			})
 
 			It("returns status 'Bad request' (400)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
-				Expect(err).ShouldNot(HaveOccurred())
-
-				request.Header.Set("Content-Type", ContentTypeText)
-				request.AddCookie(cookie)
-
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
+				response := send()
 				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
 			})
 		})
+	})
 
-		When("the method is POST, everything is right but the order has been already created by another user", func() {
-			BeforeEach(func() {
-				orderNumber = "12345678903"
-
-				expectOrder := &model.Order{
-					Login:      "another user",
-					Number:     orderNumber,
-					Status:     "NEW",
-					Accrual:    0,
-					UploadedAt: time.Now(),
-				}
-
-				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, repository.ErrConflict).Times(1)
-			})
-
-			It("returns status 'Conflict' (409)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
-				Expect(err).ShouldNot(HaveOccurred())
-
-				request.Header.Set("Content-Type", ContentTypeText)
-				request.AddCookie(cookie)
+	Context("Receiving request at the /api/user/timezone endpoint", func() {
+		var preference model.TimezonePreference
 
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusConflict))
-			})
-		})
+		send := func() *http.Response {
+			preferenceBytes, err := json.Marshal(preference)
+			Expect(err).ShouldNot(HaveOccurred())
 
-		When("the method is POST and the order number is invalid", func() {
-			BeforeEach(func() {
-				orderNumber = "order #123456"
-			})
+			request, err := http.NewRequest(http.MethodPut, server.URL()+endpoint, bytes.NewReader(preferenceBytes))
+			Expect(err).ShouldNot(HaveOccurred())
 
-			It("returns status 'Unprocessable entity' (422)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
-				Expect(err).ShouldNot(HaveOccurred())
+			request.Header.Set("Content-Type", ContentTypeJSON)
+			request.AddCookie(cookie)
 
-				request.Header.Set("Content-Type", ContentTypeText)
-				request.AddCookie(cookie)
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).ShouldNot(HaveOccurred())
 
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
-			})
-		})
+			return response
+		}
 
-		When("the method is POST, everything is right with the request, but something has gone wrong with service", func() {
-			BeforeEach(func() {
-				orderNumber = "12345678903"
+		BeforeEach(func() {
+			endpoint = "/api/user/timezone"
+			server.AppendHandlers(validated(handler.UserTimezoneChange))
 
-				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(nil, errSomethingStrange).Times(1)
-			})
+			secretKey = "secret"
+			login = "user"
 
-			It("returns status 'Internal server error' (500)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
-				Expect(err).ShouldNot(HaveOccurred())
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
 
-				request.Header.Set("Content-Type", ContentTypeText)
-				request.AddCookie(cookie)
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
 
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
-			})
+			cookie = auth.NewCookieWithDefaults(tokenString)
 		})
 
-		// GET
-		When("the method is GET and there are orders to return", func() {
+		When("the time zone is known", func() {
 			BeforeEach(func() {
-				expectOrders = []*model.Order{
-					{
-						Login:      login,
-						Number:     orderNumber,
-						Status:     "NEW",
-						Accrual:    0,
-						UploadedAt: time.Now(),
-					},
-				}
+				preference = model.TimezonePreference{Timezone: "Europe/Moscow"}
 
-				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any()).Return(expectOrders, nil).Times(1)
+				userRepository.EXPECT().UpdateUserTimezone(gomock.Any(), login, "Europe/Moscow").Return(nil).Times(1)
 			})
 
-			It("returns status 'OK' (200) and a list of orders in JSON", func() {
-				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
-				Expect(err).ShouldNot(HaveOccurred())
-
-				request.AddCookie(cookie)
-
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
+			It("returns status 'OK' (200) and the time zone in JSON", func() {
+				response := send()
 				Expect(response.StatusCode).Should(Equal(http.StatusOK))
 
-				var orders model.Orders
-				err = json.NewDecoder(response.Body).Decode(&orders)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(orders).ShouldNot(BeEmpty())
-				Expect(orders).Should(HaveLen(len(expectOrders)))
+				var changed model.TimezonePreference
+				Expect(json.NewDecoder(response.Body).Decode(&changed)).To(Succeed())
+				Expect(changed).To(Equal(preference))
 			})
 		})
 
-		When("the method is GET and there are no orders to return", func() {
+		When("the time zone is unknown", func() {
 			BeforeEach(func() {
-				expectOrders = []*model.Order{}
-
-				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any()).Return(expectOrders, nil).Times(1)
+				preference = model.TimezonePreference{Timezone: "Mars/Olympus"}
 			})
 
-			It("returns status 'No content' (204) and response body is empty", func() {
-				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
-				Expect(err).ShouldNot(HaveOccurred())
-
-				request.AddCookie(cookie)
-
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusNoContent))
+			It("returns status 'Bad request' (400)", func() {
+				response := send()
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
 			})
 		})
 
-		When("the method is GET, but something has gone wrong with the service", func() {
+		When("the user doesn't exist anymore", func() {
 			BeforeEach(func() {
-				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any()).Return(nil, errSomethingStrange).Times(1)
-			})
-
-			It("returns status 'Internal server error' (500)", func() {
-				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
-				Expect(err).ShouldNot(HaveOccurred())
+				preference = model.TimezonePreference{Timezone: "UTC"}
 
-				request.AddCookie(cookie)
+				userRepository.EXPECT().UpdateUserTimezone(gomock.Any(), login, "UTC").Return(repository.ErrNotFound).Times(1)
+			})
 
-				response, err := http.DefaultClient.Do(request)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			It("returns status 'Unauthorized' (401)", func() {
+				response := send()
+				Expect(response.StatusCode).Should(Equal(http.StatusUnauthorized))
 			})
 		})
 	})
 
-	Context("Receiving request at the /api/user/balance endpoint", func() {
+	Context("Receiving request at the /api/user/export endpoint", func() {
 		BeforeEach(func() {
-			endpoint = "/api/user/balance"
-			server.AppendHandlers(handler.UserBalanceRequest)
+			endpoint = "/api/user/export"
+			server.AppendHandlers(validated(handler.UserDataExport))
 
 			secretKey = "secret"
 			login = "user"
@@ -629,7 +682,7 @@ var _ = Describe("Handler", func() {
 			ja = auth.NewAuth(secretKey)
 			Expect(ja).ShouldNot(BeNil())
 
-			_, tokenString, err = auth.NewJWTToken(ja, login)
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(tokenString).NotTo(BeEmpty())
 
@@ -638,15 +691,17 @@ var _ = Describe("Handler", func() {
 
 		When("the method is GET and everything is right", func() {
 			BeforeEach(func() {
-				expectBalance = model.Balance{
-					Current:   500,
-					Withdrawn: 42,
-				}
-
-				balanceRepository.EXPECT().GetBalance(gomock.Any(), gomock.Any()).Return(&expectBalance, nil).Times(1)
+				balanceRepository.EXPECT().GetBalance(gomock.Any(), &model.User{Login: login}).
+					Return(&model.Balance{Current: 500, Withdrawn: 42}, nil).Times(1)
+				balanceRepository.EXPECT().GetUpcomingExpirations(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), &model.User{Login: login}, model.Page{}).
+					Return(model.Orders{{Number: "12345678903", Status: "PROCESSED", Accrual: 500}}, 1, nil).Times(1)
+				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), &model.User{Login: login}, model.Page{}).
+					Return(model.Withdrawals{{OrderNumber: "2377225624", Sum: 42}}, 1, nil).Times(1)
+				balanceRepository.EXPECT().GetListOfTransfers(gomock.Any(), &model.User{Login: login}, model.Page{}).Return(nil, 0, nil).Times(1)
 			})
 
-			It("returns status 'OK' (200) and a balance structure in JSON", func() {
+			It("returns status 'OK' (200) and all user data in JSON", func() {
 				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
 				Expect(err).ShouldNot(HaveOccurred())
 
@@ -656,10 +711,14 @@ var _ = Describe("Handler", func() {
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(response.StatusCode).Should(Equal(http.StatusOK))
 
-				var blnc model.Balance
-				err = json.NewDecoder(response.Body).Decode(&blnc)
+				var export model.UserExport
+				err = json.NewDecoder(response.Body).Decode(&export)
 				Expect(err).ShouldNot(HaveOccurred())
-				Expect(blnc).To(Equal(expectBalance))
+				Expect(export.Login).To(Equal(login))
+				Expect(export.Balance.Current).To(Equal(float64(500)))
+				Expect(export.Orders).To(HaveLen(1))
+				Expect(export.Withdrawals).To(HaveLen(1))
+				Expect(export.Transfers).To(BeEmpty())
 			})
 		})
 
@@ -681,44 +740,132 @@ var _ = Describe("Handler", func() {
 		})
 	})
 
-	Context("Receiving request at the /api/user/balance/withdraw endpoint", func() {
-		BeforeEach(func() {
-			endpoint = "/api/user/balance/withdraw"
-			server.AppendHandlers(handler.WithdrawRequest)
+	Context("Receiving DELETE request at the /api/user endpoint", func() {
+		var deletion model.AccountDeletion
 
-			secretKey = "secret"
+		send := func() *http.Response {
+			deletionBytes, err := json.Marshal(deletion)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request, err := http.NewRequest(http.MethodDelete, server.URL()+endpoint, bytes.NewReader(deletionBytes))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request.Header.Set("Content-Type", ContentTypeJSON)
+			request.AddCookie(cookie)
+
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			return response
+		}
+
+		BeforeEach(func() {
+			endpoint = "/api/user"
+			server.AppendHandlers(validated(handler.UserDelete))
+
+			secretKey = "secret"
 			login = "user"
 
 			ja = auth.NewAuth(secretKey)
 			Expect(ja).ShouldNot(BeNil())
 
-			_, tokenString, err = auth.NewJWTToken(ja, login)
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(tokenString).NotTo(BeEmpty())
 
 			cookie = auth.NewCookieWithDefaults(tokenString)
+
+			hash, err := auth.HashPassword("password")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			userRepository.EXPECT().GetUser(gomock.Any(), login).Return(&model.User{Login: login, Password: hash}, nil).AnyTimes()
 		})
 
-		When("the method is POST and balance is enough to withdraw", func() {
+		When("the deletion is confirmed with the right password", func() {
 			BeforeEach(func() {
-				withdrawal = model.Withdrawal{
-					Login:       login,
-					OrderNumber: "2377225624",
-					Sum:         751,
-					ProcessedAt: time.Now(),
-				}
+				deletion = model.AccountDeletion{Password: "password", Confirm: true}
 
-				withdrawalBytes, err = json.Marshal(withdrawal)
+				// User data is anonymized by default
+				userRepository.EXPECT().DeleteUser(gomock.Any(), login, true).Return(nil).Times(1)
+			})
+
+			It("returns status 'No content' (204) and removes the cookie", func() {
+				response := send()
+				Expect(response.StatusCode).Should(Equal(http.StatusNoContent))
+
+				cookies := response.Cookies()
+				Expect(cookies).To(HaveLen(1))
+				Expect(cookies[0].MaxAge).To(BeNumerically("<", 0))
+			})
+		})
+
+		When("the deletion is not confirmed", func() {
+			BeforeEach(func() {
+				deletion = model.AccountDeletion{Password: "password"}
+			})
+
+			It("returns status 'Bad request' (400) and offers the data export", func() {
+				response := send()
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+
+				body, err := io.ReadAll(response.Body)
 				Expect(err).ShouldNot(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring("/api/user/export"))
+			})
+		})
 
-				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+		When("the password is wrong", func() {
+			BeforeEach(func() {
+				deletion = model.AccountDeletion{Password: "wrong password", Confirm: true}
+			})
+
+			It("returns status 'Unauthorized' (401)", func() {
+				response := send()
+				Expect(response.StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/orders endpoint", func() {
+		BeforeEach(func() {
+			endpoint = "/api/user/orders"
+			server.RouteToHandler("POST", endpoint, validated(handler.OrderNumberUpload))
+			server.RouteToHandler("GET", endpoint, validated(handler.OrderListRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+		})
+
+		// POST
+		When("the method is POST, everything is right but the order has been already created by this user", func() {
+			BeforeEach(func() {
+				orderNumber = "12345678903"
+
+				expectOrder := &model.Order{
+					Login:      login,
+					Number:     orderNumber,
+					Status:     "NEW",
+					Accrual:    0,
+					UploadedAt: time.Now(),
+				}
+
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, repository.ErrConflict).Times(1)
 			})
 
 			It("returns status 'OK' (200)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
 				Expect(err).ShouldNot(HaveOccurred())
 
-				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.Header.Set("Content-Type", ContentTypeText)
 				request.AddCookie(cookie)
 
 				response, err := http.DefaultClient.Do(request)
@@ -727,48 +874,90 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
-		When("the method is POST and balance is not enough to withdraw", func() {
+		When("the method is POST, everything is right and order doesn`t exist", func() {
 			BeforeEach(func() {
-				withdrawal = model.Withdrawal{
-					OrderNumber: "2377225624",
-					Sum:         751,
+				orderNumber = "12345678903"
+
+				expectOrder := &model.Order{
+					Login:      login,
+					Number:     orderNumber,
+					Status:     "NEW",
+					Accrual:    0,
+					UploadedAt: time.Now(),
 				}
 
-				withdrawalBytes, err = json.Marshal(withdrawal)
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, nil).Times(1)
+			})
+
+			It("returns status 'Accepted' (202)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
 				Expect(err).ShouldNot(HaveOccurred())
 
-				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(repository.ErrNegativeBalance).Times(1)
+				request.Header.Set("Content-Type", ContentTypeText)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusAccepted))
 			})
+		})
 
-			It("returns status 'Payment required' (402)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+		When("the method is POST and order number is empty", func() {
+			BeforeEach(func() {
+				orderNumber = ""
+			})
+
+			It("returns status 'Bad request' (400)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
 				Expect(err).ShouldNot(HaveOccurred())
 
-				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.Header.Set("Content-Type", ContentTypeText)
 				request.AddCookie(cookie)
 
 				response, err := http.DefaultClient.Do(request)
 				Expect(err).ShouldNot(HaveOccurred())
-				Expect(response.StatusCode).Should(Equal(http.StatusPaymentRequired))
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
 			})
 		})
 
-		When("the method is POST and the order number is invalid", func() {
+		When("the method is POST, everything is right but the order has been already created by another user", func() {
 			BeforeEach(func() {
-				withdrawal = model.Withdrawal{
-					OrderNumber: "Order #12345",
-					Sum:         751,
+				orderNumber = "12345678903"
+
+				expectOrder := &model.Order{
+					Login:      "another user",
+					Number:     orderNumber,
+					Status:     "NEW",
+					Accrual:    0,
+					UploadedAt: time.Now(),
 				}
 
-				withdrawalBytes, err = json.Marshal(withdrawal)
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, repository.ErrConflict).Times(1)
+			})
+
+			It("returns status 'Conflict' (409)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeText)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
 				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusConflict))
+			})
+		})
+
+		When("the method is POST and the order number is invalid", func() {
+			BeforeEach(func() {
+				orderNumber = "order #123456"
 			})
 
 			It("returns status 'Unprocessable entity' (422)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
 				Expect(err).ShouldNot(HaveOccurred())
 
-				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.Header.Set("Content-Type", ContentTypeText)
 				request.AddCookie(cookie)
 
 				response, err := http.DefaultClient.Do(request)
@@ -779,22 +968,16 @@ var _ = Describe("Handler", func() {
 
 		When("the method is POST, everything is right with the request, but something has gone wrong with service", func() {
 			BeforeEach(func() {
-				withdrawal = model.Withdrawal{
-					OrderNumber: "2377225624",
-					Sum:         751,
-				}
-
-				withdrawalBytes, err = json.Marshal(withdrawal)
-				Expect(err).ShouldNot(HaveOccurred())
+				orderNumber = "12345678903"
 
-				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errSomethingStrange).Times(1)
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(nil, errSomethingStrange).Times(1)
 			})
 
 			It("returns status 'Internal server error' (500)", func() {
-				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader([]byte(orderNumber)))
 				Expect(err).ShouldNot(HaveOccurred())
 
-				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.Header.Set("Content-Type", ContentTypeText)
 				request.AddCookie(cookie)
 
 				response, err := http.DefaultClient.Do(request)
@@ -802,40 +985,64 @@ var _ = Describe("Handler", func() {
 				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
 			})
 		})
-	})
 
-	Context("Receiving request at the /api/user/withdrawals endpoint", func() {
-		BeforeEach(func() {
-			endpoint = "/api/user/withdrawals"
-			server.AppendHandlers(handler.WithdrawalsInformationRequest)
+		// GET
+		When("the method is GET and there are orders to return", func() {
+			BeforeEach(func() {
+				expectOrders = []*model.Order{
+					{
+						Login:      login,
+						Number:     orderNumber,
+						Status:     "NEW",
+						Accrual:    0,
+						UploadedAt: time.Now(),
+					},
+				}
 
-			secretKey = "secret"
-			login = "user"
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), model.Page{}).Return(expectOrders, len(expectOrders), nil).Times(1)
+			})
 
-			ja = auth.NewAuth(secretKey)
-			Expect(ja).ShouldNot(BeNil())
+			It("returns status 'OK' (200) and a list of orders in JSON", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
 
-			_, tokenString, err = auth.NewJWTToken(ja, login)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(tokenString).NotTo(BeEmpty())
+				request.AddCookie(cookie)
 
-			cookie = auth.NewCookieWithDefaults(tokenString)
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var orders model.Orders
+				err = json.NewDecoder(response.Body).Decode(&orders)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(orders).ShouldNot(BeEmpty())
+				Expect(orders).Should(HaveLen(len(expectOrders)))
+			})
 		})
 
-		When("the method is GET and there are withdrawals to return", func() {
+		When("the method is GET and the orders are requested in a time zone", func() {
 			BeforeEach(func() {
-				expectWithdrawals = model.Withdrawals{
+				moscow, err := timezone.Load("Europe/Moscow")
+				Expect(err).ShouldNot(HaveOccurred())
+
+				// The server takes the time zone from the request or from the user preference
+				server.RouteToHandler("GET", endpoint, validated(func(w http.ResponseWriter, r *http.Request) {
+					handler.OrderListRequest(w, r.WithContext(timezone.WithLocation(r.Context(), moscow)))
+				}))
+
+				expectOrders = []*model.Order{
 					{
-						OrderNumber: "2377225624",
-						Sum:         500,
-						ProcessedAt: time.Now(),
+						Login:      login,
+						Number:     orderNumber,
+						Status:     "NEW",
+						UploadedAt: time.Date(2024, time.March, 1, 21, 30, 0, 0, time.UTC),
 					},
 				}
 
-				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any()).Return(expectWithdrawals, nil).Times(1)
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), model.Page{}).Return(expectOrders, len(expectOrders), nil).Times(1)
 			})
 
-			It("returns status 'OK' (200) and a list of withdrawals in JSON", func() {
+			It("returns status 'OK' (200) and upload times in the time zone", func() {
 				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
 				Expect(err).ShouldNot(HaveOccurred())
 
@@ -845,19 +1052,18 @@ var _ = Describe("Handler", func() {
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(response.StatusCode).Should(Equal(http.StatusOK))
 
-				var withdrawals model.Withdrawals
-				err = json.NewDecoder(response.Body).Decode(&withdrawals)
-				Expect(err).ShouldNot(HaveOccurred())
-				Expect(expectWithdrawals).ShouldNot(BeEmpty())
-				Expect(withdrawals).Should(HaveLen(len(expectWithdrawals)))
+				var orders []map[string]any
+				Expect(json.NewDecoder(response.Body).Decode(&orders)).To(Succeed())
+				Expect(orders).To(HaveLen(1))
+				Expect(orders[0]["uploaded_at"]).To(Equal("2024-03-02T00:30:00+03:00"))
 			})
 		})
 
-		When("the method is GET and there are no withdrawals to return", func() {
+		When("the method is GET and there are no orders to return", func() {
 			BeforeEach(func() {
-				expectWithdrawals = model.Withdrawals{}
+				expectOrders = []*model.Order{}
 
-				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any()).Return(expectWithdrawals, nil).Times(1)
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), model.Page{}).Return(expectOrders, len(expectOrders), nil).Times(1)
 			})
 
 			It("returns status 'No content' (204) and response body is empty", func() {
@@ -874,7 +1080,7 @@ var _ = Describe("Handler", func() {
 
 		When("the method is GET, but something has gone wrong with the service", func() {
 			BeforeEach(func() {
-				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any()).Return(nil, errSomethingStrange).Times(1)
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), model.Page{}).Return(nil, 0, errSomethingStrange).Times(1)
 			})
 
 			It("returns status 'Internal server error' (500)", func() {
@@ -889,4 +1095,1098 @@ var _ = Describe("Handler", func() {
 			})
 		})
 	})
+
+	Context("Receiving request at the /api/user/orders/batch endpoint", func() {
+		var (
+			body        []byte
+			contentType string
+		)
+
+		post := func() *http.Response {
+			request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(body))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request.Header.Set("Content-Type", contentType)
+			request.AddCookie(cookie)
+
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			return response
+		}
+
+		BeforeEach(func() {
+			endpoint = "/api/user/orders/batch"
+			server.RouteToHandler("POST", endpoint, validated(handler.OrderBatchUpload))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+		})
+
+		When("the numbers are given in JSON and some of them are duplicates or invalid", func() {
+			BeforeEach(func() {
+				contentType = ContentTypeJSON
+				body = []byte(`["12345678903", "2377225624", "79927398713", "12345", "12345678903"]`)
+
+				orderRepository.EXPECT().CreateOrders(gomock.Any(), login, []string{"12345678903", "2377225624", "79927398713"}).
+					Return([]string{"12345678903"}, model.Orders{
+						{Login: login, Number: "2377225624"},
+						{Login: "another", Number: "79927398713"},
+					}, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and result of every number", func() {
+				response := post()
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var results model.OrderUploadResults
+				Expect(json.NewDecoder(response.Body).Decode(&results)).To(Succeed())
+				Expect(results).To(Equal(model.OrderUploadResults{
+					{Number: "12345678903", Status: model.OrderUploadAccepted},
+					{Number: "2377225624", Status: model.OrderUploadDuplicateOwn},
+					{Number: "79927398713", Status: model.OrderUploadDuplicateOther},
+					{Number: "12345", Status: model.OrderUploadInvalid},
+					{Number: "12345678903", Status: model.OrderUploadDuplicateOwn},
+				}))
+			})
+		})
+
+		When("the numbers are given in newline-delimited text", func() {
+			BeforeEach(func() {
+				contentType = ContentTypeText
+				body = []byte("12345678903\n\n2377225624\n")
+
+				orderRepository.EXPECT().CreateOrders(gomock.Any(), login, []string{"12345678903", "2377225624"}).
+					Return([]string{"12345678903", "2377225624"}, nil, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and accepts all numbers", func() {
+				response := post()
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var results model.OrderUploadResults
+				Expect(json.NewDecoder(response.Body).Decode(&results)).To(Succeed())
+				Expect(results).To(HaveLen(2))
+				Expect(results[0].Status).To(Equal(model.OrderUploadAccepted))
+				Expect(results[1].Status).To(Equal(model.OrderUploadAccepted))
+			})
+		})
+
+		When("all of the numbers are invalid", func() {
+			BeforeEach(func() {
+				contentType = ContentTypeText
+				body = []byte("12345")
+			})
+
+			It("returns status 'OK' (200) without touching the repository", func() {
+				response := post()
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+			})
+		})
+
+		When("the batch is empty", func() {
+			BeforeEach(func() {
+				contentType = ContentTypeJSON
+				body = []byte(`[]`)
+			})
+
+			It("returns status 'Bad request' (400)", func() {
+				response := post()
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the JSON is malformed", func() {
+			BeforeEach(func() {
+				contentType = ContentTypeJSON
+				body = []byte(`[12345678903]`)
+			})
+
+			It("returns status 'Bad request' (400)", func() {
+				response := post()
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the batch is too large", func() {
+			BeforeEach(func() {
+				contentType = ContentTypeText
+				body = bytes.Repeat([]byte("12345678903\n"), order.MaxBatchSize+1)
+			})
+
+			It("returns status 'Request entity too large' (413)", func() {
+				response := post()
+				Expect(response.StatusCode).Should(Equal(http.StatusRequestEntityTooLarge))
+			})
+		})
+
+		When("the repository fails", func() {
+			BeforeEach(func() {
+				contentType = ContentTypeText
+				body = []byte("12345678903")
+
+				orderRepository.EXPECT().CreateOrders(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil, errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500)", func() {
+				response := post()
+				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/balance endpoint", func() {
+		BeforeEach(func() {
+			endpoint = "/api/user/balance"
+			server.AppendHandlers(validated(handler.UserBalanceRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+		})
+
+		When("the method is GET and everything is right", func() {
+			BeforeEach(func() {
+				expectBalance = model.Balance{
+					Current:   500,
+					Withdrawn: 42,
+				}
+
+				balanceRepository.EXPECT().GetBalance(gomock.Any(), gomock.Any()).Return(&expectBalance, nil).Times(1)
+				balanceRepository.EXPECT().GetUpcomingExpirations(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(model.Expirations{{Amount: 100, ExpiresOn: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)}}, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and a balance structure in JSON", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var blnc model.Balance
+				err = json.NewDecoder(response.Body).Decode(&blnc)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(blnc).To(Equal(expectBalance))
+			})
+		})
+
+		When("the method is GET, but something has gone wrong with the service", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetBalance(gomock.Any(), gomock.Any()).Return(nil, errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500)", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/tier endpoint", func() {
+		BeforeEach(func() {
+			cfg.LoyaltyTiers = []config.LoyaltyTier{
+				{Name: "bronze", Threshold: 0, Multiplier: 1},
+				{Name: "silver", Threshold: 1000, Multiplier: 1.05},
+				{Name: "gold", Threshold: 5000, Multiplier: 1.1},
+			}
+
+			balanceService, err = balance.NewService(balanceRepository, cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			handler = api.NewHandler(cfg, userService, orderService, balanceService, promotionService, auditService)
+
+			endpoint = "/api/user/tier"
+			server.AppendHandlers(validated(handler.UserTierRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+		})
+
+		When("the method is GET and everything is right", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetAccruedTotal(gomock.Any(), &model.User{Login: login}, gomock.Any()).Return(float64(1200), nil).Times(1)
+				balanceRepository.EXPECT().GetTierHistory(gomock.Any(), &model.User{Login: login}, gomock.Any()).
+					Return(model.TierChanges{{Tier: "silver", Total: 1010, ChangedAt: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)}}, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and the tier with the progress to the next tier in JSON", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var tierStatus model.TierStatus
+				err = json.NewDecoder(response.Body).Decode(&tierStatus)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tierStatus.Tier).To(Equal("silver"))
+				Expect(tierStatus.Multiplier).To(Equal(1.05))
+				Expect(tierStatus.NextTier).To(Equal("gold"))
+				Expect(tierStatus.Remaining).To(Equal(float64(3800)))
+				Expect(tierStatus.History).To(HaveLen(1))
+			})
+		})
+
+		When("the method is GET, but something has gone wrong with the service", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetAccruedTotal(gomock.Any(), gomock.Any(), gomock.Any()).Return(float64(0), errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500)", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/balance/withdraw endpoint", func() {
+		BeforeEach(func() {
+			endpoint = "/api/user/balance/withdraw"
+			server.AppendHandlers(validated(handler.WithdrawRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+		})
+
+		When("the method is POST and balance is enough to withdraw", func() {
+			BeforeEach(func() {
+				withdrawal = model.Withdrawal{
+					Login:       login,
+					OrderNumber: "2377225624",
+					Sum:         751,
+					ProcessedAt: time.Now(),
+				}
+
+				withdrawalBytes, err = json.Marshal(withdrawal)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			})
+
+			It("returns status 'OK' (200)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+			})
+		})
+
+		When("the method is POST and balance is not enough to withdraw", func() {
+			BeforeEach(func() {
+				withdrawal = model.Withdrawal{
+					OrderNumber: "2377225624",
+					Sum:         751,
+				}
+
+				withdrawalBytes, err = json.Marshal(withdrawal)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(repository.ErrNegativeBalance).Times(1)
+			})
+
+			It("returns status 'Payment required' (402)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusPaymentRequired))
+			})
+		})
+
+		When("the method is POST and the order number is invalid", func() {
+			BeforeEach(func() {
+				withdrawal = model.Withdrawal{
+					OrderNumber: "Order #12345",
+					Sum:         751,
+				}
+
+				withdrawalBytes, err = json.Marshal(withdrawal)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns status 'Unprocessable entity' (422)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+
+		When("the method is POST, everything is right with the request, but something has gone wrong with service", func() {
+			BeforeEach(func() {
+				withdrawal = model.Withdrawal{
+					OrderNumber: "2377225624",
+					Sum:         751,
+				}
+
+				withdrawalBytes, err = json.Marshal(withdrawal)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(withdrawalBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/balance/transfer endpoint", func() {
+		var transferBytes []byte
+
+		BeforeEach(func() {
+			endpoint = "/api/user/balance/transfer"
+			server.AppendHandlers(validated(handler.TransferRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+
+			transferBytes, err = json.Marshal(model.Transfer{Recipient: "friend", Sum: 100})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("the method is POST and balance is enough to transfer", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().Transfer(gomock.Any(), &model.Transfer{Sender: login, Recipient: "friend", Sum: 100}, cfg.TransferDailyLimit, gomock.Any(), gomock.Any()).
+					Return(&model.Transfer{Sender: login, Recipient: "friend", Sum: 100, TransferredAt: time.Now()}, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and the transfer in JSON", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(transferBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var transfer model.Transfer
+				err = json.NewDecoder(response.Body).Decode(&transfer)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(transfer.Sender).To(Equal(login))
+				Expect(transfer.Recipient).To(Equal("friend"))
+			})
+		})
+
+		DescribeTable("the method is POST, but the transfer is rejected",
+			func(repositoryErr error, expectedStatus int) {
+				balanceRepository.EXPECT().Transfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, repositoryErr).Times(1)
+
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(transferBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(expectedStatus))
+			},
+
+			Entry("not enough balance", repository.ErrNegativeBalance, http.StatusPaymentRequired),
+			Entry("recipient is disabled", repository.ErrDisabled, http.StatusForbidden),
+			Entry("recipient doesn't exist", repository.ErrNotFound, http.StatusNotFound),
+			Entry("daily limit is exceeded", repository.ErrLimitExceeded, http.StatusTooManyRequests),
+			Entry("something has gone wrong", errors.New("something strange"), http.StatusInternalServerError),
+		)
+
+		When("the method is POST and the recipient is the user", func() {
+			BeforeEach(func() {
+				transferBytes, err = json.Marshal(model.Transfer{Recipient: login, Sum: 100})
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns status 'Bad request' (400)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(transferBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the method is POST and the sum is not positive", func() {
+			BeforeEach(func() {
+				transferBytes, err = json.Marshal(model.Transfer{Recipient: "friend", Sum: -100})
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns status 'Bad request' (400)", func() {
+				request, err := http.NewRequest(http.MethodPost, server.URL()+endpoint, bytes.NewReader(transferBytes))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.Header.Set("Content-Type", ContentTypeJSON)
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/transfers endpoint", func() {
+		BeforeEach(func() {
+			endpoint = "/api/user/transfers"
+			server.AppendHandlers(validated(handler.TransfersInformationRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+		})
+
+		When("the method is GET and transfers exist", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetListOfTransfers(gomock.Any(), &model.User{Login: login}, model.Page{}).
+					Return(model.Transfers{
+						{Sender: "friend", Recipient: login, Sum: 50, TransferredAt: time.Now()},
+						{Sender: login, Recipient: "friend", Sum: 100, TransferredAt: time.Now()},
+					}, 2, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and sent and received transfers in JSON", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var transfers model.Transfers
+				err = json.NewDecoder(response.Body).Decode(&transfers)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(transfers).To(HaveLen(2))
+			})
+		})
+
+		When("the method is GET and there are no transfers", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetListOfTransfers(gomock.Any(), gomock.Any(), model.Page{}).Return(model.Transfers{}, 0, nil).Times(1)
+			})
+
+			It("returns status 'No content' (204)", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusNoContent))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/withdrawals endpoint", func() {
+		BeforeEach(func() {
+			endpoint = "/api/user/withdrawals"
+			server.AppendHandlers(validated(handler.WithdrawalsInformationRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+		})
+
+		When("the method is GET and there are withdrawals to return", func() {
+			BeforeEach(func() {
+				expectWithdrawals = model.Withdrawals{
+					{
+						OrderNumber: "2377225624",
+						Sum:         500,
+						ProcessedAt: time.Now(),
+					},
+				}
+
+				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any(), model.Page{}).Return(expectWithdrawals, len(expectWithdrawals), nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and a list of withdrawals in JSON", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var withdrawals model.Withdrawals
+				err = json.NewDecoder(response.Body).Decode(&withdrawals)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(expectWithdrawals).ShouldNot(BeEmpty())
+				Expect(withdrawals).Should(HaveLen(len(expectWithdrawals)))
+			})
+		})
+
+		When("the method is GET and there are no withdrawals to return", func() {
+			BeforeEach(func() {
+				expectWithdrawals = model.Withdrawals{}
+
+				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any(), model.Page{}).Return(expectWithdrawals, len(expectWithdrawals), nil).Times(1)
+			})
+
+			It("returns status 'No content' (204) and response body is empty", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusNoContent))
+			})
+		})
+
+		When("the method is GET, but something has gone wrong with the service", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any(), model.Page{}).Return(nil, 0, errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500)", func() {
+				request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				request.AddCookie(cookie)
+
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/user/statement endpoint", func() {
+		var entries []*model.StatementEntry
+
+		get := func(query string) *http.Response {
+			request, err := http.NewRequest(http.MethodGet, server.URL()+endpoint+query, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request.AddCookie(cookie)
+
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			return response
+		}
+
+		BeforeEach(func() {
+			endpoint = "/api/user/statement"
+			server.RouteToHandler("GET", endpoint, validated(handler.StatementRequest))
+
+			secretKey = "secret"
+			login = "user"
+
+			ja = auth.NewAuth(secretKey)
+			Expect(ja).ShouldNot(BeNil())
+
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{Login: login})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenString).NotTo(BeEmpty())
+
+			cookie = auth.NewCookieWithDefaults(tokenString)
+
+			entries = []*model.StatementEntry{
+				{Type: model.StatementAccrual, OrderNumber: "12345678903", Amount: 500, Balance: 500, ProcessedAt: time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)},
+				{Type: model.StatementWithdrawal, OrderNumber: "2377225624", Amount: -100.5, Balance: 399.5, ProcessedAt: time.Date(2024, 1, 20, 12, 0, 0, 0, time.UTC)},
+			}
+		})
+
+		streamEntries := func(_ context.Context, _ *model.User, _, _ time.Time, f func(entry *model.StatementEntry) error) error {
+			for _, entry := range entries {
+				if err := f(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		When("the format is CSV and the period is given by dates", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().Statement(gomock.Any(), gomock.Any(),
+					time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+					gomock.Any()).DoAndReturn(streamEntries).Times(1)
+			})
+
+			It("returns status 'OK' (200) and statement attachment in CSV", func() {
+				response := get("?from=2024-01-01&to=2024-01-31&format=csv")
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+				Expect(response.Header.Get("Content-Type")).To(HavePrefix("text/csv"))
+				Expect(response.Header.Get("Content-Disposition")).To(Equal(`attachment; filename="statement.csv"`))
+
+				body, err := io.ReadAll(response.Body)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(string(body)).To(Equal("processed_at,type,order,amount,balance\n" +
+					"2024-01-10T12:00:00Z,accrual,12345678903,500.00,500.00\n" +
+					"2024-01-20T12:00:00Z,withdrawal,2377225624,-100.50,399.50\n"))
+			})
+		})
+
+		When("the format is JSON Lines", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().Statement(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(streamEntries).Times(1)
+			})
+
+			It("returns status 'OK' (200) and an entry per line", func() {
+				response := get("?format=jsonl")
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+				Expect(response.Header.Get("Content-Disposition")).To(Equal(`attachment; filename="statement.jsonl"`))
+
+				decoder := json.NewDecoder(response.Body)
+				for _, expected := range entries {
+					var entry model.StatementEntry
+					Expect(decoder.Decode(&entry)).To(Succeed())
+					Expect(entry.OrderNumber).To(Equal(expected.OrderNumber))
+					Expect(entry.Balance).To(Equal(expected.Balance))
+				}
+			})
+		})
+
+		When("there are no entries in the period", func() {
+			BeforeEach(func() {
+				entries = nil
+				balanceRepository.EXPECT().Statement(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(streamEntries).Times(1)
+			})
+
+			It("returns status 'OK' (200) and CSV header only", func() {
+				response := get("")
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				body, err := io.ReadAll(response.Body)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(string(body)).To(Equal("processed_at,type,order,amount,balance\n"))
+			})
+		})
+
+		When("the format is unknown", func() {
+			It("returns status 'Bad request' (400)", func() {
+				response := get("?format=xlsx")
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the period is wrong", func() {
+			It("returns status 'Bad request' (400)", func() {
+				response := get("?from=2024-02-01&to=2024-01-01")
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the repository fails before the first entry", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().Statement(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500)", func() {
+				response := get("")
+				Expect(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/partner/withdrawals/{number}/reversal endpoint", func() {
+		var body []byte
+
+		post := func(orderNumber string) *http.Response {
+			response, err := http.Post(server.URL()+"/api/partner/withdrawals/"+orderNumber+"/reversal", ContentTypeJSON, bytes.NewReader(body))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			return response
+		}
+
+		BeforeEach(func() {
+			router := chi.NewRouter()
+			router.Post("/api/partner/withdrawals/{number}/reversal", handler.WithdrawalReversal)
+			server.RouteToHandler("POST", regexp.MustCompile(`^/api/partner/withdrawals/.+/reversal$`), validated(router.ServeHTTP))
+
+			orderNumber = "2377225624"
+			body = []byte(`{"reason": "order cancelled"}`)
+		})
+
+		When("the withdrawal exists", func() {
+			BeforeEach(func() {
+				reversedAt := time.Now()
+				balanceRepository.EXPECT().ReverseWithdrawal(gomock.Any(), orderNumber, "order cancelled", gomock.Any()).Return(&model.Withdrawal{
+					Login:          "user",
+					OrderNumber:    orderNumber,
+					Sum:            100,
+					ReversedAt:     &reversedAt,
+					ReversalReason: "order cancelled",
+				}, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and the reversed withdrawal", func() {
+				response := post(orderNumber)
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var withdrawal model.Withdrawal
+				Expect(json.NewDecoder(response.Body).Decode(&withdrawal)).To(Succeed())
+				Expect(withdrawal.Reversed()).To(BeTrue())
+				Expect(withdrawal.ReversalReason).To(Equal("order cancelled"))
+			})
+		})
+
+		When("the withdrawal has already been reversed", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().ReverseWithdrawal(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, repository.ErrAlreadyReversed).Times(1)
+			})
+
+			It("returns status 'Conflict' (409)", func() {
+				response := post(orderNumber)
+				Expect(response.StatusCode).Should(Equal(http.StatusConflict))
+			})
+		})
+
+		When("the withdrawal doesn't exist", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().ReverseWithdrawal(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, repository.ErrNotFound).Times(1)
+			})
+
+			It("returns status 'Not found' (404)", func() {
+				response := post(orderNumber)
+				Expect(response.StatusCode).Should(Equal(http.StatusNotFound))
+			})
+		})
+
+		When("the reason is empty", func() {
+			BeforeEach(func() {
+				body = []byte(`{}`)
+			})
+
+			It("returns status 'Bad request' (400)", func() {
+				response := post(orderNumber)
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the order number is invalid", func() {
+			It("returns status 'Unprocessable entity' (422)", func() {
+				response := post("12345")
+				Expect(response.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/admin/promotions endpoints", func() {
+		var body []byte
+
+		send := func(method, path string) *http.Response {
+			request, err := http.NewRequest(method, server.URL()+path, bytes.NewReader(body))
+			Expect(err).ShouldNot(HaveOccurred())
+			request.Header.Set("Content-Type", ContentTypeJSON)
+
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			return response
+		}
+
+		BeforeEach(func() {
+			router := chi.NewRouter()
+			router.Get("/api/admin/promotions", handler.PromotionRulesRequest)
+			router.Post("/api/admin/promotions", handler.PromotionRuleCreate)
+			router.Put("/api/admin/promotions/{id}", handler.PromotionRuleUpdate)
+			router.Delete("/api/admin/promotions/{id}", handler.PromotionRuleDelete)
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/admin/promotions$`), validated(router.ServeHTTP))
+			server.RouteToHandler("POST", regexp.MustCompile(`^/api/admin/promotions$`), validated(router.ServeHTTP))
+			server.RouteToHandler("PUT", regexp.MustCompile(`^/api/admin/promotions/.+$`), validated(router.ServeHTTP))
+			server.RouteToHandler("DELETE", regexp.MustCompile(`^/api/admin/promotions/.+$`), validated(router.ServeHTTP))
+
+			body = []byte(`{"name": "weekend", "weekdays": [0, 6], "bonus_rate": 1}`)
+		})
+
+		When("rules are requested", func() {
+			BeforeEach(func() {
+				promotionRepository.EXPECT().GetPromotionRules(gomock.Any()).Return(model.PromotionRules{
+					{ID: 1, Name: "weekend", Weekdays: []time.Weekday{time.Sunday, time.Saturday}, BonusRate: 1},
+				}, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) and the rules", func() {
+				response := send(http.MethodGet, "/api/admin/promotions")
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var rules model.PromotionRules
+				Expect(json.NewDecoder(response.Body).Decode(&rules)).To(Succeed())
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Name).To(Equal("weekend"))
+			})
+		})
+
+		When("a valid rule is created", func() {
+			BeforeEach(func() {
+				promotionRepository.EXPECT().CreatePromotionRule(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+						created := *rule
+						created.ID = 1
+						return &created, nil
+					}).Times(1)
+			})
+
+			It("returns status 'Created' (201) and the rule", func() {
+				response := send(http.MethodPost, "/api/admin/promotions")
+				Expect(response.StatusCode).Should(Equal(http.StatusCreated))
+
+				var rule model.PromotionRule
+				Expect(json.NewDecoder(response.Body).Decode(&rule)).To(Succeed())
+				Expect(rule.ID).To(Equal(int32(1)))
+				Expect(rule.Weekdays).To(Equal([]time.Weekday{time.Sunday, time.Saturday}))
+			})
+		})
+
+		When("the rule has no action", func() {
+			BeforeEach(func() {
+				body = []byte(`{"name": "weekend", "weekdays": [0, 6]}`)
+			})
+
+			It("returns status 'Bad request' (400)", func() {
+				response := send(http.MethodPost, "/api/admin/promotions")
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the updated rule doesn't exist", func() {
+			BeforeEach(func() {
+				promotionRepository.EXPECT().UpdatePromotionRule(gomock.Any(), gomock.Any()).Return(nil, repository.ErrNotFound).Times(1)
+			})
+
+			It("returns status 'Not found' (404)", func() {
+				response := send(http.MethodPut, "/api/admin/promotions/1")
+				Expect(response.StatusCode).Should(Equal(http.StatusNotFound))
+			})
+		})
+
+		When("the rule is deleted", func() {
+			BeforeEach(func() {
+				promotionRepository.EXPECT().DeletePromotionRule(gomock.Any(), int32(1)).Return(nil).Times(1)
+			})
+
+			It("returns status 'No content' (204)", func() {
+				response := send(http.MethodDelete, "/api/admin/promotions/1")
+				Expect(response.StatusCode).Should(Equal(http.StatusNoContent))
+			})
+		})
+
+		When("the deleted rule has given bonuses", func() {
+			BeforeEach(func() {
+				promotionRepository.EXPECT().DeletePromotionRule(gomock.Any(), int32(1)).Return(repository.ErrConflict).Times(1)
+			})
+
+			It("returns status 'Conflict' (409)", func() {
+				response := send(http.MethodDelete, "/api/admin/promotions/1")
+				Expect(response.StatusCode).Should(Equal(http.StatusConflict))
+			})
+		})
+
+		When("the rule ID is invalid", func() {
+			It("returns status 'Bad request' (400)", func() {
+				response := send(http.MethodDelete, "/api/admin/promotions/first")
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/admin/audit endpoints", func() {
+		BeforeEach(func() {
+			router := chi.NewRouter()
+			router.Get("/api/admin/audit", handler.AuditLogRequest)
+			router.Get("/api/admin/audit/verify", handler.AuditVerifyRequest)
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/admin/audit`), validated(router.ServeHTTP))
+		})
+
+		When("entries are requested with filters", func() {
+			BeforeEach(func() {
+				auditRepository.EXPECT().GetAuditEntries(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, filter *model.AuditFilter) (model.AuditEntries, error) {
+						Expect(filter.Login).To(Equal("user"))
+						Expect(filter.Action).To(Equal(model.AuditBalanceWithdraw))
+						Expect(*filter.From).To(Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)))
+						Expect(*filter.To).To(Equal(time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)))
+						Expect(filter.Limit).To(Equal(audit.DefaultLimit))
+
+						return model.AuditEntries{
+							{Login: "user", Action: model.AuditBalanceWithdraw, Queued: true},
+							{ID: 1, Login: "user", Action: model.AuditBalanceWithdraw},
+						}, nil
+					}).Times(1)
+			})
+
+			It("returns status 'OK' (200) and the entries", func() {
+				response, err := http.Get(server.URL() + "/api/admin/audit?login=user&action=balance.withdraw&from=2026-10-01&to=2026-10-19")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var entries model.AuditEntries
+				Expect(json.NewDecoder(response.Body).Decode(&entries)).To(Succeed())
+				Expect(entries).To(HaveLen(2))
+				Expect(entries[0].Queued).To(BeTrue())
+				Expect(entries[1].Queued).To(BeFalse())
+			})
+		})
+
+		When("there are no entries", func() {
+			BeforeEach(func() {
+				auditRepository.EXPECT().GetAuditEntries(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+			})
+
+			It("returns status 'No content' (204)", func() {
+				response, err := http.Get(server.URL() + "/api/admin/audit")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusNoContent))
+			})
+		})
+
+		When("the limit is too large", func() {
+			It("returns status 'Bad request' (400)", func() {
+				response, err := http.Get(server.URL() + "/api/admin/audit?limit=100000")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+		})
+
+		When("the chain has been changed", func() {
+			BeforeEach(func() {
+				auditRepository.EXPECT().ChainAuditLog(gomock.Any()).Return(int64(0), nil).Times(1)
+				auditRepository.EXPECT().AuditChain(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, f func(entry *model.AuditEntry) error) error {
+						first := &model.AuditEntry{ID: 1, Login: "user", Action: model.AuditUserRegister, PrevHash: auditpkg.GenesisHash}
+						first.Hash = auditpkg.Hash(first)
+						if err := f(first); err != nil {
+							return err
+						}
+
+						second := &model.AuditEntry{ID: 2, Login: "user", Action: model.AuditUserLogin, PrevHash: first.Hash}
+						second.Hash = auditpkg.Hash(second)
+						second.Login = "another"
+						return f(second)
+					}).Times(1)
+			})
+
+			It("returns status 'OK' (200) and the broken entry", func() {
+				response, err := http.Get(server.URL() + "/api/admin/audit/verify")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+
+				var verification model.AuditVerification
+				Expect(json.NewDecoder(response.Body).Decode(&verification)).To(Succeed())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.Checked).To(Equal(int64(1)))
+				Expect(verification.BrokenID).To(Equal(int64(2)))
+			})
+		})
+	})
 })
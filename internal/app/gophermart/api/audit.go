@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+)
+
+// parseAuditFilter parses audit log filter from the query parameters.
+// The period bounds are RFC 3339 times or dates in the location, a date in "to" includes the whole day.
+func parseAuditFilter(query url.Values, loc *time.Location) (*model.AuditFilter, error) {
+	filter := &model.AuditFilter{
+		Login:  query.Get("login"),
+		Action: model.AuditAction(query.Get("action")),
+	}
+
+	if value := query.Get("from"); value != "" {
+		from, _, err := parseStatementTime(value, loc)
+		if err != nil {
+			return nil, err
+		}
+		filter.From = &from
+	}
+
+	if value := query.Get("to"); value != "" {
+		to, isDate, err := parseStatementTime(value, loc)
+		if err != nil {
+			return nil, err
+		}
+		if isDate {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
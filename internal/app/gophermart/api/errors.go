@@ -3,45 +3,41 @@ package api
 import (
 	"net/http"
 
-	"github.com/go-chi/render"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
 )
 
-type ErrorResponse struct {
-	Err        error  `json:"-"`
-	StatusCode int    `json:"-"`
-	StatusText string `json:"status_text"`
-	Message    string `json:"message"`
-}
+// Error codes of the API.
+const (
+	CodeWrongLoginPassword        problem.Code = "wrong_login_password"
+	CodeNotEnoughBalance          problem.Code = "not_enough_balance"
+	CodeSelfTransfer              problem.Code = "self_transfer"
+	CodeRecipientDisabled         problem.Code = "recipient_disabled"
+	CodeWithdrawalNotFound        problem.Code = "withdrawal_not_found"
+	CodeRecipientNotFound         problem.Code = "recipient_not_found"
+	CodeRuleNotFound              problem.Code = "promotion_rule_not_found"
+	CodeLoginTaken                problem.Code = "login_taken"
+	CodeOrderUploadedByAnother    problem.Code = "order_uploaded_by_another_user"
+	CodeWithdrawalAlreadyReversed problem.Code = "withdrawal_already_reversed"
+	CodeRuleInUse                 problem.Code = "promotion_rule_in_use"
+	CodeBatchTooLarge             problem.Code = "batch_too_large"
+	CodeInvalidOrderNumber        problem.Code = "invalid_order_number"
+	CodeTransferLimitExceeded     problem.Code = "transfer_limit_exceeded"
+)
 
 var (
-	ErrOrderUploadedByThisLogin    = &ErrorResponse{StatusCode: 200, Message: "Order number has already been uploaded by this user"}
-	ErrNoOrders                    = &ErrorResponse{StatusCode: 204, Message: "There are no orders"}
-	ErrNoWithdrawals               = &ErrorResponse{StatusCode: 204, Message: "There are no withdrawals"}
-	ErrBadRequest                  = &ErrorResponse{StatusCode: 400, Message: "Bad request"}
-	ErrWrongLoginPassword          = &ErrorResponse{StatusCode: 401, Message: "Wrong login/password"}
-	ErrNotEnoughBalance            = &ErrorResponse{StatusCode: 402, Message: "Not enough balance for withdrawal"}
-	ErrLoginIsAlreadyTaken         = &ErrorResponse{StatusCode: 409, Message: "Login has already been taken"}
-	ErrOrderUploadedByAnotherLogin = &ErrorResponse{StatusCode: 409, Message: "Order number has already been uploaded by another user"}
-	ErrInvalidOrderNumber          = &ErrorResponse{StatusCode: 422, Message: "Invalid order number"}
+	ErrBadRequest                  = problem.New(http.StatusBadRequest, problem.CodeBadRequest, "Bad request")
+	ErrSelfTransfer                = problem.New(http.StatusBadRequest, CodeSelfTransfer, "Points cannot be transferred to yourself")
+	ErrWrongLoginPassword          = problem.New(http.StatusUnauthorized, CodeWrongLoginPassword, "Wrong login/password")
+	ErrNotEnoughBalance            = problem.New(http.StatusPaymentRequired, CodeNotEnoughBalance, "Not enough balance for withdrawal")
+	ErrRecipientDisabled           = problem.New(http.StatusForbidden, CodeRecipientDisabled, "Recipient account is disabled")
+	ErrWithdrawalNotFound          = problem.New(http.StatusNotFound, CodeWithdrawalNotFound, "Withdrawal not found")
+	ErrRecipientNotFound           = problem.New(http.StatusNotFound, CodeRecipientNotFound, "Recipient not found")
+	ErrRuleNotFound                = problem.New(http.StatusNotFound, CodeRuleNotFound, "Promotion rule not found")
+	ErrLoginIsAlreadyTaken         = problem.New(http.StatusConflict, CodeLoginTaken, "Login has already been taken")
+	ErrOrderUploadedByAnotherLogin = problem.New(http.StatusConflict, CodeOrderUploadedByAnother, "Order number has already been uploaded by another user")
+	ErrWithdrawalAlreadyReversed   = problem.New(http.StatusConflict, CodeWithdrawalAlreadyReversed, "Withdrawal has already been reversed")
+	ErrRuleInUse                   = problem.New(http.StatusConflict, CodeRuleInUse, "Promotion rule has given bonuses, disable it instead")
+	ErrBatchTooLarge               = problem.New(http.StatusRequestEntityTooLarge, CodeBatchTooLarge, "Too many order numbers in a batch")
+	ErrInvalidOrderNumber          = problem.New(http.StatusUnprocessableEntity, CodeInvalidOrderNumber, "Invalid order number")
+	ErrTransferLimitExceeded       = problem.New(http.StatusTooManyRequests, CodeTransferLimitExceeded, "Daily transfer limit exceeded")
 )
-
-func (e *ErrorResponse) Render(w http.ResponseWriter, r *http.Request) error {
-	render.Status(r, e.StatusCode)
-	return nil
-}
-func ErrorRenderer(err error) *ErrorResponse {
-	return &ErrorResponse{
-		Err:        err,
-		StatusCode: 400,
-		StatusText: "Bad request",
-		Message:    err.Error(),
-	}
-}
-func ServerErrorRenderer(err error) *ErrorResponse {
-	return &ErrorResponse{
-		Err:        err,
-		StatusCode: 500,
-		StatusText: "Internal server error",
-		Message:    err.Error(),
-	}
-}
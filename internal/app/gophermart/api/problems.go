@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+)
+
+// The helpers below are shared by API v1 and API v2, so both versions answer service errors the same way.
+
+// SetSessionCookie sets a cookie with a new JWT token of the user.
+func SetSessionCookie(w http.ResponseWriter, cfg *config.Config, usr *model.User) *problem.Problem {
+	// Generate JWT token
+	ja := auth.NewAuth(cfg.SecretKey)
+	_, tokenString, err := auth.NewJWTToken(ja, usr)
+	if err != nil {
+		return problem.Internal(msgNewJWTToken, err)
+	}
+
+	// Set a cookie with generated JWT token
+	http.SetCookie(w, auth.NewCookie(tokenString, cfg.TLSEnabled()))
+
+	return nil
+}
+
+// RegistrationProblem returns the problem of a failed user registration.
+func RegistrationProblem(ctx context.Context, err error) *problem.Problem {
+	if errors.Is(err, user.ErrLoginIsAlreadyTaken) {
+		logger.FromContext(ctx).Info(msgUserRegistration, argError, err.Error())
+		return ErrLoginIsAlreadyTaken
+	}
+
+	return problem.Internal(msgUserRegistration, err)
+}
+
+// LoginProblem returns the problem of a failed user login.
+func LoginProblem(ctx context.Context, err error) *problem.Problem {
+	if errors.Is(err, user.ErrWrongLoginPassword) {
+		logger.FromContext(ctx).Info(msgUserLogin, argError, err.Error())
+		return ErrWrongLoginPassword
+	}
+
+	return problem.Internal(msgUserLogin, err)
+}
+
+// OrderUploadProblem returns the problem of a failed order number upload.
+// The order uploaded by the same user is answered differently by API versions, so it is checked by the caller.
+func OrderUploadProblem(ctx context.Context, err error) *problem.Problem {
+	if errors.Is(err, order.ErrOrderUploadedByAnotherLogin) {
+		logger.FromContext(ctx).Info(msgOrderNumberUpload, argError, err.Error())
+		return ErrOrderUploadedByAnotherLogin
+	}
+
+	return problem.Internal(msgOrderNumberUpload, err)
+}
+
+// OrderListProblem returns the problem of a failed user orders request.
+func OrderListProblem(err error) *problem.Problem {
+	return problem.Internal(msgOrderList, err)
+}
+
+// BalanceProblem returns the problem of a failed user balance request.
+func BalanceProblem(err error) *problem.Problem {
+	return problem.Internal(msgUserBalance, err)
+}
+
+// TierProblem returns the problem of a failed user tier request.
+func TierProblem(err error) *problem.Problem {
+	return problem.Internal(msgUserTier, err)
+}
+
+// WithdrawProblem returns the problem of a failed withdrawal.
+func WithdrawProblem(ctx context.Context, err error) *problem.Problem {
+	if errors.Is(err, balance.ErrNotEnoughBalance) {
+		logger.FromContext(ctx).Info(msgWithdraw, argError, err.Error())
+		return ErrNotEnoughBalance
+	}
+
+	return problem.Internal(msgWithdraw, err)
+}
+
+// WithdrawalsProblem returns the problem of a failed user withdrawals request.
+func WithdrawalsProblem(err error) *problem.Problem {
+	return problem.Internal(msgUserWithdrawals, err)
+}
+
+// TransferProblem returns the problem of a failed transfer.
+func TransferProblem(err error) *problem.Problem {
+	switch {
+	case errors.Is(err, balance.ErrSenderNotFound), errors.Is(err, balance.ErrSenderDisabled):
+		return problem.ErrUnauthorized
+	case errors.Is(err, balance.ErrSelfTransfer):
+		return ErrSelfTransfer
+	case errors.Is(err, balance.ErrNotEnoughBalance):
+		return ErrNotEnoughBalance
+	case errors.Is(err, balance.ErrRecipientDisabled):
+		return ErrRecipientDisabled
+	case errors.Is(err, balance.ErrRecipientNotFound):
+		return ErrRecipientNotFound
+	case errors.Is(err, balance.ErrTransferLimitExceeded):
+		return ErrTransferLimitExceeded
+	default:
+		return problem.Internal(msgTransfer, err)
+	}
+}
+
+// TransfersProblem returns the problem of a failed user transfers request.
+func TransfersProblem(err error) *problem.Problem {
+	return problem.Internal(msgUserTransfers, err)
+}
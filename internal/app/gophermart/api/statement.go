@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+)
+
+const (
+	statementFormatCSV   = "csv"
+	statementFormatJSONL = "jsonl"
+
+	statementDateLayout = "2006-01-02"
+)
+
+// ErrUnknownStatementFormat - unsupported statement format error.
+var ErrUnknownStatementFormat = fmt.Errorf("unknown statement format")
+
+// statementWriter writes statement entries to response in CSV or JSON Lines.
+// Headers are written with the first entry, so the response status can be changed until then.
+// Times are written in the location.
+type statementWriter struct {
+	w       http.ResponseWriter
+	format  string
+	loc     *time.Location
+	started bool
+
+	csvWriter   *csv.Writer
+	jsonEncoder *json.Encoder
+}
+
+// newStatementWriter creates new statement writer of the format, which writes times in the location.
+func newStatementWriter(w http.ResponseWriter, format string, loc *time.Location) (*statementWriter, error) {
+	switch format {
+	case "", statementFormatCSV:
+		format = statementFormatCSV
+	case statementFormatJSONL:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStatementFormat, format)
+	}
+
+	return &statementWriter{
+		w:      w,
+		format: format,
+		loc:    loc,
+	}, nil
+}
+
+// start writes response headers and the CSV header row.
+func (sw *statementWriter) start() error {
+	sw.started = true
+
+	header := sw.w.Header()
+
+	switch sw.format {
+	case statementFormatCSV:
+		header.Set("Content-Type", "text/csv; charset=utf-8")
+		header.Set("Content-Disposition", `attachment; filename="statement.csv"`)
+		sw.w.WriteHeader(http.StatusOK)
+
+		sw.csvWriter = csv.NewWriter(sw.w)
+		return sw.csvWriter.Write([]string{"processed_at", "type", "order", "amount", "balance"})
+	default:
+		header.Set("Content-Type", "application/jsonl; charset=utf-8")
+		header.Set("Content-Disposition", `attachment; filename="statement.jsonl"`)
+		sw.w.WriteHeader(http.StatusOK)
+
+		sw.jsonEncoder = json.NewEncoder(sw.w)
+		return nil
+	}
+}
+
+// Write writes the statement entry.
+func (sw *statementWriter) Write(entry *model.StatementEntry) error {
+	if !sw.started {
+		if err := sw.start(); err != nil {
+			return err
+		}
+	}
+
+	entry.ProcessedAt = entry.ProcessedAt.In(sw.loc)
+
+	if sw.format == statementFormatJSONL {
+		return sw.jsonEncoder.Encode(entry)
+	}
+
+	return sw.csvWriter.Write([]string{
+		entry.ProcessedAt.Format(time.RFC3339),
+		string(entry.Type),
+		entry.OrderNumber,
+		strconv.FormatFloat(entry.Amount, 'f', 2, 64),
+		strconv.FormatFloat(entry.Balance, 'f', 2, 64),
+	})
+}
+
+// Close finishes the statement, empty statement gets headers only.
+func (sw *statementWriter) Close() error {
+	if !sw.started {
+		if err := sw.start(); err != nil {
+			return err
+		}
+	}
+
+	if sw.csvWriter != nil {
+		sw.csvWriter.Flush()
+		return sw.csvWriter.Error()
+	}
+
+	return nil
+}
+
+// parseStatementPeriod parses the period [from, to) of a statement.
+// Bounds are RFC 3339 times or dates, date "to" includes the whole day. Missing bounds mean an open period.
+// Days start at midnight in the location.
+func parseStatementPeriod(fromValue, toValue string, now time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	from, to := time.Time{}, now
+
+	if fromValue != "" {
+		t, _, err := parseStatementTime(fromValue, loc)
+		if err != nil {
+			return from, to, err
+		}
+		from = t
+	}
+
+	if toValue != "" {
+		t, isDate, err := parseStatementTime(toValue, loc)
+		if err != nil {
+			return from, to, err
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = t
+	}
+
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("statement period is empty")
+	}
+
+	return from, to, nil
+}
+
+// parseStatementTime parses RFC 3339 time or date in the location and tells, if it was a date.
+func parseStatementTime(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(statementDateLayout, value, loc); err == nil {
+		return t, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
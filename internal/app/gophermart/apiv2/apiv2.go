@@ -0,0 +1,391 @@
+package apiv2
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/api"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	orderpkg "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	// DefaultPageLimit is the number of list items returned, when the limit is not requested.
+	DefaultPageLimit = 50
+	// MaxPageLimit is the maximal number of list items returned at once.
+	MaxPageLimit = 100
+)
+
+const msgRendering = "response rendering"
+
+// Envelope is a response of API v2, lists have pagination metadata.
+type Envelope struct {
+	Data any   `json:"data"`
+	Meta *Page `json:"meta,omitempty"`
+}
+
+// Page is pagination metadata of a list.
+type Page struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// Session is a response of registration and login.
+type Session struct {
+	Login string `json:"login"`
+}
+
+// WithdrawalResult is a response of withdrawal.
+type WithdrawalResult struct {
+	OrderNumber string  `json:"order"`
+	Sum         float64 `json:"sum"`
+}
+
+// Handler handles API v2 requests with the same services and service error problems as API v1.
+// API v2 wraps responses in envelopes, returns empty lists with 200, creations with 201 and duplicates with 409.
+type Handler struct {
+	cfg *config.Config
+
+	userService    user.Service
+	orderService   order.Service
+	balanceService balance.Service
+}
+
+// NewHandler is a Handler constructor.
+func NewHandler(cfg *config.Config, userService user.Service, orderService order.Service, balanceService balance.Service) *Handler {
+	return &Handler{
+		cfg:            cfg,
+		userService:    userService,
+		orderService:   orderService,
+		balanceService: balanceService,
+	}
+}
+
+// UserRegistration handles user registration request.
+func (h *Handler) UserRegistration(w http.ResponseWriter, r *http.Request) {
+	// Get user from request
+	var usr model.User
+	if err := render.Bind(r, &usr); err != nil {
+		problem.Write(w, r, problem.Validation(err))
+		return
+	}
+
+	// Get context from request
+	ctx := r.Context()
+
+	// Register user
+	if err := h.userService.Register(ctx, &usr); err != nil {
+		problem.Write(w, r, api.RegistrationProblem(ctx, err))
+		return
+	}
+
+	h.startSession(w, r, &usr, http.StatusCreated)
+}
+
+// UserLogin handles user login request.
+func (h *Handler) UserLogin(w http.ResponseWriter, r *http.Request) {
+	// Get user from request
+	var usr model.User
+	if err := render.Bind(r, &usr); err != nil {
+		problem.Write(w, r, problem.Validation(err))
+		return
+	}
+
+	// Get context from request
+	ctx := r.Context()
+
+	// Login user
+	if err := h.userService.Login(ctx, &usr); err != nil {
+		problem.Write(w, r, api.LoginProblem(ctx, err))
+		return
+	}
+
+	h.startSession(w, r, &usr, http.StatusOK)
+}
+
+// startSession sets a cookie with new JWT token of the user and responds with the session.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, usr *model.User, status int) {
+	// Set a cookie with new JWT token
+	if p := api.SetSessionCookie(w, h.cfg, usr); p != nil {
+		problem.Write(w, r, p)
+		return
+	}
+
+	respond(w, r, status, &Session{Login: usr.Login}, nil)
+}
+
+// OrderNumberUpload handles order number upload request.
+func (h *Handler) OrderNumberUpload(w http.ResponseWriter, r *http.Request) {
+	// Get order number from request
+	var upload model.OrderUpload
+	if err := render.Bind(r, &upload); err != nil {
+		problem.Write(w, r, problem.Validation(err))
+		return
+	}
+
+	// Check if the order number is valid with Luhn algorithm
+	if !orderpkg.IsNumberValid(upload.Number) {
+		problem.Write(w, r, api.ErrInvalidOrderNumber)
+		return
+	}
+
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Create order with order service
+	err = h.orderService.Create(r.Context(), &model.Order{
+		Login:  usr.Login,
+		Number: upload.Number,
+	})
+	if errors.Is(err, order.ErrOrderUploadedByThisLogin) {
+		problem.Write(w, r, ErrOrderAlreadyUploaded)
+		return
+	}
+
+	if err != nil {
+		problem.Write(w, r, api.OrderUploadProblem(r.Context(), err))
+		return
+	}
+
+	respond(w, r, http.StatusCreated, &model.OrderUploadResult{
+		Number: upload.Number,
+		Status: model.OrderUploadAccepted,
+	}, nil)
+}
+
+// OrderListRequest handles a page of user orders request.
+func (h *Handler) OrderListRequest(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, ErrInvalidPage)
+		return
+	}
+
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Get a page of orders from order service
+	orders, total, err := h.orderService.UserOrdersPage(r.Context(), usr, page.model())
+	if err != nil {
+		problem.Write(w, r, api.OrderListProblem(err))
+		return
+	}
+
+	page.Total = total
+	respond(w, r, http.StatusOK, orders, page)
+}
+
+// UserBalanceRequest handles user balance request.
+func (h *Handler) UserBalanceRequest(w http.ResponseWriter, r *http.Request) {
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Get user balance from balance service
+	userBalance, err := h.balanceService.Get(r.Context(), usr)
+	if err != nil {
+		problem.Write(w, r, api.BalanceProblem(err))
+		return
+	}
+
+	respond(w, r, http.StatusOK, userBalance, nil)
+}
+
+// UserTierRequest handles user loyalty tier request.
+func (h *Handler) UserTierRequest(w http.ResponseWriter, r *http.Request) {
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Get loyalty tier status from balance service
+	tierStatus, err := h.balanceService.Tier(r.Context(), usr)
+	if err != nil {
+		problem.Write(w, r, api.TierProblem(err))
+		return
+	}
+
+	respond(w, r, http.StatusOK, tierStatus, nil)
+}
+
+// WithdrawRequest handles withdrawal creation request.
+func (h *Handler) WithdrawRequest(w http.ResponseWriter, r *http.Request) {
+	// Get withdrawal from request
+	var withdrawal model.Withdrawal
+	if err := render.Bind(r, &withdrawal); err != nil {
+		problem.Write(w, r, problem.Validation(err))
+		return
+	}
+
+	// Check if order number is valid with Luhn algorithm
+	if !orderpkg.IsNumberValid(withdrawal.OrderNumber) {
+		problem.Write(w, r, api.ErrInvalidOrderNumber)
+		return
+	}
+
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Withdraw from user balance with balance service
+	err = h.balanceService.Withdraw(r.Context(), usr, withdrawal.OrderNumber, withdrawal.Sum)
+	if err != nil {
+		problem.Write(w, r, api.WithdrawProblem(r.Context(), err))
+		return
+	}
+
+	respond(w, r, http.StatusCreated, &WithdrawalResult{
+		OrderNumber: withdrawal.OrderNumber,
+		Sum:         withdrawal.Sum,
+	}, nil)
+}
+
+// WithdrawalsInformationRequest handles a page of user withdrawals request.
+func (h *Handler) WithdrawalsInformationRequest(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, ErrInvalidPage)
+		return
+	}
+
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Get a page of user withdrawals from balance service
+	withdrawals, total, err := h.balanceService.WithdrawalsPage(r.Context(), usr, page.model())
+	if err != nil {
+		problem.Write(w, r, api.WithdrawalsProblem(err))
+		return
+	}
+
+	page.Total = total
+	respond(w, r, http.StatusOK, withdrawals, page)
+}
+
+// TransferRequest handles transfer creation request.
+func (h *Handler) TransferRequest(w http.ResponseWriter, r *http.Request) {
+	// Get transfer from request
+	var transfer model.Transfer
+	if err := render.Bind(r, &transfer); err != nil {
+		problem.Write(w, r, problem.Validation(err))
+		return
+	}
+
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Transfer points with balance service
+	created, err := h.balanceService.Transfer(r.Context(), usr, transfer.Recipient, transfer.Sum)
+	if err != nil {
+		problem.Write(w, r, api.TransferProblem(err))
+		return
+	}
+
+	respond(w, r, http.StatusCreated, created, nil)
+}
+
+// TransfersInformationRequest handles a page of user transfers request.
+func (h *Handler) TransfersInformationRequest(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, ErrInvalidPage)
+		return
+	}
+
+	// Get user from request
+	usr, err := auth.UserFromRequest(r, h.cfg.SecretKey)
+	if err != nil {
+		problem.Write(w, r, problem.ErrUnauthorized)
+		return
+	}
+
+	// Get a page of user transfers from balance service
+	transfers, total, err := h.balanceService.TransfersPage(r.Context(), usr, page.model())
+	if err != nil {
+		problem.Write(w, r, api.TransfersProblem(err))
+		return
+	}
+
+	page.Total = total
+	respond(w, r, http.StatusOK, transfers, page)
+}
+
+// respond writes the data wrapped in an envelope with the status.
+// The data is tuned for rendering as API v1 does it, so times are rendered in the requested time zone.
+func respond(w http.ResponseWriter, r *http.Request, status int, data any, page *Page) {
+	if renderer, ok := data.(render.Renderer); ok {
+		if err := renderer.Render(w, r); err != nil {
+			problem.Write(w, r, problem.Internal(msgRendering, err))
+			return
+		}
+	}
+
+	render.Status(r, status)
+	render.JSON(w, r, &Envelope{Data: data, Meta: page})
+}
+
+// errInvalidPage - wrong pagination parameters error.
+var errInvalidPage = errors.New("invalid page")
+
+// parsePage parses pagination parameters, the limit is from 1 to MaxPageLimit, the offset is not negative.
+func parsePage(query url.Values) (*Page, error) {
+	page := &Page{Limit: DefaultPageLimit}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxPageLimit {
+			return nil, errInvalidPage
+		}
+		page.Limit = limit
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return nil, errInvalidPage
+		}
+		page.Offset = offset
+	}
+
+	return page, nil
+}
+
+// model returns the page requested from the services.
+func (p *Page) model() model.Page {
+	return model.Page{Limit: p.Limit, Offset: p.Offset}
+}
@@ -0,0 +1,13 @@
+package apiv2_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestApiv2(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Apiv2 Suite")
+}
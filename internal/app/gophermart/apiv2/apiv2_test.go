@@ -0,0 +1,429 @@
+package apiv2_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	openapispec "github.com/RomanAgaltsev/ya_gophermart/api"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/api"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/apiv2"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	balanceMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/balance"
+	orderMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/order"
+	userMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/openapi"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"go.uber.org/mock/gomock"
+)
+
+const ContentTypeJSON = "application/json"
+
+// envelope is a response of API v2 with raw data.
+type envelope struct {
+	Data json.RawMessage `json:"data"`
+	Meta *apiv2.Page     `json:"meta"`
+}
+
+var _ = Describe("Handler", func() {
+	var (
+		err                 error
+		errSomethingStrange error
+
+		cfg *config.Config
+
+		server *ghttp.Server
+
+		userRepository    *userMocks.MockRepository
+		orderRepository   *orderMocks.MockRepository
+		balanceRepository *balanceMocks.MockRepository
+
+		handler *apiv2.Handler
+
+		// Handlers are validated against the OpenAPI document, responses too
+		validator *openapi.Validator
+
+		login  string
+		cookie *http.Cookie
+	)
+
+	BeforeEach(func() {
+		errSomethingStrange = errors.New("something strange")
+
+		cfg, err = config.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).ShouldNot(BeNil())
+
+		server = ghttp.NewServer()
+
+		userRepository = userMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+
+		// Units of work run the function with the same mocked repository
+		userRepository.EXPECT().WithinTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, f func(ctx context.Context) error) error { return f(ctx) }).AnyTimes()
+
+		userService, err := user.NewService(userRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		orderRepository = orderMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+		orderService, err := order.NewService(orderRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		balanceRepository = balanceMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+		balanceService, err := balance.NewService(balanceRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		handler = apiv2.NewHandler(cfg, userService, orderService, balanceService)
+		Expect(handler).ShouldNot(BeNil())
+
+		validator, err = openapi.NewValidator(openapispec.Spec, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		login = "user"
+
+		_, tokenString, err := auth.NewJWTToken(auth.NewAuth(cfg.SecretKey), &model.User{Login: login})
+		Expect(err).NotTo(HaveOccurred())
+
+		cookie = auth.NewCookieWithDefaults(tokenString)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	route := func(method, endpoint string, h http.HandlerFunc) {
+		server.RouteToHandler(method, endpoint, validator.Middleware(h).ServeHTTP)
+	}
+
+	send := func(method, endpoint string, body any) *http.Response {
+		var reader io.Reader
+		if body != nil {
+			payload, err := json.Marshal(body)
+			Expect(err).ShouldNot(HaveOccurred())
+			reader = bytes.NewReader(payload)
+		}
+
+		request, err := http.NewRequest(method, server.URL()+endpoint, reader)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		if body != nil {
+			request.Header.Set("Content-Type", ContentTypeJSON)
+		}
+		request.AddCookie(cookie)
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return response
+	}
+
+	decode := func(response *http.Response, data any) *apiv2.Page {
+		var env envelope
+		Expect(json.NewDecoder(response.Body).Decode(&env)).To(Succeed())
+		Expect(json.Unmarshal(env.Data, data)).To(Succeed())
+
+		return env.Meta
+	}
+
+	problemCode := func(response *http.Response) problem.Code {
+		Expect(response.Header.Get("Content-Type")).To(Equal(problem.ContentType))
+
+		var details problem.Problem
+		Expect(json.NewDecoder(response.Body).Decode(&details)).To(Succeed())
+
+		return details.Code
+	}
+
+	Context("Receiving request at the /api/v2/user/register endpoint", func() {
+		BeforeEach(func() {
+			route(http.MethodPost, "/api/v2/user/register", handler.UserRegistration)
+		})
+
+		When("the user is new", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				userRepository.EXPECT().CreateBalance(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			})
+
+			It("returns status 'Created' (201), the session and a cookie", func() {
+				response := send(http.MethodPost, "/api/v2/user/register", model.User{Login: login, Password: "password"})
+				Expect(response.StatusCode).To(Equal(http.StatusCreated))
+				Expect(response.Header.Get("Set-Cookie")).NotTo(BeEmpty())
+
+				var session apiv2.Session
+				Expect(decode(response, &session)).To(BeNil())
+				Expect(session.Login).To(Equal(login))
+			})
+		})
+
+		When("the login is already taken", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(repository.ErrConflict).Times(1)
+			})
+
+			It("returns status 'Conflict' (409) with the code of the problem", func() {
+				response := send(http.MethodPost, "/api/v2/user/register", model.User{Login: login, Password: "password"})
+				Expect(response.StatusCode).To(Equal(http.StatusConflict))
+				Expect(problemCode(response)).To(Equal(api.CodeLoginTaken))
+			})
+		})
+	})
+
+	Context("Receiving POST request at the /api/v2/user/orders endpoint", func() {
+		var expectOrder *model.Order
+
+		BeforeEach(func() {
+			route(http.MethodPost, "/api/v2/user/orders", handler.OrderNumberUpload)
+
+			expectOrder = &model.Order{
+				Login:      login,
+				Number:     "12345678903",
+				Status:     "NEW",
+				UploadedAt: time.Now(),
+			}
+		})
+
+		When("the order number is new", func() {
+			BeforeEach(func() {
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, nil).Times(1)
+			})
+
+			It("returns status 'Created' (201) and the upload result", func() {
+				response := send(http.MethodPost, "/api/v2/user/orders", model.OrderUpload{Number: "12345678903"})
+				Expect(response.StatusCode).To(Equal(http.StatusCreated))
+
+				var result model.OrderUploadResult
+				decode(response, &result)
+				Expect(result).To(Equal(model.OrderUploadResult{Number: "12345678903", Status: model.OrderUploadAccepted}))
+			})
+		})
+
+		When("the order number has been already uploaded by this user", func() {
+			BeforeEach(func() {
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, repository.ErrConflict).Times(1)
+			})
+
+			It("returns status 'Conflict' (409) instead of 'OK' (200) of API v1", func() {
+				response := send(http.MethodPost, "/api/v2/user/orders", model.OrderUpload{Number: "12345678903"})
+				Expect(response.StatusCode).To(Equal(http.StatusConflict))
+				Expect(problemCode(response)).To(Equal(apiv2.CodeOrderAlreadyUploaded))
+			})
+		})
+
+		When("the order number has been already uploaded by another user", func() {
+			BeforeEach(func() {
+				expectOrder.Login = "another user"
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(expectOrder, repository.ErrConflict).Times(1)
+			})
+
+			It("returns status 'Conflict' (409) with the code of API v1", func() {
+				response := send(http.MethodPost, "/api/v2/user/orders", model.OrderUpload{Number: "12345678903"})
+				Expect(response.StatusCode).To(Equal(http.StatusConflict))
+				Expect(problemCode(response)).To(Equal(api.CodeOrderUploadedByAnother))
+			})
+		})
+
+		When("the order number is invalid", func() {
+			It("returns status 'Unprocessable entity' (422)", func() {
+				response := send(http.MethodPost, "/api/v2/user/orders", model.OrderUpload{Number: "12345"})
+				Expect(response.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+
+	Context("Receiving GET request at the /api/v2/user/orders endpoint", func() {
+		BeforeEach(func() {
+			route(http.MethodGet, "/api/v2/user/orders", handler.OrderListRequest)
+		})
+
+		When("there are no orders", func() {
+			BeforeEach(func() {
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), model.Page{Limit: apiv2.DefaultPageLimit}).
+					Return(model.Orders{}, 0, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) with an empty list and pagination metadata", func() {
+				response := send(http.MethodGet, "/api/v2/user/orders", nil)
+				Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+				var orders model.Orders
+				page := decode(response, &orders)
+				Expect(orders).NotTo(BeNil())
+				Expect(orders).To(BeEmpty())
+				Expect(page).To(Equal(&apiv2.Page{Total: 0, Limit: apiv2.DefaultPageLimit, Offset: 0}))
+			})
+		})
+
+		When("a page of orders is requested", func() {
+			BeforeEach(func() {
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), model.Page{Limit: 1, Offset: 1}).Return(model.Orders{
+					{Login: login, Number: "2377225624", Status: "PROCESSED", Accrual: 500, UploadedAt: time.Now()},
+				}, 3, nil).Times(1)
+			})
+
+			It("returns the page and the total", func() {
+				response := send(http.MethodGet, "/api/v2/user/orders?limit=1&offset=1", nil)
+				Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+				var orders model.Orders
+				page := decode(response, &orders)
+				Expect(orders).To(HaveLen(1))
+				Expect(orders[0].Number).To(Equal("2377225624"))
+				Expect(page).To(Equal(&apiv2.Page{Total: 3, Limit: 1, Offset: 1}))
+			})
+		})
+
+		When("the limit is out of range", func() {
+			It("returns status 'Bad request' (400) with the invalid parameter", func() {
+				response := send(http.MethodGet, "/api/v2/user/orders?limit=1000", nil)
+				Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(problemCode(response)).To(Equal(problem.CodeValidationFailed))
+			})
+		})
+
+		When("the service fails", func() {
+			BeforeEach(func() {
+				orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, 0, errSomethingStrange).Times(1)
+			})
+
+			It("returns status 'Internal server error' (500)", func() {
+				response := send(http.MethodGet, "/api/v2/user/orders", nil)
+				Expect(response.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/v2/user/balance endpoint", func() {
+		BeforeEach(func() {
+			route(http.MethodGet, "/api/v2/user/balance", handler.UserBalanceRequest)
+
+			balanceRepository.EXPECT().GetBalance(gomock.Any(), gomock.Any()).Return(&model.Balance{Current: 500, Withdrawn: 42}, nil).Times(1)
+			balanceRepository.EXPECT().GetUpcomingExpirations(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+		})
+
+		It("returns status 'OK' (200) and the balance in the envelope", func() {
+			response := send(http.MethodGet, "/api/v2/user/balance", nil)
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+			var userBalance model.Balance
+			decode(response, &userBalance)
+			Expect(userBalance.Current).To(Equal(float64(500)))
+			Expect(userBalance.Withdrawn).To(Equal(float64(42)))
+		})
+	})
+
+	Context("Receiving request at the /api/v2/user/withdrawals endpoint", func() {
+		BeforeEach(func() {
+			route(http.MethodPost, "/api/v2/user/withdrawals", handler.WithdrawRequest)
+			route(http.MethodGet, "/api/v2/user/withdrawals", handler.WithdrawalsInformationRequest)
+		})
+
+		When("the balance is enough to withdraw", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			})
+
+			It("returns status 'Created' (201) and the withdrawal", func() {
+				response := send(http.MethodPost, "/api/v2/user/withdrawals", model.Withdrawal{OrderNumber: "2377225624", Sum: 751})
+				Expect(response.StatusCode).To(Equal(http.StatusCreated))
+
+				var result apiv2.WithdrawalResult
+				decode(response, &result)
+				Expect(result).To(Equal(apiv2.WithdrawalResult{OrderNumber: "2377225624", Sum: 751}))
+			})
+		})
+
+		When("the balance is not enough to withdraw", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(repository.ErrNegativeBalance).Times(1)
+			})
+
+			It("returns status 'Payment required' (402)", func() {
+				response := send(http.MethodPost, "/api/v2/user/withdrawals", model.Withdrawal{OrderNumber: "2377225624", Sum: 751})
+				Expect(response.StatusCode).To(Equal(http.StatusPaymentRequired))
+				Expect(problemCode(response)).To(Equal(api.CodeNotEnoughBalance))
+			})
+		})
+
+		When("the sum is negative", func() {
+			It("returns status 'Bad request' (400) without withdrawing", func() {
+				response := send(http.MethodPost, "/api/v2/user/withdrawals", model.Withdrawal{OrderNumber: "2377225624", Sum: -751})
+				Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(problemCode(response)).To(Equal(problem.CodeValidationFailed))
+			})
+		})
+
+		When("there are no withdrawals", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any(), model.Page{Limit: apiv2.DefaultPageLimit}).
+					Return(model.Withdrawals{}, 0, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) with an empty list instead of 'No content' (204) of API v1", func() {
+				response := send(http.MethodGet, "/api/v2/user/withdrawals", nil)
+				Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+				var withdrawals model.Withdrawals
+				page := decode(response, &withdrawals)
+				Expect(withdrawals).NotTo(BeNil())
+				Expect(withdrawals).To(BeEmpty())
+				Expect(page.Total).To(Equal(0))
+			})
+		})
+	})
+
+	Context("Receiving request at the /api/v2/user/transfers endpoint", func() {
+		BeforeEach(func() {
+			route(http.MethodPost, "/api/v2/user/transfers", handler.TransferRequest)
+			route(http.MethodGet, "/api/v2/user/transfers", handler.TransfersInformationRequest)
+		})
+
+		When("the transfer is made", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().Transfer(gomock.Any(), &model.Transfer{Sender: login, Recipient: "friend", Sum: 100}, cfg.TransferDailyLimit, gomock.Any(), gomock.Any()).
+					Return(&model.Transfer{Sender: login, Recipient: "friend", Sum: 100, TransferredAt: time.Now()}, nil).Times(1)
+			})
+
+			It("returns status 'Created' (201) and the transfer", func() {
+				response := send(http.MethodPost, "/api/v2/user/transfers", model.Transfer{Recipient: "friend", Sum: 100})
+				Expect(response.StatusCode).To(Equal(http.StatusCreated))
+
+				var transfer model.Transfer
+				decode(response, &transfer)
+				Expect(transfer.Sender).To(Equal(login))
+				Expect(transfer.Recipient).To(Equal("friend"))
+			})
+		})
+
+		When("the offset is beyond the list", func() {
+			BeforeEach(func() {
+				balanceRepository.EXPECT().GetListOfTransfers(gomock.Any(), gomock.Any(), model.Page{Limit: apiv2.DefaultPageLimit, Offset: 10}).
+					Return(model.Transfers{}, 1, nil).Times(1)
+			})
+
+			It("returns status 'OK' (200) with an empty page and the total", func() {
+				response := send(http.MethodGet, "/api/v2/user/transfers?offset=10", nil)
+				Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+				var transfers model.Transfers
+				page := decode(response, &transfers)
+				Expect(transfers).To(BeEmpty())
+				Expect(page).To(Equal(&apiv2.Page{Total: 1, Limit: apiv2.DefaultPageLimit, Offset: 10}))
+			})
+		})
+	})
+})
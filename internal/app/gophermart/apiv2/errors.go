@@ -0,0 +1,18 @@
+package apiv2
+
+import (
+	"net/http"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+)
+
+// Error codes of API v2, the other codes are shared with API v1.
+const (
+	CodeOrderAlreadyUploaded problem.Code = "order_already_uploaded"
+	CodeInvalidPage          problem.Code = "invalid_page"
+)
+
+var (
+	ErrOrderAlreadyUploaded = problem.New(http.StatusConflict, CodeOrderAlreadyUploaded, "Order number has already been uploaded by you")
+	ErrInvalidPage          = problem.New(http.StatusBadRequest, CodeInvalidPage, "Invalid pagination parameters")
+)
@@ -3,37 +3,63 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/grpcapi"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/server"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/audit"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/promotion"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/database"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Repository is the repository of all application services.
+type Repository interface {
+	user.Repository
+	order.Repository
+	balance.Repository
+	promotion.Repository
+	audit.Repository
+}
+
 // App struct of the application.
 type App struct {
-	cfg    *config.Config
-	server *http.Server
-
-	userService    user.Service
-	orderService   order.Service
-	balanceService balance.Service
-
-	balanceCancel context.CancelFunc
+	cfg        *config.Config
+	server     *http.Server
+	grpcServer *grpcapi.Server
+	dbpool     *pgxpool.Pool
+	lifecycle  *Lifecycle
+
+	// serverErr receives HTTP and gRPC servers errors occurred after start
+	serverErr chan error
+
+	userService      user.Service
+	orderService     order.Service
+	balanceService   balance.Service
+	promotionService promotion.Service
+	auditService     audit.Service
 }
 
 // New creates new application.
 func New() (*App, error) {
-	app := &App{}
+	app := &App{
+		lifecycle: NewLifecycle(),
+		serverErr: make(chan error, 2),
+	}
 
 	// Configuration initialization
 	err := app.initConfig()
@@ -59,6 +85,12 @@ func New() (*App, error) {
 		return nil, err
 	}
 
+	// gRPC server initialization
+	err = app.initGRPCServer()
+	if err != nil {
+		return nil, err
+	}
+
 	return app, nil
 }
 
@@ -75,7 +107,7 @@ func (a *App) initConfig() error {
 
 // initLogger initializes logger.
 func (a *App) initLogger() error {
-	err := logger.Initialize()
+	err := logger.Initialize(a.cfg)
 	if err != nil {
 		return err
 	}
@@ -89,13 +121,8 @@ func (a *App) initServices() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Create connection pool
-	dbpool, err := database.NewConnectionPool(ctx, a.cfg.DatabaseURI)
-	if err != nil {
-		return err
-	}
 	// Create repository
-	repo, err := repository.New(dbpool)
+	repo, err := a.initRepository(ctx)
 	if err != nil {
 		return err
 	}
@@ -103,84 +130,214 @@ func (a *App) initServices() error {
 	// Create user service
 	userService, err := user.NewService(repo, a.cfg)
 	if err != nil {
-		return nil
+		return err
 	}
 	a.userService = userService
 
 	// Create order service
 	orderService, err := order.NewService(repo, a.cfg)
 	if err != nil {
-		return nil
+		return err
 	}
 	a.orderService = orderService
 
 	// Create balance service
-	balanceCtx, balanceCancel := context.WithCancel(context.Background())
-	balanceService, err := balance.NewService(balanceCtx, repo, a.cfg, true)
+	balanceService, err := balance.NewService(repo, a.cfg)
 	if err != nil {
-		balanceCancel()
-		return nil
+		return err
 	}
 	a.balanceService = balanceService
-	a.balanceCancel = balanceCancel
+
+	// Create promotion service
+	promotionService, err := promotion.NewService(repo, a.cfg)
+	if err != nil {
+		return err
+	}
+	a.promotionService = promotionService
+
+	// Create audit service
+	auditService, err := audit.NewService(repo, a.cfg)
+	if err != nil {
+		return err
+	}
+	a.auditService = auditService
+
+	// Audit log is chained until order processing and servers have stopped, so its last entries are chained too
+	a.lifecycle.Append(Hook{
+		Name:        "audit log chaining",
+		OnStart:     a.auditService.Start,
+		OnStop:      a.auditService.Shutdown,
+		StopTimeout: a.cfg.ProcessingShutdownTimeout,
+	})
+
+	// Order processing must be drained before the pool is closed
+	a.lifecycle.Append(Hook{
+		Name:        "order processing",
+		OnStart:     a.balanceService.Start,
+		OnStop:      a.balanceService.Shutdown,
+		StopTimeout: a.cfg.ProcessingShutdownTimeout,
+	})
 
 	return nil
 }
 
+// initRepository creates the database repository or, if the database is not set, the in-memory repository.
+func (a *App) initRepository(ctx context.Context) (Repository, error) {
+	if a.cfg.DatabaseURI == "" {
+		slog.Warn("database URI is not set, data is kept in memory and will be lost on stop")
+		return repository.NewMemory()
+	}
+
+	// Create connection pool
+	dbpool, err := database.NewConnectionPool(ctx, a.cfg.DatabaseURI, !a.cfg.SkipMigrations)
+	if err != nil {
+		return nil, err
+	}
+	a.dbpool = dbpool
+
+	// The pool is the first to start and the last to stop
+	a.lifecycle.Append(Hook{
+		Name: "database",
+		OnStop: func(_ context.Context) error {
+			a.dbpool.Close()
+			return nil
+		},
+	})
+
+	return repository.New(dbpool, repository.NewRetryPolicy(a.cfg))
+}
+
 // initServer initializes HTTP server.
 func (a *App) initServer() error {
-	srvr, err := server.New(a.cfg, a.userService, a.orderService, a.balanceService)
+	srvr, err := server.New(a.cfg, server.Services{
+		User:      a.userService,
+		Order:     a.orderService,
+		Balance:   a.balanceService,
+		Promotion: a.promotionService,
+		Audit:     a.auditService,
+	})
 	if err != nil {
 		return err
 	}
 	a.server = srvr
 
+	// HTTP server is the last to start and the first to stop
+	a.lifecycle.Append(Hook{
+		Name:        "HTTP server",
+		OnStart:     a.startServer,
+		OnStop:      a.server.Shutdown,
+		StopTimeout: a.cfg.ServerShutdownTimeout,
+	})
+
 	return nil
 }
 
-// Run runs the application.
-func (a *App) Run() error {
-	return a.runApplication()
+// initGRPCServer initializes gRPC server, if its address is configured.
+func (a *App) initGRPCServer() error {
+	if a.cfg.GRPCAddress == "" {
+		return nil
+	}
+
+	srvr, err := grpcapi.New(a.cfg, server.Services{
+		User:      a.userService,
+		Order:     a.orderService,
+		Balance:   a.balanceService,
+		Promotion: a.promotionService,
+		Audit:     a.auditService,
+	})
+	if err != nil {
+		return err
+	}
+	a.grpcServer = srvr
+
+	// gRPC server is stopped together with HTTP server - before order processing
+	a.lifecycle.Append(Hook{
+		Name:        "gRPC server",
+		OnStart:     a.startGRPCServer,
+		OnStop:      a.grpcServer.Shutdown,
+		StopTimeout: a.cfg.ServerShutdownTimeout,
+	})
+
+	return nil
 }
 
-func (a *App) runApplication() error {
-	// Create channels for graceful shutdown
-	done := make(chan bool, 1)
-	quit := make(chan os.Signal, 1)
+// startGRPCServer starts listening the gRPC server address and serves requests in a goroutine.
+func (a *App) startGRPCServer(_ context.Context) error {
+	listener, err := net.Listen("tcp", a.grpcServer.Addr)
+	if err != nil {
+		return err
+	}
 
-	// Interrupt signal
-	signal.Notify(quit, os.Interrupt)
+	slog.Info("starting gRPC server", "addr", a.grpcServer.Addr)
 
-	// Graceful shutdown executes in a goroutine
 	go func() {
-		<-quit
+		if err := a.grpcServer.Serve(listener); err != nil {
+			a.serverErr <- err
+		}
+	}()
 
-		// Stopping order processing
-		slog.Info("stopping order processing")
-		a.balanceCancel()
-		
-		slog.Info("shutting down HTTP server")
+	return nil
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
+// startServer starts listening the server address and serves HTTP requests in a goroutine.
+func (a *App) startServer(_ context.Context) error {
+	// Listen synchronously to catch address errors on start
+	listener, err := net.Listen("tcp", a.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("starting HTTP server", "addr", a.server.Addr, "tls", a.server.TLSConfig != nil)
 
-		// Shutdown HTTP server
-		if err := a.server.Shutdown(ctx); err != nil {
-			slog.Error("HTTP server shutdown error", slog.String("error", err.Error()))
+	go func() {
+		var err error
+		if a.server.TLSConfig != nil {
+			// Certificate is provided by TLS configuration
+			err = a.server.ServeTLS(listener, "", "")
+		} else {
+			err = a.server.Serve(listener)
 		}
 
-		close(done)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.serverErr <- err
+		}
 	}()
 
-	slog.Info("starting HTTP server", "addr", a.server.Addr)
+	return nil
+}
 
-	// Run HTTP server
-	if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		slog.Error("HTTP server error", slog.String("error", err.Error()))
+// Run runs the application.
+func (a *App) Run() error {
+	return a.runApplication()
+}
+
+func (a *App) runApplication() error {
+	// Interrupt and termination signals start graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start all components
+	if err := a.lifecycle.Start(ctx); err != nil {
 		return err
 	}
 
-	<-done
-	slog.Info("HTTP server stopped")
-	return nil
+	// Wait for a signal or a server failure
+	var runErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("shutdown signal received")
+	case runErr = <-a.serverErr:
+		slog.Error("server error", slog.String("error", runErr.Error()))
+	}
+
+	// Restore default signal behaviour, so the second signal terminates the application immediately
+	stop()
+
+	// Stop all components
+	if err := a.lifecycle.Stop(context.Background()); err != nil {
+		return fmt.Errorf("%w: %w", ErrUncleanShutdown, err)
+	}
+
+	slog.Info("application stopped")
+	return runErr
 }
@@ -0,0 +1,346 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	orderpkg "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/order"
+	pb "github.com/RomanAgaltsev/ya_gophermart/pkg/pb/gophermart"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// orderWatchInterval is the interval of order statuses polling for watchers.
+const orderWatchInterval = 2 * time.Second
+
+const (
+	argError = "error"
+
+	msgNewJWTToken       = "new JWT token"
+	msgUserRegistration  = "user registration"
+	msgUserLogin         = "user login"
+	msgOrderNumberUpload = "order number upload"
+	msgOrderList         = "get orders list"
+	msgUserBalance       = "user balance request"
+	msgWithdraw          = "withdraw request"
+	msgUserWithdrawals   = "user withdrawals request"
+	msgOrderWatch        = "order watch"
+)
+
+var (
+	errBadRequest                  = status.Error(codes.InvalidArgument, "Bad request")
+	errInvalidOrderNumber          = status.Error(codes.InvalidArgument, "Invalid order number")
+	errLoginIsAlreadyTaken         = status.Error(codes.AlreadyExists, "Login has already been taken")
+	errWrongLoginPassword          = status.Error(codes.Unauthenticated, "Wrong login/password")
+	errOrderUploadedByAnotherLogin = status.Error(codes.AlreadyExists,
+		"Order number has already been uploaded by another user")
+	errNotEnoughBalance = status.Error(codes.FailedPrecondition, "Not enough balance for withdrawal")
+	errInternal         = status.Error(codes.Internal, "Internal server error")
+	errShuttingDown     = status.Error(codes.Unavailable, "Server is shutting down")
+)
+
+// Handler handles all gRPC requests with the same services as the REST API.
+type Handler struct {
+	pb.UnimplementedGophermartServer
+
+	cfg *config.Config
+
+	userService    user.Service
+	orderService   order.Service
+	balanceService balance.Service
+
+	// shutdown is closed when the server is shutting down to end order watchers
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+// NewHandler is a Handler constructor.
+func NewHandler(cfg *config.Config, userService user.Service, orderService order.Service, balanceService balance.Service) *Handler {
+	return &Handler{
+		cfg:            cfg,
+		userService:    userService,
+		orderService:   orderService,
+		balanceService: balanceService,
+		shutdown:       make(chan struct{}),
+	}
+}
+
+// Register handles user registration request.
+func (h *Handler) Register(ctx context.Context, req *pb.Credentials) (*pb.AuthResponse, error) {
+	usr := model.User{
+		Login:    req.GetLogin(),
+		Password: req.GetPassword(),
+	}
+
+	// Validate user the same way as REST API does
+	if err := usr.Bind(nil); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Register user
+	err := h.userService.Register(ctx, &usr)
+	if errors.Is(err, user.ErrLoginIsAlreadyTaken) {
+		logger.FromContext(ctx).Info(msgUserRegistration, argError, err.Error())
+		return nil, errLoginIsAlreadyTaken
+	}
+	if err != nil {
+		logger.FromContext(ctx).Info(msgUserRegistration, argError, err.Error())
+		return nil, errInternal
+	}
+
+	return h.authResponse(ctx, &usr)
+}
+
+// Login handles user login request.
+func (h *Handler) Login(ctx context.Context, req *pb.Credentials) (*pb.AuthResponse, error) {
+	usr := model.User{
+		Login:    req.GetLogin(),
+		Password: req.GetPassword(),
+	}
+
+	if err := usr.Bind(nil); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Login user
+	err := h.userService.Login(ctx, &usr)
+	if errors.Is(err, user.ErrWrongLoginPassword) {
+		logger.FromContext(ctx).Info(msgUserLogin, argError, err.Error())
+		return nil, errWrongLoginPassword
+	}
+	if err != nil {
+		logger.FromContext(ctx).Info(msgUserLogin, argError, err.Error())
+		return nil, errInternal
+	}
+
+	return h.authResponse(ctx, &usr)
+}
+
+// UploadOrder handles order number upload request.
+func (h *Handler) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	// Check if the order number is empty
+	if req.GetNumber() == "" {
+		return nil, errBadRequest
+	}
+
+	// Check if the order number is valid with Luhn algorithm
+	if !orderpkg.IsNumberValid(req.GetNumber()) {
+		return nil, errInvalidOrderNumber
+	}
+
+	usr, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create order with order service
+	err = h.orderService.Create(ctx, &model.Order{
+		Login:  usr.Login,
+		Number: req.GetNumber(),
+	})
+	if errors.Is(err, order.ErrOrderUploadedByThisLogin) {
+		return &pb.UploadOrderResponse{AlreadyUploaded: true}, nil
+	}
+	if errors.Is(err, order.ErrOrderUploadedByAnotherLogin) {
+		logger.FromContext(ctx).Info(msgOrderNumberUpload, argError, err.Error())
+		return nil, errOrderUploadedByAnotherLogin
+	}
+	if err != nil {
+		logger.FromContext(ctx).Info(msgOrderNumberUpload, argError, err.Error())
+		return nil, errInternal
+	}
+
+	return &pb.UploadOrderResponse{}, nil
+}
+
+// ListOrders handles order list request.
+func (h *Handler) ListOrders(ctx context.Context, _ *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	usr, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a list of user orders with order service
+	orders, err := h.orderService.UserOrders(ctx, usr)
+	if err != nil {
+		logger.FromContext(ctx).Info(msgOrderList, argError, err.Error())
+		return nil, errInternal
+	}
+
+	resp := &pb.ListOrdersResponse{
+		Orders: make([]*pb.Order, 0, len(orders)),
+	}
+	for _, ordr := range orders {
+		resp.Orders = append(resp.Orders, orderToPB(ordr))
+	}
+
+	return resp, nil
+}
+
+// GetBalance handles user balance request.
+func (h *Handler) GetBalance(ctx context.Context, _ *pb.GetBalanceRequest) (*pb.Balance, error) {
+	usr, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get user balance with balance service
+	userBalance, err := h.balanceService.Get(ctx, usr)
+	if err != nil {
+		logger.FromContext(ctx).Info(msgUserBalance, argError, err.Error())
+		return nil, errInternal
+	}
+
+	return &pb.Balance{
+		Current:   userBalance.Current,
+		Withdrawn: userBalance.Withdrawn,
+	}, nil
+}
+
+// Withdraw handles withdraw from user balance request.
+func (h *Handler) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*pb.WithdrawResponse, error) {
+	withdrawal := model.Withdrawal{
+		OrderNumber: req.GetOrder(),
+		Sum:         req.GetSum(),
+	}
+
+	if err := withdrawal.Bind(nil); err != nil {
+		return nil, errBadRequest
+	}
+
+	// Check if order number is valid with Luhn algorithm
+	if !orderpkg.IsNumberValid(withdrawal.OrderNumber) {
+		return nil, errInvalidOrderNumber
+	}
+
+	usr, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Register withdraw from user balance with balance service
+	err = h.balanceService.Withdraw(ctx, usr, withdrawal.OrderNumber, withdrawal.Sum)
+	if errors.Is(err, balance.ErrNotEnoughBalance) {
+		logger.FromContext(ctx).Info(msgWithdraw, argError, err.Error())
+		return nil, errNotEnoughBalance
+	}
+	if err != nil {
+		logger.FromContext(ctx).Info(msgWithdraw, argError, err.Error())
+		return nil, errInternal
+	}
+
+	return &pb.WithdrawResponse{}, nil
+}
+
+// ListWithdrawals handles list of user withdrawals request.
+func (h *Handler) ListWithdrawals(ctx context.Context, _ *pb.ListWithdrawalsRequest) (*pb.ListWithdrawalsResponse, error) {
+	usr, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a list of user withdrawals
+	withdrawals, err := h.balanceService.Withdrawals(ctx, usr)
+	if err != nil {
+		logger.FromContext(ctx).Info(msgUserWithdrawals, argError, err.Error())
+		return nil, errInternal
+	}
+
+	resp := &pb.ListWithdrawalsResponse{
+		Withdrawals: make([]*pb.Withdrawal, 0, len(withdrawals)),
+	}
+	for _, withdrawal := range withdrawals {
+		resp.Withdrawals = append(resp.Withdrawals, &pb.Withdrawal{
+			Order:       withdrawal.OrderNumber,
+			Sum:         withdrawal.Sum,
+			ProcessedAt: timestamppb.New(withdrawal.ProcessedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+// WatchOrders sends all user orders and then every change of their statuses or accruals.
+func (h *Handler) WatchOrders(_ *pb.WatchOrdersRequest, stream pb.Gophermart_WatchOrdersServer) error {
+	ctx := stream.Context()
+
+	usr, err := userFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Last sent state of every order
+	sent := make(map[string]*model.Order)
+
+	ticker := time.NewTicker(orderWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		orders, err := h.orderService.UserOrders(ctx, usr)
+		if err != nil {
+			logger.FromContext(ctx).Info(msgOrderWatch, argError, err.Error())
+			return errInternal
+		}
+
+		// Send new and changed orders only
+		for _, ordr := range orders {
+			prev, ok := sent[ordr.Number]
+			if ok && prev.Status == ordr.Status && prev.Accrual == ordr.Accrual {
+				continue
+			}
+
+			if err := stream.Send(orderToPB(ordr)); err != nil {
+				return err
+			}
+			sent[ordr.Number] = ordr
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.shutdown:
+			return errShuttingDown
+		case <-ticker.C:
+		}
+	}
+}
+
+// close ends all order watchers.
+func (h *Handler) close() {
+	h.shutdownOnce.Do(func() {
+		close(h.shutdown)
+	})
+}
+
+// authResponse generates JWT token for the user session.
+func (h *Handler) authResponse(ctx context.Context, usr *model.User) (*pb.AuthResponse, error) {
+	ja := auth.NewAuth(h.cfg.SecretKey)
+	_, tokenString, err := auth.NewJWTToken(ja, usr)
+	if err != nil {
+		logger.FromContext(ctx).Info(msgNewJWTToken, argError, err.Error())
+		return nil, errInternal
+	}
+
+	return &pb.AuthResponse{Token: tokenString}, nil
+}
+
+// orderToPB converts order to its protobuf message.
+func orderToPB(ordr *model.Order) *pb.Order {
+	return &pb.Order{
+		Number:     ordr.Number,
+		Status:     string(ordr.Status),
+		Accrual:    ordr.Accrual,
+		UploadedAt: timestamppb.New(ordr.UploadedAt),
+	}
+}
@@ -0,0 +1,355 @@
+package grpcapi_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/grpcapi"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/server"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	balanceMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/balance"
+	orderMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/order"
+	userMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	pb "github.com/RomanAgaltsev/ya_gophermart/pkg/pb/gophermart"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var _ = Describe("gRPC API", func() {
+	var (
+		errSomethingStrange error
+
+		ctx     context.Context
+		authCtx context.Context
+
+		cfg *config.Config
+
+		userRepository    *userMocks.MockRepository
+		orderRepository   *orderMocks.MockRepository
+		balanceRepository *balanceMocks.MockRepository
+
+		srvr   *grpcapi.Server
+		conn   *grpc.ClientConn
+		client pb.GophermartClient
+
+		login string
+
+		expectSession func()
+	)
+
+	BeforeEach(func() {
+		errSomethingStrange = errors.New("something strange")
+		ctx = context.Background()
+		login = "user"
+
+		cfg = &config.Config{
+			GRPCAddress: "bufconn",
+			SecretKey:   "secret",
+		}
+
+		// Services with mocked repositories
+		userRepository = userMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+
+		// Units of work run the function with the same mocked repository
+		userRepository.EXPECT().WithinTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, f func(ctx context.Context) error) error { return f(ctx) }).AnyTimes()
+
+		orderRepository = orderMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+		balanceRepository = balanceMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+
+		userService, err := user.NewService(userRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		orderService, err := order.NewService(orderRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		balanceService, err := balance.NewService(balanceRepository, cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Server on in-memory listener
+		srvr, err = grpcapi.New(cfg, server.Services{
+			User:    userService,
+			Order:   orderService,
+			Balance: balanceService,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		listener := bufconn.Listen(1024 * 1024)
+		go func() { _ = srvr.Serve(listener) }()
+
+		conn, err = grpc.NewClient("passthrough:///bufconn",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		client = pb.NewGophermartClient(conn)
+
+		// Context with authentication token
+		_, tokenString, err := auth.NewJWTToken(auth.NewAuth(cfg.SecretKey), &model.User{ID: 1, Login: login})
+		Expect(err).NotTo(HaveOccurred())
+		authCtx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tokenString)
+
+		// Session of the token is checked on every authenticated call
+		expectSession = func() {
+			userRepository.EXPECT().GetUser(gomock.Any(), login).Return(&model.User{ID: 1, Login: login}, nil).AnyTimes()
+		}
+	})
+
+	AfterEach(func() {
+		_ = conn.Close()
+		_ = srvr.Shutdown(ctx)
+	})
+
+	Context("Calling Register", func() {
+		When("the user doesn't exist", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				userRepository.EXPECT().CreateBalance(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			})
+
+			It("returns a token of the user", func() {
+				resp, err := client.Register(ctx, &pb.Credentials{Login: login, Password: "password"})
+				Expect(err).NotTo(HaveOccurred())
+
+				usr, err := auth.UserFromToken(resp.GetToken(), cfg.SecretKey)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr.Login).To(Equal(login))
+			})
+		})
+
+		When("the login has already been taken", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(repository.ErrConflict).Times(1)
+			})
+
+			It("returns AlreadyExists", func() {
+				_, err := client.Register(ctx, &pb.Credentials{Login: login, Password: "password"})
+				Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+			})
+		})
+
+		When("the password is empty", func() {
+			It("returns InvalidArgument", func() {
+				_, err := client.Register(ctx, &pb.Credentials{Login: login})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+	})
+
+	Context("Calling Login", func() {
+		When("the password is wrong", func() {
+			BeforeEach(func() {
+				hash, err := auth.HashPassword("password")
+				Expect(err).NotTo(HaveOccurred())
+
+				userRepository.EXPECT().GetUser(gomock.Any(), login).Return(&model.User{Login: login, Password: hash}, nil).Times(1)
+				userRepository.EXPECT().AppendAudit(gomock.Any(), login, model.AuditUserLoginFailed).Return(nil).Times(1)
+			})
+
+			It("returns Unauthenticated", func() {
+				_, err := client.Login(ctx, &pb.Credentials{Login: login, Password: "wrong password"})
+				Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+			})
+		})
+	})
+
+	Context("Calling UploadOrder", func() {
+		When("there is no token", func() {
+			It("returns Unauthenticated", func() {
+				_, err := client.UploadOrder(ctx, &pb.UploadOrderRequest{Number: "12345678903"})
+				Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+			})
+		})
+
+		When("the session has been revoked", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().GetUser(gomock.Any(), login).Return(&model.User{ID: 1, Login: login, SessionVersion: 1}, nil).Times(1)
+			})
+
+			It("returns Unauthenticated", func() {
+				_, err := client.UploadOrder(authCtx, &pb.UploadOrderRequest{Number: "12345678903"})
+				Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+			})
+		})
+
+		When("the user has been deleted and the login has been registered again", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().GetUser(gomock.Any(), login).Return(&model.User{ID: 2, Login: login}, nil).Times(1)
+			})
+
+			It("returns Unauthenticated", func() {
+				_, err := client.UploadOrder(authCtx, &pb.UploadOrderRequest{Number: "12345678903"})
+				Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+			})
+		})
+
+		When("the order number is invalid", func() {
+			BeforeEach(func() {
+				expectSession()
+			})
+
+			It("returns InvalidArgument", func() {
+				_, err := client.UploadOrder(authCtx, &pb.UploadOrderRequest{Number: "12345678904"})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+
+		When("the order has been already uploaded by this user", func() {
+			BeforeEach(func() {
+				expectSession()
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).
+					Return(&model.Order{Login: login, Number: "12345678903"}, repository.ErrConflict).Times(1)
+			})
+
+			It("reports the order as already uploaded", func() {
+				resp, err := client.UploadOrder(authCtx, &pb.UploadOrderRequest{Number: "12345678903"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetAlreadyUploaded()).To(BeTrue())
+			})
+		})
+
+		When("something has gone wrong with the service", func() {
+			BeforeEach(func() {
+				expectSession()
+				orderRepository.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(nil, errSomethingStrange).Times(1)
+			})
+
+			It("returns Internal without the error details", func() {
+				_, err := client.UploadOrder(authCtx, &pb.UploadOrderRequest{Number: "12345678903"})
+				Expect(status.Code(err)).To(Equal(codes.Internal))
+				Expect(err.Error()).NotTo(ContainSubstring(errSomethingStrange.Error()))
+			})
+		})
+	})
+
+	Context("Calling Withdraw", func() {
+		When("the balance is not enough", func() {
+			BeforeEach(func() {
+				expectSession()
+				balanceRepository.EXPECT().WithdrawFromBalance(gomock.Any(), gomock.Any(), "2377225624", float64(751)).
+					Return(repository.ErrNegativeBalance).Times(1)
+			})
+
+			It("returns FailedPrecondition", func() {
+				_, err := client.Withdraw(authCtx, &pb.WithdrawRequest{Order: "2377225624", Sum: 751})
+				Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+			})
+		})
+
+		When("the sum is negative", func() {
+			BeforeEach(func() {
+				expectSession()
+			})
+
+			It("returns InvalidArgument", func() {
+				_, err := client.Withdraw(authCtx, &pb.WithdrawRequest{Order: "2377225624", Sum: -100})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+	})
+
+	Context("Calling GetBalance", func() {
+		BeforeEach(func() {
+			expectSession()
+			balanceRepository.EXPECT().GetBalance(gomock.Any(), &model.User{ID: 1, Login: login}).
+				Return(&model.Balance{Current: 500, Withdrawn: 42}, nil).Times(1)
+			balanceRepository.EXPECT().GetUpcomingExpirations(gomock.Any(), &model.User{ID: 1, Login: login}, gomock.Any()).
+				Return(nil, nil).Times(1)
+		})
+
+		It("returns the balance of the authenticated user", func() {
+			resp, err := client.GetBalance(authCtx, &pb.GetBalanceRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetCurrent()).To(Equal(float64(500)))
+			Expect(resp.GetWithdrawn()).To(Equal(float64(42)))
+		})
+	})
+
+	Context("Calling with a token issued before user IDs", func() {
+		var legacyCtx context.Context
+
+		BeforeEach(func() {
+			_, tokenString, err := auth.NewAuth(cfg.SecretKey).Encode(map[string]interface{}{string(auth.UserLoginClaimName): login})
+			Expect(err).NotTo(HaveOccurred())
+			legacyCtx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tokenString)
+		})
+
+		When("the account has been created before user IDs", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().GetUser(gomock.Any(), login).Return(&model.User{ID: 1, Login: login}, nil).Times(1)
+				balanceRepository.EXPECT().GetBalance(gomock.Any(), &model.User{ID: 1, Login: login}).
+					Return(&model.Balance{Current: 500}, nil).Times(1)
+				balanceRepository.EXPECT().GetUpcomingExpirations(gomock.Any(), &model.User{ID: 1, Login: login}, gomock.Any()).
+					Return(nil, nil).Times(1)
+			})
+
+			It("accepts the token by login", func() {
+				resp, err := client.GetBalance(legacyCtx, &pb.GetBalanceRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetCurrent()).To(Equal(float64(500)))
+			})
+		})
+
+		When("the account has been created after user IDs", func() {
+			BeforeEach(func() {
+				userRepository.EXPECT().GetUser(gomock.Any(), login).Return(&model.User{ID: 2, Login: login, SessionVersion: 1}, nil).Times(1)
+			})
+
+			It("returns Unauthenticated", func() {
+				_, err := client.GetBalance(legacyCtx, &pb.GetBalanceRequest{})
+				Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+			})
+		})
+	})
+
+	Context("Calling WatchOrders", func() {
+		BeforeEach(func() {
+			expectSession()
+			orderRepository.EXPECT().GetListOfOrders(gomock.Any(), gomock.Any(), model.Page{}).
+				Return(model.Orders{{Login: login, Number: "12345678903", Status: "NEW", UploadedAt: time.Now()}}, 1, nil).
+				AnyTimes()
+		})
+
+		It("streams the orders of the user", func() {
+			stream, err := client.WatchOrders(authCtx, &pb.WatchOrdersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			ordr, err := stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ordr.GetNumber()).To(Equal("12345678903"))
+			Expect(ordr.GetStatus()).To(Equal("NEW"))
+		})
+
+		It("ends the stream on shutdown", func() {
+			stream, err := client.WatchOrders(authCtx, &pb.WatchOrdersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(srvr.Shutdown(ctx)).To(Succeed())
+
+			_, err = stream.Recv()
+			Expect(status.Code(err)).To(Equal(codes.Unavailable))
+		})
+	})
+})
@@ -0,0 +1,219 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/server"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	pb "github.com/RomanAgaltsev/ya_gophermart/pkg/pb/gophermart"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// authorizationHeader is the metadata key of authentication token.
+	authorizationHeader = "authorization"
+	// requestIDHeader is the metadata key of request ID.
+	requestIDHeader = "x-request-id"
+)
+
+var (
+	ErrGRPCAddressIsEmpty = fmt.Errorf("configuration: gRPC server address is empty")
+
+	errUnauthenticated = status.Error(codes.Unauthenticated, "Absent or invalid authentication token")
+)
+
+// publicMethods contains methods available without authentication.
+var publicMethods = map[string]bool{
+	pb.Gophermart_Register_FullMethodName: true,
+	pb.Gophermart_Login_FullMethodName:    true,
+}
+
+// userContextKey is the context key of authenticated user.
+type userContextKey struct{}
+
+// Server is the gRPC server.
+type Server struct {
+	Addr string
+
+	server  *grpc.Server
+	handler *Handler
+}
+
+// New creates new gRPC server with authentication and registered handler, it is wired with the services of HTTP server.
+func New(cfg *config.Config, services server.Services) (*Server, error) {
+	if cfg.GRPCAddress == "" {
+		return nil, ErrGRPCAddressIsEmpty
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unarySourceInterceptor, unaryAuthInterceptor(cfg.SecretKey, services.User)),
+		grpc.ChainStreamInterceptor(streamSourceInterceptor, streamAuthInterceptor(cfg.SecretKey, services.User)),
+	}
+
+	// Use the same certificate as HTTP server does
+	if cfg.TLSEnabled() {
+		tlsConfig, err := server.NewTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	handler := NewHandler(cfg, services.User, services.Order, services.Balance)
+
+	srvr := grpc.NewServer(opts...)
+	pb.RegisterGophermartServer(srvr, handler)
+
+	return &Server{
+		Addr:    cfg.GRPCAddress,
+		server:  srvr,
+		handler: handler,
+	}, nil
+}
+
+// Serve accepts incoming connections on the listener.
+func (s *Server) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Shutdown ends order watchers and stops the server gracefully.
+// If the context is done before all requests are finished, the server is stopped immediately.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.handler.close()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// unarySourceInterceptor puts the source of unary requests to the context of audited actions.
+func unarySourceInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withSource(ctx), req)
+}
+
+// streamSourceInterceptor puts the source of streaming requests to the context of audited actions.
+func streamSourceInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withSource(ss.Context())})
+}
+
+// withSource puts the request ID from metadata and the client IP to the context of audited actions.
+// The request ID is generated, if it is absent, and is added to the request-scoped logger.
+func withSource(ctx context.Context) context.Context {
+	var source audit.Source
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDHeader); len(values) > 0 {
+			source.RequestID = values[0]
+		}
+	}
+	source.RequestID = logger.EnsureRequestID(source.RequestID)
+	ctx = logger.WithRequestID(ctx, source.RequestID)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		source.IP = audit.HostIP(p.Addr.String())
+	}
+
+	return audit.WithSource(ctx, source)
+}
+
+// unaryAuthInterceptor authenticates unary requests to protected methods.
+func unaryAuthInterceptor(secretKey string, validator auth.SessionValidator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if publicMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticate(ctx, secretKey, validator)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// streamAuthInterceptor authenticates streaming requests.
+func streamAuthInterceptor(secretKey string, validator auth.SessionValidator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), secretKey, validator)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// contextStream replaces stream context with the one containing the request source or authenticated user.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the replaced stream context.
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate gets user from the token in metadata and puts it into the context.
+// Tokens of revoked sessions are rejected.
+func authenticate(ctx context.Context, secretKey string, validator auth.SessionValidator) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return nil, errUnauthenticated
+	}
+
+	// Token may be passed with or without Bearer scheme
+	tokenString := strings.TrimSpace(values[0])
+	if len(tokenString) > 7 && strings.EqualFold(tokenString[:7], "bearer ") {
+		tokenString = strings.TrimSpace(tokenString[7:])
+	}
+
+	usr, err := auth.UserFromToken(tokenString, secretKey)
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+
+	if err := validator.ValidateSession(ctx, usr); err != nil {
+		return nil, errUnauthenticated
+	}
+
+	return context.WithValue(logger.With(ctx, logger.LoginAttr, usr.Login), userContextKey{}, usr), nil
+}
+
+// userFromContext returns authenticated user from the context.
+func userFromContext(ctx context.Context) (*model.User, error) {
+	usr, ok := ctx.Value(userContextKey{}).(*model.User)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+
+	return usr, nil
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrUncleanShutdown - shutdown error, when some of the components haven't stopped properly.
+var ErrUncleanShutdown = fmt.Errorf("unclean shutdown")
+
+// Hook contains start and stop functions of an application component.
+type Hook struct {
+	Name        string                          // Component name
+	OnStart     func(ctx context.Context) error // Component start function, can be nil
+	OnStop      func(ctx context.Context) error // Component stop function, can be nil
+	StopTimeout time.Duration                   // Time given to the component to stop, zero means no own limit
+}
+
+// Lifecycle starts and stops application components in dependency order.
+type Lifecycle struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+}
+
+// NewLifecycle creates new lifecycle manager.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Append registers a component hook.
+// Components must be appended in dependency order - dependencies go first.
+func (l *Lifecycle) Append(hook Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start starts components in the order of registration.
+// If a component fails to start, already started components are stopped.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.started < len(l.hooks) {
+		hook := l.hooks[l.started]
+
+		if hook.OnStart != nil {
+			slog.Info("starting component", "name", hook.Name)
+
+			if err := hook.OnStart(ctx); err != nil {
+				slog.Error("component start", "name", hook.Name, "error", err.Error())
+				return errors.Join(fmt.Errorf("%s: %w", hook.Name, err), l.stop(context.WithoutCancel(ctx)))
+			}
+		}
+
+		l.started++
+	}
+
+	return nil
+}
+
+// Stop stops started components in the reverse order of registration.
+// Every component gets its chance to stop, even if some of the previous ones have failed.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stop(ctx)
+}
+
+// stop stops started components, the lock must be held by the caller.
+func (l *Lifecycle) stop(ctx context.Context) error {
+	var errs []error
+
+	for ; l.started > 0; l.started-- {
+		hook := l.hooks[l.started-1]
+
+		if hook.OnStop == nil {
+			continue
+		}
+
+		slog.Info("stopping component", "name", hook.Name)
+
+		// Limit the component stop time if needed
+		stopCtx, cancel := ctx, context.CancelFunc(func() {})
+		if hook.StopTimeout > 0 {
+			stopCtx, cancel = context.WithTimeout(ctx, hook.StopTimeout)
+		}
+
+		err := hook.OnStop(stopCtx)
+		cancel()
+
+		if err != nil {
+			slog.Error("component stop", "name", hook.Name, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", hook.Name, err))
+			continue
+		}
+
+		slog.Info("component stopped", "name", hook.Name)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,119 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lifecycle", func() {
+	var (
+		ctx context.Context
+
+		lc    *app.Lifecycle
+		calls []string
+
+		errSomethingStrange error
+	)
+
+	// hook creates a hook, which records its start and stop calls
+	hook := func(name string, errStart, errStop error) app.Hook {
+		return app.Hook{
+			Name: name,
+			OnStart: func(_ context.Context) error {
+				calls = append(calls, "start "+name)
+				return errStart
+			},
+			OnStop: func(_ context.Context) error {
+				calls = append(calls, "stop "+name)
+				return errStop
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		lc = app.NewLifecycle()
+		calls = nil
+		errSomethingStrange = errors.New("something strange")
+	})
+
+	When("all components start and stop successfully", func() {
+		BeforeEach(func() {
+			lc.Append(hook("database", nil, nil))
+			lc.Append(hook("processing", nil, nil))
+			lc.Append(hook("server", nil, nil))
+		})
+
+		It("starts components in order and stops them in reverse order", func() {
+			Expect(lc.Start(ctx)).To(Succeed())
+			Expect(lc.Stop(ctx)).To(Succeed())
+			Expect(calls).To(Equal([]string{
+				"start database", "start processing", "start server",
+				"stop server", "stop processing", "stop database",
+			}))
+		})
+
+		It("stops components only once", func() {
+			Expect(lc.Start(ctx)).To(Succeed())
+			Expect(lc.Stop(ctx)).To(Succeed())
+			Expect(lc.Stop(ctx)).To(Succeed())
+			Expect(calls).To(HaveLen(6))
+		})
+	})
+
+	When("a component fails to start", func() {
+		BeforeEach(func() {
+			lc.Append(hook("database", nil, nil))
+			lc.Append(hook("processing", errSomethingStrange, nil))
+			lc.Append(hook("server", nil, nil))
+		})
+
+		It("returns an error and stops already started components", func() {
+			err := lc.Start(ctx)
+			Expect(err).To(MatchError(errSomethingStrange))
+			Expect(calls).To(Equal([]string{
+				"start database", "start processing", "stop database",
+			}))
+		})
+	})
+
+	When("a component fails to stop", func() {
+		BeforeEach(func() {
+			lc.Append(hook("database", nil, nil))
+			lc.Append(hook("processing", nil, errSomethingStrange))
+			lc.Append(hook("server", nil, nil))
+		})
+
+		It("stops the rest of components and returns an error", func() {
+			Expect(lc.Start(ctx)).To(Succeed())
+
+			err := lc.Stop(ctx)
+			Expect(err).To(MatchError(errSomethingStrange))
+			Expect(calls).To(ContainElement("stop database"))
+		})
+	})
+
+	When("a component doesn't stop in time", func() {
+		BeforeEach(func() {
+			lc.Append(app.Hook{
+				Name: "processing",
+				OnStop: func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+				StopTimeout: 10 * time.Millisecond,
+			})
+		})
+
+		It("returns deadline exceeded error", func() {
+			Expect(lc.Start(ctx)).To(Succeed())
+			Expect(lc.Stop(ctx)).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+})
@@ -4,13 +4,22 @@ import (
 	"fmt"
 	"net/http"
 
+	openapispec "github.com/RomanAgaltsev/ya_gophermart/api"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/api"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/apiv2"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/audit"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/promotion"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+	auditpkg "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/openapi"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/timezone"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -25,20 +34,38 @@ const (
 
 var ErrRunAddressIsEmpty = fmt.Errorf("configuration: HTTP server run address is empty")
 
+// Services are the services, which the server handlers use.
+type Services struct {
+	User      user.Service
+	Order     order.Service
+	Balance   balance.Service
+	Promotion promotion.Service
+	Audit     audit.Service
+}
+
 // New creates new http server with middleware and routes.
-func New(cfg *config.Config, userService user.Service, orderService order.Service, balanceService balance.Service) (*http.Server, error) {
+func New(cfg *config.Config, services Services) (*http.Server, error) {
 	if cfg.RunAddress == "" {
 		return nil, ErrRunAddressIsEmpty
 	}
 
-	// Create handler
-	handle := api.NewHandler(cfg, userService, orderService, balanceService)
+	// Create handlers, API v2 shares services with API v1
+	handle := api.NewHandler(cfg, services.User, services.Order, services.Balance, services.Promotion, services.Audit)
+	handleV2 := apiv2.NewHandler(cfg, services.User, services.Order, services.Balance)
+
+	// Create OpenAPI validator, it is used in route groups after authentication
+	validator, err := openapi.NewValidator(openapispec.Spec, cfg.ValidateResponses)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create router
 	router := chi.NewRouter()
 
 	// Enable common middleware
-	router.Use(logger.NewRequestLogger())
+	router.Use(logger.RequestID)
+	router.Use(auditpkg.Middleware)
+	router.Use(logger.NewRequestLogger(cfg.LogRequestBodies))
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.Compress(5, ContentTypeJSON, ContentTypeText))
 	router.Use(render.SetContentType(render.ContentTypeJSON))
@@ -53,6 +80,8 @@ func New(cfg *config.Config, userService user.Service, orderService order.Servic
 
 	// Public routes
 	router.Group(func(r chi.Router) {
+		r.Use(validator.Middleware)
+
 		r.Post("/api/user/register", handle.UserRegistrion)
 		r.Post("/api/user/login", handle.UserLogin)
 	})
@@ -60,28 +89,125 @@ func New(cfg *config.Config, userService user.Service, orderService order.Servic
 	router.Group(func(r chi.Router) {
 		tokenAuth := auth.NewAuth(cfg.SecretKey)
 		r.Use(jwtauth.Verifier(tokenAuth))
-		r.Use(jwtauth.Authenticator(tokenAuth))
+		r.Use(auth.Authenticator)
+		r.Use(auth.SessionAuthenticator(cfg.SecretKey, services.User))
+		r.Use(requestTimezone)
+		r.Use(validator.Middleware)
 
+		r.Put("/api/user/password", handle.UserPasswordChange)
+		r.Put("/api/user/timezone", handle.UserTimezoneChange)
+		r.Get("/api/user/export", handle.UserDataExport)
+		r.Delete("/api/user", handle.UserDelete)
 		r.Post("/api/user/orders", handle.OrderNumberUpload)
+		r.Post("/api/user/orders/batch", handle.OrderBatchUpload)
 		r.Get("/api/user/orders", handle.OrderListRequest)
 		r.Get("/api/user/balance", handle.UserBalanceRequest)
+		r.Get("/api/user/tier", handle.UserTierRequest)
 		r.Post("/api/user/balance/withdraw", handle.WithdrawRequest)
 		r.Get("/api/user/withdrawals", handle.WithdrawalsInformationRequest)
+		r.Post("/api/user/balance/transfer", handle.TransferRequest)
+		r.Get("/api/user/transfers", handle.TransfersInformationRequest)
+		r.Get("/api/user/statement", handle.StatementRequest)
+	})
+	// Public routes of API v2
+	router.Group(func(r chi.Router) {
+		r.Use(validator.Middleware)
+
+		r.Post("/api/v2/user/register", handleV2.UserRegistration)
+		r.Post("/api/v2/user/login", handleV2.UserLogin)
 	})
+	// Protected routes of API v2
+	router.Group(func(r chi.Router) {
+		tokenAuth := auth.NewAuth(cfg.SecretKey)
+		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(auth.Authenticator)
+		r.Use(auth.SessionAuthenticator(cfg.SecretKey, services.User))
+		r.Use(requestTimezone)
+		r.Use(validator.Middleware)
+
+		r.Post("/api/v2/user/orders", handleV2.OrderNumberUpload)
+		r.Get("/api/v2/user/orders", handleV2.OrderListRequest)
+		r.Get("/api/v2/user/balance", handleV2.UserBalanceRequest)
+		r.Get("/api/v2/user/tier", handleV2.UserTierRequest)
+		r.Post("/api/v2/user/withdrawals", handleV2.WithdrawRequest)
+		r.Get("/api/v2/user/withdrawals", handleV2.WithdrawalsInformationRequest)
+		r.Post("/api/v2/user/transfers", handleV2.TransferRequest)
+		r.Get("/api/v2/user/transfers", handleV2.TransfersInformationRequest)
+	})
+	// Partner routes
+	if cfg.PartnerAPIKey != "" {
+		router.Group(func(r chi.Router) {
+			r.Use(auth.APIKeyAuthenticator(cfg.PartnerAPIKey))
+			r.Use(requestTimezone)
+			r.Use(validator.Middleware)
+
+			r.Post("/api/partner/withdrawals/{number}/reversal", handle.WithdrawalReversal)
+		})
+	}
+	// Admin routes
+	if cfg.AdminAPIKey != "" {
+		router.Group(func(r chi.Router) {
+			r.Use(auth.APIKeyAuthenticator(cfg.AdminAPIKey))
+			r.Use(requestTimezone)
+			r.Use(validator.Middleware)
+
+			r.Get("/api/admin/promotions", handle.PromotionRulesRequest)
+			r.Post("/api/admin/promotions", handle.PromotionRuleCreate)
+			r.Put("/api/admin/promotions/{id}", handle.PromotionRuleUpdate)
+			r.Delete("/api/admin/promotions/{id}", handle.PromotionRuleDelete)
+			r.Get("/api/admin/audit", handle.AuditLogRequest)
+			r.Get("/api/admin/audit/verify", handle.AuditVerifyRequest)
+			r.Get("/api/admin/metrics", metricsHandler)
+		})
+	}
 
-	return &http.Server{
+	srvr := &http.Server{
 		Addr:    cfg.RunAddress,
 		Handler: router,
-	}, nil
+	}
+
+	// Enable TLS and HTTP/2 if certificate is configured
+	if cfg.TLSEnabled() {
+		tlsConfig, err := NewTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		srvr.TLSConfig = tlsConfig
+	}
+
+	return srvr, nil
+}
+
+// requestTimezone is a middleware, which renders times in the time zone of the request query parameter.
+// It must be used after session authenticator, because the requested time zone overrides the user preference.
+func requestTimezone(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get(timezone.QueryParameter)
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		loc, err := timezone.Load(name)
+		if err != nil {
+			problem.Write(w, r, problem.BadRequest(err))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(timezone.WithLocation(r.Context(), loc)))
+	})
+}
+
+// metricsHandler writes the service metrics in expvar format.
+// Only the metrics of the service are published: the standard cmdline variable may contain the database password.
+func metricsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = fmt.Fprintf(w, "{\n%q: %s\n}\n", "repository_retries", repository.Retries.String())
 }
 
 func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-type", ContentTypeJSON)
-	w.WriteHeader(405)
-	_ = render.Render(w, r, ErrMethodNotAllowed)
+	problem.Write(w, r, problem.ErrMethodNotAllowed)
 }
 func notFoundHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-type", ContentTypeJSON)
-	w.WriteHeader(400)
-	_ = render.Render(w, r, ErrNotFound)
+	problem.Write(w, r, problem.ErrNotFound)
 }
@@ -0,0 +1,288 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	openapispec "github.com/RomanAgaltsev/ya_gophermart/api"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/server"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/openapi"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+
+	"github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			RunAddress:    "localhost:8080",
+			SecretKey:     "secret",
+			TLSMinVersion: tls.VersionTLS13,
+		}
+	})
+
+	When("TLS is not configured", func() {
+		It("creates cleartext HTTP server", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(srvr.TLSConfig).To(BeNil())
+		})
+	})
+
+	When("the route doesn't exist", func() {
+		It("returns 'Not found' (404) problem details", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			recorder := httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/unknown", nil))
+
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal(problem.ContentType))
+		})
+	})
+
+	When("the request has no token", func() {
+		It("returns 'Unauthorized' (401) problem details", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			recorder := httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/user/orders", nil))
+
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal(problem.ContentType))
+		})
+	})
+
+	When("the request has an ID", func() {
+		It("returns the ID in the header and in problem details", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			request.Header.Set(logger.RequestIDHeader, "client-request-1")
+
+			recorder := httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, request)
+
+			Expect(recorder.Header().Get(logger.RequestIDHeader)).To(Equal("client-request-1"))
+
+			var details problem.Problem
+			Expect(json.NewDecoder(recorder.Body).Decode(&details)).To(Succeed())
+			Expect(details.RequestID).To(Equal("client-request-1"))
+		})
+	})
+
+	When("the request has no ID", func() {
+		It("generates the ID and returns it in the header and in problem details", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			recorder := httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/user/orders", nil))
+
+			requestID := recorder.Header().Get(logger.RequestIDHeader)
+			Expect(requestID).NotTo(BeEmpty())
+
+			var details problem.Problem
+			Expect(json.NewDecoder(recorder.Body).Decode(&details)).To(Succeed())
+			Expect(details.RequestID).To(Equal(requestID))
+		})
+	})
+
+	When("all routes are enabled", func() {
+		BeforeEach(func() {
+			cfg.PartnerAPIKey = "partner"
+			cfg.AdminAPIKey = "admin"
+		})
+
+		It("serves exactly the routes of the OpenAPI document", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var routes []string
+			err = chi.Walk(srvr.Handler.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+				routes = append(routes, method+" "+route)
+				return nil
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			validator, err := openapi.NewValidator(openapispec.Spec, false)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var operations []string
+			for path, pathItem := range validator.Document().Paths.Map() {
+				for method := range pathItem.Operations() {
+					operations = append(operations, strings.ToUpper(method)+" "+path)
+				}
+			}
+
+			Expect(routes).To(ConsistOf(operations))
+		})
+	})
+
+	When("admin routes are enabled", func() {
+		BeforeEach(func() {
+			cfg.AdminAPIKey = "admin"
+		})
+
+		It("serves metrics with the admin key only", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			recorder := httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/admin/metrics", nil))
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+
+			request := httptest.NewRequest(http.MethodGet, "/api/admin/metrics", nil)
+			request.Header.Set(auth.APIKeyHeader, cfg.AdminAPIKey)
+
+			recorder = httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var metrics map[string]json.RawMessage
+			Expect(json.NewDecoder(recorder.Body).Decode(&metrics)).To(Succeed())
+			Expect(metrics).To(HaveKey("repository_retries"))
+			Expect(metrics).NotTo(HaveKey("cmdline"))
+			Expect(metrics).NotTo(HaveKey("memstats"))
+		})
+	})
+
+	When("the request has an unknown time zone", func() {
+		BeforeEach(func() {
+			cfg.AdminAPIKey = "admin"
+		})
+
+		It("returns 'Bad request' (400) problem details", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request := httptest.NewRequest(http.MethodGet, "/api/admin/audit?tz=Mars/Olympus", nil)
+			request.Header.Set(auth.APIKeyHeader, cfg.AdminAPIKey)
+
+			recorder := httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, request)
+
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal(problem.ContentType))
+		})
+	})
+
+	When("the request doesn't match the OpenAPI document", func() {
+		It("returns 'Bad request' (400) with the invalid fields", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewBufferString(`{"login": "user"}`))
+			request.Header.Set("Content-Type", server.ContentTypeJSON)
+
+			recorder := httptest.NewRecorder()
+			srvr.Handler.ServeHTTP(recorder, request)
+
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+
+			var details problem.Problem
+			Expect(json.NewDecoder(recorder.Body).Decode(&details)).To(Succeed())
+			Expect(details.Code).To(Equal(problem.CodeValidationFailed))
+			Expect(details.Errors).To(ConsistOf(problem.FieldError{Field: "password", Message: "is a required field"}))
+		})
+	})
+
+	When("TLS is configured", func() {
+		BeforeEach(func() {
+			dir := GinkgoT().TempDir()
+			cfg.TLSCertFile = filepath.Join(dir, "cert.pem")
+			cfg.TLSKeyFile = filepath.Join(dir, "key.pem")
+
+			writeCertificate(cfg.TLSCertFile, cfg.TLSKeyFile, "first")
+		})
+
+		It("creates HTTP/2 capable server with minimal TLS version", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(srvr.TLSConfig).NotTo(BeNil())
+			Expect(srvr.TLSConfig.MinVersion).To(Equal(uint16(tls.VersionTLS13)))
+			Expect(srvr.TLSConfig.NextProtos).To(ContainElement("h2"))
+		})
+
+		It("reloads the certificate when the files change", func() {
+			srvr, err := server.New(cfg, server.Services{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(commonName(srvr.TLSConfig)).To(Equal("first"))
+
+			// Make sure modification time differs
+			time.Sleep(1100 * time.Millisecond)
+			writeCertificate(cfg.TLSCertFile, cfg.TLSKeyFile, "second")
+
+			Eventually(func() string {
+				return commonName(srvr.TLSConfig)
+			}).WithTimeout(3 * time.Second).Should(Equal("second"))
+		})
+
+		It("fails when the certificate cannot be loaded", func() {
+			cfg.TLSKeyFile = cfg.TLSKeyFile + ".absent"
+
+			_, err := server.New(cfg, server.Services{})
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+})
+
+// commonName returns common name of the certificate given by TLS configuration.
+func commonName(tlsConfig *tls.Config) string {
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	Expect(err).ShouldNot(HaveOccurred())
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return leaf.Subject.CommonName
+}
+
+// writeCertificate writes self-signed certificate and its key to the files.
+func writeCertificate(certFile, keyFile, cn string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	Expect(err).ShouldNot(HaveOccurred())
+}
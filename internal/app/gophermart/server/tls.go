@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+)
+
+// certCheckInterval is the minimal interval between certificate files checks.
+const certCheckInterval = time.Second
+
+// NewTLSConfig creates TLS configuration with HTTP/2 support and certificate reload.
+func NewTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	reloader, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     cfg.TLSMinVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// certReloader keeps TLS certificate and reloads it when certificate or key files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	// checkedAt is the time of the last files check in Unix nanoseconds, handshakes read it without locking
+	checkedAt atomic.Int64
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// newCertReloader creates new certificate reloader and loads the certificate.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	cr.checkedAt.Store(time.Now().UnixNano())
+
+	return cr, nil
+}
+
+// GetCertificate returns actual certificate, it is used as tls.Config GetCertificate function.
+func (cr *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// Don't check files on every handshake, only one of the concurrent handshakes checks them
+	now := time.Now().UnixNano()
+	checkedAt := cr.checkedAt.Load()
+	if now-checkedAt >= int64(certCheckInterval) && cr.checkedAt.CompareAndSwap(checkedAt, now) {
+		// The old certificate stays in use if the new one cannot be loaded
+		if err := cr.reload(); err != nil {
+			slog.Error("TLS certificate reload", slog.String("error", err.Error()))
+		}
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.cert, nil
+}
+
+// reload loads the certificate if the files have changed since the last load.
+// The write lock is taken only to swap in the new certificate.
+func (cr *certReloader) reload() error {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	// Check if the files have been changed
+	cr.mu.RLock()
+	loaded := cr.cert != nil
+	unchanged := loaded && certInfo.ModTime().Equal(cr.certModTime) && keyInfo.ModTime().Equal(cr.keyModTime)
+	cr.mu.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if loaded {
+		slog.Info("TLS certificate reloaded", "cert", cr.certFile)
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.certModTime = certInfo.ModTime()
+	cr.keyModTime = keyInfo.ModTime()
+	cr.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	auditpkg "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
+)
+
+const (
+	// DefaultLimit is the number of entries returned, when the limit is not set.
+	DefaultLimit = 100
+	// MaxLimit is the maximum number of entries returned at once.
+	MaxLimit = 1000
+)
+
+var (
+	_ Service    = (*service)(nil)
+	_ Repository = (*repository.Repository)(nil)
+
+	ErrWrongLimit = fmt.Errorf("limit must be from 1 to %d", MaxLimit)
+
+	// errChainBroken stops the chain verification at the first broken entry.
+	errChainBroken = fmt.Errorf("audit log hash chain is broken")
+)
+
+// Service is the audit service interface.
+type Service interface {
+	Entries(ctx context.Context, filter *model.AuditFilter) (model.AuditEntries, error)
+	Verify(ctx context.Context) (*model.AuditVerification, error)
+	Start(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
+// Repository is the audit service repository interface.
+type Repository interface {
+	GetAuditEntries(ctx context.Context, filter *model.AuditFilter) (model.AuditEntries, error)
+	AuditChain(ctx context.Context, f func(entry *model.AuditEntry) error) error
+	ChainAuditLog(ctx context.Context) (int64, error)
+}
+
+// NewService creates new audit service.
+func NewService(repository Repository, cfg *config.Config) (Service, error) {
+	return &service{
+		repository: repository,
+		cfg:        cfg,
+	}, nil
+}
+
+// service is the audit service structure.
+type service struct {
+	repository Repository
+	cfg        *config.Config
+
+	// cancel stops the audit log writer
+	cancel context.CancelFunc
+	// done is closed when the audit log writer has stopped
+	done chan struct{}
+}
+
+// Entries returns audit log entries selected by the filter, the newest first.
+func (s *service) Entries(ctx context.Context, filter *model.AuditFilter) (model.AuditEntries, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultLimit
+	}
+	if filter.Limit < 0 || filter.Limit > MaxLimit {
+		return nil, ErrWrongLimit
+	}
+
+	// Entries, which are not chained yet, are returned by the repository too
+	return s.repository.GetAuditEntries(ctx, filter)
+}
+
+// Verify checks the hash chain of the whole audit log and returns the first entry, which breaks it.
+func (s *service) Verify(ctx context.Context) (*model.AuditVerification, error) {
+	// Entries queued before the request are checked too
+	if _, err := s.repository.ChainAuditLog(ctx); err != nil {
+		return nil, err
+	}
+
+	verification := &model.AuditVerification{Valid: true}
+	chain := auditpkg.NewChain()
+
+	err := s.repository.AuditChain(ctx, func(entry *model.AuditEntry) error {
+		if !chain.Append(entry) {
+			verification.Valid = false
+			verification.BrokenID = entry.ID
+			return errChainBroken
+		}
+		verification.Checked++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errChainBroken) {
+		return nil, err
+	}
+
+	verification.LastHash = chain.LastHash()
+
+	return verification, nil
+}
+
+// Start runs the audit log writer in a goroutine, it is stopped by Shutdown.
+func (s *service) Start(ctx context.Context) error {
+	// The writer outlives the start context, only Shutdown stops it
+	ctx, s.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		s.auditLogChaining(ctx)
+	}()
+
+	return nil
+}
+
+// Shutdown stops the audit log writer and waits until it has chained the queued entries or the context is done.
+func (s *service) Shutdown(ctx context.Context) error {
+	// Nothing to stop, if the service hasn't been started
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// auditLogChaining appends queued entries to the audit log with the configured interval.
+// It is the only writer of the log, so changes don't wait for each other to write their entries.
+func (s *service) auditLogChaining(ctx context.Context) {
+	logger.FromContext(ctx).Info("starting audit log chaining")
+
+	ticker := time.NewTicker(s.cfg.AuditChainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.chainAuditLog(ctx)
+		case <-ctx.Done():
+			// Entries queued by the last changes are chained before stop
+			s.chainAuditLog(context.WithoutCancel(ctx))
+			logger.FromContext(ctx).Info("audit log chaining stopped")
+			return
+		}
+	}
+}
+
+// chainAuditLog appends queued entries to the audit log.
+func (s *service) chainAuditLog(ctx context.Context) {
+	if _, err := s.repository.ChainAuditLog(ctx); err != nil {
+		logger.FromContext(ctx).Error("audit log chaining", "error", err.Error())
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/database/queries"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/promotion"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/go-chi/render"
@@ -20,7 +23,15 @@ var (
 	_ Service    = (*service)(nil)
 	_ Repository = (*repository.Repository)(nil)
 
-	ErrNotEnoughBalance = fmt.Errorf("not enough balance for withdrawal")
+	ErrNotEnoughBalance          = fmt.Errorf("not enough balance for withdrawal")
+	ErrWithdrawalNotFound        = fmt.Errorf("withdrawal not found")
+	ErrWithdrawalAlreadyReversed = fmt.Errorf("withdrawal has already been reversed")
+	ErrSelfTransfer              = fmt.Errorf("points cannot be transferred to the same user")
+	ErrSenderNotFound            = fmt.Errorf("transfer sender not found")
+	ErrSenderDisabled            = fmt.Errorf("transfer sender is disabled")
+	ErrRecipientNotFound         = fmt.Errorf("transfer recipient not found")
+	ErrRecipientDisabled         = fmt.Errorf("transfer recipient is disabled")
+	ErrTransferLimitExceeded     = fmt.Errorf("daily transfer limit exceeded")
 )
 
 // Service is the balance service interface.
@@ -29,6 +40,15 @@ type Service interface {
 	Get(ctx context.Context, user *model.User) (*model.Balance, error)
 	Withdraw(ctx context.Context, user *model.User, orderNumber string, sum float64) error
 	Withdrawals(ctx context.Context, user *model.User) (model.Withdrawals, error)
+	WithdrawalsPage(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error)
+	Statement(ctx context.Context, user *model.User, from, to time.Time, f func(entry *model.StatementEntry) error) error
+	ReverseWithdrawal(ctx context.Context, orderNumber string, reason string) (*model.Withdrawal, error)
+	Tier(ctx context.Context, user *model.User) (*model.TierStatus, error)
+	Transfer(ctx context.Context, sender *model.User, recipient string, sum float64) (*model.Transfer, error)
+	Transfers(ctx context.Context, user *model.User) (model.Transfers, error)
+	TransfersPage(ctx context.Context, user *model.User, page model.Page) (model.Transfers, int, error)
+	Start(ctx context.Context) error
+	Shutdown(ctx context.Context) error
 }
 
 // Repository is the balance service repository interface.
@@ -36,29 +56,65 @@ type Repository interface {
 	CreateBalance(ctx context.Context, user *model.User) error
 	GetBalance(ctx context.Context, user *model.User) (*model.Balance, error)
 	WithdrawFromBalance(ctx context.Context, user *model.User, orderNumber string, sum float64) error
-	GetListOfWithdrawals(ctx context.Context, user *model.User) (model.Withdrawals, error)
+	GetListOfWithdrawals(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error)
+	Statement(ctx context.Context, user *model.User, from, to time.Time, f func(entry *model.StatementEntry) error) error
+	ReverseWithdrawal(ctx context.Context, orderNumber string, reason string, expiresAt *time.Time) (*model.Withdrawal, error)
 	GetListOfOrdersToProcess(ctx context.Context) (model.Orders, error)
-	UpdateBalanceAccrued(ctx context.Context, order *model.Order, accrual *model.OrderAccrual) error
+	UpdateBalanceAccrued(ctx context.Context, order *model.Order, accrual *model.OrderAccrual, bonuses model.BonusesFunc, expiresAt *time.Time) error
+	ExpireLots(ctx context.Context, now time.Time) (int64, float64, error)
+	GetUpcomingExpirations(ctx context.Context, user *model.User, limit int) (model.Expirations, error)
+	GetAccruedTotal(ctx context.Context, user *model.User, since time.Time) (float64, error)
+	GetSpentTotal(ctx context.Context, user *model.User, since time.Time) (float64, error)
+	SaveTier(ctx context.Context, user *model.User, tier string, total float64) (bool, error)
+	GetTierHistory(ctx context.Context, user *model.User, limit int) (model.TierChanges, error)
+	Transfer(ctx context.Context, transfer *model.Transfer, dailyLimit float64, since time.Time, expiresAt *time.Time) (*model.Transfer, error)
+	GetListOfTransfers(ctx context.Context, user *model.User, page model.Page) (model.Transfers, int, error)
+	GetEnabledPromotionRules(ctx context.Context) (model.PromotionRules, error)
 }
 
+const (
+	// upcomingExpirationsNumber is the number of the nearest expiration days shown with the balance.
+	upcomingExpirationsNumber = 5
+	// tierHistoryNumber is the number of the latest tier changes shown with the tier.
+	tierHistoryNumber = 10
+)
+
 // NewService creates new balance service.
-func NewService(ctx context.Context, repository Repository, cfg *config.Config, runProcessing bool) (Service, error) {
-	balanceService := &service{
+// Orders processing is not run until the service is started.
+func NewService(repository Repository, cfg *config.Config) (Service, error) {
+	return &service{
 		repository: repository,
 		cfg:        cfg,
-	}
-	// Run orders processing goroutine only if needed
-	if runProcessing {
-		go balanceService.ordersProcessing(ctx)
+		policy:     model.ExpiryPolicy{Lifetime: cfg.PointsLifetime},
+		tiers:      newTiers(cfg.LoyaltyTiers),
+	}, nil
+}
+
+// newTiers creates loyalty tiers from configuration.
+func newTiers(loyaltyTiers []config.LoyaltyTier) model.Tiers {
+	tiers := make(model.Tiers, 0, len(loyaltyTiers))
+	for _, tier := range loyaltyTiers {
+		tiers = append(tiers, &model.Tier{
+			Name:       tier.Name,
+			Threshold:  tier.Threshold,
+			Multiplier: tier.Multiplier,
+		})
 	}
 
-	return balanceService, nil
+	return tiers
 }
 
 // service is the balance service structure.
 type service struct {
 	repository Repository
 	cfg        *config.Config
+	policy     model.ExpiryPolicy
+	tiers      model.Tiers
+
+	// cancel stops background jobs
+	cancel context.CancelFunc
+	// done is closed when background jobs have stopped
+	done chan struct{}
 }
 
 // Create creates new user balance.
@@ -66,9 +122,19 @@ func (s *service) Create(ctx context.Context, user *model.User) error {
 	return s.repository.CreateBalance(ctx, user)
 }
 
-// Get returns user balance.
+// Get returns user balance with the nearest expirations of points.
 func (s *service) Get(ctx context.Context, user *model.User) (*model.Balance, error) {
-	return s.repository.GetBalance(ctx, user)
+	userBalance, err := s.repository.GetBalance(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	userBalance.Expiring, err = s.repository.GetUpcomingExpirations(ctx, user, upcomingExpirationsNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return userBalance, nil
 }
 
 // Withdraw creates a withdrawal from user balance.
@@ -82,53 +148,315 @@ func (s *service) Withdraw(ctx context.Context, user *model.User, orderNumber st
 		return err
 	}
 
+	// Spending moves the user to the next tier
+	if s.tierBasis() == config.TierBasisSpent {
+		s.refreshTier(ctx, user)
+	}
+
 	return nil
 }
 
 // Withdrawals returns a list of withdrawals from user balance.
 func (s *service) Withdrawals(ctx context.Context, user *model.User) (model.Withdrawals, error) {
-	return s.repository.GetListOfWithdrawals(ctx, user)
+	withdrawals, _, err := s.repository.GetListOfWithdrawals(ctx, user, model.Page{})
+	return withdrawals, err
+}
+
+// WithdrawalsPage returns a page of withdrawals from user balance and the total number of them.
+func (s *service) WithdrawalsPage(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error) {
+	return s.repository.GetListOfWithdrawals(ctx, user, page)
+}
+
+// Statement calls the function for every user statement entry processed in the period [from, to).
+func (s *service) Statement(ctx context.Context, user *model.User, from, to time.Time, f func(entry *model.StatementEntry) error) error {
+	return s.repository.Statement(ctx, user, from, to, f)
+}
+
+// ReverseWithdrawal reverses the withdrawal made for the order and returns the sum to the user balance.
+func (s *service) ReverseWithdrawal(ctx context.Context, orderNumber string, reason string) (*model.Withdrawal, error) {
+	withdrawal, err := s.repository.ReverseWithdrawal(ctx, orderNumber, reason, s.policy.ExpiresAt(time.Now()))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrWithdrawalNotFound
+	}
+	if errors.Is(err, repository.ErrAlreadyReversed) {
+		return nil, ErrWithdrawalAlreadyReversed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Reversed withdrawals are not counted as spent points
+	if s.tierBasis() == config.TierBasisSpent {
+		s.refreshTier(ctx, &model.User{Login: withdrawal.Login})
+	}
+
+	return withdrawal, nil
+}
+
+// Transfer moves points from the sender balance to the recipient balance.
+// The daily limit is counted from the UTC midnight.
+func (s *service) Transfer(ctx context.Context, sender *model.User, recipient string, sum float64) (*model.Transfer, error) {
+	if sender.Login == recipient {
+		return nil, ErrSelfTransfer
+	}
+
+	// The daily limit is counted from the UTC midnight, it doesn't depend on the server time zone
+	now := time.Now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	transfer, err := s.repository.Transfer(ctx, &model.Transfer{
+		Sender:    sender.Login,
+		Recipient: recipient,
+		Sum:       sum,
+	}, s.cfg.TransferDailyLimit, dayStart, s.policy.ExpiresAt(now))
+	switch {
+	case errors.Is(err, repository.ErrSenderNotFound):
+		return nil, ErrSenderNotFound
+	case errors.Is(err, repository.ErrSenderDisabled):
+		return nil, ErrSenderDisabled
+	case errors.Is(err, repository.ErrNotFound):
+		return nil, ErrRecipientNotFound
+	case errors.Is(err, repository.ErrDisabled):
+		return nil, ErrRecipientDisabled
+	case errors.Is(err, repository.ErrLimitExceeded):
+		return nil, ErrTransferLimitExceeded
+	case errors.Is(err, repository.ErrNegativeBalance):
+		return nil, ErrNotEnoughBalance
+	case err != nil:
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// Transfers returns a list of transfers sent and received by the user.
+func (s *service) Transfers(ctx context.Context, user *model.User) (model.Transfers, error) {
+	transfers, _, err := s.repository.GetListOfTransfers(ctx, user, model.Page{})
+	return transfers, err
+}
+
+// TransfersPage returns a page of transfers sent and received by the user and the total number of them.
+func (s *service) TransfersPage(ctx context.Context, user *model.User, page model.Page) (model.Transfers, int, error) {
+	return s.repository.GetListOfTransfers(ctx, user, page)
+}
+
+// Tier returns user loyalty tier with the progress to the next tier and the latest tier changes.
+func (s *service) Tier(ctx context.Context, user *model.User) (*model.TierStatus, error) {
+	since := time.Now().Add(-s.cfg.TierPeriod)
+
+	total, err := s.tierTotal(ctx, user, since)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.repository.GetTierHistory(ctx, user, tierHistoryNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	tierStatus := &model.TierStatus{
+		Multiplier: 1,
+		Basis:      s.tierBasis(),
+		Total:      total,
+		Since:      since,
+		History:    history,
+	}
+
+	current, next := s.tiers.Find(total)
+	if current != nil {
+		tierStatus.Tier = current.Name
+		tierStatus.Multiplier = current.Multiplier
+	}
+	if next != nil {
+		tierStatus.NextTier = next.Name
+		tierStatus.NextThreshold = next.Threshold
+		tierStatus.Remaining = next.Threshold - total
+	}
+
+	return tierStatus, nil
+}
+
+// tierBasis returns the kind of user totals loyalty tiers are computed from.
+func (s *service) tierBasis() string {
+	if s.cfg.TierBasis == "" {
+		return config.TierBasisAccrued
+	}
+
+	return s.cfg.TierBasis
+}
+
+// tierTotal returns the user total of accrued or spent points since the given time.
+func (s *service) tierTotal(ctx context.Context, user *model.User, since time.Time) (float64, error) {
+	if s.tierBasis() == config.TierBasisSpent {
+		return s.repository.GetSpentTotal(ctx, user, since)
+	}
+
+	return s.repository.GetAccruedTotal(ctx, user, since)
+}
+
+// currentTier returns the tier reached by the user within the rolling period and the user total.
+func (s *service) currentTier(ctx context.Context, user *model.User) (*model.Tier, float64, error) {
+	total, err := s.tierTotal(ctx, user, time.Now().Add(-s.cfg.TierPeriod))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	current, _ := s.tiers.Find(total)
+
+	return current, total, nil
+}
+
+// refreshTier adds the current user tier to the tier history, if the tier has been changed.
+// Errors are only logged - the balance has already been changed.
+func (s *service) refreshTier(ctx context.Context, user *model.User) {
+	tier, total, err := s.currentTier(ctx, user)
+	if err != nil {
+		logger.FromContext(ctx).Info("tier refresh", "error", err.Error())
+		return
+	}
+
+	if tier == nil {
+		return
+	}
+
+	changed, err := s.repository.SaveTier(ctx, user, tier.Name, total)
+	if err != nil {
+		logger.FromContext(ctx).Info("tier refresh", "error", err.Error())
+		return
+	}
+
+	if changed {
+		logger.FromContext(ctx).Info("tier changed", "login", user.Login, "tier", tier.Name)
+	}
+}
+
+// Start runs background jobs in goroutines, they are stopped by Shutdown.
+func (s *service) Start(ctx context.Context) error {
+	// Jobs outlive the start context, only Shutdown stops them
+	ctx, s.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	s.done = make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.ordersProcessing(ctx)
+	}()
+
+	// Points can expire only if they have a lifetime
+	if s.cfg.PointsLifetime > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.lotsExpiration(ctx)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(s.done)
+	}()
+
+	return nil
+}
+
+// Shutdown stops background jobs and waits until in-flight jobs are finished or the context is done.
+func (s *service) Shutdown(ctx context.Context) error {
+	// Nothing to stop, if the service hasn't been started
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // ordersProcessing runs orders processing every 10 seconds.
 func (s *service) ordersProcessing(ctx context.Context) {
 	const ordersProcessingInterval = 10
 
-	slog.Info("starting order processing")
+	logger.FromContext(ctx).Info("starting order processing")
 
 	ticker := time.NewTicker(ordersProcessingInterval * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			logger.FromContext(ctx).Info("order processing execution")
+			s.processOrders(ctx)
+		case <-ctx.Done():
+			logger.FromContext(ctx).Info("order processing stopped")
+			return
+		}
+	}
+}
+
+// lotsExpiration expires points with the configured interval.
+func (s *service) lotsExpiration(ctx context.Context) {
+	logger.FromContext(ctx).Info("starting points expiration")
+
+	ticker := time.NewTicker(s.cfg.ExpirationInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			slog.Info("order processing execution")
-			s.processOrders()
+			s.expireLots(ctx)
 		case <-ctx.Done():
-			slog.Info("order processing stopped")
+			logger.FromContext(ctx).Info("points expiration stopped")
 			return
-		default:
-			continue
 		}
 	}
 }
 
+// expireLots expires the lots, which have reached their expiry time.
+func (s *service) expireLots(ctx context.Context) {
+	lots, amount, err := s.repository.ExpireLots(ctx, time.Now())
+	if err != nil {
+		logger.FromContext(ctx).Info("points expiration", "error", err.Error())
+		return
+	}
+
+	if lots > 0 {
+		logger.FromContext(ctx).Info("points expired", "lots", lots, "amount", amount)
+	}
+}
+
 // processOrders processes unprocessed orders.
-func (s *service) processOrders() {
+// When the context is cancelled, in-flight jobs are finished and the rest of the orders are released -
+// they keep their statuses and will be processed on the next run.
+func (s *service) processOrders(ctx context.Context) {
 	// Fix the number of workers
 	const workersNumber = 3
 
-	// Create context
-	ctx := context.Background()
-
 	// Get orders to process - NEW and PROCESSING statuses
 	ordersToProcess, err := s.repository.GetListOfOrdersToProcess(ctx)
 	if err != nil {
-		slog.Info("orders processing", "error", err.Error())
+		logger.FromContext(ctx).Info("orders processing", "error", err.Error())
 		return
 	}
 
+	// Get promotion rules once for all the orders
+	var rules model.PromotionRules
+	if len(ordersToProcess) > 0 {
+		rules, err = s.repository.GetEnabledPromotionRules(ctx)
+		if err != nil {
+			logger.FromContext(ctx).Info("orders processing", "error", err.Error())
+			return
+		}
+	}
+
 	// Create a channel for processing jobs
 	jobs := make(chan *model.Order, len(ordersToProcess))
+
 	// Create a channel for jobs completion awating
 	done := make(chan struct{}, len(ordersToProcess))
 
@@ -138,10 +466,19 @@ func (s *service) processOrders() {
 		go func(jobs chan *model.Order, done chan struct{}) {
 			// Get orders from job channel
 			for order := range jobs {
+				// Release the order if processing is stopping
+				if ctx.Err() != nil {
+					done <- struct{}{}
+					continue
+				}
+
+				// Every order gets its own request ID, it is logged and sent to the accrual system
+				ctx := logger.With(logger.WithRequestID(ctx, logger.NewRequestID()), "order", order.Number, logger.LoginAttr, order.Login)
+
 				// Get data from accrual system
-				accrual, err := orderAccrual(s.cfg.AccrualSystemAddress, order.Number)
+				accrual, err := orderAccrual(ctx, s.cfg.AccrualSystemAddress, order.Number)
 				if err != nil {
-					slog.Info("orders processing", "error", err.Error())
+					logger.FromContext(ctx).Info("orders processing", "error", err.Error())
 					done <- struct{}{}
 					continue
 				}
@@ -152,14 +489,36 @@ func (s *service) processOrders() {
 					continue
 				}
 
-				// If order status has been changed, update balance
-				errUpdate := s.repository.UpdateBalanceAccrued(ctx, order, accrual)
+				// Accrual is credited with the multiplier of the user tier and promotion bonuses
+				user := &model.User{Login: order.Login}
+				processed := accrual.Status == queries.OrderStatusPROCESSED
+				var bonuses model.BonusesFunc
+				if processed {
+					tier, _, err := s.currentTier(ctx, user)
+					if err != nil {
+						logger.FromContext(ctx).Info("orders processing", "error", err.Error())
+						done <- struct{}{}
+						continue
+					}
+					accrual.Accrual = tier.Apply(accrual.Accrual)
+
+					bonuses = promotionBonuses(order, accrual, tier, rules)
+				}
+
+				// If order status has been changed, update balance.
+				// The accrual data has already been received, so the update must not be interrupted
+				errUpdate := s.repository.UpdateBalanceAccrued(context.WithoutCancel(ctx), order, accrual, bonuses, s.policy.ExpiresAt(time.Now()))
 				if errUpdate != nil {
-					slog.Info("orders processing", "error", errUpdate.Error())
+					logger.FromContext(ctx).Info("orders processing", "error", errUpdate.Error())
 					done <- struct{}{}
 					continue
 				}
 
+				// Accruals move the user to the next tier
+				if processed && accrual.Accrual > 0 && s.tierBasis() == config.TierBasisAccrued {
+					s.refreshTier(context.WithoutCancel(ctx), user)
+				}
+
 				// Done with the order
 				done <- struct{}{}
 			}
@@ -170,6 +529,7 @@ func (s *service) processOrders() {
 	for _, orderNumber := range ordersToProcess {
 		jobs <- orderNumber
 	}
+
 	// Close jobs channel after filling
 	close(jobs)
 
@@ -179,23 +539,55 @@ func (s *service) processOrders() {
 	}
 }
 
+// promotionBonuses returns the function, which evaluates the promotion rules for the processed order.
+// The repository calls it with the number of processed orders counted in the accrual transaction.
+func promotionBonuses(order *model.Order, accrual *model.OrderAccrual, tier *model.Tier, rules model.PromotionRules) model.BonusesFunc {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return func(previousOrders int64) model.Bonuses {
+		facts := &promotion.Facts{
+			OrderNumber:    order.Number,
+			UploadedAt:     order.UploadedAt,
+			Accrual:        accrual.Accrual,
+			PreviousOrders: previousOrders,
+		}
+		if tier != nil {
+			facts.Tier = tier.Name
+		}
+
+		return promotion.Evaluate(rules, facts)
+	}
+}
+
 // orderAccrual fetches order data from the external accrual system.
-func orderAccrual(accrualSystemAddress string, orderNumber string) (*model.OrderAccrual, error) {
+func orderAccrual(ctx context.Context, accrualSystemAddress string, orderNumber string) (*model.OrderAccrual, error) {
 	// Create HTTP client
 	client := http.Client{}
 
-	// Send request to the accrual system with exponential backoff
+	// Send request to the accrual system with exponential backoff, which stops with the context
 	resp, err := backoff.RetryWithData(func() (*http.Response, error) {
 		url := fmt.Sprintf("%s/api/orders/%s", accrualSystemAddress, orderNumber)
-		slog.Info("accrual system request", "address", url, "order", orderNumber)
-		return client.Get(url)
-	}, backoff.NewExponentialBackOff())
-	defer func() { _ = resp.Body.Close() }()
+		logger.FromContext(ctx).Info("accrual system request", "address", url)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+		if err != nil {
+			return nil, backoff.Permanent(err)
+		}
+
+		if requestID := logger.RequestIDFromContext(ctx); requestID != "" {
+			req.Header.Set(logger.RequestIDHeader, requestID)
+		}
+
+		return client.Do(req)
+	}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 
 	// Something has gone wrong
 	if err != nil {
 		return nil, err
 	}
+	defer func() { _ = resp.Body.Close() }()
 
 	// Decode order accrual data from JSON to accrual structure
 	var accrual model.OrderAccrual
@@ -0,0 +1,135 @@
+package balance_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	balanceMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+)
+
+var _ = Describe("Balance service", func() {
+	var (
+		ctx  context.Context
+		cfg  *config.Config
+		repo *balanceMocks.MockRepository
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cfg = &config.Config{}
+		repo = balanceMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+	})
+
+	Context("Expiring points", func() {
+		It("expires lots periodically when points have a lifetime", func() {
+			cfg.PointsLifetime = time.Hour
+			cfg.ExpirationInterval = 10 * time.Millisecond
+
+			expired := make(chan struct{}, 1)
+			repo.EXPECT().ExpireLots(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, time.Time) (int64, float64, error) {
+				select {
+				case expired <- struct{}{}:
+				default:
+				}
+				return 1, 100, nil
+			}).MinTimes(1)
+
+			balanceService, err := balance.NewService(repo, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(balanceService.Start(ctx)).To(Succeed())
+
+			Eventually(expired).Should(Receive())
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+
+			Expect(balanceService.Shutdown(shutdownCtx)).To(Succeed())
+		})
+	})
+
+	Context("Computing tiers on spent points", func() {
+		BeforeEach(func() {
+			cfg.TierBasis = config.TierBasisSpent
+			cfg.TierPeriod = time.Hour
+			cfg.LoyaltyTiers = []config.LoyaltyTier{
+				{Name: "bronze", Threshold: 0, Multiplier: 1},
+				{Name: "silver", Threshold: 1000, Multiplier: 1.05},
+			}
+		})
+
+		It("saves the tier reached with a withdrawal", func() {
+			user := &model.User{Login: "user"}
+
+			repo.EXPECT().WithdrawFromBalance(gomock.Any(), user, "2377225624", float64(500)).Return(nil)
+			repo.EXPECT().GetSpentTotal(gomock.Any(), user, gomock.Any()).Return(float64(1100), nil)
+			repo.EXPECT().SaveTier(gomock.Any(), user, "silver", float64(1100)).Return(true, nil)
+
+			balanceService, err := balance.NewService(repo, cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(balanceService.Withdraw(ctx, user, "2377225624", 500)).To(Succeed())
+		})
+	})
+
+	Context("Transferring points", func() {
+		It("counts the daily limit from the UTC midnight", func() {
+			cfg.TransferDailyLimit = 1000
+
+			sender := &model.User{Login: "user"}
+			repo.EXPECT().Transfer(gomock.Any(), gomock.Any(), float64(1000), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, transfer *model.Transfer, _ float64, since time.Time, _ *time.Time) (*model.Transfer, error) {
+					Expect(since.Location()).To(Equal(time.UTC))
+					Expect(since).To(Equal(since.Truncate(24 * time.Hour)))
+					Expect(time.Since(since)).To(BeNumerically("<", 24*time.Hour))
+					return transfer, nil
+				})
+
+			balanceService, err := balance.NewService(repo, cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = balanceService.Transfer(ctx, sender, "friend", 100)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports a disabled sender", func() {
+			repo.EXPECT().Transfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, repository.ErrSenderDisabled)
+
+			balanceService, err := balance.NewService(repo, cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = balanceService.Transfer(ctx, &model.User{Login: "user"}, "friend", 100)
+			Expect(err).To(MatchError(balance.ErrSenderDisabled))
+		})
+	})
+
+	Context("Shutting down", func() {
+		It("stops running orders processing", func() {
+			balanceService, err := balance.NewService(repo, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(balanceService.Start(ctx)).To(Succeed())
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+
+			Expect(balanceService.Shutdown(shutdownCtx)).To(Succeed())
+		})
+
+		It("returns at once when orders processing has not been started", func() {
+			balanceService, err := balance.NewService(repo, cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+
+			Expect(balanceService.Shutdown(shutdownCtx)).To(Succeed())
+		})
+	})
+})
@@ -8,26 +8,35 @@ import (
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	orderpkg "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/order"
 )
 
+// MaxBatchSize is the maximum number of order numbers in a batch upload.
+const MaxBatchSize = 1000
+
 var (
 	_ Service    = (*service)(nil)
 	_ Repository = (*repository.Repository)(nil)
 
 	ErrOrderUploadedByThisLogin    = fmt.Errorf("order number has already been uploaded by this user")
 	ErrOrderUploadedByAnotherLogin = fmt.Errorf("order number has already been uploaded by another user")
+	ErrEmptyBatch                  = fmt.Errorf("batch contains no order numbers")
+	ErrBatchTooLarge               = fmt.Errorf("batch contains more than %d order numbers", MaxBatchSize)
 )
 
 // Service is the order service interface.
 type Service interface {
 	Create(ctx context.Context, order *model.Order) error
+	CreateBatch(ctx context.Context, user *model.User, numbers []string) (model.OrderUploadResults, error)
 	UserOrders(ctx context.Context, user *model.User) (model.Orders, error)
+	UserOrdersPage(ctx context.Context, user *model.User, page model.Page) (model.Orders, int, error)
 }
 
 // Repository is the order service repository interface.
 type Repository interface {
 	CreateOrder(ctx context.Context, order *model.Order) (*model.Order, error)
-	GetListOfOrders(ctx context.Context, user *model.User) (model.Orders, error)
+	CreateOrders(ctx context.Context, login string, numbers []string) ([]string, model.Orders, error)
+	GetListOfOrders(ctx context.Context, user *model.User, page model.Page) (model.Orders, int, error)
 }
 
 // NewService creates new order service.
@@ -65,7 +74,82 @@ func (s *service) Create(ctx context.Context, order *model.Order) error {
 	return nil
 }
 
+// CreateBatch creates orders with valid numbers and returns upload result of every number in the batch.
+func (s *service) CreateBatch(ctx context.Context, user *model.User, numbers []string) (model.OrderUploadResults, error) {
+	if len(numbers) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(numbers) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	// Collect unique valid numbers to create
+	results := make(model.OrderUploadResults, 0, len(numbers))
+	valid := make([]string, 0, len(numbers))
+	seen := make(map[string]struct{}, len(numbers))
+
+	for _, number := range numbers {
+		result := &model.OrderUploadResult{Number: number}
+		results = append(results, result)
+
+		if !orderpkg.IsNumberValid(number) {
+			result.Status = model.OrderUploadInvalid
+			continue
+		}
+
+		if _, ok := seen[number]; !ok {
+			seen[number] = struct{}{}
+			valid = append(valid, number)
+		}
+	}
+
+	// There is nothing to create
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	// Create orders
+	created, existing, err := s.repository.CreateOrders(ctx, user.Login, valid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Define statuses of valid numbers
+	statuses := make(map[string]model.OrderUploadStatus, len(valid))
+	for _, order := range existing {
+		if order.Login == user.Login {
+			statuses[order.Number] = model.OrderUploadDuplicateOwn
+		} else {
+			statuses[order.Number] = model.OrderUploadDuplicateOther
+		}
+	}
+	for _, number := range created {
+		statuses[number] = model.OrderUploadAccepted
+	}
+
+	for _, result := range results {
+		if result.Status == model.OrderUploadInvalid {
+			continue
+		}
+
+		// Repeated number of the batch is a duplicate of the first one
+		status := statuses[result.Number]
+		if status == model.OrderUploadAccepted {
+			statuses[result.Number] = model.OrderUploadDuplicateOwn
+		}
+		result.Status = status
+	}
+
+	return results, nil
+}
+
 // UserOrders returns a list of orders uploaded by user.
 func (s *service) UserOrders(ctx context.Context, user *model.User) (model.Orders, error) {
-	return s.repository.GetListOfOrders(ctx, user)
+	orders, _, err := s.repository.GetListOfOrders(ctx, user, model.Page{})
+	return orders, err
+}
+
+// UserOrdersPage returns a page of orders uploaded by user and the total number of user orders.
+func (s *service) UserOrdersPage(ctx context.Context, user *model.User, page model.Page) (model.Orders, int, error) {
+	return s.repository.GetListOfOrders(ctx, user, page)
 }
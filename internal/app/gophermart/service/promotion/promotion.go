@@ -0,0 +1,86 @@
+package promotion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+)
+
+var (
+	_ Service    = (*service)(nil)
+	_ Repository = (*repository.Repository)(nil)
+
+	ErrRuleNotFound = fmt.Errorf("promotion rule not found")
+	ErrRuleInUse    = fmt.Errorf("promotion rule has given bonuses")
+)
+
+// Service is the promotion service interface.
+type Service interface {
+	Rules(ctx context.Context) (model.PromotionRules, error)
+	CreateRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error)
+	UpdateRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error)
+	DeleteRule(ctx context.Context, id int32) error
+}
+
+// Repository is the promotion service repository interface.
+type Repository interface {
+	GetPromotionRules(ctx context.Context) (model.PromotionRules, error)
+	CreatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error)
+	UpdatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error)
+	DeletePromotionRule(ctx context.Context, id int32) error
+}
+
+// NewService creates new promotion service.
+func NewService(repository Repository, cfg *config.Config) (Service, error) {
+	return &service{
+		repository: repository,
+		cfg:        cfg,
+	}, nil
+}
+
+// service is the promotion service structure.
+type service struct {
+	repository Repository
+	cfg        *config.Config
+}
+
+// Rules returns all promotion rules.
+func (s *service) Rules(ctx context.Context) (model.PromotionRules, error) {
+	return s.repository.GetPromotionRules(ctx)
+}
+
+// CreateRule creates new promotion rule.
+func (s *service) CreateRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+	return s.repository.CreatePromotionRule(ctx, rule)
+}
+
+// UpdateRule replaces the promotion rule.
+func (s *service) UpdateRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+	updated, err := s.repository.UpdatePromotionRule(ctx, rule)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DeleteRule deletes the promotion rule.
+// The rule, which has given bonuses, must be disabled instead - bonuses keep the rule that produced them.
+func (s *service) DeleteRule(ctx context.Context, id int32) error {
+	err := s.repository.DeletePromotionRule(ctx, id)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return ErrRuleNotFound
+	case errors.Is(err, repository.ErrConflict):
+		return ErrRuleInUse
+	}
+
+	return err
+}
@@ -0,0 +1,873 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/audit"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/balance"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/order"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/promotion"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/user"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermartctl"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/database"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/database/queries"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	auditchain "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
+	"github.com/RomanAgaltsev/ya_gophermart/migrations"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pressly/goose/v3"
+)
+
+// testDatabaseURIEnv is the name of environment variable with the connection string of a disposable test database.
+// The database repository conformance is checked only when the variable is set.
+const testDatabaseURIEnv = "TEST_DATABASE_URI"
+
+// conformingRepository is the repository of all services, which every implementation must provide.
+type conformingRepository interface {
+	user.Repository
+	ctl.Repository
+	order.Repository
+	balance.Repository
+	promotion.Repository
+	audit.Repository
+}
+
+var _ = describeConformance("Memory repository", func(_ context.Context) conformingRepository {
+	repo, err := repository.NewMemory()
+	Expect(err).NotTo(HaveOccurred())
+
+	return repo
+})
+
+var _ = describeConformance("Database repository", func(ctx context.Context) conformingRepository {
+	databaseURI := os.Getenv(testDatabaseURIEnv)
+	if databaseURI == "" {
+		Skip(testDatabaseURIEnv + " is not set")
+	}
+
+	dbpool, err := database.NewPool(ctx, databaseURI)
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(dbpool.Close)
+
+	// The audit log cannot be truncated, so every spec starts from the empty schema
+	provider, err := goose.NewProvider(goose.DialectPostgres, stdlib.OpenDBFromPool(dbpool), migrations.Migrations)
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(provider.Close)
+
+	_, err = provider.DownTo(ctx, 0)
+	Expect(err).NotTo(HaveOccurred())
+	_, err = provider.Up(ctx)
+	Expect(err).NotTo(HaveOccurred())
+
+	repo, err := repository.New(dbpool, repository.RetryPolicy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     10 * time.Millisecond,
+		MaxElapsedTime:  time.Second,
+		MaxRetries:      3,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	return repo
+})
+
+// describeConformance describes the behaviour, which the repository implementations share.
+// The function creates an empty repository before every spec.
+func describeConformance(name string, newRepository func(ctx context.Context) conformingRepository) bool {
+	return Describe(name, Label("conformance"), func() {
+		var (
+			ctx  context.Context
+			repo conformingRepository
+
+			alice *model.User
+			bob   *model.User
+
+			expiresAt *time.Time
+		)
+
+		// createUser creates the user with the balance.
+		createUser := func(login string) *model.User {
+			usr := &model.User{Login: login, Password: "hash"}
+			Expect(repo.CreateUser(ctx, usr)).To(Succeed())
+			Expect(repo.CreateBalance(ctx, usr)).To(Succeed())
+
+			return usr
+		}
+
+		// accrue uploads new order of the user and processes it with the accrual.
+		accrue := func(usr *model.User, number string, accrual float64, bonuses ...*model.Bonus) {
+			existing, err := repo.CreateOrder(ctx, &model.Order{Login: usr.Login, Number: number})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(existing).To(BeNil())
+
+			var given model.BonusesFunc
+			if len(bonuses) > 0 {
+				given = func(int64) model.Bonuses { return bonuses }
+			}
+			Expect(repo.UpdateBalanceAccrued(ctx, &model.Order{Login: usr.Login, Number: number}, &model.OrderAccrual{
+				OrderNumber: number,
+				Status:      queries.OrderStatusPROCESSED,
+				Accrual:     accrual,
+			}, given, expiresAt)).To(Succeed())
+		}
+
+		// current returns the current balance of the user.
+		current := func(usr *model.User) float64 {
+			userBalance, err := repo.GetBalance(ctx, usr)
+			Expect(err).NotTo(HaveOccurred())
+
+			return userBalance.Current
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			repo = newRepository(ctx)
+
+			alice = createUser("alice")
+			bob = createUser("bob")
+
+			expiresAt = nil
+		})
+
+		Describe("users", func() {
+			It("rejects a duplicate login", func() {
+				Expect(repo.CreateUser(ctx, &model.User{Login: "alice", Password: "other"})).To(MatchError(repository.ErrConflict))
+			})
+
+			It("keeps the existing balance, when it is created again", func() {
+				accrue(alice, "12345678903", 100)
+
+				Expect(repo.CreateBalance(ctx, alice)).To(Succeed())
+				Expect(current(alice)).To(Equal(100.0))
+			})
+
+			It("returns nil for an unknown login", func() {
+				usr, err := repo.GetUser(ctx, "carol")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr).To(BeNil())
+			})
+
+			It("returns not found error for changes of an unknown user", func() {
+				Expect(repo.DisableUser(ctx, "carol")).To(MatchError(repository.ErrNotFound))
+				_, err := repo.UpdateUserPassword(ctx, "carol", "new hash")
+				Expect(err).To(MatchError(repository.ErrNotFound))
+				Expect(repo.UpdateUserTimezone(ctx, "carol", "Europe/Moscow")).To(MatchError(repository.ErrNotFound))
+			})
+
+			It("replaces the password and revokes sessions", func() {
+				sessionVersion, err := repo.UpdateUserPassword(ctx, "alice", "new hash")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sessionVersion).To(BeEquivalentTo(2))
+
+				usr, err := repo.GetUser(ctx, "alice")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr.Password).To(Equal("new hash"))
+				Expect(usr.SessionVersion).To(BeEquivalentTo(2))
+
+				_, err = repo.UpdateUserPassword(ctx, "carol", "hash")
+				Expect(err).To(MatchError(repository.ErrNotFound))
+			})
+
+			It("keeps the time zone preference", func() {
+				Expect(repo.UpdateUserTimezone(ctx, "alice", "Asia/Tokyo")).To(Succeed())
+
+				usr, err := repo.GetUser(ctx, "alice")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr.Timezone).To(Equal("Asia/Tokyo"))
+
+				Expect(repo.UpdateUserTimezone(ctx, "carol", "Asia/Tokyo")).To(MatchError(repository.ErrNotFound))
+			})
+
+			It("anonymizes the data of a deleted user", func() {
+				accrue(alice, "12345678903", 100)
+				Expect(repo.DeleteUser(ctx, "alice", true)).To(Succeed())
+
+				usr, err := repo.GetUser(ctx, "alice")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr).To(BeNil())
+
+				pseudonym := &model.User{Login: model.DeletedLoginPrefix + "1"}
+				usr, err = repo.GetUser(ctx, pseudonym.Login)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr.Password).To(BeEmpty())
+				Expect(usr.Disabled).To(BeTrue())
+
+				orders, _, err := repo.GetListOfOrders(ctx, pseudonym, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orders).To(HaveLen(1))
+				Expect(current(pseudonym)).To(Equal(100.0))
+
+				// The number stays taken
+				existing, err := repo.CreateOrder(ctx, &model.Order{Login: "bob", Number: "12345678903"})
+				Expect(err).To(MatchError(repository.ErrConflict))
+				Expect(existing.Login).To(Equal(pseudonym.Login))
+			})
+
+			It("deletes the data of a deleted user, but keeps transfers", func() {
+				accrue(alice, "12345678903", 100)
+				_, err := repo.Transfer(ctx, &model.Transfer{Sender: "alice", Recipient: "bob", Sum: 30}, 0, time.Time{}, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(repo.DeleteUser(ctx, "alice", false)).To(Succeed())
+				Expect(repo.DeleteUser(ctx, "alice", false)).To(MatchError(repository.ErrNotFound))
+
+				orders, _, err := repo.GetListOfOrders(ctx, alice, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orders).To(BeEmpty())
+
+				// The number is free again
+				existing, err := repo.CreateOrder(ctx, &model.Order{Login: "bob", Number: "12345678903"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(existing).To(BeNil())
+
+				transfers, _, err := repo.GetListOfTransfers(ctx, bob, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(transfers).To(HaveLen(1))
+				Expect(transfers[0].Sender).To(Equal(model.DeletedLoginPrefix + "1"))
+			})
+
+			It("gives a new ID to a new account with the login of a deleted user", func() {
+				Expect(repo.DeleteUser(ctx, "alice", false)).To(Succeed())
+
+				newAlice := createUser("alice")
+				Expect(newAlice.ID).NotTo(BeZero())
+				Expect(newAlice.ID).NotTo(Equal(alice.ID))
+
+				usr, err := repo.GetUser(ctx, "alice")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr.ID).To(Equal(newAlice.ID))
+
+				// Version 0 is left to the accounts created before tokens had user IDs
+				Expect(newAlice.SessionVersion).To(BeEquivalentTo(1))
+				Expect(usr.SessionVersion).To(BeEquivalentTo(1))
+			})
+		})
+
+		Describe("units of work", func() {
+			It("commits all changes", func() {
+				carol := &model.User{Login: "carol", Password: "hash"}
+				Expect(repo.WithinTransaction(ctx, func(ctx context.Context) error {
+					if err := repo.CreateUser(ctx, carol); err != nil {
+						return err
+					}
+					return repo.CreateBalance(ctx, carol)
+				})).To(Succeed())
+
+				usr, err := repo.GetUser(ctx, "carol")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr).NotTo(BeNil())
+				Expect(current(carol)).To(BeZero())
+			})
+
+			It("discards all changes, if the function fails", func() {
+				accrue(alice, "12345678903", 100)
+
+				errFailed := errors.New("failed")
+				Expect(repo.WithinTransaction(ctx, func(ctx context.Context) error {
+					if err := repo.CreateUser(ctx, &model.User{Login: "carol", Password: "hash"}); err != nil {
+						return err
+					}
+					if err := repo.WithdrawFromBalance(ctx, alice, "2377225624", 60); err != nil {
+						return err
+					}
+					return errFailed
+				})).To(MatchError(errFailed))
+
+				usr, err := repo.GetUser(ctx, "carol")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr).To(BeNil())
+				Expect(current(alice)).To(Equal(100.0))
+			})
+
+			It("discards the changes of a failed nested unit of work only", func() {
+				Expect(repo.WithinTransaction(ctx, func(ctx context.Context) error {
+					if err := repo.CreateUser(ctx, &model.User{Login: "carol", Password: "hash"}); err != nil {
+						return err
+					}
+
+					// The conflict fails the nested unit of work, but the outer one goes on
+					err := repo.WithinTransaction(ctx, func(ctx context.Context) error {
+						if err := repo.CreateUser(ctx, &model.User{Login: "dave", Password: "hash"}); err != nil {
+							return err
+						}
+						return repo.CreateUser(ctx, &model.User{Login: "alice", Password: "hash"})
+					})
+					Expect(err).To(MatchError(repository.ErrConflict))
+
+					return nil
+				})).To(Succeed())
+
+				usr, err := repo.GetUser(ctx, "carol")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr).NotTo(BeNil())
+
+				usr, err = repo.GetUser(ctx, "dave")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usr).To(BeNil())
+			})
+		})
+
+		Describe("orders", func() {
+			It("returns the existing order on conflict", func() {
+				existing, err := repo.CreateOrder(ctx, &model.Order{Login: "alice", Number: "12345678903"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(existing).To(BeNil())
+
+				existing, err = repo.CreateOrder(ctx, &model.Order{Login: "bob", Number: "12345678903"})
+				Expect(err).To(MatchError(repository.ErrConflict))
+				Expect(existing.Login).To(Equal("alice"))
+				Expect(existing.Status).To(Equal(queries.OrderStatusNEW))
+			})
+
+			It("requeues only the orders waiting for processing", func() {
+				for number, status := range map[string]queries.OrderStatus{
+					"12345678903": queries.OrderStatusPROCESSING,
+					"2377225624":  queries.OrderStatusINVALID,
+					"49927398716": queries.OrderStatusPROCESSED,
+				} {
+					_, err := repo.CreateOrder(ctx, &model.Order{Login: "alice", Number: number})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(repo.UpdateBalanceAccrued(ctx, &model.Order{Login: "alice", Number: number}, &model.OrderAccrual{
+						OrderNumber: number,
+						Status:      status,
+					}, nil, expiresAt)).To(Succeed())
+				}
+
+				Expect(repo.RequeueOrder(ctx, "12345678903")).To(Succeed())
+				Expect(repo.RequeueOrder(ctx, "2377225624")).To(MatchError(repository.ErrNotFound))
+				Expect(repo.RequeueOrder(ctx, "49927398716")).To(MatchError(repository.ErrNotFound))
+			})
+
+			It("creates a batch and returns the existing orders", func() {
+				_, err := repo.CreateOrder(ctx, &model.Order{Login: "bob", Number: "12345678903"})
+				Expect(err).NotTo(HaveOccurred())
+
+				created, existing, err := repo.CreateOrders(ctx, "alice", []string{"79927398713", "12345678903"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(ConsistOf("79927398713"))
+				Expect(existing).To(HaveLen(1))
+				Expect(existing[0].Login).To(Equal("bob"))
+
+				toProcess, err := repo.GetListOfOrdersToProcess(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(toProcess).To(HaveLen(2))
+			})
+
+			It("returns upload times in UTC", func() {
+				before := time.Now()
+				accrue(alice, "12345678903", 10)
+
+				orders, _, err := repo.GetListOfOrders(ctx, alice, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orders).To(HaveLen(1))
+				Expect(orders[0].UploadedAt.Location()).To(Equal(time.UTC))
+				Expect(orders[0].UploadedAt).To(BeTemporally("~", before, time.Minute))
+			})
+
+			It("lists the orders of the user, the newest first", func() {
+				accrue(alice, "12345678903", 10)
+				_, err := repo.CreateOrder(ctx, &model.Order{Login: "alice", Number: "79927398713"})
+				Expect(err).NotTo(HaveOccurred())
+
+				orders, _, err := repo.GetListOfOrders(ctx, alice, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orders).To(HaveLen(2))
+				Expect(orders[0].Number).To(Equal("79927398713"))
+				Expect(orders[1].Status).To(Equal(queries.OrderStatusPROCESSED))
+				Expect(orders[1].Accrual).To(Equal(10.0))
+
+				// Bonuses of the order are evaluated with the processed orders, the order itself is not counted
+				var previousOrders int64 = -1
+				Expect(repo.UpdateBalanceAccrued(ctx, &model.Order{Login: "alice", Number: "79927398713"}, &model.OrderAccrual{
+					OrderNumber: "79927398713",
+					Status:      queries.OrderStatusPROCESSED,
+				}, func(count int64) model.Bonuses {
+					previousOrders = count
+					return nil
+				}, nil)).To(Succeed())
+				Expect(previousOrders).To(BeEquivalentTo(1))
+			})
+
+			It("returns a page of the orders and the total number of them", func() {
+				for _, number := range []string{"12345678903", "79927398713", "2377225624"} {
+					_, err := repo.CreateOrder(ctx, &model.Order{Login: "alice", Number: number})
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				orders, total, err := repo.GetListOfOrders(ctx, alice, model.Page{Limit: 1, Offset: 1})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orders).To(HaveLen(1))
+				Expect(orders[0].Number).To(Equal("79927398713"))
+				Expect(total).To(Equal(3))
+
+				orders, total, err = repo.GetListOfOrders(ctx, alice, model.Page{Limit: 10, Offset: 5})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orders).NotTo(BeNil())
+				Expect(orders).To(BeEmpty())
+				Expect(total).To(Equal(3))
+			})
+		})
+
+		Describe("balance", func() {
+			It("adds every accrual to the accrued total", func() {
+				accrue(alice, "12345678903", 100)
+				accrue(alice, "2377225624", 50)
+
+				Expect(current(alice)).To(Equal(float64(150)))
+			})
+
+			It("returns not found error for an unknown balance", func() {
+				carol := &model.User{Login: "carol"}
+
+				_, err := repo.GetBalance(ctx, carol)
+				Expect(err).To(MatchError(repository.ErrNotFound))
+				Expect(repo.WithdrawFromBalance(ctx, carol, "2377225624", 10)).To(MatchError(repository.ErrNotFound))
+			})
+
+			It("never becomes negative", func() {
+				accrue(alice, "12345678903", 100)
+
+				Expect(repo.WithdrawFromBalance(ctx, alice, "2377225624", 150)).To(MatchError(repository.ErrNegativeBalance))
+				Expect(current(alice)).To(Equal(100.0))
+
+				Expect(repo.WithdrawFromBalance(ctx, alice, "2377225624", 60)).To(Succeed())
+				Expect(current(alice)).To(Equal(40.0))
+
+				withdrawals, _, err := repo.GetListOfWithdrawals(ctx, alice, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(withdrawals).To(HaveLen(1))
+				Expect(withdrawals[0].Sum).To(Equal(60.0))
+			})
+
+			It("rejects withdrawals of a sum, which is not positive", func() {
+				accrue(alice, "12345678903", 100)
+
+				validationErr := &model.ValidationError{Field: "sum", Message: "must be positive"}
+				Expect(repo.WithdrawFromBalance(ctx, alice, "2377225624", -50)).To(MatchError(validationErr))
+				Expect(repo.WithdrawFromBalance(ctx, alice, "2377225624", 0)).To(MatchError(validationErr))
+				Expect(current(alice)).To(Equal(100.0))
+
+				withdrawals, _, err := repo.GetListOfWithdrawals(ctx, alice, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(withdrawals).To(BeEmpty())
+			})
+
+			It("allows concurrent withdrawals up to the balance only", func() {
+				accrue(alice, "12345678903", 100)
+
+				var (
+					wg        sync.WaitGroup
+					mu        sync.Mutex
+					succeeded int
+				)
+				for i := 0; i < 20; i++ {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+
+						err := repo.WithdrawFromBalance(ctx, alice, "2377225624", 10)
+						if err == nil {
+							mu.Lock()
+							succeeded++
+							mu.Unlock()
+							return
+						}
+						Expect(err).To(MatchError(repository.ErrNegativeBalance))
+					}()
+				}
+				wg.Wait()
+
+				Expect(succeeded).To(Equal(10))
+				Expect(current(alice)).To(BeZero())
+			})
+
+			It("reverses the last withdrawal of the order once", func() {
+				accrue(alice, "12345678903", 100)
+				Expect(repo.WithdrawFromBalance(ctx, alice, "2377225624", 60)).To(Succeed())
+
+				withdrawal, err := repo.ReverseWithdrawal(ctx, "2377225624", "cancelled", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(withdrawal.Sum).To(Equal(60.0))
+				Expect(withdrawal.ReversedAt).NotTo(BeNil())
+				Expect(withdrawal.ReversalReason).To(Equal("cancelled"))
+				Expect(current(alice)).To(Equal(100.0))
+
+				_, err = repo.ReverseWithdrawal(ctx, "2377225624", "cancelled", nil)
+				Expect(err).To(MatchError(repository.ErrAlreadyReversed))
+				_, err = repo.ReverseWithdrawal(ctx, "79927398713", "cancelled", nil)
+				Expect(err).To(MatchError(repository.ErrNotFound))
+
+				spent, err := repo.GetSpentTotal(ctx, alice, time.Now().Add(-time.Hour))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spent).To(BeZero())
+			})
+
+			It("gives a bonus of a rule for an order once", func() {
+				rule, err := repo.CreatePromotionRule(ctx, &model.PromotionRule{Name: "double", BonusRate: 1})
+				Expect(err).NotTo(HaveOccurred())
+
+				bonus := &model.Bonus{RuleID: rule.ID, OrderNumber: "12345678903", Amount: 50}
+				accrue(alice, "12345678903", 50, bonus)
+				Expect(current(alice)).To(Equal(100.0))
+
+				// Processing of the order is repeated
+				Expect(repo.UpdateBalanceAccrued(ctx, &model.Order{Login: "alice", Number: "12345678903"}, &model.OrderAccrual{
+					OrderNumber: "12345678903",
+					Status:      queries.OrderStatusPROCESSED,
+				}, func(int64) model.Bonuses { return model.Bonuses{bonus} }, nil)).To(Succeed())
+				Expect(current(alice)).To(Equal(100.0))
+
+				// The rule has given a bonus, so it can be disabled only
+				Expect(repo.DeletePromotionRule(ctx, rule.ID)).To(MatchError(repository.ErrConflict))
+			})
+
+			It("gives a first order bonus once, when orders are processed concurrently", func() {
+				rule, err := repo.CreatePromotionRule(ctx, &model.PromotionRule{Name: "first order", FirstOrder: true, BonusPoints: 100})
+				Expect(err).NotTo(HaveOccurred())
+
+				numbers := []string{"12345678903", "79927398713", "2377225624", "49927398716"}
+				for _, number := range numbers {
+					_, err := repo.CreateOrder(ctx, &model.Order{Login: alice.Login, Number: number})
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				var wg sync.WaitGroup
+				for _, number := range numbers {
+					wg.Add(1)
+					go func(number string) {
+						defer GinkgoRecover()
+						defer wg.Done()
+
+						Expect(repo.UpdateBalanceAccrued(ctx, &model.Order{Login: alice.Login, Number: number}, &model.OrderAccrual{
+							OrderNumber: number,
+							Status:      queries.OrderStatusPROCESSED,
+							Accrual:     10,
+						}, func(previousOrders int64) model.Bonuses {
+							if previousOrders > 0 {
+								return nil
+							}
+							return model.Bonuses{{RuleID: rule.ID, OrderNumber: number, Amount: rule.BonusPoints}}
+						}, nil)).To(Succeed())
+					}(number)
+				}
+				wg.Wait()
+
+				Expect(current(alice)).To(Equal(140.0))
+			})
+
+			It("expires the remainders of the oldest lots", func() {
+				expiring := time.Now().UTC().Add(time.Hour)
+				expiresAt = &expiring
+				accrue(alice, "12345678903", 100)
+				expiresAt = nil
+				accrue(alice, "79927398713", 50)
+
+				// The oldest points are spent first
+				Expect(repo.WithdrawFromBalance(ctx, alice, "2377225624", 30)).To(Succeed())
+
+				expirations, err := repo.GetUpcomingExpirations(ctx, alice, 10)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expirations).To(HaveLen(1))
+				Expect(expirations[0].Amount).To(Equal(70.0))
+
+				lots, amount, err := repo.ExpireLots(ctx, expiring.Add(time.Hour))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lots).To(BeEquivalentTo(1))
+				Expect(amount).To(Equal(70.0))
+				Expect(current(alice)).To(Equal(50.0))
+
+				// Expired lots are not expired again
+				lots, _, err = repo.ExpireLots(ctx, expiring.Add(time.Hour))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lots).To(BeZero())
+			})
+
+			It("streams the statement with running balance", func() {
+				accrue(alice, "12345678903", 100)
+				Expect(repo.WithdrawFromBalance(ctx, alice, "2377225624", 40)).To(Succeed())
+
+				var entries []model.StatementEntry
+				now := time.Now().UTC()
+				Expect(repo.Statement(ctx, alice, now.Add(-24*time.Hour), now.Add(24*time.Hour), func(entry *model.StatementEntry) error {
+					entries = append(entries, *entry)
+					return nil
+				})).To(Succeed())
+
+				Expect(entries).To(HaveLen(2))
+				Expect(entries[0].Type).To(Equal(model.StatementAccrual))
+				Expect(entries[0].Balance).To(Equal(100.0))
+				Expect(entries[1].Type).To(Equal(model.StatementWithdrawal))
+				Expect(entries[1].Amount).To(Equal(-40.0))
+				Expect(entries[1].Balance).To(Equal(60.0))
+			})
+		})
+
+		Describe("concurrent load", Label("stress"), func() {
+			const (
+				workers     = 32
+				operations  = 2000 // Accruals and withdrawals by turns
+				accrual     = 10.0
+				withdrawal  = 7.0
+				monitorTick = time.Millisecond
+			)
+
+			It("keeps the balance invariants under parallel withdrawals and accruals", func() {
+				numbers := make([]string, operations/2)
+				for i := range numbers {
+					numbers[i] = fmt.Sprintf("1%09d", i)
+				}
+				created, _, err := repo.CreateOrders(ctx, alice.Login, numbers)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(HaveLen(len(numbers)))
+
+				var (
+					mu                     sync.Mutex
+					accruals, withdrawals  int
+					monitorDone, loadDone  = make(chan struct{}), make(chan struct{})
+					minCurrent, maxCurrent float64
+				)
+
+				// The balance must never be negative, and the withdrawn sum must never decrease
+				go func() {
+					defer GinkgoRecover()
+					defer close(monitorDone)
+
+					var lastWithdrawn float64
+					for {
+						select {
+						case <-loadDone:
+							return
+						case <-time.After(monitorTick):
+						}
+
+						userBalance, err := repo.GetBalance(ctx, alice)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(userBalance.Withdrawn).To(BeNumerically(">=", lastWithdrawn))
+						lastWithdrawn = userBalance.Withdrawn
+						minCurrent, maxCurrent = min(minCurrent, userBalance.Current), max(maxCurrent, userBalance.Current)
+					}
+				}()
+
+				jobs := make(chan int)
+				var wg sync.WaitGroup
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+
+						for i := range jobs {
+							if i%2 == 0 {
+								number := numbers[i/2]
+								Expect(repo.UpdateBalanceAccrued(ctx, &model.Order{Login: alice.Login, Number: number}, &model.OrderAccrual{
+									OrderNumber: number,
+									Status:      queries.OrderStatusPROCESSED,
+									Accrual:     accrual,
+								}, nil, nil)).To(Succeed())
+
+								mu.Lock()
+								accruals++
+								mu.Unlock()
+								continue
+							}
+
+							err := repo.WithdrawFromBalance(ctx, alice, fmt.Sprintf("2%09d", i), withdrawal)
+							if errors.Is(err, repository.ErrNegativeBalance) {
+								continue
+							}
+							Expect(err).NotTo(HaveOccurred())
+
+							mu.Lock()
+							withdrawals++
+							mu.Unlock()
+						}
+					}()
+				}
+				for i := 0; i < operations; i++ {
+					jobs <- i
+				}
+				close(jobs)
+				wg.Wait()
+				close(loadDone)
+				<-monitorDone
+
+				Expect(accruals).To(Equal(operations / 2))
+				Expect(withdrawals).To(BeNumerically(">", 0))
+				Expect(minCurrent).To(BeNumerically(">=", 0))
+
+				// Every successful operation is accounted for exactly once
+				userBalance, err := repo.GetBalance(ctx, alice)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(userBalance.Withdrawn).To(BeNumerically("~", float64(withdrawals)*withdrawal, 1e-6))
+				Expect(userBalance.Current).To(BeNumerically("~", float64(accruals)*accrual-float64(withdrawals)*withdrawal, 1e-6))
+				Expect(userBalance.Current).To(BeNumerically(">=", 0))
+				Expect(maxCurrent).To(BeNumerically("<=", float64(accruals)*accrual))
+
+				userWithdrawals, _, err := repo.GetListOfWithdrawals(ctx, alice, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(userWithdrawals).To(HaveLen(withdrawals))
+
+				// The running balance of the statement ends with the current balance
+				var last *model.StatementEntry
+				now := time.Now().UTC()
+				Expect(repo.Statement(ctx, alice, now.Add(-24*time.Hour), now.Add(24*time.Hour), func(entry *model.StatementEntry) error {
+					last = entry
+					return nil
+				})).To(Succeed())
+				Expect(last).NotTo(BeNil())
+				Expect(last.Balance).To(BeNumerically("~", userBalance.Current, 1e-6))
+
+				// Every change is audited in an unbroken chain
+				_, err = repo.ChainAuditLog(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				chain := auditchain.NewChain()
+				var checked int
+				Expect(repo.AuditChain(ctx, func(entry *model.AuditEntry) error {
+					Expect(chain.Append(entry)).To(BeTrue(), "entry %d breaks the chain", entry.ID)
+					checked++
+					return nil
+				})).To(Succeed())
+				Expect(checked).To(Equal(2 + accruals + withdrawals))
+			})
+		})
+
+		Describe("transfers", func() {
+			It("moves points between users within the daily limit", func() {
+				accrue(alice, "12345678903", 100)
+				since := time.Now().UTC().Add(-time.Hour)
+
+				transfer, err := repo.Transfer(ctx, &model.Transfer{Sender: "alice", Recipient: "bob", Sum: 30}, 50, since, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(transfer.Sum).To(Equal(30.0))
+				Expect(transfer.TransferredAt).NotTo(BeZero())
+
+				_, err = repo.Transfer(ctx, &model.Transfer{Sender: "alice", Recipient: "bob", Sum: 30}, 50, since, nil)
+				Expect(err).To(MatchError(repository.ErrLimitExceeded))
+
+				Expect(current(alice)).To(Equal(70.0))
+				Expect(current(bob)).To(Equal(30.0))
+
+				transfers, _, err := repo.GetListOfTransfers(ctx, alice, model.Page{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(transfers).To(HaveLen(1))
+			})
+
+			It("rejects unknown and disabled recipients", func() {
+				accrue(alice, "12345678903", 100)
+
+				_, err := repo.Transfer(ctx, &model.Transfer{Sender: "alice", Recipient: "carol", Sum: 10}, 0, time.Time{}, nil)
+				Expect(err).To(MatchError(repository.ErrNotFound))
+
+				Expect(repo.DeleteUser(ctx, "bob", true)).To(Succeed())
+				_, err = repo.Transfer(ctx, &model.Transfer{Sender: "alice", Recipient: model.DeletedLoginPrefix + "2", Sum: 10}, 0, time.Time{}, nil)
+				Expect(err).To(MatchError(repository.ErrDisabled))
+
+				Expect(current(alice)).To(Equal(100.0))
+			})
+
+			It("rejects unknown and disabled senders", func() {
+				accrue(alice, "12345678903", 100)
+
+				_, err := repo.Transfer(ctx, &model.Transfer{Sender: "carol", Recipient: "alice", Sum: 10}, 0, time.Time{}, nil)
+				Expect(err).To(MatchError(repository.ErrSenderNotFound))
+
+				Expect(repo.DisableUser(ctx, "alice")).To(Succeed())
+				_, err = repo.Transfer(ctx, &model.Transfer{Sender: "alice", Recipient: "bob", Sum: 10}, 0, time.Time{}, nil)
+				Expect(err).To(MatchError(repository.ErrSenderDisabled))
+
+				Expect(current(alice)).To(Equal(100.0))
+				Expect(current(bob)).To(BeZero())
+			})
+		})
+
+		Describe("tiers", func() {
+			It("saves changed tiers only", func() {
+				changed, err := repo.SaveTier(ctx, alice, "silver", 100)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changed).To(BeTrue())
+
+				changed, err = repo.SaveTier(ctx, alice, "silver", 150)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changed).To(BeFalse())
+
+				changed, err = repo.SaveTier(ctx, alice, "gold", 500)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changed).To(BeTrue())
+
+				history, err := repo.GetTierHistory(ctx, alice, 10)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(history).To(HaveLen(2))
+				Expect(history[0].Tier).To(Equal("gold"))
+			})
+		})
+
+		Describe("promotion rules", func() {
+			It("creates, updates and deletes rules", func() {
+				rule, err := repo.CreatePromotionRule(ctx, &model.PromotionRule{Name: "weekend", Weekdays: []time.Weekday{time.Saturday}, BonusPoints: 10})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rule.ID).NotTo(BeZero())
+
+				rule.Disabled = true
+				updated, err := repo.UpdatePromotionRule(ctx, rule)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(updated.Disabled).To(BeTrue())
+				Expect(updated.Weekdays).To(Equal([]time.Weekday{time.Saturday}))
+
+				enabled, err := repo.GetEnabledPromotionRules(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(enabled).To(BeEmpty())
+
+				Expect(repo.DeletePromotionRule(ctx, rule.ID)).To(Succeed())
+				Expect(repo.DeletePromotionRule(ctx, rule.ID)).To(MatchError(repository.ErrNotFound))
+
+				_, err = repo.UpdatePromotionRule(ctx, rule)
+				Expect(err).To(MatchError(repository.ErrNotFound))
+			})
+		})
+
+		Describe("audit log", func() {
+			It("chains the entries of all changes", func() {
+				accrue(alice, "12345678903", 100)
+				Expect(repo.AppendAudit(ctx, "alice", model.AuditUserLogin)).To(Succeed())
+
+				// Queued entries are appended to the log by its writer
+				_, err := repo.ChainAuditLog(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				chained, err := repo.ChainAuditLog(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chained).To(BeZero())
+
+				entries, err := repo.GetAuditEntries(ctx, &model.AuditFilter{Login: "alice", Limit: 10})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(3))
+				Expect(entries[0].Action).To(Equal(model.AuditUserLogin))
+				Expect(entries[1].Action).To(Equal(model.AuditBalanceAccrual))
+				Expect(string(entries[1].NewValue)).To(ContainSubstring(`"current":100`))
+
+				entries, err = repo.GetAuditEntries(ctx, &model.AuditFilter{Action: model.AuditUserRegister, Limit: 1})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0].Login).To(Equal("bob"))
+
+				chain := auditchain.NewChain()
+				var checked int
+				Expect(repo.AuditChain(ctx, func(entry *model.AuditEntry) error {
+					Expect(chain.Append(entry)).To(BeTrue(), "entry %d breaks the chain", entry.ID)
+					checked++
+					return nil
+				})).To(Succeed())
+				Expect(checked).To(Equal(4))
+				Expect(chain.LastHash()).NotTo(Equal(auditchain.GenesisHash))
+			})
+		})
+	})
+}
@@ -0,0 +1,1471 @@
+package repository
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/RomanAgaltsev/ya_gophermart/internal/database/queries"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/model"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
+)
+
+// memoryUser is a user kept in memory.
+type memoryUser struct {
+    user model.User
+}
+
+// memoryBalance is a user balance kept in memory.
+type memoryBalance struct {
+    accrued   float64
+    withdrawn float64
+}
+
+// memoryReversal is a compensating entry of a reversed withdrawal.
+type memoryReversal struct {
+    login       string
+    orderNumber string
+    sum         float64
+    reversedAt  time.Time
+}
+
+// memoryLot is a lot of accrued points.
+type memoryLot struct {
+    id          int32
+    login       string
+    orderNumber string
+    amount      float64
+    remaining   float64
+    accruedAt   time.Time
+    expiresAt   *time.Time
+}
+
+// memoryExpiration is an expired remainder of a lot.
+type memoryExpiration struct {
+    lot       *memoryLot
+    login     string
+    amount    float64
+    expiredAt time.Time
+}
+
+// memoryTierChange is a tier history record of a user.
+type memoryTierChange struct {
+    login  string
+    change model.TierChange
+}
+
+// memoryBonus is a bonus given to a user.
+type memoryBonus struct {
+    login     string
+    bonus     model.Bonus
+    createdAt time.Time
+}
+
+// MemoryRepository is the repository, which keeps all data in memory.
+// It is used for development and fast tests - the data is lost, when the application stops.
+// The methods are safe for concurrent use and have the same semantics as the methods of Repository.
+type MemoryRepository struct {
+    mu sync.RWMutex
+
+    memoryState
+}
+
+// memoryState is the data of the in-memory repository.
+type memoryState struct {
+    users          map[string]*memoryUser
+    orders         []*model.Order
+    ordersByNumber map[string]*model.Order
+    balances       map[string]*memoryBalance
+    withdrawals    []*model.Withdrawal
+    reversals      []*memoryReversal
+    lots           []*memoryLot
+    expirations    []*memoryExpiration
+    tierHistory    []*memoryTierChange
+    transfers      []*model.Transfer
+    promotionRules []*model.PromotionRule
+    bonuses        []*memoryBonus
+    auditLog       []*model.AuditEntry
+
+    // Identifiers are never reused, like database sequences
+    lastUserID int32
+    lastLotID  int32
+    lastRuleID int32
+}
+
+// NewMemory creates new in-memory repository.
+func NewMemory() (*MemoryRepository, error) {
+    return &MemoryRepository{
+        memoryState: memoryState{
+            users:          make(map[string]*memoryUser),
+            ordersByNumber: make(map[string]*model.Order),
+            balances:       make(map[string]*memoryBalance),
+        },
+    }, nil
+}
+
+// memoryTxContextKey is the context key of the in-memory repository, which runs a unit of work.
+type memoryTxContextKey struct{}
+
+// WithinTransaction runs the function as a unit of work - the repository is locked while the function runs,
+// and the changes made with the context passed to the function are discarded, if the function fails.
+// A nested unit of work discards its own changes only.
+func (r *MemoryRepository) WithinTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+    defer r.lock(ctx)()
+
+    snapshot := r.memoryState.clone()
+    if err := f(context.WithValue(ctx, memoryTxContextKey{}, r)); err != nil {
+        r.memoryState = snapshot
+        return err
+    }
+
+    return nil
+}
+
+// inTransaction checks if the context belongs to a unit of work of the repository, which holds the lock.
+func (r *MemoryRepository) inTransaction(ctx context.Context) bool {
+    tx, ok := ctx.Value(memoryTxContextKey{}).(*MemoryRepository)
+    return ok && tx == r
+}
+
+// lock locks the repository for writing, unless it is locked by the unit of work, and returns the unlock function.
+func (r *MemoryRepository) lock(ctx context.Context) func() {
+    if r.inTransaction(ctx) {
+        return func() {}
+    }
+    r.mu.Lock()
+
+    return r.mu.Unlock
+}
+
+// rlock locks the repository for reading, unless it is locked by the unit of work, and returns the unlock function.
+func (r *MemoryRepository) rlock(ctx context.Context) func() {
+    if r.inTransaction(ctx) {
+        return func() {}
+    }
+    r.mu.RLock()
+
+    return r.mu.RUnlock
+}
+
+// clone returns a deep copy of the data, which a failed unit of work is rolled back to.
+func (s *memoryState) clone() memoryState {
+    cloned := *s
+
+    cloned.users = make(map[string]*memoryUser, len(s.users))
+    for login, usr := range s.users {
+        copied := *usr
+        cloned.users[login] = &copied
+    }
+
+    cloned.orders = make([]*model.Order, 0, len(s.orders))
+    cloned.ordersByNumber = make(map[string]*model.Order, len(s.ordersByNumber))
+    for _, order := range s.orders {
+        copied := *order
+        cloned.orders = append(cloned.orders, &copied)
+        cloned.ordersByNumber[copied.Number] = &copied
+    }
+
+    cloned.balances = make(map[string]*memoryBalance, len(s.balances))
+    for login, balance := range s.balances {
+        copied := *balance
+        cloned.balances[login] = &copied
+    }
+
+    cloned.withdrawals = make([]*model.Withdrawal, 0, len(s.withdrawals))
+    for _, withdrawal := range s.withdrawals {
+        cloned.withdrawals = append(cloned.withdrawals, copyWithdrawal(withdrawal))
+    }
+
+    cloned.reversals = cloneAll(s.reversals)
+    cloned.tierHistory = cloneAll(s.tierHistory)
+    cloned.transfers = cloneAll(s.transfers)
+    cloned.bonuses = cloneAll(s.bonuses)
+
+    // Expirations refer to the cloned lots
+    lots := make(map[*memoryLot]*memoryLot, len(s.lots))
+    cloned.lots = make([]*memoryLot, 0, len(s.lots))
+    for _, lot := range s.lots {
+        copied := *lot
+        lots[lot] = &copied
+        cloned.lots = append(cloned.lots, &copied)
+    }
+    cloned.expirations = make([]*memoryExpiration, 0, len(s.expirations))
+    for _, expiration := range s.expirations {
+        copied := *expiration
+        copied.lot = lots[expiration.lot]
+        cloned.expirations = append(cloned.expirations, &copied)
+    }
+
+    cloned.promotionRules = make([]*model.PromotionRule, 0, len(s.promotionRules))
+    for _, rule := range s.promotionRules {
+        cloned.promotionRules = append(cloned.promotionRules, copyPromotionRule(rule))
+    }
+
+    // Audit log entries are never changed
+    cloned.auditLog = s.auditLog[:len(s.auditLog):len(s.auditLog)]
+
+    return cloned
+}
+
+// cloneAll returns a slice of copies of the items.
+func cloneAll[T any](items []*T) []*T {
+    cloned := make([]*T, 0, len(items))
+    for _, item := range items {
+        copied := *item
+        cloned = append(cloned, &copied)
+    }
+
+    return cloned
+}
+
+// now returns current time with the precision of database timestamps.
+func (r *MemoryRepository) now() time.Time {
+    return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// CreateUser creates new user in the repository.
+func (r *MemoryRepository) CreateUser(ctx context.Context, user *model.User) error {
+    defer r.lock(ctx)()
+
+    // Logins are unique
+    if _, ok := r.users[user.Login]; ok {
+        return ErrConflict
+    }
+
+    // New users start with session version 1, version 0 is left to the accounts created before tokens had user IDs
+    r.lastUserID++
+    r.users[user.Login] = &memoryUser{
+        user: model.User{
+            ID:             r.lastUserID,
+            Login:          user.Login,
+            Password:       user.Password,
+            SessionVersion: 1,
+        },
+    }
+    user.ID, user.SessionVersion = r.lastUserID, 1
+
+    r.appendAudit(ctx, auditRecord{
+        login:    user.Login,
+        action:   model.AuditUserRegister,
+        newValue: auditUser{Disabled: false},
+    })
+
+    return nil
+}
+
+// GetUser returns a user from repository.
+func (r *MemoryRepository) GetUser(ctx context.Context, login string) (*model.User, error) {
+    defer r.rlock(ctx)()
+
+    // Check if there is nothing to return
+    usr, ok := r.users[login]
+    if !ok {
+        return nil, nil
+    }
+
+    user := usr.user
+    return &user, nil
+}
+
+// DisableUser disables the user, so the user cannot log in anymore.
+func (r *MemoryRepository) DisableUser(ctx context.Context, login string) error {
+    defer r.lock(ctx)()
+
+    // There is no such user
+    usr, ok := r.users[login]
+    if !ok {
+        return ErrNotFound
+    }
+
+    usr.user.Disabled = true
+    usr.user.SessionVersion++
+
+    r.appendAudit(ctx, auditRecord{
+        login:    login,
+        action:   model.AuditUserDisable,
+        newValue: auditUser{Disabled: true},
+    })
+
+    return nil
+}
+
+// UpdateUserPassword replaces the user password hash and returns the new session version.
+func (r *MemoryRepository) UpdateUserPassword(ctx context.Context, login string, password string) (int32, error) {
+    defer r.lock(ctx)()
+
+    // There is no such user
+    usr, ok := r.users[login]
+    if !ok {
+        return 0, ErrNotFound
+    }
+
+    usr.user.Password = password
+    usr.user.SessionVersion++
+
+    // Password hashes are not written to the audit log
+    r.appendAudit(ctx, auditRecord{
+        login:  login,
+        action: model.AuditUserPasswordChange,
+    })
+
+    return usr.user.SessionVersion, nil
+}
+
+// UpdateUserTimezone sets the time zone, which times are rendered in for the user.
+func (r *MemoryRepository) UpdateUserTimezone(ctx context.Context, login string, timezone string) error {
+    defer r.lock(ctx)()
+
+    // There is no such user
+    usr, ok := r.users[login]
+    if !ok {
+        return ErrNotFound
+    }
+
+    usr.user.Timezone = timezone
+
+    return nil
+}
+
+// DeleteUser deletes the user and anonymizes or deletes the user data.
+// The login is replaced with a pseudonym in the data, which is kept.
+// Transfers are always anonymized - they belong to the statements of other users too.
+func (r *MemoryRepository) DeleteUser(ctx context.Context, login string, anonymize bool) error {
+    defer r.lock(ctx)()
+
+    // There is no such user
+    usr, ok := r.users[login]
+    if !ok {
+        return ErrNotFound
+    }
+
+    // Pseudonym is unique, as user IDs are never reused
+    pseudonym := fmt.Sprintf("%s%d", model.DeletedLoginPrefix, usr.user.ID)
+    disabled := usr.user.Disabled
+
+    for _, transfer := range r.transfers {
+        if transfer.Sender == login {
+            transfer.Sender = pseudonym
+        }
+        if transfer.Recipient == login {
+            transfer.Recipient = pseudonym
+        }
+    }
+
+    if anonymize {
+        r.renameUserData(login, pseudonym)
+
+        usr.user.Login = pseudonym
+        usr.user.Password = ""
+        usr.user.Disabled = true
+        usr.user.SessionVersion++
+        usr.user.Timezone = ""
+        r.users[pseudonym] = usr
+    } else {
+        r.deleteUserData(login)
+    }
+    delete(r.users, login)
+
+    // The audit log is append-only, so the entries of the user are kept as they are
+    r.appendAudit(ctx, auditRecord{
+        login:    login,
+        action:   model.AuditUserDelete,
+        oldValue: auditUser{Disabled: disabled},
+        newValue: auditDeletion{Anonymized: anonymize, Pseudonym: pseudonym},
+    })
+
+    return nil
+}
+
+// renameUserData replaces the login with the pseudonym in all user data except transfers.
+func (r *MemoryRepository) renameUserData(login, pseudonym string) {
+    for _, order := range r.orders {
+        if order.Login == login {
+            order.Login = pseudonym
+        }
+    }
+    for _, withdrawal := range r.withdrawals {
+        if withdrawal.Login == login {
+            withdrawal.Login = pseudonym
+        }
+    }
+    for _, reversal := range r.reversals {
+        if reversal.login == login {
+            reversal.login = pseudonym
+        }
+    }
+    for _, lot := range r.lots {
+        if lot.login == login {
+            lot.login = pseudonym
+        }
+    }
+    for _, expiration := range r.expirations {
+        if expiration.login == login {
+            expiration.login = pseudonym
+        }
+    }
+    for _, change := range r.tierHistory {
+        if change.login == login {
+            change.login = pseudonym
+        }
+    }
+    for _, bonus := range r.bonuses {
+        if bonus.login == login {
+            bonus.login = pseudonym
+        }
+    }
+    if balance, ok := r.balances[login]; ok {
+        delete(r.balances, login)
+        r.balances[pseudonym] = balance
+    }
+}
+
+// deleteUserData deletes all user data except transfers.
+func (r *MemoryRepository) deleteUserData(login string) {
+    r.expirations = slices.DeleteFunc(r.expirations, func(expiration *memoryExpiration) bool {
+        return expiration.login == login
+    })
+    r.lots = slices.DeleteFunc(r.lots, func(lot *memoryLot) bool {
+        return lot.login == login
+    })
+    r.reversals = slices.DeleteFunc(r.reversals, func(reversal *memoryReversal) bool {
+        return reversal.login == login
+    })
+    r.withdrawals = slices.DeleteFunc(r.withdrawals, func(withdrawal *model.Withdrawal) bool {
+        return withdrawal.Login == login
+    })
+    r.orders = slices.DeleteFunc(r.orders, func(order *model.Order) bool {
+        if order.Login == login {
+            delete(r.ordersByNumber, order.Number)
+            return true
+        }
+        return false
+    })
+    r.tierHistory = slices.DeleteFunc(r.tierHistory, func(change *memoryTierChange) bool {
+        return change.login == login
+    })
+    r.bonuses = slices.DeleteFunc(r.bonuses, func(bonus *memoryBonus) bool {
+        return bonus.login == login
+    })
+    delete(r.balances, login)
+}
+
+// CreateOrder creates new order in the repository.
+// If the order number already exists, the existing order is returned with conflict error.
+func (r *MemoryRepository) CreateOrder(ctx context.Context, order *model.Order) (*model.Order, error) {
+    defer r.lock(ctx)()
+
+    // There is a conflict
+    if existing, ok := r.ordersByNumber[order.Number]; ok {
+        existingOrder := *existing
+        return &existingOrder, ErrConflict
+    }
+
+    r.createOrder(order.Login, order.Number)
+
+    return nil, nil
+}
+
+// createOrder creates new order with NEW status.
+func (r *MemoryRepository) createOrder(login, number string) {
+    order := &model.Order{
+        Login:      login,
+        Number:     number,
+        Status:     queries.OrderStatusNEW,
+        UploadedAt: r.now(),
+    }
+    r.orders = append(r.orders, order)
+    r.ordersByNumber[number] = order
+}
+
+// CreateOrders creates orders with the given numbers at once.
+// It returns numbers of created orders and already existing orders for the rest of numbers.
+func (r *MemoryRepository) CreateOrders(ctx context.Context, login string, numbers []string) ([]string, model.Orders, error) {
+    defer r.lock(ctx)()
+
+    var (
+        created  []string
+        existing model.Orders
+        skipped  []string
+    )
+
+    // Existing numbers are skipped
+    for _, number := range numbers {
+        if _, ok := r.ordersByNumber[number]; ok {
+            skipped = append(skipped, number)
+            continue
+        }
+        r.createOrder(login, number)
+        created = append(created, number)
+    }
+
+    for _, number := range skipped {
+        existingOrder := *r.ordersByNumber[number]
+        existing = append(existing, &existingOrder)
+    }
+
+    return created, existing, nil
+}
+
+// GetListOfOrders returns a page of user orders, the newest first, and the total number of user orders.
+func (r *MemoryRepository) GetListOfOrders(ctx context.Context, user *model.User, page model.Page) (model.Orders, int, error) {
+    defer r.rlock(ctx)()
+
+    orders := r.selectOrders(func(order *model.Order) bool {
+        return order.Login == user.Login
+    })
+    sortNewestFirst(orders, func(order *model.Order) time.Time {
+        return order.UploadedAt
+    })
+
+    orders, total := pageOf(orders, page)
+    return orders, total, nil
+}
+
+// GetListOfStuckOrders returns the orders, which are still NEW or PROCESSING after being uploaded before the given time.
+func (r *MemoryRepository) GetListOfStuckOrders(ctx context.Context, uploadedBefore time.Time) (model.Orders, error) {
+    defer r.rlock(ctx)()
+
+    orders := r.selectOrders(func(order *model.Order) bool {
+        return isOrderToProcess(order) && order.UploadedAt.Before(uploadedBefore)
+    })
+    sort.SliceStable(orders, func(i, j int) bool {
+        return orders[i].UploadedAt.Before(orders[j].UploadedAt)
+    })
+
+    return orders, nil
+}
+
+// RequeueOrder returns the order waiting for processing to NEW status, so it will be processed again.
+// Processed and invalid orders are final and cannot be requeued.
+func (r *MemoryRepository) RequeueOrder(ctx context.Context, orderNumber string) error {
+    defer r.lock(ctx)()
+
+    // There is no such order or its status is final
+    order, ok := r.ordersByNumber[orderNumber]
+    if !ok || !isOrderToProcess(order) {
+        return ErrNotFound
+    }
+
+    order.Status = queries.OrderStatusNEW
+
+    return nil
+}
+
+// GetListOfOrdersToProcess returns the orders that need to be processed - NEW and PROCESSING statuses.
+func (r *MemoryRepository) GetListOfOrdersToProcess(ctx context.Context) (model.Orders, error) {
+    defer r.rlock(ctx)()
+
+    return r.selectOrders(isOrderToProcess), nil
+}
+
+// countProcessedOrders returns the number of processed orders of the user, the caller holds the lock.
+func (r *MemoryRepository) countProcessedOrders(login string) int64 {
+    var count int64
+    for _, order := range r.orders {
+        if order.Login == login && order.Status == queries.OrderStatusPROCESSED {
+            count++
+        }
+    }
+
+    return count
+}
+
+// selectOrders returns copies of the orders, which match the condition, in the order of creation.
+func (r *MemoryRepository) selectOrders(match func(order *model.Order) bool) model.Orders {
+    orders := make(model.Orders, 0)
+    for _, order := range r.orders {
+        if match(order) {
+            selected := *order
+            orders = append(orders, &selected)
+        }
+    }
+
+    return orders
+}
+
+// isOrderToProcess checks if the order has NEW or PROCESSING status.
+func isOrderToProcess(order *model.Order) bool {
+    return order.Status == queries.OrderStatusNEW || order.Status == queries.OrderStatusPROCESSING
+}
+
+// CreateBalance creates user balance.
+// The existing balance is kept, if it has already been created.
+func (r *MemoryRepository) CreateBalance(ctx context.Context, user *model.User) error {
+    defer r.lock(ctx)()
+
+    if _, ok := r.balances[user.Login]; !ok {
+        r.balances[user.Login] = &memoryBalance{}
+    }
+
+    return nil
+}
+
+// GetBalance returns user balance.
+func (r *MemoryRepository) GetBalance(ctx context.Context, user *model.User) (*model.Balance, error) {
+    defer r.rlock(ctx)()
+
+    balance, ok := r.balances[user.Login]
+    if !ok {
+        return nil, ErrNotFound
+    }
+
+    return &model.Balance{
+        Current:   balance.accrued - balance.withdrawn,
+        Withdrawn: balance.withdrawn,
+    }, nil
+}
+
+// WithdrawFromBalance - withdraw the given sum from the user balance if its enough to withdraw.
+func (r *MemoryRepository) WithdrawFromBalance(ctx context.Context, user *model.User, orderNumber string, sum float64) error {
+    // Only a positive sum can be withdrawn, a negative one would add points
+    if sum <= 0 {
+        return &model.ValidationError{Field: "sum", Message: "must be positive"}
+    }
+
+    defer r.lock(ctx)()
+
+    balance, ok := r.balances[user.Login]
+    if !ok {
+        return ErrNotFound
+    }
+
+    // The balance cannot become negative after withdrawal
+    if balance.accrued-(balance.withdrawn+sum) < 0 {
+        return ErrNegativeBalance
+    }
+
+    balance.withdrawn += sum
+    r.withdrawals = append(r.withdrawals, &model.Withdrawal{
+        Login:       user.Login,
+        OrderNumber: orderNumber,
+        Sum:         sum,
+        ProcessedAt: r.now(),
+    })
+
+    // Spend the oldest points first
+    r.consumeLots(user.Login, sum)
+
+    newBalance := balanceState(balance.accrued, balance.withdrawn)
+    newBalance.Order, newBalance.Sum = orderNumber, sum
+    r.appendAudit(ctx, auditRecord{
+        login:    user.Login,
+        action:   model.AuditBalanceWithdraw,
+        oldValue: balanceState(balance.accrued, balance.withdrawn-sum),
+        newValue: newBalance,
+    })
+
+    return nil
+}
+
+// GetListOfWithdrawals returns a page of withdrawals from the user balance, the newest first, and the total number of them.
+func (r *MemoryRepository) GetListOfWithdrawals(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error) {
+    defer r.rlock(ctx)()
+
+    withdrawals := make(model.Withdrawals, 0)
+    for _, withdrawal := range r.withdrawals {
+        if withdrawal.Login == user.Login {
+            withdrawals = append(withdrawals, copyWithdrawal(withdrawal))
+        }
+    }
+    sortNewestFirst(withdrawals, func(withdrawal *model.Withdrawal) time.Time {
+        return withdrawal.ProcessedAt
+    })
+
+    withdrawals, total := pageOf(withdrawals, page)
+    return withdrawals, total, nil
+}
+
+// ReverseWithdrawal marks the last withdrawal of the order as reversed, creates a compensating reversal
+// and returns the sum back to the user balance at once.
+func (r *MemoryRepository) ReverseWithdrawal(ctx context.Context, orderNumber string, reason string, expiresAt *time.Time) (*model.Withdrawal, error) {
+    defer r.lock(ctx)()
+
+    // Find the last withdrawal of the order
+    var withdrawal *model.Withdrawal
+    for _, w := range r.withdrawals {
+        if w.OrderNumber == orderNumber && (withdrawal == nil || !w.ProcessedAt.Before(withdrawal.ProcessedAt)) {
+            withdrawal = w
+        }
+    }
+    if withdrawal == nil {
+        return nil, ErrNotFound
+    }
+
+    // A withdrawal can be reversed only once
+    if withdrawal.ReversedAt != nil {
+        return nil, ErrAlreadyReversed
+    }
+
+    balance, ok := r.balances[withdrawal.Login]
+    if !ok {
+        return nil, ErrNotFound
+    }
+
+    // Mark the withdrawal as reversed and create compensating entry
+    reversedAt := r.now()
+    withdrawal.ReversedAt, withdrawal.ReversalReason = &reversedAt, reason
+    r.reversals = append(r.reversals, &memoryReversal{
+        login:       withdrawal.Login,
+        orderNumber: withdrawal.OrderNumber,
+        sum:         withdrawal.Sum,
+        reversedAt:  reversedAt,
+    })
+
+    // Return the sum back to the balance, returned points make a new lot
+    balance.withdrawn -= withdrawal.Sum
+    r.createLot(withdrawal.Login, withdrawal.OrderNumber, withdrawal.Sum, expiresAt)
+
+    newBalance := balanceState(balance.accrued, balance.withdrawn)
+    newBalance.Order, newBalance.Sum, newBalance.Reason = withdrawal.OrderNumber, withdrawal.Sum, reason
+    r.appendAudit(ctx, auditRecord{
+        login:    withdrawal.Login,
+        action:   model.AuditBalanceReversal,
+        oldValue: balanceState(balance.accrued, balance.withdrawn+withdrawal.Sum),
+        newValue: newBalance,
+    })
+
+    return copyWithdrawal(withdrawal), nil
+}
+
+// copyWithdrawal returns a copy of the withdrawal, which doesn't share the reversal time.
+func copyWithdrawal(withdrawal *model.Withdrawal) *model.Withdrawal {
+    copied := *withdrawal
+    if withdrawal.ReversedAt != nil {
+        reversedAt := *withdrawal.ReversedAt
+        copied.ReversedAt = &reversedAt
+    }
+
+    return &copied
+}
+
+// UpdateBalanceAccrued encreases user balance by the order accrual and the bonuses given for the order.
+// Bonuses are evaluated under the lock, so concurrent accruals of the user count the same processed orders.
+// A bonus of a rule is given for an order only once.
+func (r *MemoryRepository) UpdateBalanceAccrued(ctx context.Context, order *model.Order, accrual *model.OrderAccrual, bonuses model.BonusesFunc, expiresAt *time.Time) error {
+    defer r.lock(ctx)()
+
+    balance, ok := r.balances[order.Login]
+    if !ok {
+        return ErrNotFound
+    }
+
+    // The order is not processed yet, so it is not counted
+    var given model.Bonuses
+    if bonuses != nil {
+        given = bonuses(r.countProcessedOrders(order.Login))
+    }
+
+    // Add the sum to the user balance and update the order
+    balance.accrued += accrual.Accrual
+    if stored, ok := r.ordersByNumber[order.Number]; ok {
+        stored.Status, stored.Accrual = accrual.Status, accrual.Accrual
+    }
+
+    // Accrued points make a new lot
+    if accrual.Accrual > 0 {
+        r.createLot(order.Login, order.Number, accrual.Accrual, expiresAt)
+    }
+
+    newBalance := balanceState(balance.accrued, balance.withdrawn)
+    newBalance.Order, newBalance.Sum, newBalance.Status = order.Number, accrual.Accrual, string(accrual.Status)
+    records := []auditRecord{{
+        login:    order.Login,
+        action:   model.AuditBalanceAccrual,
+        oldValue: balanceState(balance.accrued-accrual.Accrual, balance.withdrawn),
+        newValue: newBalance,
+    }}
+
+    // Give the bonuses
+    for _, bonus := range given {
+        if record := r.createBonus(order.Login, balance, bonus, expiresAt); record != nil {
+            records = append(records, *record)
+        }
+    }
+
+    r.appendAudit(ctx, records...)
+
+    return nil
+}
+
+// createBonus records the bonus and adds it to the user balance as a new lot.
+// The bonus is skipped, if it has already been given for the order by the rule - nil audit record is returned then.
+func (r *MemoryRepository) createBonus(login string, balance *memoryBalance, bonus *model.Bonus, expiresAt *time.Time) *auditRecord {
+    for _, given := range r.bonuses {
+        if given.bonus.OrderNumber == bonus.OrderNumber && given.bonus.RuleID == bonus.RuleID {
+            return nil
+        }
+    }
+
+    r.bonuses = append(r.bonuses, &memoryBonus{
+        login:     login,
+        bonus:     *bonus,
+        createdAt: r.now(),
+    })
+    balance.accrued += bonus.Amount
+    r.createLot(login, bonus.OrderNumber, bonus.Amount, expiresAt)
+
+    newBalance := balanceState(balance.accrued, balance.withdrawn)
+    newBalance.Order, newBalance.Sum, newBalance.RuleID = bonus.OrderNumber, bonus.Amount, bonus.RuleID
+
+    return &auditRecord{
+        login:    login,
+        action:   model.AuditBalanceBonus,
+        oldValue: balanceState(balance.accrued-bonus.Amount, balance.withdrawn),
+        newValue: newBalance,
+    }
+}
+
+// Statement calls the function for every user statement entry processed in the period [from, to).
+// The running balance includes entries before the period.
+func (r *MemoryRepository) Statement(ctx context.Context, user *model.User, from, to time.Time, f func(entry *model.StatementEntry) error) error {
+    unlock := r.rlock(ctx)
+    entries := r.statementEntries(user.Login)
+    unlock()
+
+    // The lock is released - the function can take long
+    var balance float64
+    for _, entry := range entries {
+        balance += entry.Amount
+        entry.Balance = balance
+
+        if entry.ProcessedAt.Before(from) || !entry.ProcessedAt.Before(to) {
+            continue
+        }
+        if err := f(entry); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// statementEntries returns all statement entries of the user ordered by processing time, type and order number.
+func (r *MemoryRepository) statementEntries(login string) []*model.StatementEntry {
+    var entries []*model.StatementEntry
+    add := func(entryType model.StatementEntryType, orderNumber string, amount float64, processedAt time.Time) {
+        entries = append(entries, &model.StatementEntry{
+            Type:        entryType,
+            OrderNumber: orderNumber,
+            Amount:      amount,
+            ProcessedAt: processedAt,
+        })
+    }
+
+    for _, order := range r.orders {
+        if order.Login == login && order.Status == queries.OrderStatusPROCESSED && order.Accrual > 0 {
+            add(model.StatementAccrual, order.Number, order.Accrual, order.UploadedAt)
+        }
+    }
+    for _, withdrawal := range r.withdrawals {
+        if withdrawal.Login == login {
+            add(model.StatementWithdrawal, withdrawal.OrderNumber, -withdrawal.Sum, withdrawal.ProcessedAt)
+        }
+    }
+    for _, reversal := range r.reversals {
+        if reversal.login == login {
+            add(model.StatementReversal, reversal.orderNumber, reversal.sum, reversal.reversedAt)
+        }
+    }
+    for _, expiration := range r.expirations {
+        if expiration.login == login {
+            add(model.StatementExpiration, expiration.lot.orderNumber, -expiration.amount, expiration.expiredAt)
+        }
+    }
+    for _, transfer := range r.transfers {
+        if transfer.Recipient == login {
+            add(model.StatementTransferIn, "", transfer.Sum, transfer.TransferredAt)
+        }
+        if transfer.Sender == login {
+            add(model.StatementTransferOut, "", -transfer.Sum, transfer.TransferredAt)
+        }
+    }
+    for _, bonus := range r.bonuses {
+        if bonus.login == login {
+            add(model.StatementBonus, bonus.bonus.OrderNumber, bonus.bonus.Amount, bonus.createdAt)
+        }
+    }
+
+    sort.SliceStable(entries, func(i, j int) bool {
+        a, b := entries[i], entries[j]
+        if !a.ProcessedAt.Equal(b.ProcessedAt) {
+            return a.ProcessedAt.Before(b.ProcessedAt)
+        }
+        if a.Type != b.Type {
+            return a.Type < b.Type
+        }
+        return a.OrderNumber < b.OrderNumber
+    })
+
+    return entries
+}
+
+// AdjustBalance adds the delta, which can be negative, to the user balance.
+func (r *MemoryRepository) AdjustBalance(ctx context.Context, user *model.User, delta float64, expiresAt *time.Time) (*model.Balance, error) {
+    defer r.lock(ctx)()
+
+    balance, ok := r.balances[user.Login]
+    if !ok {
+        return nil, ErrNotFound
+    }
+
+    // The balance cannot become negative after adjustment
+    current := balance.accrued + delta - balance.withdrawn
+    if current < 0 {
+        return nil, ErrNegativeBalance
+    }
+
+    balance.accrued += delta
+
+    // Added points make a new lot, removed points are taken from the oldest lots
+    if delta > 0 {
+        r.createLot(user.Login, "", delta, expiresAt)
+    } else {
+        r.consumeLots(user.Login, -delta)
+    }
+
+    newBalance := balanceState(balance.accrued, balance.withdrawn)
+    newBalance.Sum = delta
+    r.appendAudit(ctx, auditRecord{
+        login:    user.Login,
+        action:   model.AuditBalanceAdjust,
+        oldValue: balanceState(balance.accrued-delta, balance.withdrawn),
+        newValue: newBalance,
+    })
+
+    return &model.Balance{
+        Current:   current,
+        Withdrawn: balance.withdrawn,
+    }, nil
+}
+
+// ExpireLots expires all lots with expiry time up to the given time and takes their remaining points from balances.
+// It returns the number of expired lots and the total amount of expired points.
+func (r *MemoryRepository) ExpireLots(ctx context.Context, now time.Time) (int64, float64, error) {
+    defer r.lock(ctx)()
+
+    type expiredTotal struct {
+        lots   int64
+        amount float64
+    }
+
+    // Expire lots of all users
+    totals := make(map[string]*expiredTotal)
+    for _, lot := range r.lots {
+        if lot.expiresAt == nil || lot.expiresAt.After(now) || lot.remaining <= 0 {
+            continue
+        }
+
+        r.expirations = append(r.expirations, &memoryExpiration{
+            lot:       lot,
+            login:     lot.login,
+            amount:    lot.remaining,
+            expiredAt: *lot.expiresAt,
+        })
+
+        total, ok := totals[lot.login]
+        if !ok {
+            total = &expiredTotal{}
+            totals[lot.login] = total
+        }
+        total.lots++
+        total.amount += lot.remaining
+
+        lot.remaining = 0
+    }
+
+    // There is nothing expired
+    if len(totals) == 0 {
+        return 0, 0, nil
+    }
+
+    logins := make([]string, 0, len(totals))
+    for login := range totals {
+        logins = append(logins, login)
+    }
+    sort.Strings(logins)
+
+    var (
+        lots    int64
+        amount  float64
+        records = make([]auditRecord, 0, len(logins))
+    )
+    for _, login := range logins {
+        balance, ok := r.balances[login]
+        if !ok {
+            continue
+        }
+
+        total := totals[login]
+        balance.accrued -= total.amount
+        lots += total.lots
+        amount += total.amount
+
+        newBalance := balanceState(balance.accrued, balance.withdrawn)
+        newBalance.Sum = total.amount
+        records = append(records, auditRecord{
+            login:    login,
+            action:   model.AuditBalanceExpiration,
+            oldValue: balanceState(balance.accrued+total.amount, balance.withdrawn),
+            newValue: newBalance,
+        })
+    }
+
+    r.appendAudit(ctx, records...)
+
+    return lots, amount, nil
+}
+
+// GetUpcomingExpirations returns amounts of user points by the days they expire, the nearest days first.
+func (r *MemoryRepository) GetUpcomingExpirations(ctx context.Context, user *model.User, limit int) (model.Expirations, error) {
+    defer r.rlock(ctx)()
+
+    amounts := make(map[time.Time]float64)
+    for _, lot := range r.lots {
+        if lot.login != user.Login || lot.remaining <= 0 || lot.expiresAt == nil {
+            continue
+        }
+        expiresAt := lot.expiresAt.UTC()
+        expiresOn := time.Date(expiresAt.Year(), expiresAt.Month(), expiresAt.Day(), 0, 0, 0, 0, time.UTC)
+        amounts[expiresOn] += lot.remaining
+    }
+
+    expirations := make(model.Expirations, 0, len(amounts))
+    for expiresOn, amount := range amounts {
+        expirations = append(expirations, &model.Expiration{
+            Amount:    amount,
+            ExpiresOn: expiresOn,
+        })
+    }
+    sort.Slice(expirations, func(i, j int) bool {
+        return expirations[i].ExpiresOn.Before(expirations[j].ExpiresOn)
+    })
+
+    return expirations[:min(max(limit, 0), len(expirations))], nil
+}
+
+// createLot creates new lot of accrued points.
+func (r *MemoryRepository) createLot(login string, orderNumber string, amount float64, expiresAt *time.Time) {
+    r.lastLotID++
+
+    lot := &memoryLot{
+        id:          r.lastLotID,
+        login:       login,
+        orderNumber: orderNumber,
+        amount:      amount,
+        remaining:   amount,
+        accruedAt:   r.now(),
+    }
+    if expiresAt != nil {
+        expires := expiresAt.UTC().Truncate(time.Microsecond)
+        lot.expiresAt = &expires
+    }
+
+    r.lots = append(r.lots, lot)
+}
+
+// consumeLots takes the amount from user lots, the oldest lots first.
+// Lots can cover less than the amount, if the balance has been changed bypassing lots.
+func (r *MemoryRepository) consumeLots(login string, amount float64) {
+    // Lots are kept in the order of accrual
+    for _, lot := range r.lots {
+        if amount <= 0 {
+            break
+        }
+        if lot.login != login || lot.remaining <= 0 {
+            continue
+        }
+
+        consumed := min(lot.remaining, amount)
+        amount -= consumed
+        lot.remaining -= consumed
+    }
+}
+
+// GetAccruedTotal returns the total of points accrued to the user for the orders uploaded since the given time.
+func (r *MemoryRepository) GetAccruedTotal(ctx context.Context, user *model.User, since time.Time) (float64, error) {
+    defer r.rlock(ctx)()
+
+    var total float64
+    for _, order := range r.orders {
+        if order.Login == user.Login && order.Status == queries.OrderStatusPROCESSED && !order.UploadedAt.Before(since) {
+            total += order.Accrual
+        }
+    }
+
+    return total, nil
+}
+
+// GetSpentTotal returns the total of points spent by the user since the given time, reversed withdrawals are not counted.
+func (r *MemoryRepository) GetSpentTotal(ctx context.Context, user *model.User, since time.Time) (float64, error) {
+    defer r.rlock(ctx)()
+
+    var total float64
+    for _, withdrawal := range r.withdrawals {
+        if withdrawal.Login == user.Login && withdrawal.ReversedAt == nil && !withdrawal.ProcessedAt.Before(since) {
+            total += withdrawal.Sum
+        }
+    }
+
+    return total, nil
+}
+
+// SaveTier adds the user tier to the tier history, if it differs from the last one.
+// It returns true, if the tier has been changed.
+func (r *MemoryRepository) SaveTier(ctx context.Context, user *model.User, tier string, total float64) (bool, error) {
+    defer r.lock(ctx)()
+
+    // History is kept in the order of changes
+    for i := len(r.tierHistory) - 1; i >= 0; i-- {
+        if r.tierHistory[i].login == user.Login {
+            if r.tierHistory[i].change.Tier == tier {
+                return false, nil
+            }
+            break
+        }
+    }
+
+    r.tierHistory = append(r.tierHistory, &memoryTierChange{
+        login: user.Login,
+        change: model.TierChange{
+            Tier:      tier,
+            Total:     total,
+            ChangedAt: r.now(),
+        },
+    })
+
+    return true, nil
+}
+
+// GetTierHistory returns the latest changes of the user tier, the newest first.
+func (r *MemoryRepository) GetTierHistory(ctx context.Context, user *model.User, limit int) (model.TierChanges, error) {
+    defer r.rlock(ctx)()
+
+    history := make(model.TierChanges, 0)
+    for i := len(r.tierHistory) - 1; i >= 0 && len(history) < limit; i-- {
+        if r.tierHistory[i].login == user.Login {
+            change := r.tierHistory[i].change
+            history = append(history, &change)
+        }
+    }
+
+    return history, nil
+}
+
+// Transfer moves the sum from the sender balance to the recipient balance at once.
+// The sum transferred by the sender since the given time together with this transfer cannot exceed the daily limit,
+// zero limit means there is no limit.
+func (r *MemoryRepository) Transfer(ctx context.Context, transfer *model.Transfer, dailyLimit float64, since time.Time, expiresAt *time.Time) (*model.Transfer, error) {
+    defer r.lock(ctx)()
+
+    // Points can be transferred by an active user only
+    sender, ok := r.users[transfer.Sender]
+    if !ok {
+        return nil, ErrSenderNotFound
+    }
+    if sender.user.Disabled {
+        return nil, ErrSenderDisabled
+    }
+
+    // Points can be transferred to an active user only
+    recipient, ok := r.users[transfer.Recipient]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    if recipient.user.Disabled {
+        return nil, ErrDisabled
+    }
+
+    // Both balances must exist and differ
+    senderBalance, ok := r.balances[transfer.Sender]
+    if !ok {
+        return nil, ErrSenderNotFound
+    }
+    recipientBalance, ok := r.balances[transfer.Recipient]
+    if !ok || transfer.Sender == transfer.Recipient {
+        return nil, ErrNotFound
+    }
+
+    // Check the daily limit
+    if dailyLimit > 0 {
+        var transferred float64
+        for _, t := range r.transfers {
+            if t.Sender == transfer.Sender && !t.TransferredAt.Before(since) {
+                transferred += t.Sum
+            }
+        }
+        if transferred+transfer.Sum > dailyLimit {
+            return nil, ErrLimitExceeded
+        }
+    }
+
+    // The sender balance cannot become negative
+    if senderBalance.accrued-transfer.Sum-senderBalance.withdrawn < 0 {
+        return nil, ErrNegativeBalance
+    }
+
+    // Take the sum from the sender, the oldest points first
+    senderBalance.accrued -= transfer.Sum
+    r.consumeLots(transfer.Sender, transfer.Sum)
+
+    // Give the sum to the recipient, received points make a new lot
+    recipientBalance.accrued += transfer.Sum
+    r.createLot(transfer.Recipient, "", transfer.Sum, expiresAt)
+
+    created := &model.Transfer{
+        Sender:        transfer.Sender,
+        Recipient:     transfer.Recipient,
+        Sum:           transfer.Sum,
+        TransferredAt: r.now(),
+    }
+    r.transfers = append(r.transfers, created)
+
+    // Both balances are written to the audit log
+    senderState := balanceState(senderBalance.accrued, senderBalance.withdrawn)
+    senderState.Sum, senderState.Counterparty = transfer.Sum, transfer.Recipient
+    recipientState := balanceState(recipientBalance.accrued, recipientBalance.withdrawn)
+    recipientState.Sum, recipientState.Counterparty = transfer.Sum, transfer.Sender
+    r.appendAudit(ctx, auditRecord{
+        login:    transfer.Sender,
+        action:   model.AuditBalanceTransferOut,
+        oldValue: balanceState(senderBalance.accrued+transfer.Sum, senderBalance.withdrawn),
+        newValue: senderState,
+    }, auditRecord{
+        login:    transfer.Recipient,
+        action:   model.AuditBalanceTransferIn,
+        oldValue: balanceState(recipientBalance.accrued-transfer.Sum, recipientBalance.withdrawn),
+        newValue: recipientState,
+    })
+
+    result := *created
+    return &result, nil
+}
+
+// GetListOfTransfers returns a page of transfers sent and received by the user, the newest first, and the total number of them.
+func (r *MemoryRepository) GetListOfTransfers(ctx context.Context, user *model.User, page model.Page) (model.Transfers, int, error) {
+    defer r.rlock(ctx)()
+
+    transfers := make(model.Transfers, 0)
+    for _, transfer := range r.transfers {
+        if transfer.Sender == user.Login || transfer.Recipient == user.Login {
+            selected := *transfer
+            transfers = append(transfers, &selected)
+        }
+    }
+    sortNewestFirst(transfers, func(transfer *model.Transfer) time.Time {
+        return transfer.TransferredAt
+    })
+
+    transfers, total := pageOf(transfers, page)
+    return transfers, total, nil
+}
+
+// GetEnabledPromotionRules returns the promotion rules, which are not disabled.
+func (r *MemoryRepository) GetEnabledPromotionRules(ctx context.Context) (model.PromotionRules, error) {
+    defer r.rlock(ctx)()
+
+    rules := make(model.PromotionRules, 0, len(r.promotionRules))
+    for _, rule := range r.promotionRules {
+        if !rule.Disabled {
+            rules = append(rules, copyPromotionRule(rule))
+        }
+    }
+
+    return rules, nil
+}
+
+// GetPromotionRules returns all promotion rules.
+func (r *MemoryRepository) GetPromotionRules(ctx context.Context) (model.PromotionRules, error) {
+    defer r.rlock(ctx)()
+
+    rules := make(model.PromotionRules, 0, len(r.promotionRules))
+    for _, rule := range r.promotionRules {
+        rules = append(rules, copyPromotionRule(rule))
+    }
+
+    return rules, nil
+}
+
+// CreatePromotionRule creates new promotion rule.
+func (r *MemoryRepository) CreatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+    defer r.lock(ctx)()
+
+    r.lastRuleID++
+    created := copyPromotionRule(rule)
+    created.ID = r.lastRuleID
+    r.promotionRules = append(r.promotionRules, created)
+
+    return copyPromotionRule(created), nil
+}
+
+// UpdatePromotionRule replaces the promotion rule with the same ID.
+func (r *MemoryRepository) UpdatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+    defer r.lock(ctx)()
+
+    i := slices.IndexFunc(r.promotionRules, func(stored *model.PromotionRule) bool {
+        return stored.ID == rule.ID
+    })
+    if i < 0 {
+        return nil, ErrNotFound
+    }
+    r.promotionRules[i] = copyPromotionRule(rule)
+
+    return copyPromotionRule(rule), nil
+}
+
+// DeletePromotionRule deletes the promotion rule.
+// A rule, which has given bonuses, cannot be deleted - it can be disabled only.
+func (r *MemoryRepository) DeletePromotionRule(ctx context.Context, id int32) error {
+    defer r.lock(ctx)()
+
+    // Bonuses refer to the rule
+    for _, bonus := range r.bonuses {
+        if bonus.bonus.RuleID == id {
+            return ErrConflict
+        }
+    }
+
+    i := slices.IndexFunc(r.promotionRules, func(stored *model.PromotionRule) bool {
+        return stored.ID == id
+    })
+    if i < 0 {
+        return ErrNotFound
+    }
+    r.promotionRules = slices.Delete(r.promotionRules, i, i+1)
+
+    return nil
+}
+
+// copyPromotionRule returns a copy of the promotion rule, which doesn't share conditions.
+func copyPromotionRule(rule *model.PromotionRule) *model.PromotionRule {
+    copied := *rule
+    if rule.StartsAt != nil {
+        startsAt := *rule.StartsAt
+        copied.StartsAt = &startsAt
+    }
+    if rule.EndsAt != nil {
+        endsAt := *rule.EndsAt
+        copied.EndsAt = &endsAt
+    }
+    copied.Weekdays = append(make([]time.Weekday, 0, len(rule.Weekdays)), rule.Weekdays...)
+
+    return &copied
+}
+
+// AppendAudit writes an action, which doesn't change any data, to the audit log.
+func (r *MemoryRepository) AppendAudit(ctx context.Context, login string, action model.AuditAction) error {
+    defer r.lock(ctx)()
+
+    r.appendAudit(ctx, auditRecord{login: login, action: action})
+
+    return nil
+}
+
+// appendAudit writes the records to the audit log together with the change, the repository must be locked.
+// Every entry is chained to the previous one.
+func (r *MemoryRepository) appendAudit(ctx context.Context, records ...auditRecord) {
+    // The log can be empty
+    prevHash := audit.GenesisHash
+    if len(r.auditLog) > 0 {
+        prevHash = r.auditLog[len(r.auditLog)-1].Hash
+    }
+
+    source := audit.SourceFromContext(ctx)
+    createdAt := r.now()
+
+    for _, record := range records {
+        entry := &model.AuditEntry{
+            ID:        int64(len(r.auditLog) + 1),
+            Login:     record.login,
+            Action:    record.action,
+            RequestID: source.RequestID,
+            IP:        source.IP,
+            CreatedAt: createdAt,
+            PrevHash:  prevHash,
+        }
+        // Audit values are the structures of this package, which are always marshalled
+        entry.OldValue, _ = auditValue(record.oldValue)
+        entry.NewValue, _ = auditValue(record.newValue)
+        entry.Hash = audit.Hash(entry)
+
+        r.auditLog = append(r.auditLog, entry)
+        prevHash = entry.Hash
+    }
+}
+
+// GetAuditEntries returns audit log entries selected by the filter, the newest first.
+func (r *MemoryRepository) GetAuditEntries(ctx context.Context, filter *model.AuditFilter) (model.AuditEntries, error) {
+    defer r.rlock(ctx)()
+
+    entries := make(model.AuditEntries, 0)
+    for i := len(r.auditLog) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+        entry := r.auditLog[i]
+        if filter.Login != "" && entry.Login != filter.Login {
+            continue
+        }
+        if filter.Action != "" && entry.Action != filter.Action {
+            continue
+        }
+        if filter.From != nil && entry.CreatedAt.Before(*filter.From) {
+            continue
+        }
+        if filter.To != nil && !entry.CreatedAt.Before(*filter.To) {
+            continue
+        }
+
+        selected := *entry
+        entries = append(entries, &selected)
+    }
+
+    return entries, nil
+}
+
+// ChainAuditLog appends the queued entries to the audit log.
+// Memory entries are chained when they are written, so nothing is queued.
+func (r *MemoryRepository) ChainAuditLog(ctx context.Context) (int64, error) {
+    return 0, nil
+}
+
+// AuditChain calls the function for every audit log entry in the order they were appended.
+func (r *MemoryRepository) AuditChain(ctx context.Context, f func(entry *model.AuditEntry) error) error {
+    // Entries are never changed, so the log can be passed without the lock
+    unlock := r.rlock(ctx)
+    entries := r.auditLog[:len(r.auditLog):len(r.auditLog)]
+    unlock()
+
+    for _, entry := range entries {
+        passed := *entry
+        if err := f(&passed); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// sortNewestFirst sorts the items by the time descending, the items created later go first at the same time.
+// The items must be in the order of creation.
+func sortNewestFirst[T any](items []T, at func(item T) time.Time) {
+    slices.Reverse(items)
+    sort.SliceStable(items, func(i, j int) bool {
+        return at(items[i]).After(at(items[j]))
+    })
+}
+
+// pageOf returns the page of the items and the total number of the items.
+func pageOf[S ~[]T, T any](items S, page model.Page) (S, int) {
+    total := len(items)
+
+    if page.Offset >= total {
+        return S{}, total
+    }
+
+    end := total
+    if page.Limit > 0 {
+        end = min(page.Offset+page.Limit, total)
+    }
+
+    return items[page.Offset:end], total
+}
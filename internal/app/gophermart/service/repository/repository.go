@@ -2,14 +2,16 @@ package repository
 
 import (
     "context"
-    "database/sql"
+    "encoding/json"
     "errors"
     "fmt"
+    "slices"
+    "time"
 
     "github.com/RomanAgaltsev/ya_gophermart/internal/database/queries"
     "github.com/RomanAgaltsev/ya_gophermart/internal/model"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
 
-    "github.com/cenkalti/backoff/v4"
     "github.com/jackc/pgerrcode"
     "github.com/jackc/pgx/v5"
     "github.com/jackc/pgx/v5/pgconn"
@@ -19,6 +21,12 @@ import (
 var (
     ErrConflict        = fmt.Errorf("data conflict")
     ErrNegativeBalance = fmt.Errorf("negative balance")
+    ErrNotFound        = fmt.Errorf("not found")
+    ErrAlreadyReversed = fmt.Errorf("already reversed")
+    ErrDisabled        = fmt.Errorf("disabled")
+    ErrLimitExceeded   = fmt.Errorf("limit exceeded")
+    ErrSenderNotFound  = fmt.Errorf("sender not found")
+    ErrSenderDisabled  = fmt.Errorf("sender disabled")
 )
 
 // conflictOrder contains confict order and an error.
@@ -27,6 +35,62 @@ type conflictOrder struct {
     err   error
 }
 
+// conflictOrders contains numbers of created orders and existing orders of a batch.
+type conflictOrders struct {
+    created  []string
+    existing []queries.Order
+}
+
+// statementQuery selects user accruals, bonuses, withdrawals and transfers with running balance for a period.
+// The query is not generated, because generated queries load all rows in memory and statements are streamed.
+const statementQuery = `WITH entries AS (SELECT 'accrual' AS type, number AS order_number, accrual AS amount, uploaded_at AS processed_at
+                 FROM orders
+                 WHERE login = $1
+                   AND status = 'PROCESSED'
+                   AND accrual > 0
+                 UNION ALL
+                 SELECT 'withdrawal', order_number, -sum, processed_at
+                 FROM withdrawals
+                 WHERE login = $1
+                 UNION ALL
+                 SELECT 'reversal', order_number, sum, reversed_at
+                 FROM reversals
+                 WHERE login = $1
+                 UNION ALL
+                 SELECT 'expiration', lots.order_number, -expirations.amount, expirations.expired_at
+                 FROM expirations
+                          JOIN lots ON lots.id = expirations.lot_id
+                 WHERE expirations.login = $1
+                 UNION ALL
+                 SELECT 'transfer_in', '', sum, transferred_at
+                 FROM transfers
+                 WHERE recipient = $1
+                 UNION ALL
+                 SELECT 'transfer_out', '', -sum, transferred_at
+                 FROM transfers
+                 WHERE sender = $1
+                 UNION ALL
+                 SELECT 'bonus', order_number, amount, created_at
+                 FROM bonuses
+                 WHERE login = $1),
+     balanced AS (SELECT type, order_number, amount, processed_at,
+                         SUM(amount) OVER (ORDER BY processed_at, type, order_number) AS balance
+                  FROM entries)
+SELECT type, order_number, amount, balance, processed_at
+FROM balanced
+WHERE processed_at >= $2
+  AND processed_at < $3
+ORDER BY processed_at, type, order_number`
+
+// auditChainBatchSize is the maximal number of queued audit entries chained in one transaction.
+const auditChainBatchSize = 1000
+
+// auditChainQuery selects all audit log entries in the order they were appended.
+// The query is not generated, because the whole log is streamed to verify the hash chain.
+const auditChainQuery = `SELECT id, login, action, old_value, new_value, request_id, ip, created_at, prev_hash, hash
+FROM audit_log
+ORDER BY id`
+
 // PgxPool needs to mock pgxpool in tests.
 type PgxPool interface {
     Close()
@@ -46,85 +110,282 @@ type PgxPool interface {
     Ping(ctx context.Context) error
 }
 
-// New creates new repository.
-func New(dbpool PgxPool) (*Repository, error) {
+// New creates new repository, which retries queries on transient errors according to the policy.
+func New(dbpool PgxPool, policy RetryPolicy) (*Repository, error) {
     // Return Repository struct with new queries
     return &Repository{
-        db: dbpool,
-        q:  queries.New(dbpool),
+        db:     dbpool,
+        q:      queries.New(dbpool),
+        policy: policy,
     }, nil
 }
 
 // Repository is the repository structure.
 type Repository struct {
-    db PgxPool
-    q  *queries.Queries
+    db     PgxPool
+    q      *queries.Queries
+    policy RetryPolicy
+}
+
+// txContextKey is the context key of the unit of work transaction.
+type txContextKey struct{}
+
+// WithinTransaction runs the function as a unit of work - the repository calls made with the context passed to the function
+// are executed in a single transaction, which is committed, if the function succeeds, and rolled back otherwise.
+// Calls, which run their own transactions, and nested units of work are executed in savepoints of the transaction.
+func (r *Repository) WithinTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+    // Begin transaction
+    ctx, tx, err := r.begin(ctx)
+    if err != nil {
+        return err
+    }
+    // Defer transaction rollback
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    if err := f(context.WithValue(ctx, txContextKey{}, tx)); err != nil {
+        return err
+    }
+
+    return tx.Commit(ctx)
+}
+
+// begin begins new transaction or, within a unit of work, a savepoint of its transaction.
+// The returned context marks the statements of the transaction, so they are not retried.
+func (r *Repository) begin(ctx context.Context) (context.Context, pgx.Tx, error) {
+    var (
+        tx  pgx.Tx
+        err error
+    )
+    if utx, ok := ctx.Value(txContextKey{}).(pgx.Tx); ok {
+        tx, err = utx.Begin(ctx)
+    } else {
+        tx, err = retryWithData(ctx, r.policy, func() (pgx.Tx, error) {
+            return r.db.Begin(ctx)
+        })
+    }
+    if err != nil {
+        return ctx, nil, err
+    }
+
+    return context.WithValue(ctx, txStartedContextKey{}, true), tx, nil
+}
+
+// retryTransaction runs the function in new transaction, which is committed, if the function succeeds.
+// The whole transaction is retried on transient errors according to the policy, within a unit of work it is not retried.
+func (r *Repository) retryTransaction(ctx context.Context, f func(ctx context.Context, qtx *queries.Queries) error) error {
+    return retry(ctx, r.policy, func() error {
+        // Begin transaction, the beginning is retried with the whole transaction, not on its own
+        ctx, tx, err := r.begin(context.WithValue(ctx, txStartedContextKey{}, true))
+        if err != nil {
+            return err
+        }
+        // Defer transaction rollback
+        defer func() { _ = tx.Rollback(ctx) }()
+
+        if err := f(ctx, r.q.WithTx(tx)); err != nil {
+            return err
+        }
+
+        return tx.Commit(ctx)
+    })
+}
+
+// dbtx returns the unit of work transaction, if there is one, or the pool.
+func (r *Repository) dbtx(ctx context.Context) queries.DBTX {
+    if tx, ok := ctx.Value(txContextKey{}).(pgx.Tx); ok {
+        return tx
+    }
+
+    return r.db
+}
+
+// querier returns the queries executed in the unit of work transaction, if there is one.
+func (r *Repository) querier(ctx context.Context) *queries.Queries {
+    if tx, ok := ctx.Value(txContextKey{}).(pgx.Tx); ok {
+        return r.q.WithTx(tx)
+    }
+
+    return r.q
 }
 
 // CreateUser creates new user in the repository.
 func (r *Repository) CreateUser(ctx context.Context, user *model.User) error {
-    // PG error to catch the conflict
-    var pgErr *pgconn.PgError
-
-    // Create a function to wrap user creation with exponential backoff
-    f := func() (error, error) {
+    return r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
         // Create user
-        _, err := r.q.CreateUser(ctx, queries.CreateUserParams{
+        created, err := qtx.CreateUser(ctx, queries.CreateUserParams{
             Login:    user.Login,
             Password: user.Password,
         })
 
         // Check if there is a conflict
+        var pgErr *pgconn.PgError
         if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
-            return ErrConflict, nil
+            return ErrConflict
         }
 
         // Check if something has gone wrong
         if err != nil {
-            return nil, err
+            return err
         }
 
-        return nil, nil
-    }
-
-    // Call the wrapping function
-    errConf, err := backoff.RetryWithData(f, backoff.NewExponentialBackOff())
-    if err != nil {
-        return err
-    }
-
-    // There is a conflict
-    if errConf != nil {
-        return errConf
-    }
+        user.ID, user.SessionVersion = created.ID, created.SessionVersion
 
-    return nil
+        return appendAudit(ctx, qtx, auditRecord{
+            login:    user.Login,
+            action:   model.AuditUserRegister,
+            newValue: auditUser{Disabled: false},
+        })
+    })
 }
 
 // GetUser returns a user from repository.
 func (r *Repository) GetUser(ctx context.Context, login string) (*model.User, error) {
     // Get user from DB
-    usr, err := backoff.RetryWithData(func() (queries.User, error) {
-        return r.q.GetUser(ctx, login)
-    }, backoff.NewExponentialBackOff())
+    usr, err := retryWithData(ctx, r.policy, func() (queries.User, error) {
+        return r.querier(ctx).GetUser(ctx, login)
+    })
 
     // Check if something has gone wrong
-    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) {
         return nil, err
     }
 
     // Check if there is nothing to return
-    if errors.Is(err, sql.ErrNoRows) {
+    if errors.Is(err, pgx.ErrNoRows) {
         return nil, nil
     }
 
     // Return user
     return &model.User{
+        ID:       usr.ID,
         Login:    usr.Login,
         Password: usr.Password,
+        Disabled: usr.Disabled,
+
+        SessionVersion: usr.SessionVersion,
+        Timezone:       usr.Timezone,
     }, nil
 }
 
+// DisableUser disables the user, so the user cannot log in anymore.
+func (r *Repository) DisableUser(ctx context.Context, login string) error {
+    return r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Disable user in DB
+        rows, err := qtx.DisableUser(ctx, login)
+        if err != nil {
+            return err
+        }
+
+        // There is no such user
+        if rows == 0 {
+            return ErrNotFound
+        }
+
+        return appendAudit(ctx, qtx, auditRecord{
+            login:    login,
+            action:   model.AuditUserDisable,
+            newValue: auditUser{Disabled: true},
+        })
+    })
+}
+
+// UpdateUserPassword replaces the user password hash and returns the new session version.
+func (r *Repository) UpdateUserPassword(ctx context.Context, login string, password string) (int32, error) {
+    var sessionVersion int32
+
+    err := r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Update password in DB, the version is the one the update has set
+        var err error
+        sessionVersion, err = qtx.UpdateUserPassword(ctx, queries.UpdateUserPasswordParams{
+            Login:    login,
+            Password: password,
+        })
+        // There is no such user
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+
+        // Password hashes are not written to the audit log
+        return appendAudit(ctx, qtx, auditRecord{
+            login:  login,
+            action: model.AuditUserPasswordChange,
+        })
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    return sessionVersion, nil
+}
+
+// UpdateUserTimezone sets the time zone, which times are rendered in for the user.
+func (r *Repository) UpdateUserTimezone(ctx context.Context, login string, timezone string) error {
+    // Update time zone in DB
+    rows, err := retryWithData(ctx, r.policy, func() (int64, error) {
+        return r.querier(ctx).UpdateUserTimezone(ctx, queries.UpdateUserTimezoneParams{
+            Login:    login,
+            Timezone: timezone,
+        })
+    })
+    if err != nil {
+        return err
+    }
+
+    // There is no such user
+    if rows == 0 {
+        return ErrNotFound
+    }
+
+    return nil
+}
+
+// DeleteUser deletes the user and anonymizes or deletes the user data.
+// The login is replaced with a pseudonym in the data, which is kept.
+// Transfers are always anonymized - they belong to the statements of other users too.
+func (r *Repository) DeleteUser(ctx context.Context, login string, anonymize bool) error {
+    return r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Get and lock the user
+        usr, err := qtx.GetUserForUpdate(ctx, login)
+        // There is no user
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+
+        // Pseudonym is unique, as user IDs are never reused
+        pseudonym := fmt.Sprintf("%s%d", model.DeletedLoginPrefix, usr.ID)
+
+        // Anonymize or delete user data
+        if anonymize {
+            err = qtx.AnonymizeUser(ctx, queries.AnonymizeUserParams{
+                Login:     login,
+                Pseudonym: pseudonym,
+            })
+        } else {
+            err = qtx.DeleteUserData(ctx, queries.DeleteUserDataParams{
+                Login:     login,
+                Pseudonym: pseudonym,
+            })
+        }
+        if err != nil {
+            return err
+        }
+
+        // The audit log is append-only, so the entries of the user are kept as they are
+        return appendAudit(ctx, qtx, auditRecord{
+            login:    login,
+            action:   model.AuditUserDelete,
+            oldValue: auditUser{Disabled: usr.Disabled},
+            newValue: auditDeletion{Anonymized: anonymize, Pseudonym: pseudonym},
+        })
+    })
+}
+
 // CreateOrder creates new order in the repository.
 func (r *Repository) CreateOrder(ctx context.Context, order *model.Order) (*model.Order, error) {
     // PG error to catch the conflict
@@ -134,17 +395,17 @@ func (r *Repository) CreateOrder(ctx context.Context, order *model.Order) (*mode
     f := func() (conflictOrder, error) {
         var co conflictOrder
         // Try to create an order
-        _, errStore := r.q.CreateOrder(ctx, queries.CreateOrderParams{
+        _, errStore := r.querier(ctx).CreateOrder(ctx, queries.CreateOrderParams{
             Login:  order.Login,
             Number: order.Number,
         })
 
         // Check if there is a conflict
         if errors.As(errStore, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
-            orderByNumber, errGet := backoff.RetryWithData(func() (queries.Order, error) {
+            orderByNumber, errGet := retryWithData(ctx, r.policy, func() (queries.Order, error) {
                 // Return existing order
-                return r.q.GetOrder(ctx, order.Number)
-            }, backoff.NewExponentialBackOff())
+                return r.querier(ctx).GetOrder(ctx, order.Number)
+            })
 
             // Something has gone wrong
             if errGet != nil {
@@ -168,7 +429,7 @@ func (r *Repository) CreateOrder(ctx context.Context, order *model.Order) (*mode
     }
 
     // Call the wrapping function
-    confOrder, err := backoff.RetryWithData(f, backoff.NewExponentialBackOff())
+    confOrder, err := retryWithData(ctx, r.policy, f)
     if err != nil {
         return nil, err
     }
@@ -187,12 +448,128 @@ func (r *Repository) CreateOrder(ctx context.Context, order *model.Order) (*mode
     return nil, nil
 }
 
-// GetListOfOrders returns a list of user orders.
-func (r *Repository) GetListOfOrders(ctx context.Context, user *model.User) (model.Orders, error) {
+// CreateOrders creates orders with the given numbers in a single transaction.
+// It returns numbers of created orders and already existing orders for the rest of numbers.
+func (r *Repository) CreateOrders(ctx context.Context, login string, numbers []string) ([]string, model.Orders, error) {
+    var confOrders conflictOrders
+
+    // A failed transaction cannot be continued, it is repeated as a whole
+    err := r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // The orders of a failed attempt are not kept
+        var (
+            co  conflictOrders
+            err error
+        )
+
+        // Create all orders at once, existing numbers are skipped
+        co.created, err = qtx.CreateOrders(ctx, queries.CreateOrdersParams{
+            Login:   login,
+            Numbers: numbers,
+        })
+        if err != nil {
+            return err
+        }
+
+        // Get existing orders, if some of numbers have been skipped
+        if len(co.created) < len(numbers) {
+            created := make(map[string]struct{}, len(co.created))
+            for _, number := range co.created {
+                created[number] = struct{}{}
+            }
+
+            var skipped []string
+            for _, number := range numbers {
+                if _, ok := created[number]; !ok {
+                    skipped = append(skipped, number)
+                }
+            }
+
+            co.existing, err = qtx.ListOrdersByNumbers(ctx, skipped)
+            if err != nil {
+                return err
+            }
+        }
+
+        confOrders = co
+        return nil
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+
+    // Convert existing orders to model
+    var existing model.Orders
+    for _, order := range confOrders.existing {
+        existing = append(existing, &model.Order{
+            Login:      order.Login,
+            Number:     order.Number,
+            Status:     order.Status,
+            Accrual:    order.Accrual,
+            UploadedAt: order.UploadedAt,
+        })
+    }
+
+    return confOrders.created, existing, nil
+}
+
+// GetListOfOrders returns a page of user orders, the newest first, and the total number of user orders.
+func (r *Repository) GetListOfOrders(ctx context.Context, user *model.User, page model.Page) (model.Orders, int, error) {
+    // Get orders from DB
+    ordersQuery, err := retryWithData(ctx, r.policy, func() ([]queries.Order, error) {
+        return r.querier(ctx).ListOrders(ctx, queries.ListOrdersParams{
+            Login:      user.Login,
+            PageLimit:  int32(page.Limit),
+            PageOffset: int32(page.Offset),
+        })
+    })
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // Count all user orders
+    total, err := r.countPage(ctx, page, len(ordersQuery), func() (int64, error) {
+        return r.querier(ctx).CountOrders(ctx, user.Login)
+    })
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // Fill the slice of orders to return
+    orders := make([]*model.Order, 0, len(ordersQuery))
+    for _, order := range ordersQuery {
+        orders = append(orders, &model.Order{
+            Login:      order.Login,
+            Number:     order.Number,
+            Status:     order.Status,
+            Accrual:    order.Accrual,
+            UploadedAt: order.UploadedAt,
+        })
+    }
+
+    return orders, total, nil
+}
+
+// countPage returns the total number of list items, the count is only queried for a part of the list.
+func (r *Repository) countPage(ctx context.Context, page model.Page, items int, count func() (int64, error)) (int, error) {
+    // The whole list has been got
+    if page.Limit == 0 && page.Offset == 0 {
+        return items, nil
+    }
+
+    total, err := retryWithData(ctx, r.policy, count)
+    if err != nil {
+        return 0, err
+    }
+
+    return int(total), nil
+}
+
+// GetListOfStuckOrders returns the orders, which are still NEW or PROCESSING after being uploaded before the given time.
+func (r *Repository) GetListOfStuckOrders(ctx context.Context, uploadedBefore time.Time) (model.Orders, error) {
     // Get orders from DB
-    ordersQuery, err := backoff.RetryWithData(func() ([]queries.Order, error) {
-        return r.q.ListOrders(ctx, user.Login)
-    }, backoff.NewExponentialBackOff())
+    ordersQuery, err := retryWithData(ctx, r.policy, func() ([]queries.Order, error) {
+        return r.querier(ctx).ListStuckOrders(ctx, uploadedBefore)
+    })
     if err != nil {
         return nil, err
     }
@@ -212,12 +589,32 @@ func (r *Repository) GetListOfOrders(ctx context.Context, user *model.User) (mod
     return orders, nil
 }
 
+// RequeueOrder returns the order waiting for processing to NEW status, so it will be processed again.
+// Processed and invalid orders are final and cannot be requeued.
+func (r *Repository) RequeueOrder(ctx context.Context, orderNumber string) error {
+    // Update order status in DB
+    rows, err := retryWithData(ctx, r.policy, func() (int64, error) {
+        return r.querier(ctx).RequeueOrder(ctx, orderNumber)
+    })
+    if err != nil {
+        return err
+    }
+
+    // There is no such order or its status is final
+    if rows == 0 {
+        return ErrNotFound
+    }
+
+    return nil
+}
+
 // CreateBalance creates user balance.
+// The existing balance is kept, if it has already been created.
 func (r *Repository) CreateBalance(ctx context.Context, user *model.User) error {
-    // Create new balance in DB
-    _, err := backoff.RetryWithData(func() (int32, error) {
-        return r.q.CreateBalance(ctx, user.Login)
-    }, backoff.NewExponentialBackOff())
+    // Create new balance in DB, the existing one is kept
+    err := retry(ctx, r.policy, func() error {
+        return r.querier(ctx).CreateBalance(ctx, user.Login)
+    })
 
     if err != nil {
         return err
@@ -229,9 +626,14 @@ func (r *Repository) CreateBalance(ctx context.Context, user *model.User) error
 // GetBalance returns user balance.
 func (r *Repository) GetBalance(ctx context.Context, user *model.User) (*model.Balance, error) {
     // Get user balance from DB.
-    balanceQuery, err := backoff.RetryWithData(func() (queries.Balance, error) {
-        return r.q.GetBalance(ctx, user.Login)
-    }, backoff.NewExponentialBackOff())
+    balanceQuery, err := retryWithData(ctx, r.policy, func() (queries.Balance, error) {
+        return r.querier(ctx).GetBalance(ctx, user.Login)
+    })
+
+    // There is no balance
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrNotFound
+    }
 
     // Something has gone wrong
     if err != nil {
@@ -247,62 +649,83 @@ func (r *Repository) GetBalance(ctx context.Context, user *model.User) (*model.B
 
 // WithdrawFromBalance - withdraw the given sum from the user balance if its enough to withdraw.
 func (r *Repository) WithdrawFromBalance(ctx context.Context, user *model.User, orderNumber string, sum float64) error {
-    // Begin transaction
-    tx, err := r.db.Begin(ctx)
-    if err != nil {
-        return err
+    // Only a positive sum can be withdrawn, a negative one would add points
+    if sum <= 0 {
+        return &model.ValidationError{Field: "sum", Message: "must be positive"}
     }
-    // Defer transaction rollback
-    defer func() { _ = tx.Rollback(ctx) }()
 
-    // Create query with transaction
-    qtx := r.q.WithTx(tx)
+    return r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Lock the balance, concurrent withdrawals and accruals wait for the transaction to end
+        balance, err := qtx.GetBalanceForUpdate(ctx, user.Login)
+        // There is no balance
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+
+        // Check the balance before withdrawal
+        if balance.Accrued-(balance.Withdrawn+sum) < 0 {
+            return ErrNegativeBalance
+        }
 
-    // Withdraw from balance
-    withdrawnRow, err := backoff.RetryWithData(func() (queries.UpdateBalanceWithdrawnRow, error) {
-        return qtx.UpdateBalanceWithdrawn(ctx, queries.UpdateBalanceWithdrawnParams{
+        // Withdraw from the locked balance
+        withdrawnRow, err := qtx.UpdateBalanceWithdrawn(ctx, queries.UpdateBalanceWithdrawnParams{
             Login:     user.Login,
             Withdrawn: sum,
         })
-    }, backoff.NewExponentialBackOff())
-    if err != nil {
-        // TODO
-        _ = tx.Rollback(ctx)
-        return err
-    }
-
-    // If the balance has become negative after withdrawal,
-    // rollback the transaction and return the negative balance error
-    if withdrawnRow.Accrued-withdrawnRow.Withdrawn < 0 {
-        _ = tx.Rollback(ctx)
-        return ErrNegativeBalance
-    }
+        if err != nil {
+            return err
+        }
 
-    // Balance enough to withdraw - create new withdrawal in DB
-    _, err = backoff.RetryWithData(func() (int32, error) {
-        return qtx.CreateWithdraw(ctx, queries.CreateWithdrawParams{
+        // Balance enough to withdraw - create new withdrawal in DB
+        _, err = qtx.CreateWithdraw(ctx, queries.CreateWithdrawParams{
             Login:       user.Login,
             OrderNumber: orderNumber,
             Sum:         sum,
         })
-    }, backoff.NewExponentialBackOff())
-    if err != nil {
-        // TODO
-        _ = tx.Rollback(ctx)
-        return err
-    }
+        if err != nil {
+            return err
+        }
 
-    return tx.Commit(ctx)
+        // Spend the oldest points first
+        if err := consumeLots(ctx, qtx, user.Login, sum); err != nil {
+            return err
+        }
+
+        newBalance := balanceState(withdrawnRow.Accrued, withdrawnRow.Withdrawn)
+        newBalance.Order, newBalance.Sum = orderNumber, sum
+
+        return appendAudit(ctx, qtx, auditRecord{
+            login:    user.Login,
+            action:   model.AuditBalanceWithdraw,
+            oldValue: balanceState(balance.Accrued, balance.Withdrawn),
+            newValue: newBalance,
+        })
+    })
 }
 
-// GetListOfWithdrawals returns a list of withdrawals from the user balance.
-func (r *Repository) GetListOfWithdrawals(ctx context.Context, user *model.User) (model.Withdrawals, error) {
+// GetListOfWithdrawals returns a page of withdrawals from the user balance, the newest first, and the total number of them.
+func (r *Repository) GetListOfWithdrawals(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error) {
     // Get withdrawals from DB
-    withdrawalsQuery, err := backoff.RetryWithData(func() ([]queries.Withdrawal, error) {
-        return r.q.ListWithdrawals(ctx, user.Login)
-    }, backoff.NewExponentialBackOff())
+    withdrawalsQuery, err := retryWithData(ctx, r.policy, func() ([]queries.Withdrawal, error) {
+        return r.querier(ctx).ListWithdrawals(ctx, queries.ListWithdrawalsParams{
+            Login:      user.Login,
+            PageLimit:  int32(page.Limit),
+            PageOffset: int32(page.Offset),
+        })
+    })
     if err != nil {
-        return nil, err
+        return nil, 0, err
+    }
+
+    // Count all user withdrawals
+    total, err := r.countPage(ctx, page, len(withdrawalsQuery), func() (int64, error) {
+        return r.querier(ctx).CountWithdrawals(ctx, user.Login)
+    })
+    if err != nil {
+        return nil, 0, err
     }
 
     // Fill the slice of withdrawals to return
@@ -313,18 +736,111 @@ func (r *Repository) GetListOfWithdrawals(ctx context.Context, user *model.User)
             OrderNumber: withdrawal.OrderNumber,
             Sum:         withdrawal.Sum,
             ProcessedAt: withdrawal.ProcessedAt,
+
+            ReversedAt:     withdrawal.ReversedAt,
+            ReversalReason: withdrawal.ReversalReason,
+        })
+    }
+
+    return withdrawals, total, nil
+}
+
+// ReverseWithdrawal marks the last withdrawal of the order as reversed, creates a compensating reversal
+// and returns the sum back to the user balance in a single transaction.
+func (r *Repository) ReverseWithdrawal(ctx context.Context, orderNumber string, reason string, expiresAt *time.Time) (*model.Withdrawal, error) {
+    var reversed *model.Withdrawal
+
+    err := r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Get and lock the withdrawal
+        withdrawal, err := qtx.GetWithdrawalForUpdate(ctx, orderNumber)
+        // There is no withdrawal
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+
+        // A withdrawal can be reversed only once
+        if withdrawal.ReversedAt != nil {
+            return ErrAlreadyReversed
+        }
+
+        // Mark the withdrawal as reversed
+        reversedAt, err := qtx.MarkWithdrawalReversed(ctx, queries.MarkWithdrawalReversedParams{
+            ID:             withdrawal.ID,
+            ReversalReason: reason,
+        })
+        if err != nil {
+            return err
+        }
+
+        // Create compensating entry
+        _, err = qtx.CreateReversal(ctx, queries.CreateReversalParams{
+            WithdrawalID: withdrawal.ID,
+            Login:        withdrawal.Login,
+            OrderNumber:  withdrawal.OrderNumber,
+            Sum:          withdrawal.Sum,
+            Reason:       reason,
+            ReversedAt:   *reversedAt,
+        })
+        if err != nil {
+            return err
+        }
+
+        // Return the sum back to the balance
+        balanceRow, err := qtx.UpdateBalanceWithdrawn(ctx, queries.UpdateBalanceWithdrawnParams{
+            Login:     withdrawal.Login,
+            Withdrawn: -withdrawal.Sum,
         })
+        // There is no balance
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+
+        // Returned points make a new lot
+        if err := createLot(ctx, qtx, withdrawal.Login, withdrawal.OrderNumber, withdrawal.Sum, expiresAt); err != nil {
+            return err
+        }
+
+        newBalance := balanceState(balanceRow.Accrued, balanceRow.Withdrawn)
+        newBalance.Order, newBalance.Sum, newBalance.Reason = withdrawal.OrderNumber, withdrawal.Sum, reason
+        if err := appendAudit(ctx, qtx, auditRecord{
+            login:    withdrawal.Login,
+            action:   model.AuditBalanceReversal,
+            oldValue: balanceState(balanceRow.Accrued, balanceRow.Withdrawn+withdrawal.Sum),
+            newValue: newBalance,
+        }); err != nil {
+            return err
+        }
+
+        reversed = &model.Withdrawal{
+            Login:       withdrawal.Login,
+            OrderNumber: withdrawal.OrderNumber,
+            Sum:         withdrawal.Sum,
+            ProcessedAt: withdrawal.ProcessedAt,
+
+            ReversedAt:     reversedAt,
+            ReversalReason: reason,
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
     }
 
-    return withdrawals, nil
+    return reversed, nil
 }
 
 // GetListOfOrdersToProcess returns the orders that need to be processed - NEW and PROCESSING statuses.
 func (r *Repository) GetListOfOrdersToProcess(ctx context.Context) (model.Orders, error) {
     // Get orders from DB
-    ordersQuery, err := backoff.RetryWithData(func() ([]queries.Order, error) {
-        return r.q.ListOrdersToProcess(ctx)
-    }, backoff.NewExponentialBackOff())
+    ordersQuery, err := retryWithData(ctx, r.policy, func() ([]queries.Order, error) {
+        return r.querier(ctx).ListOrdersToProcess(ctx)
+    })
     if err != nil {
         return nil, err
     }
@@ -344,45 +860,929 @@ func (r *Repository) GetListOfOrdersToProcess(ctx context.Context) (model.Orders
     return ordersToProcess, nil
 }
 
-// UpdateBalanceAccrued encreases user balance.
-func (r *Repository) UpdateBalanceAccrued(ctx context.Context, order *model.Order, accrual *model.OrderAccrual) error {
-    // Begin transaction
-    tx, err := r.db.Begin(ctx)
-    if err != nil {
-        return err
-    }
-    // Defer transaction rollback
-    defer func() { _ = tx.Rollback(ctx) }()
+// UpdateBalanceAccrued encreases user balance by the order accrual and the bonuses given for the order.
+// Bonuses are evaluated under the balance lock, so concurrent accruals of the user count the same processed orders.
+// A bonus of a rule is given for an order only once.
+func (r *Repository) UpdateBalanceAccrued(ctx context.Context, order *model.Order, accrual *model.OrderAccrual, bonuses model.BonusesFunc, expiresAt *time.Time) error {
+    return r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Lock the balance, concurrent withdrawals and accruals wait for the transaction to end
+        balance, err := qtx.GetBalanceForUpdate(ctx, order.Login)
+        // There is no balance
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
 
-    // Get query with transaction
-    qtx := r.q.WithTx(tx)
+        // The order is not processed yet, so it is not counted
+        var given model.Bonuses
+        if bonuses != nil {
+            previousOrders, err := qtx.CountProcessedOrders(ctx, order.Login)
+            if err != nil {
+                return err
+            }
+            given = bonuses(previousOrders)
+        }
 
-    // Add the sum to the user balance in DB
-    _, err = backoff.RetryWithData(func() (queries.UpdateBalanceAccruedRow, error) {
-        return qtx.UpdateBalanceAccrued(ctx, queries.UpdateBalanceAccruedParams{
+        // Add the sum to the locked balance
+        balanceRow, err := qtx.UpdateBalanceAccrued(ctx, queries.UpdateBalanceAccruedParams{
             Login:   order.Login,
             Accrued: accrual.Accrual,
         })
-    }, backoff.NewExponentialBackOff())
-    if err != nil {
-        // TODO
-        _ = tx.Rollback(ctx)
-        return err
-    }
+        if err != nil {
+            return err
+        }
 
-    // Update order in DB 
-    err = backoff.Retry(func() error {
-        return qtx.UpdateOrder(ctx, queries.UpdateOrderParams{
+        // Update order in DB
+        err = qtx.UpdateOrder(ctx, queries.UpdateOrderParams{
             Number:  order.Number,
             Status:  accrual.Status,
             Accrual: accrual.Accrual,
         })
-    }, backoff.NewExponentialBackOff())
-    if err != nil {
-        // TODO
-        _ = tx.Rollback(ctx)
-        return err
-    }
+        if err != nil {
+            return err
+        }
 
-    return tx.Commit(ctx)
+        // Accrued points make a new lot
+        if accrual.Accrual > 0 {
+            if err := createLot(ctx, qtx, order.Login, order.Number, accrual.Accrual, expiresAt); err != nil {
+                return err
+            }
+        }
+
+        newBalance := balanceState(balanceRow.Accrued, balanceRow.Withdrawn)
+        newBalance.Order, newBalance.Sum, newBalance.Status = order.Number, accrual.Accrual, string(accrual.Status)
+        records := []auditRecord{{
+            login:    order.Login,
+            action:   model.AuditBalanceAccrual,
+            oldValue: balanceState(balance.Accrued, balance.Withdrawn),
+            newValue: newBalance,
+        }}
+
+        // Give the bonuses
+        for _, bonus := range given {
+            record, err := createBonus(ctx, qtx, order.Login, bonus, expiresAt)
+            if err != nil {
+                return err
+            }
+            if record != nil {
+                records = append(records, *record)
+            }
+        }
+
+        return appendAudit(ctx, qtx, records...)
+    })
+}
+
+// Statement calls the function for every user statement entry processed in the period [from, to).
+// Entries are read from the database one by one, the running balance includes entries before the period.
+func (r *Repository) Statement(ctx context.Context, user *model.User, from, to time.Time, f func(entry *model.StatementEntry) error) error {
+    // Only the query is retried - entries cannot be repeated after they have been passed to the function
+    rows, err := retryWithData(ctx, r.policy, func() (pgx.Rows, error) {
+        return r.dbtx(ctx).Query(ctx, statementQuery, user.Login, from, to)
+    })
+    if err != nil {
+        return err
+    }
+
+    var (
+        entry     model.StatementEntry
+        entryType string
+    )
+    _, err = pgx.ForEachRow(rows, []any{&entryType, &entry.OrderNumber, &entry.Amount, &entry.Balance, &entry.ProcessedAt}, func() error {
+        entry.Type = model.StatementEntryType(entryType)
+        return f(&entry)
+    })
+
+    return err
+}
+
+// AdjustBalance adds the delta, which can be negative, to the user balance.
+func (r *Repository) AdjustBalance(ctx context.Context, user *model.User, delta float64, expiresAt *time.Time) (*model.Balance, error) {
+    var adjusted *model.Balance
+
+    err := r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Adjust the balance
+        adjustedRow, err := qtx.UpdateBalanceAccrued(ctx, queries.UpdateBalanceAccruedParams{
+            Login:   user.Login,
+            Accrued: delta,
+        })
+        // There is no balance
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+
+        // The balance cannot become negative after adjustment
+        current := adjustedRow.Accrued - adjustedRow.Withdrawn
+        if current < 0 {
+            return ErrNegativeBalance
+        }
+
+        // Added points make a new lot, removed points are taken from the oldest lots
+        if delta > 0 {
+            err = createLot(ctx, qtx, user.Login, "", delta, expiresAt)
+        } else {
+            err = consumeLots(ctx, qtx, user.Login, -delta)
+        }
+        if err != nil {
+            return err
+        }
+
+        newBalance := balanceState(adjustedRow.Accrued, adjustedRow.Withdrawn)
+        newBalance.Sum = delta
+        if err := appendAudit(ctx, qtx, auditRecord{
+            login:    user.Login,
+            action:   model.AuditBalanceAdjust,
+            oldValue: balanceState(adjustedRow.Accrued-delta, adjustedRow.Withdrawn),
+            newValue: newBalance,
+        }); err != nil {
+            return err
+        }
+
+        adjusted = &model.Balance{
+            Current:   current,
+            Withdrawn: adjustedRow.Withdrawn,
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return adjusted, nil
+}
+
+// ExpireLots expires all lots with expiry time up to the given time and takes their remaining points from balances.
+// It returns the number of expired lots and the total amount of expired points.
+func (r *Repository) ExpireLots(ctx context.Context, now time.Time) (int64, float64, error) {
+    var (
+        lots   int64
+        amount float64
+    )
+
+    err := r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Totals of a failed attempt are not kept
+        lots, amount = 0, 0
+
+        // Lock the balances first, withdrawals lock a balance before its lots too
+        logins, err := qtx.LockBalancesWithDueLots(ctx, &now)
+        if err != nil {
+            return err
+        }
+
+        // There is nothing to expire
+        if len(logins) == 0 {
+            return nil
+        }
+
+        // Expire lots of all users
+        expired, err := qtx.ExpireLots(ctx, &now)
+        if err != nil {
+            return err
+        }
+
+        records := make([]auditRecord, 0, len(expired))
+        for _, row := range expired {
+            lots += row.Lots
+            amount += row.Amount
+
+            newBalance := balanceState(row.Accrued, row.Withdrawn)
+            newBalance.Sum = row.Amount
+            records = append(records, auditRecord{
+                login:    row.Login,
+                action:   model.AuditBalanceExpiration,
+                oldValue: balanceState(row.Accrued+row.Amount, row.Withdrawn),
+                newValue: newBalance,
+            })
+        }
+
+        return appendAudit(ctx, qtx, records...)
+    })
+    if err != nil {
+        return 0, 0, err
+    }
+
+    return lots, amount, nil
+}
+
+// GetUpcomingExpirations returns amounts of user points by the days they expire, the nearest days first.
+func (r *Repository) GetUpcomingExpirations(ctx context.Context, user *model.User, limit int) (model.Expirations, error) {
+    expirationsQuery, err := retryWithData(ctx, r.policy, func() ([]queries.ListUpcomingExpirationsRow, error) {
+        return r.querier(ctx).ListUpcomingExpirations(ctx, queries.ListUpcomingExpirationsParams{
+            Login: user.Login,
+            Limit: int32(limit),
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    expirations := make(model.Expirations, 0, len(expirationsQuery))
+    for _, expiration := range expirationsQuery {
+        expirations = append(expirations, &model.Expiration{
+            Amount:    expiration.Amount,
+            ExpiresOn: expiration.ExpiresOn,
+        })
+    }
+
+    return expirations, nil
+}
+
+// createBonus records the bonus and adds it to the user balance as a new lot.
+// The bonus is skipped, if it has already been given for the order by the rule - nil audit record is returned then.
+func createBonus(ctx context.Context, qtx *queries.Queries, login string, bonus *model.Bonus, expiresAt *time.Time) (*auditRecord, error) {
+    _, err := qtx.CreateBonus(ctx, queries.CreateBonusParams{
+        Login:       login,
+        OrderNumber: bonus.OrderNumber,
+        RuleID:      bonus.RuleID,
+        Amount:      bonus.Amount,
+    })
+    // The bonus has already been given
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    balanceRow, err := qtx.UpdateBalanceAccrued(ctx, queries.UpdateBalanceAccruedParams{
+        Login:   login,
+        Accrued: bonus.Amount,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if err := createLot(ctx, qtx, login, bonus.OrderNumber, bonus.Amount, expiresAt); err != nil {
+        return nil, err
+    }
+
+    newBalance := balanceState(balanceRow.Accrued, balanceRow.Withdrawn)
+    newBalance.Order, newBalance.Sum, newBalance.RuleID = bonus.OrderNumber, bonus.Amount, bonus.RuleID
+
+    return &auditRecord{
+        login:    login,
+        action:   model.AuditBalanceBonus,
+        oldValue: balanceState(balanceRow.Accrued-bonus.Amount, balanceRow.Withdrawn),
+        newValue: newBalance,
+    }, nil
+}
+
+// createLot creates new lot of accrued points.
+func createLot(ctx context.Context, qtx *queries.Queries, login string, orderNumber string, amount float64, expiresAt *time.Time) error {
+    _, err := qtx.CreateLot(ctx, queries.CreateLotParams{
+        Login:       login,
+        OrderNumber: orderNumber,
+        Amount:      amount,
+        ExpiresAt:   expiresAt,
+    })
+
+    return err
+}
+
+// consumeLots takes the amount from user lots, the oldest lots first.
+// Lots can cover less than the amount, if the balance has been changed bypassing lots.
+func consumeLots(ctx context.Context, qtx *queries.Queries, login string, amount float64) error {
+    // Get and lock the lots
+    lots, err := qtx.ListLotsForUpdate(ctx, login)
+    if err != nil {
+        return err
+    }
+
+    for _, lot := range lots {
+        if amount <= 0 {
+            break
+        }
+
+        consumed := min(lot.Remaining, amount)
+        amount -= consumed
+
+        err = qtx.UpdateLotRemaining(ctx, queries.UpdateLotRemainingParams{
+            ID:        lot.ID,
+            Remaining: lot.Remaining - consumed,
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// GetAccruedTotal returns the total of points accrued to the user for the orders uploaded since the given time.
+func (r *Repository) GetAccruedTotal(ctx context.Context, user *model.User, since time.Time) (float64, error) {
+    return retryWithData(ctx, r.policy, func() (float64, error) {
+        return r.querier(ctx).GetAccruedTotal(ctx, queries.GetAccruedTotalParams{
+            Login:      user.Login,
+            UploadedAt: since,
+        })
+    })
+}
+
+// GetSpentTotal returns the total of points spent by the user since the given time, reversed withdrawals are not counted.
+func (r *Repository) GetSpentTotal(ctx context.Context, user *model.User, since time.Time) (float64, error) {
+    return retryWithData(ctx, r.policy, func() (float64, error) {
+        return r.querier(ctx).GetSpentTotal(ctx, queries.GetSpentTotalParams{
+            Login:       user.Login,
+            ProcessedAt: since,
+        })
+    })
+}
+
+// SaveTier adds the user tier to the tier history, if it differs from the last one.
+// It returns true, if the tier has been changed.
+func (r *Repository) SaveTier(ctx context.Context, user *model.User, tier string, total float64) (bool, error) {
+    rowsAffected, err := retryWithData(ctx, r.policy, func() (int64, error) {
+        return r.querier(ctx).CreateTierChange(ctx, queries.CreateTierChangeParams{
+            Login: user.Login,
+            Tier:  tier,
+            Total: total,
+        })
+    })
+    if err != nil {
+        return false, err
+    }
+
+    return rowsAffected > 0, nil
+}
+
+// GetTierHistory returns the latest changes of the user tier, the newest first.
+func (r *Repository) GetTierHistory(ctx context.Context, user *model.User, limit int) (model.TierChanges, error) {
+    historyQuery, err := retryWithData(ctx, r.policy, func() ([]queries.TierHistory, error) {
+        return r.querier(ctx).ListTierHistory(ctx, queries.ListTierHistoryParams{
+            Login: user.Login,
+            Limit: int32(limit),
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    history := make(model.TierChanges, 0, len(historyQuery))
+    for _, change := range historyQuery {
+        history = append(history, &model.TierChange{
+            Tier:      change.Tier,
+            Total:     change.Total,
+            ChangedAt: change.ChangedAt,
+        })
+    }
+
+    return history, nil
+}
+
+// Transfer moves the sum from the sender balance to the recipient balance in a single transaction.
+// Both balances are locked, so concurrent transfers between the same users cannot deadlock.
+// The sum transferred by the sender since the given time together with this transfer cannot exceed the daily limit,
+// zero limit means there is no limit.
+func (r *Repository) Transfer(ctx context.Context, transfer *model.Transfer, dailyLimit float64, since time.Time, expiresAt *time.Time) (*model.Transfer, error) {
+    var result *model.Transfer
+
+    err := r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        // Points can be transferred by an active user only
+        sender, err := qtx.GetUser(ctx, transfer.Sender)
+        // There is no user
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrSenderNotFound
+        }
+        if err != nil {
+            return err
+        }
+        if sender.Disabled {
+            return ErrSenderDisabled
+        }
+
+        // Points can be transferred to an active user only
+        recipient, err := qtx.GetUser(ctx, transfer.Recipient)
+        // There is no user
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+        if recipient.Disabled {
+            return ErrDisabled
+        }
+
+        // Lock both balances in the order of logins
+        balances, err := qtx.LockBalances(ctx, []string{transfer.Sender, transfer.Recipient})
+        if err != nil {
+            return err
+        }
+        if !slices.ContainsFunc(balances, func(b queries.Balance) bool { return b.Login == transfer.Sender }) {
+            return ErrSenderNotFound
+        }
+        if len(balances) != 2 {
+            return ErrNotFound
+        }
+
+        // Check the daily limit - the sender balance is locked, so concurrent transfers are counted
+        if dailyLimit > 0 {
+            transferred, err := qtx.GetTransferredTotal(ctx, queries.GetTransferredTotalParams{
+                Sender:        transfer.Sender,
+                TransferredAt: since,
+            })
+            if err != nil {
+                return err
+            }
+            if transferred+transfer.Sum > dailyLimit {
+                return ErrLimitExceeded
+            }
+        }
+
+        // Take the sum from the sender
+        senderRow, err := qtx.UpdateBalanceAccrued(ctx, queries.UpdateBalanceAccruedParams{
+            Login:   transfer.Sender,
+            Accrued: -transfer.Sum,
+        })
+        if err != nil {
+            return err
+        }
+        if senderRow.Accrued-senderRow.Withdrawn < 0 {
+            return ErrNegativeBalance
+        }
+
+        // Spend the oldest points of the sender first
+        if err := consumeLots(ctx, qtx, transfer.Sender, transfer.Sum); err != nil {
+            return err
+        }
+
+        // Give the sum to the recipient
+        recipientRow, err := qtx.UpdateBalanceAccrued(ctx, queries.UpdateBalanceAccruedParams{
+            Login:   transfer.Recipient,
+            Accrued: transfer.Sum,
+        })
+        if err != nil {
+            return err
+        }
+
+        // Received points make a new lot
+        if err := createLot(ctx, qtx, transfer.Recipient, "", transfer.Sum, expiresAt); err != nil {
+            return err
+        }
+
+        // Save the transfer
+        created, err := qtx.CreateTransfer(ctx, queries.CreateTransferParams{
+            Sender:    transfer.Sender,
+            Recipient: transfer.Recipient,
+            Sum:       transfer.Sum,
+        })
+        if err != nil {
+            return err
+        }
+
+        // Both balances are written to the audit log
+        senderBalance := balanceState(senderRow.Accrued, senderRow.Withdrawn)
+        senderBalance.Sum, senderBalance.Counterparty = transfer.Sum, transfer.Recipient
+        recipientBalance := balanceState(recipientRow.Accrued, recipientRow.Withdrawn)
+        recipientBalance.Sum, recipientBalance.Counterparty = transfer.Sum, transfer.Sender
+        if err := appendAudit(ctx, qtx, auditRecord{
+            login:    transfer.Sender,
+            action:   model.AuditBalanceTransferOut,
+            oldValue: balanceState(senderRow.Accrued+transfer.Sum, senderRow.Withdrawn),
+            newValue: senderBalance,
+        }, auditRecord{
+            login:    transfer.Recipient,
+            action:   model.AuditBalanceTransferIn,
+            oldValue: balanceState(recipientRow.Accrued-transfer.Sum, recipientRow.Withdrawn),
+            newValue: recipientBalance,
+        }); err != nil {
+            return err
+        }
+
+        result = &model.Transfer{
+            Sender:        created.Sender,
+            Recipient:     created.Recipient,
+            Sum:           created.Sum,
+            TransferredAt: created.TransferredAt,
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return result, nil
+}
+
+// GetListOfTransfers returns a page of transfers sent and received by the user, the newest first, and the total number of them.
+func (r *Repository) GetListOfTransfers(ctx context.Context, user *model.User, page model.Page) (model.Transfers, int, error) {
+    // Get transfers from DB
+    transfersQuery, err := retryWithData(ctx, r.policy, func() ([]queries.Transfer, error) {
+        return r.querier(ctx).ListTransfers(ctx, queries.ListTransfersParams{
+            Login:      user.Login,
+            PageLimit:  int32(page.Limit),
+            PageOffset: int32(page.Offset),
+        })
+    })
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // Count all user transfers
+    total, err := r.countPage(ctx, page, len(transfersQuery), func() (int64, error) {
+        return r.querier(ctx).CountTransfers(ctx, user.Login)
+    })
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // Fill the slice of transfers to return
+    transfers := make(model.Transfers, 0, len(transfersQuery))
+    for _, transfer := range transfersQuery {
+        transfers = append(transfers, &model.Transfer{
+            Sender:        transfer.Sender,
+            Recipient:     transfer.Recipient,
+            Sum:           transfer.Sum,
+            TransferredAt: transfer.TransferredAt,
+        })
+    }
+
+    return transfers, total, nil
+}
+
+// GetEnabledPromotionRules returns the promotion rules, which are not disabled.
+func (r *Repository) GetEnabledPromotionRules(ctx context.Context) (model.PromotionRules, error) {
+    rulesQuery, err := retryWithData(ctx, r.policy, func() ([]queries.PromotionRule, error) {
+        return r.querier(ctx).ListEnabledPromotionRules(ctx)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return promotionRules(rulesQuery), nil
+}
+
+// GetPromotionRules returns all promotion rules.
+func (r *Repository) GetPromotionRules(ctx context.Context) (model.PromotionRules, error) {
+    rulesQuery, err := retryWithData(ctx, r.policy, func() ([]queries.PromotionRule, error) {
+        return r.querier(ctx).ListPromotionRules(ctx)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return promotionRules(rulesQuery), nil
+}
+
+// CreatePromotionRule creates new promotion rule.
+func (r *Repository) CreatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+    created, err := retryWithData(ctx, r.policy, func() (queries.PromotionRule, error) {
+        return r.querier(ctx).CreatePromotionRule(ctx, queries.CreatePromotionRuleParams{
+            Name:        rule.Name,
+            Disabled:    rule.Disabled,
+            StartsAt:    rule.StartsAt,
+            EndsAt:      rule.EndsAt,
+            Weekdays:    weekdaysToQuery(rule.Weekdays),
+            FirstOrder:  rule.FirstOrder,
+            MinOrders:   rule.MinOrders,
+            Tier:        rule.Tier,
+            BonusRate:   rule.BonusRate,
+            BonusPoints: rule.BonusPoints,
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return promotionRule(created), nil
+}
+
+// UpdatePromotionRule replaces the promotion rule with the same ID.
+func (r *Repository) UpdatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+    updated, err := retryWithData(ctx, r.policy, func() (queries.PromotionRule, error) {
+        updated, err := r.querier(ctx).UpdatePromotionRule(ctx, queries.UpdatePromotionRuleParams{
+            ID:          rule.ID,
+            Name:        rule.Name,
+            Disabled:    rule.Disabled,
+            StartsAt:    rule.StartsAt,
+            EndsAt:      rule.EndsAt,
+            Weekdays:    weekdaysToQuery(rule.Weekdays),
+            FirstOrder:  rule.FirstOrder,
+            MinOrders:   rule.MinOrders,
+            Tier:        rule.Tier,
+            BonusRate:   rule.BonusRate,
+            BonusPoints: rule.BonusPoints,
+        })
+        // There is no rule
+        if errors.Is(err, pgx.ErrNoRows) {
+            return updated, ErrNotFound
+        }
+        return updated, err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return promotionRule(updated), nil
+}
+
+// DeletePromotionRule deletes the promotion rule.
+// A rule, which has given bonuses, cannot be deleted - it can be disabled only.
+func (r *Repository) DeletePromotionRule(ctx context.Context, id int32) error {
+    // PG error to catch the conflict
+    var pgErr *pgconn.PgError
+
+    rowsAffected, err := retryWithData(ctx, r.policy, func() (int64, error) {
+        rowsAffected, err := r.querier(ctx).DeletePromotionRule(ctx, id)
+        // Bonuses refer to the rule
+        if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+            return 0, ErrConflict
+        }
+        return rowsAffected, err
+    })
+    if err != nil {
+        return err
+    }
+
+    if rowsAffected == 0 {
+        return ErrNotFound
+    }
+
+    return nil
+}
+
+// promotionRules converts promotion rules from queries to model.
+func promotionRules(rulesQuery []queries.PromotionRule) model.PromotionRules {
+    rules := make(model.PromotionRules, 0, len(rulesQuery))
+    for _, rule := range rulesQuery {
+        rules = append(rules, promotionRule(rule))
+    }
+
+    return rules
+}
+
+// promotionRule converts promotion rule from queries to model.
+func promotionRule(rule queries.PromotionRule) *model.PromotionRule {
+    weekdays := make([]time.Weekday, 0, len(rule.Weekdays))
+    for _, weekday := range rule.Weekdays {
+        weekdays = append(weekdays, time.Weekday(weekday))
+    }
+
+    return &model.PromotionRule{
+        ID:          rule.ID,
+        Name:        rule.Name,
+        Disabled:    rule.Disabled,
+        StartsAt:    rule.StartsAt,
+        EndsAt:      rule.EndsAt,
+        Weekdays:    weekdays,
+        FirstOrder:  rule.FirstOrder,
+        MinOrders:   rule.MinOrders,
+        Tier:        rule.Tier,
+        BonusRate:   rule.BonusRate,
+        BonusPoints: rule.BonusPoints,
+    }
+}
+
+// weekdaysToQuery converts days of week to the query parameter.
+func weekdaysToQuery(weekdays []time.Weekday) []int32 {
+    days := make([]int32, 0, len(weekdays))
+    for _, weekday := range weekdays {
+        days = append(days, int32(weekday))
+    }
+
+    return days
+}
+
+// auditRecord is an action to write to the audit log, values are marshalled to JSON.
+type auditRecord struct {
+    login    string
+    action   model.AuditAction
+    oldValue any
+    newValue any
+}
+
+// auditUser is a user state written to the audit log.
+type auditUser struct {
+    Disabled bool `json:"disabled"`
+}
+
+// auditDeletion is a result of user deletion written to the audit log.
+type auditDeletion struct {
+    Anonymized bool   `json:"anonymized"`
+    Pseudonym  string `json:"pseudonym"`
+}
+
+// auditBalance is a user balance written to the audit log together with the details of the change.
+type auditBalance struct {
+    Current   float64 `json:"current"`
+    Withdrawn float64 `json:"withdrawn"`
+
+    Order        string  `json:"order,omitempty"`
+    Status       string  `json:"status,omitempty"`
+    Sum          float64 `json:"sum,omitempty"`
+    RuleID       int32   `json:"rule_id,omitempty"`
+    Counterparty string  `json:"counterparty,omitempty"`
+    Reason       string  `json:"reason,omitempty"`
+}
+
+// balanceState returns the balance to write to the audit log.
+func balanceState(accrued, withdrawn float64) auditBalance {
+    return auditBalance{
+        Current:   accrued - withdrawn,
+        Withdrawn: withdrawn,
+    }
+}
+
+// AppendAudit writes an action, which doesn't change any data, to the audit log.
+func (r *Repository) AppendAudit(ctx context.Context, login string, action model.AuditAction) error {
+    return r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+        return appendAudit(ctx, qtx, auditRecord{login: login, action: action})
+    })
+}
+
+// appendAudit queues the records for the audit log in the transaction of the change.
+// Queueing takes no lock, the entries are chained by ChainAuditLog after the transaction is committed.
+func appendAudit(ctx context.Context, qtx *queries.Queries, records ...auditRecord) error {
+    source := audit.SourceFromContext(ctx)
+    createdAt := time.Now().UTC().Truncate(time.Microsecond)
+
+    for _, record := range records {
+        oldValue, err := auditValue(record.oldValue)
+        if err != nil {
+            return err
+        }
+        newValue, err := auditValue(record.newValue)
+        if err != nil {
+            return err
+        }
+
+        err = qtx.CreateAuditQueueEntry(ctx, queries.CreateAuditQueueEntryParams{
+            Login:     record.login,
+            Action:    string(record.action),
+            OldValue:  oldValue,
+            NewValue:  newValue,
+            RequestID: source.RequestID,
+            Ip:        source.IP,
+            CreatedAt: createdAt,
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// ChainAuditLog appends the queued entries to the audit log in the order they were queued and returns their number.
+// Every entry is chained to the previous one, so the log is locked, but only by its single writer.
+func (r *Repository) ChainAuditLog(ctx context.Context) (int64, error) {
+    var chained int64
+    for {
+        var batch int
+        err := r.retryTransaction(ctx, func(ctx context.Context, qtx *queries.Queries) error {
+            // Wait for the writer of another instance to end
+            if err := qtx.LockAuditLog(ctx); err != nil {
+                return err
+            }
+
+            queued, err := qtx.ListAuditQueue(ctx, auditChainBatchSize)
+            if err != nil {
+                return err
+            }
+            batch = len(queued)
+            if batch == 0 {
+                return nil
+            }
+
+            // Get the hash of the last entry, the log can be empty
+            prevHash, err := qtx.GetLastAuditHash(ctx)
+            if errors.Is(err, pgx.ErrNoRows) {
+                prevHash = audit.GenesisHash
+            } else if err != nil {
+                return err
+            }
+
+            ids := make([]int64, 0, len(queued))
+            for _, q := range queued {
+                entry := &model.AuditEntry{
+                    Login:     q.Login,
+                    Action:    model.AuditAction(q.Action),
+                    OldValue:  q.OldValue,
+                    NewValue:  q.NewValue,
+                    RequestID: q.RequestID,
+                    IP:        q.Ip,
+                    CreatedAt: q.CreatedAt,
+                    PrevHash:  prevHash,
+                }
+                entry.Hash = audit.Hash(entry)
+
+                _, err = qtx.CreateAuditEntry(ctx, queries.CreateAuditEntryParams{
+                    Login:     entry.Login,
+                    Action:    string(entry.Action),
+                    OldValue:  entry.OldValue,
+                    NewValue:  entry.NewValue,
+                    RequestID: entry.RequestID,
+                    Ip:        entry.IP,
+                    CreatedAt: entry.CreatedAt,
+                    PrevHash:  entry.PrevHash,
+                    Hash:      entry.Hash,
+                })
+                if err != nil {
+                    return err
+                }
+
+                prevHash = entry.Hash
+                ids = append(ids, q.ID)
+            }
+
+            return qtx.DeleteAuditQueueEntries(ctx, ids)
+        })
+        if err != nil {
+            return chained, err
+        }
+        chained += int64(batch)
+
+        // The queue is empty
+        if batch < auditChainBatchSize {
+            return chained, nil
+        }
+    }
+}
+
+// auditValue marshals the value to JSON, nil value is not written.
+func auditValue(value any) (json.RawMessage, error) {
+    if value == nil {
+        return nil, nil
+    }
+    return json.Marshal(value)
+}
+
+// GetAuditEntries returns audit log entries selected by the filter, the newest first.
+// Entries, which are still queued, are returned before the chained ones.
+func (r *Repository) GetAuditEntries(ctx context.Context, filter *model.AuditFilter) (model.AuditEntries, error) {
+    entriesQuery, err := retryWithData(ctx, r.policy, func() ([]queries.ListAuditEntriesRow, error) {
+        return r.querier(ctx).ListAuditEntries(ctx, queries.ListAuditEntriesParams{
+            Login:       filter.Login,
+            Action:      string(filter.Action),
+            CreatedFrom: filter.From,
+            CreatedTo:   filter.To,
+            RowLimit:    int32(filter.Limit),
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    entries := make(model.AuditEntries, 0, len(entriesQuery))
+    for _, entry := range entriesQuery {
+        // The ID of a queued entry is its place in the queue, the entry gets its own ID when it is chained
+        id := entry.ID
+        if entry.Queued {
+            id = 0
+        }
+
+        entries = append(entries, &model.AuditEntry{
+            ID:        id,
+            Login:     entry.Login,
+            Action:    model.AuditAction(entry.Action),
+            OldValue:  entry.OldValue,
+            NewValue:  entry.NewValue,
+            RequestID: entry.RequestID,
+            IP:        entry.Ip,
+            CreatedAt: entry.CreatedAt,
+            PrevHash:  entry.PrevHash,
+            Hash:      entry.Hash,
+            Queued:    entry.Queued,
+        })
+    }
+
+    return entries, nil
+}
+
+// AuditChain calls the function for every audit log entry in the order they were appended.
+// Entries are read from the database one by one.
+func (r *Repository) AuditChain(ctx context.Context, f func(entry *model.AuditEntry) error) error {
+    // Only the query is retried - entries cannot be repeated after they have been passed to the function
+    rows, err := retryWithData(ctx, r.policy, func() (pgx.Rows, error) {
+        return r.dbtx(ctx).Query(ctx, auditChainQuery)
+    })
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    var (
+        entry              model.AuditEntry
+        action             string
+        oldValue, newValue []byte
+    )
+    for rows.Next() {
+        if err := rows.Scan(&entry.ID, &entry.Login, &action, &oldValue, &newValue,
+            &entry.RequestID, &entry.IP, &entry.CreatedAt, &entry.PrevHash, &entry.Hash); err != nil {
+            return err
+        }
+        entry.Action = model.AuditAction(action)
+        entry.OldValue, entry.NewValue = oldValue, newValue
+
+        if err := f(&entry); err != nil {
+            return err
+        }
+    }
+
+    return rows.Err()
 }
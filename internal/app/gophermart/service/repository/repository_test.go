@@ -8,6 +8,7 @@ import (
 	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/database/queries"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -23,8 +24,9 @@ var _ = Describe("Repository", func() {
 
 		ctx context.Context
 
-		mockPool pgxmock.PgxPoolIface
-		repo     *repository.Repository
+		mockPool    pgxmock.PgxPoolIface
+		repo        *repository.Repository
+		retryPolicy repository.RetryPolicy
 
 		rowID int32
 
@@ -48,6 +50,12 @@ var _ = Describe("Repository", func() {
 
 		// Accrual
 		orderAccrual model.OrderAccrual
+
+		// Balance
+		balanceColumns = []string{"id", "login", "accrued", "withdrawn"}
+
+		// Lot
+		lotColumns = []string{"id", "login", "order_number", "amount", "remaining", "accrued_at", "expires_at"}
 	)
 
 	BeforeEach(func() {
@@ -58,7 +66,14 @@ var _ = Describe("Repository", func() {
 		mockPool, err = pgxmock.NewPool()
 		Expect(err).ShouldNot(HaveOccurred())
 
-		repo, err = repository.New(mockPool)
+		retryPolicy = repository.RetryPolicy{
+			InitialInterval: time.Millisecond,
+			MaxInterval:     5 * time.Millisecond,
+			MaxElapsedTime:  time.Second,
+			MaxRetries:      3,
+		}
+
+		repo, err = repository.New(mockPool, retryPolicy)
 		Expect(err).ShouldNot(HaveOccurred())
 	})
 
@@ -66,6 +81,16 @@ var _ = Describe("Repository", func() {
 		mockPool.Close()
 	})
 
+	// expectAudit expects the entries of the actions to be queued for the audit log
+	expectAudit := func(actions ...model.AuditAction) {
+		for _, action := range actions {
+			mockPool.ExpectExec("INSERT INTO audit_queue .+").
+				WithArgs(pgxmock.AnyArg(), string(action), pgxmock.AnyArg(), pgxmock.AnyArg(), "", "", pgxmock.AnyArg()).
+				WillReturnResult(pgxmock.NewResult("INSERT", 1)).
+				Times(1)
+		}
+	}
+
 	Context("Calling CreateUser method", func() {
 		When("user doesn't exist", func() {
 			BeforeEach(func() {
@@ -78,12 +103,19 @@ var _ = Describe("Repository", func() {
 					Password: userPassword,
 				}
 
-				rs := pgxmock.NewRows([]string{"id"}).
-					AddRow(rowID)
-				mockPool.ExpectQuery("INSERT .+ VALUES .+").
+				mockPool.ExpectBegin()
+
+				rs := pgxmock.NewRows([]string{"id", "session_version"}).
+					AddRow(rowID, int32(1))
+				mockPool.ExpectQuery("INSERT INTO users .+ VALUES .+").
 					WithArgs(userLogin, userPassword).
 					WillReturnRows(rs).
 					Times(1)
+
+				expectAudit(model.AuditUserRegister)
+
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
 			})
 			AfterEach(func() {
 				err = mockPool.ExpectationsWereMet()
@@ -107,13 +139,17 @@ var _ = Describe("Repository", func() {
 					Password: userPassword,
 				}
 
-				rs := pgxmock.NewRows([]string{"id"}).
-					AddRow(rowID).
+				mockPool.ExpectBegin()
+
+				rs := pgxmock.NewRows([]string{"id", "session_version"}).
+					AddRow(rowID, int32(1)).
 					RowError(int(rowID), &pgconn.PgError{Code: pgerrcode.IntegrityConstraintViolation})
-				mockPool.ExpectQuery("INSERT .+ VALUES .+").
+				mockPool.ExpectQuery("INSERT INTO users .+ VALUES .+").
 					WithArgs(userLogin, userPassword).
 					WillReturnRows(rs).
 					Times(1)
+
+				mockPool.ExpectRollback()
 			})
 			AfterEach(func() {
 				err = mockPool.ExpectationsWereMet()
@@ -136,8 +172,8 @@ var _ = Describe("Repository", func() {
 				userPassword = ""
 				userCreatedAt = time.Now()
 
-				rs := pgxmock.NewRows([]string{"id", "login", "password", "createdat"}).
-					AddRow(rowID, userLogin, userPassword, userCreatedAt)
+				rs := pgxmock.NewRows([]string{"id", "login", "password", "createdat", "disabled", "sessionversion", "timezone"}).
+					AddRow(rowID, userLogin, userPassword, userCreatedAt, false, int32(0), "")
 				mockPool.ExpectQuery("SELECT .+ FROM users WHERE .+").
 					WithArgs(userLogin).
 					WillReturnRows(rs).
@@ -164,12 +200,14 @@ var _ = Describe("Repository", func() {
 				userCreatedAt = time.Now()
 
 				userExpected = model.User{
+					ID:       rowID,
 					Login:    userLogin,
 					Password: userPassword,
+					Timezone: "Europe/Moscow",
 				}
 
-				rs := pgxmock.NewRows([]string{"id", "login", "password", "createdat"}).
-					AddRow(rowID, userLogin, userPassword, userCreatedAt)
+				rs := pgxmock.NewRows([]string{"id", "login", "password", "createdat", "disabled", "sessionversion", "timezone"}).
+					AddRow(rowID, userLogin, userPassword, userCreatedAt, false, int32(0), "Europe/Moscow")
 				mockPool.ExpectQuery("SELECT .+ FROM users WHERE .+").
 					WithArgs(userLogin).
 					WillReturnRows(rs).
@@ -187,17 +225,11 @@ var _ = Describe("Repository", func() {
 			})
 		})
 
-		// Pending - because of exponential backoff it lasts about 10 minutes
-		// Remove 'X' to run
-		XWhen("something has gone wrong with the query", func() {
+		When("there is no such user", func() {
 			BeforeEach(func() {
-				rowID = 0
-				userLogin = ""
-				userPassword = ""
-				userCreatedAt = time.Now()
+				userLogin = "nobody"
 
-				rs := pgxmock.NewRows([]string{"id", "login", "password", "createdat"}).
-					RowError(1, errSomethingStrange)
+				rs := pgxmock.NewRows([]string{"id", "login", "password", "createdat", "disabled", "sessionversion", "timezone"})
 				mockPool.ExpectQuery("SELECT .+ FROM users WHERE .+").
 					WithArgs(userLogin).
 					WillReturnRows(rs).
@@ -208,6 +240,27 @@ var _ = Describe("Repository", func() {
 				Expect(err).ShouldNot(HaveOccurred())
 			})
 
+			It("returns nil user and nil error", func() {
+				result, err := repo.GetUser(ctx, userLogin)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(result).To(BeNil())
+			})
+		})
+
+		When("something has gone wrong with the query", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+
+				// The error is permanent, so the query is not retried
+				mockPool.ExpectQuery("SELECT .+ FROM users WHERE .+").
+					WithArgs(userLogin).
+					WillReturnError(errSomethingStrange)
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
 			It("returns nil user and an error", func() {
 				result, err := repo.GetUser(ctx, userLogin)
 				Expect(err).To(HaveOccurred())
@@ -308,7 +361,7 @@ var _ = Describe("Repository", func() {
 				rs := pgxmock.NewRows([]string{"id", "login", "ordernumber", "status", "accrual", "uploadedat"}).
 					AddRow(rowID, userLogin, orderNumber, orderStatus, accrual, orderUploadedAt)
 				mockPool.ExpectQuery("SELECT .+ FROM orders .+").
-					WithArgs(userLogin).
+					WithArgs(userLogin, int32(0), int32(0)).
 					WillReturnRows(rs).
 					Times(1)
 			})
@@ -318,10 +371,40 @@ var _ = Describe("Repository", func() {
 			})
 
 			It("returns a non-empty list of orders and nil error", func() {
-				result, err := repo.GetListOfOrders(ctx, &user)
+				result, total, err := repo.GetListOfOrders(ctx, &user, model.Page{})
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(result).NotTo(BeNil())
 				Expect(len(result)).To(Equal(1))
+				Expect(total).To(Equal(1))
+			})
+		})
+
+		When("a page of orders is requested", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+				user = model.User{Login: userLogin}
+
+				rs := pgxmock.NewRows([]string{"id", "login", "ordernumber", "status", "accrual", "uploadedat"}).
+					AddRow(int32(2), userLogin, orderNumber, queries.OrderStatusNEW, float64(0), time.Now())
+				mockPool.ExpectQuery("SELECT .+ FROM orders .+ LIMIT .+ OFFSET .+").
+					WithArgs(userLogin, int32(1), int32(1)).
+					WillReturnRows(rs).
+					Times(1)
+				mockPool.ExpectQuery("SELECT COUNT.+ FROM orders").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(3))).
+					Times(1)
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns the page and the total number of orders", func() {
+				result, total, err := repo.GetListOfOrders(ctx, &user, model.Page{Limit: 1, Offset: 1})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(result).To(HaveLen(1))
+				Expect(total).To(Equal(3))
 			})
 		})
 
@@ -336,7 +419,7 @@ var _ = Describe("Repository", func() {
 
 				rs := pgxmock.NewRows([]string{"id", "login", "ordernumber", "status", "accrual", "uploadedat"})
 				mockPool.ExpectQuery("SELECT .+ FROM orders .+").
-					WithArgs(userLogin).
+					WithArgs(userLogin, int32(0), int32(0)).
 					WillReturnRows(rs).
 					Times(1)
 
@@ -347,7 +430,7 @@ var _ = Describe("Repository", func() {
 			})
 
 			It("returns an empty list of orders and nil error", func() {
-				result, err := repo.GetListOfOrders(ctx, &user)
+				result, _, err := repo.GetListOfOrders(ctx, &user, model.Page{})
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(result).NotTo(BeNil())
 				Expect(len(result)).To(Equal(0))
@@ -365,11 +448,9 @@ var _ = Describe("Repository", func() {
 					Password: "password",
 				}
 
-				rs := pgxmock.NewRows([]string{"id"}).
-					AddRow(rowID)
-				mockPool.ExpectQuery("INSERT INTO balance .+ VALUES .+").
+				mockPool.ExpectExec("INSERT INTO balance .+ VALUES .+").
 					WithArgs(userLogin).
-					WillReturnRows(rs).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1)).
 					Times(1)
 			})
 			AfterEach(func() {
@@ -384,6 +465,73 @@ var _ = Describe("Repository", func() {
 		})
 	})
 
+	Context("Calling WithinTransaction method", func() {
+		BeforeEach(func() {
+			userLogin = "user"
+			userPassword = "password"
+
+			user = model.User{
+				Login:    userLogin,
+				Password: userPassword,
+			}
+
+			// The user is created in a savepoint of the unit of work transaction
+			mockPool.ExpectBegin()
+			mockPool.ExpectBegin()
+			mockPool.ExpectQuery("INSERT INTO users .+ VALUES .+").
+				WithArgs(userLogin, userPassword).
+				WillReturnRows(pgxmock.NewRows([]string{"id", "session_version"}).AddRow(int32(1), int32(1))).
+				Times(1)
+			expectAudit(model.AuditUserRegister)
+			mockPool.ExpectCommit()
+			mockPool.ExpectRollback()
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("all calls succeed", func() {
+			BeforeEach(func() {
+				mockPool.ExpectExec("INSERT INTO balance .+ VALUES .+").
+					WithArgs(userLogin).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1)).
+					Times(1)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+
+			It("commits the transaction", func() {
+				err = repo.WithinTransaction(ctx, func(ctx context.Context) error {
+					if err := repo.CreateUser(ctx, &user); err != nil {
+						return err
+					}
+					return repo.CreateBalance(ctx, &user)
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+
+		When("a call fails", func() {
+			BeforeEach(func() {
+				mockPool.ExpectExec("INSERT INTO balance .+ VALUES .+").
+					WithArgs(userLogin).
+					WillReturnError(errSomethingStrange)
+				mockPool.ExpectRollback()
+			})
+
+			It("rolls back the transaction and returns the error", func() {
+				err = repo.WithinTransaction(ctx, func(ctx context.Context) error {
+					if err := repo.CreateUser(ctx, &user); err != nil {
+						return err
+					}
+					return repo.CreateBalance(ctx, &user)
+				})
+				Expect(err).To(MatchError(errSomethingStrange))
+			})
+		})
+	})
+
 	Context("Calling GetBalance method", func() {
 		When("there is no error", func() {
 			BeforeEach(func() {
@@ -398,7 +546,7 @@ var _ = Describe("Repository", func() {
 					Password: userPassword,
 				}
 
-				rs := pgxmock.NewRows([]string{"id", "login", "accrued", "withdrawn"}).
+				rs := pgxmock.NewRows(balanceColumns).
 					AddRow(rowID, userLogin, accrued, withdrawn)
 				mockPool.ExpectQuery("SELECT .+ FROM balance .+").
 					WithArgs(userLogin).
@@ -418,9 +566,41 @@ var _ = Describe("Repository", func() {
 				Expect(result.Withdrawn).To(Equal(float64(50)))
 			})
 		})
+
+		When("there is no balance", func() {
+			BeforeEach(func() {
+				userLogin = "nobody"
+				user = model.User{Login: userLogin}
+
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(balanceColumns)).
+					Times(1)
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns not found error", func() {
+				result, err := repo.GetBalance(ctx, &user)
+				Expect(err).To(MatchError(repository.ErrNotFound))
+				Expect(result).To(BeNil())
+			})
+		})
 	})
 
 	Context("Calling WithdrawFromBalance method", func() {
+		When("the sum is negative", func() {
+			It("returns validation error without touching the balance", func() {
+				err = repo.WithdrawFromBalance(ctx, &model.User{Login: "user"}, "2377225624", -100)
+
+				validationErr := &model.ValidationError{Field: "sum", Message: "must be positive"}
+				Expect(err).To(MatchError(validationErr))
+				Expect(mockPool.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+
 		When("everything is right", func() {
 			BeforeEach(func() {
 				rowID = 1
@@ -439,9 +619,16 @@ var _ = Describe("Repository", func() {
 
 				mockPool.ExpectBegin()
 
+				rsBalance := pgxmock.NewRows(balanceColumns).
+					AddRow(rowID, userLogin, accrued, withdrawn)
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(rsBalance).
+					Times(1)
+
 				rsUpdate := pgxmock.NewRows([]string{"accrued", "withdrawn"}).
-					AddRow(accrued, withdrawn)
-				mockPool.ExpectQuery("UPDATE balance SET .+").
+					AddRow(accrued, withdrawn+sum)
+				mockPool.ExpectQuery("UPDATE balance SET withdrawn .+").
 					WithArgs(userLogin, sum).
 					WillReturnRows(rsUpdate).
 					Times(1)
@@ -453,6 +640,23 @@ var _ = Describe("Repository", func() {
 					WillReturnRows(rsCreate).
 					Times(1)
 
+				rsLots := pgxmock.NewRows(lotColumns).
+					AddRow(int32(1), userLogin, "12345678903", float64(60), float64(60), time.Now(), nil).
+					AddRow(int32(2), userLogin, "2377225624", float64(100), float64(100), time.Now(), nil)
+				mockPool.ExpectQuery("SELECT .+ FROM lots .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(rsLots).
+					Times(1)
+				mockPool.ExpectExec("UPDATE lots SET remaining .+").
+					WithArgs(int32(1), float64(0)).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1)).
+					Times(1)
+				mockPool.ExpectExec("UPDATE lots SET remaining .+").
+					WithArgs(int32(2), float64(60)).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1)).
+					Times(1)
+
+				expectAudit(model.AuditBalanceWithdraw)
 				mockPool.ExpectCommit()
 				mockPool.ExpectRollback()
 
@@ -476,7 +680,7 @@ var _ = Describe("Repository", func() {
 				orderNumber = "2377225624"
 
 				var accrued float64 = 100
-				var withdrawn float64 = 150
+				var withdrawn float64 = 50
 				var sum float64 = 100
 
 				user = model.User{
@@ -486,11 +690,12 @@ var _ = Describe("Repository", func() {
 
 				mockPool.ExpectBegin()
 
-				rsUpdate := pgxmock.NewRows([]string{"accrued", "withdrawn"}).
-					AddRow(accrued, withdrawn)
-				mockPool.ExpectQuery("UPDATE balance SET .+").
-					WithArgs(userLogin, sum).
-					WillReturnRows(rsUpdate).
+				// The balance is checked before withdrawal, so it is not updated
+				rsBalance := pgxmock.NewRows(balanceColumns).
+					AddRow(rowID, userLogin, accrued, withdrawn)
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(rsBalance).
 					Times(1)
 
 				mockPool.ExpectRollback()
@@ -506,6 +711,56 @@ var _ = Describe("Repository", func() {
 				Expect(err).To(Equal(repository.ErrNegativeBalance))
 			})
 		})
+
+		When("locking the balance deadlocks", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+				orderNumber = "2377225624"
+
+				var sum float64 = 100
+
+				user = model.User{Login: userLogin}
+
+				// The deadlock aborts the transaction, so it is rolled back
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnError(&pgconn.PgError{Code: pgerrcode.DeadlockDetected})
+				mockPool.ExpectRollback()
+
+				// And the whole transaction is retried
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(balanceColumns).AddRow(int32(1), userLogin, float64(500), float64(0))).
+					Times(1)
+				mockPool.ExpectQuery("UPDATE balance SET withdrawn .+").
+					WithArgs(userLogin, sum).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(500), sum)).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO withdrawals .+ VALUES .+").
+					WithArgs(userLogin, orderNumber, sum).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1))).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM lots .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(lotColumns)).
+					Times(1)
+				expectAudit(model.AuditBalanceWithdraw)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+
+				err = repo.WithdrawFromBalance(ctx, &user, orderNumber, sum)
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("retries the withdrawal and returns nil error", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
 	})
 
 	Context("Calling GetListOfWithdrawals method", func() {
@@ -521,10 +776,13 @@ var _ = Describe("Repository", func() {
 					Password: userPassword,
 				}
 
-				rs := pgxmock.NewRows([]string{"id", "login", "ordernumber", "sum", "processedat"}).
-					AddRow(rowID, userLogin, orderNumber, sum, withdrawalProcessedAt)
+				reversedAt := withdrawalProcessedAt.Add(time.Hour)
+
+				rs := pgxmock.NewRows([]string{"id", "login", "ordernumber", "sum", "processedat", "reversedat", "reversalreason"}).
+					AddRow(rowID, userLogin, orderNumber, sum, withdrawalProcessedAt, nil, "").
+					AddRow(rowID+1, userLogin, orderNumber, sum, withdrawalProcessedAt, &reversedAt, "order cancelled")
 				mockPool.ExpectQuery("SELECT .+ FROM withdrawals .+").
-					WithArgs(userLogin).
+					WithArgs(userLogin, int32(0), int32(0)).
 					WillReturnRows(rs).
 					Times(1)
 			})
@@ -534,10 +792,14 @@ var _ = Describe("Repository", func() {
 			})
 
 			It("returns a non-empty list of withdrawals and nil error", func() {
-				result, err := repo.GetListOfWithdrawals(ctx, &user)
+				result, total, err := repo.GetListOfWithdrawals(ctx, &user, model.Page{})
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(result).NotTo(BeNil())
-				Expect(len(result)).To(Equal(1))
+				Expect(len(result)).To(Equal(2))
+				Expect(total).To(Equal(2))
+				Expect(result[0].Reversed()).To(BeFalse())
+				Expect(result[1].Reversed()).To(BeTrue())
+				Expect(result[1].ReversalReason).To(Equal("order cancelled"))
 			})
 		})
 
@@ -550,9 +812,9 @@ var _ = Describe("Repository", func() {
 					Password: userPassword,
 				}
 
-				rs := pgxmock.NewRows([]string{"id", "login", "ordernumber", "sum", "processedat"})
+				rs := pgxmock.NewRows([]string{"id", "login", "ordernumber", "sum", "processedat", "reversedat", "reversalreason"})
 				mockPool.ExpectQuery("SELECT .+ FROM withdrawals .+").
-					WithArgs(userLogin).
+					WithArgs(userLogin, int32(0), int32(0)).
 					WillReturnRows(rs).
 					Times(1)
 			})
@@ -562,7 +824,7 @@ var _ = Describe("Repository", func() {
 			})
 
 			It("returns an empty list of withdrawals and nil error", func() {
-				result, err := repo.GetListOfWithdrawals(ctx, &user)
+				result, _, err := repo.GetListOfWithdrawals(ctx, &user, model.Page{})
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(result).NotTo(BeNil())
 				Expect(len(result)).To(Equal(0))
@@ -622,11 +884,14 @@ var _ = Describe("Repository", func() {
 	})
 
 	Context("Calling UpdateBalanceAccrued method", func() {
+		var expiresAt time.Time
+
 		When("everything is right", func() {
 			BeforeEach(func() {
 				var accrued float64 = 100
 				var withdrawn float64 = 0
 
+				expiresAt = time.Now().AddDate(1, 0, 0)
 				userLogin = "user"
 				orderNumber = "12345678903"
 				orderStatus := queries.OrderStatusNEW
@@ -646,9 +911,17 @@ var _ = Describe("Repository", func() {
 
 				mockPool.ExpectBegin()
 
+				rsBalance := pgxmock.NewRows(balanceColumns).
+					AddRow(int32(1), userLogin, float64(0), withdrawn)
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(rsBalance).
+					Times(1)
+
 				rs := pgxmock.NewRows([]string{"accrued", "withdrawn"}).
 					AddRow(accrued, withdrawn)
-				mockPool.ExpectQuery("UPDATE balance SET .+").
+				// The accrual is added to the accrued total, not replacing it
+				mockPool.ExpectQuery(`UPDATE balance SET accrued = accrued \+ \$2 .+`).
 					WithArgs(userLogin, accrued).
 					WillReturnRows(rs).
 					Times(1)
@@ -659,6 +932,12 @@ var _ = Describe("Repository", func() {
 					WillReturnResult(resultOrders).
 					Times(1)
 
+				mockPool.ExpectQuery("INSERT INTO lots .+").
+					WithArgs(userLogin, orderNumber, accrued, &expiresAt).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1))).
+					Times(1)
+
+				expectAudit(model.AuditBalanceAccrual)
 				mockPool.ExpectCommit()
 				mockPool.ExpectRollback()
 
@@ -669,9 +948,1022 @@ var _ = Describe("Repository", func() {
 			})
 
 			It("returns nil error", func() {
-				err = repo.UpdateBalanceAccrued(ctx, &order, &orderAccrual)
+				err = repo.UpdateBalanceAccrued(ctx, &order, &orderAccrual, nil, &expiresAt)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+
+		When("the order gets promotion bonuses", func() {
+			var bonuses model.Bonuses
+
+			BeforeEach(func() {
+				expiresAt = time.Now().AddDate(1, 0, 0)
+				userLogin = "user"
+				orderNumber = "12345678903"
+
+				order = model.Order{
+					Login:      userLogin,
+					Number:     orderNumber,
+					Status:     queries.OrderStatusPROCESSING,
+					UploadedAt: time.Now(),
+				}
+				orderAccrual = model.OrderAccrual{
+					OrderNumber: orderNumber,
+					Status:      queries.OrderStatusPROCESSED,
+					Accrual:     100,
+				}
+				bonuses = model.Bonuses{
+					{RuleID: 1, OrderNumber: orderNumber, Amount: 50},
+					{RuleID: 2, OrderNumber: orderNumber, Amount: 10},
+				}
+
+				mockPool.ExpectBegin()
+
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(balanceColumns).AddRow(int32(1), userLogin, float64(0), float64(0))).
+					Times(1)
+				mockPool.ExpectQuery("SELECT COUNT.+ FROM orders .+").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(0))).
+					Times(1)
+				mockPool.ExpectQuery(`UPDATE balance SET accrued = accrued \+ \$2 .+`).
+					WithArgs(userLogin, float64(100)).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(100), float64(0))).
+					Times(1)
+				mockPool.ExpectExec("UPDATE orders SET .+").
+					WithArgs(orderNumber, queries.OrderStatusPROCESSED, float64(100)).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1)).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO lots .+").
+					WithArgs(userLogin, orderNumber, float64(100), &expiresAt).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1))).
+					Times(1)
+
+				// The first bonus is given
+				mockPool.ExpectQuery("INSERT INTO bonuses .+").
+					WithArgs(userLogin, orderNumber, int32(1), float64(50)).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1))).
+					Times(1)
+				mockPool.ExpectQuery("UPDATE balance SET .+").
+					WithArgs(userLogin, float64(50)).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(150), float64(0))).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO lots .+").
+					WithArgs(userLogin, orderNumber, float64(50), &expiresAt).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(2))).
+					Times(1)
+
+				// The second bonus has already been given
+				mockPool.ExpectQuery("INSERT INTO bonuses .+").
+					WithArgs(userLogin, orderNumber, int32(2), float64(10)).
+					WillReturnRows(pgxmock.NewRows([]string{"id"})).
+					Times(1)
+
+				expectAudit(model.AuditBalanceAccrual, model.AuditBalanceBonus)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("credits every bonus once", func() {
+				err = repo.UpdateBalanceAccrued(ctx, &order, &orderAccrual, func(previousOrders int64) model.Bonuses {
+					Expect(previousOrders).To(BeZero())
+					return bonuses
+				}, &expiresAt)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+	})
+
+	Context("Calling ChainAuditLog method", func() {
+		var (
+			queueColumns = []string{"id", "login", "action", "old_value", "new_value", "request_id", "ip", "created_at"}
+			createdAt    time.Time
+		)
+
+		BeforeEach(func() {
+			createdAt = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("entries are queued", func() {
+			var first, second *model.AuditEntry
+
+			BeforeEach(func() {
+				first = &model.AuditEntry{Login: "user", Action: model.AuditUserRegister, CreatedAt: createdAt, PrevHash: audit.GenesisHash}
+				first.Hash = audit.Hash(first)
+				second = &model.AuditEntry{Login: "user", Action: model.AuditUserLogin, RequestID: "req", IP: "127.0.0.1", CreatedAt: createdAt, PrevHash: first.Hash}
+				second.Hash = audit.Hash(second)
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectExec("SELECT pg_advisory_xact_lock.+").
+					WillReturnResult(pgxmock.NewResult("SELECT", 1)).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM audit_queue .+").
+					WithArgs(int32(1000)).
+					WillReturnRows(pgxmock.NewRows(queueColumns).
+						AddRow(int64(3), "user", string(model.AuditUserRegister), []byte(nil), []byte(nil), "", "", createdAt).
+						AddRow(int64(5), "user", string(model.AuditUserLogin), []byte(nil), []byte(nil), "req", "127.0.0.1", createdAt)).
+					Times(1)
+				mockPool.ExpectQuery("SELECT hash FROM audit_log .+").
+					WillReturnRows(pgxmock.NewRows([]string{"hash"})).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO audit_log .+").
+					WithArgs("user", string(model.AuditUserRegister), []byte(nil), []byte(nil), "", "", createdAt, audit.GenesisHash, first.Hash).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(1))).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO audit_log .+").
+					WithArgs("user", string(model.AuditUserLogin), []byte(nil), []byte(nil), "req", "127.0.0.1", createdAt, first.Hash, second.Hash).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(2))).
+					Times(1)
+				mockPool.ExpectExec("DELETE FROM audit_queue .+").
+					WithArgs([]int64{3, 5}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 2)).
+					Times(1)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+
+			It("chains the entries in the order they were queued", func() {
+				chained, err := repo.ChainAuditLog(ctx)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(chained).To(BeEquivalentTo(2))
+			})
+		})
+
+		When("the queue is empty", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				mockPool.ExpectExec("SELECT pg_advisory_xact_lock.+").
+					WillReturnResult(pgxmock.NewResult("SELECT", 1)).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM audit_queue .+").
+					WithArgs(int32(1000)).
+					WillReturnRows(pgxmock.NewRows(queueColumns)).
+					Times(1)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+
+			It("chains nothing", func() {
+				chained, err := repo.ChainAuditLog(ctx)
 				Expect(err).ShouldNot(HaveOccurred())
+				Expect(chained).To(BeZero())
 			})
 		})
 	})
+
+	Context("Calling GetAuditEntries method", func() {
+		var (
+			entryColumns = []string{"id", "login", "action", "old_value", "new_value", "request_id", "ip", "created_at", "prev_hash", "hash", "queued"}
+			createdAt    time.Time
+		)
+
+		BeforeEach(func() {
+			createdAt = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("some entries are not chained yet", func() {
+			BeforeEach(func() {
+				mockPool.ExpectQuery("SELECT .+ FROM \\(SELECT .+ FROM audit_log UNION ALL SELECT .+ FROM audit_queue\\) .+").
+					WithArgs("user", "", (*time.Time)(nil), (*time.Time)(nil), int32(10)).
+					WillReturnRows(pgxmock.NewRows(entryColumns).
+						AddRow(int64(7), "user", string(model.AuditBalanceWithdraw), []byte(nil), []byte(nil), "req", "127.0.0.1", createdAt, "", "", true).
+						AddRow(int64(2), "user", string(model.AuditUserLogin), []byte(nil), []byte(nil), "", "", createdAt, "prev", "hash", false)).
+					Times(1)
+			})
+
+			It("returns the queued entries first without ID and hashes", func() {
+				entries, err := repo.GetAuditEntries(ctx, &model.AuditFilter{Login: "user", Limit: 10})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(entries).To(HaveLen(2))
+
+				Expect(entries[0].Queued).To(BeTrue())
+				Expect(entries[0].ID).To(BeZero())
+				Expect(entries[0].Action).To(Equal(model.AuditBalanceWithdraw))
+				Expect(entries[0].Hash).To(BeEmpty())
+
+				Expect(entries[1].Queued).To(BeFalse())
+				Expect(entries[1].ID).To(BeEquivalentTo(2))
+				Expect(entries[1].Hash).To(Equal("hash"))
+			})
+		})
+	})
+
+	Context("Calling DisableUser method", func() {
+		When("the user exists", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectExec("UPDATE users SET disabled .+").
+					WithArgs(userLogin).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1)).
+					Times(1)
+				expectAudit(model.AuditUserDisable)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns nil error", func() {
+				err = repo.DisableUser(ctx, userLogin)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+
+		When("the user doesn't exist", func() {
+			BeforeEach(func() {
+				userLogin = "nobody"
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectExec("UPDATE users SET disabled .+").
+					WithArgs(userLogin).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 0)).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns not found error", func() {
+				err = repo.DisableUser(ctx, userLogin)
+				Expect(err).To(Equal(repository.ErrNotFound))
+			})
+		})
+	})
+
+	Context("Calling DeleteUser method", func() {
+		var userColumns []string
+
+		BeforeEach(func() {
+			userLogin = "user"
+			userColumns = []string{"id", "login", "password", "created_at", "disabled", "session_version", "timezone"}
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("the user data is anonymized", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM users .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(userColumns).AddRow(int32(7), userLogin, "hash", time.Now(), false, int32(0), "")).
+					Times(1)
+				mockPool.ExpectExec("WITH anonymized_orders AS .+ UPDATE users").
+					WithArgs(userLogin, "deleted-7").
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1)).
+					Times(1)
+				expectAudit(model.AuditUserDelete)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+
+			It("replaces the login with a pseudonym", func() {
+				err = repo.DeleteUser(ctx, userLogin, true)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+
+		When("the user data is deleted", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM users .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(userColumns).AddRow(int32(7), userLogin, "hash", time.Now(), false, int32(0), "")).
+					Times(1)
+				mockPool.ExpectExec("WITH deleted_expirations AS .+ DELETE FROM users").
+					WithArgs(userLogin, "deleted-7").
+					WillReturnResult(pgxmock.NewResult("DELETE", 1)).
+					Times(1)
+				expectAudit(model.AuditUserDelete)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+
+			It("deletes the user data", func() {
+				err = repo.DeleteUser(ctx, userLogin, false)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+
+		When("the user doesn't exist", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM users .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(userColumns)).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+
+			It("returns not found error", func() {
+				err = repo.DeleteUser(ctx, userLogin, true)
+				Expect(err).To(Equal(repository.ErrNotFound))
+			})
+		})
+	})
+
+	Context("Calling RequeueOrder method", func() {
+		When("the order has already been processed", func() {
+			BeforeEach(func() {
+				orderNumber = "12345678903"
+
+				mockPool.ExpectExec("UPDATE orders SET status .+").
+					WithArgs(orderNumber).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 0)).
+					Times(1)
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns not found error", func() {
+				err = repo.RequeueOrder(ctx, orderNumber)
+				Expect(err).To(Equal(repository.ErrNotFound))
+			})
+		})
+	})
+
+	Context("Calling CreateOrders method", func() {
+		When("some of the numbers already exist", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+				orderUploadedAt = time.Now()
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("INSERT INTO orders .+ ON CONFLICT .+").
+					WithArgs(userLogin, []string{"12345678903", "2377225624"}).
+					WillReturnRows(pgxmock.NewRows([]string{"number"}).AddRow("12345678903")).
+					Times(1)
+				mockPool.ExpectQuery("SELECT (.+) FROM orders WHERE number = ANY .+").
+					WithArgs([]string{"2377225624"}).
+					WillReturnRows(pgxmock.NewRows([]string{"id", "login", "number", "status", "accrual", "uploaded_at"}).
+						AddRow(int32(1), "another", "2377225624", queries.OrderStatusNEW, float64(0), orderUploadedAt)).
+					Times(1)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns created numbers and existing orders", func() {
+				created, existing, err := repo.CreateOrders(ctx, userLogin, []string{"12345678903", "2377225624"})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(created).To(Equal([]string{"12345678903"}))
+				Expect(existing).To(HaveLen(1))
+				Expect(existing[0].Login).To(Equal("another"))
+				Expect(existing[0].Number).To(Equal("2377225624"))
+			})
+		})
+
+		When("all of the numbers are new", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("INSERT INTO orders .+ ON CONFLICT .+").
+					WithArgs(userLogin, []string{"12345678903"}).
+					WillReturnRows(pgxmock.NewRows([]string{"number"}).AddRow("12345678903")).
+					Times(1)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("does not look for existing orders", func() {
+				created, existing, err := repo.CreateOrders(ctx, userLogin, []string{"12345678903"})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(created).To(Equal([]string{"12345678903"}))
+				Expect(existing).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("Calling Statement method", func() {
+		BeforeEach(func() {
+			userLogin = "user"
+			user = model.User{Login: userLogin}
+			withdrawalProcessedAt = time.Now()
+
+			mockPool.ExpectQuery("WITH entries AS .+").
+				WithArgs(userLogin, time.Time{}, withdrawalProcessedAt).
+				WillReturnRows(pgxmock.NewRows([]string{"type", "order_number", "amount", "balance", "processed_at"}).
+					AddRow("accrual", "12345678903", float64(500), float64(500), withdrawalProcessedAt.Add(-time.Hour)).
+					AddRow("withdrawal", "2377225624", float64(-100), float64(400), withdrawalProcessedAt.Add(-time.Minute))).
+				Times(1)
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("passes every entry to the function", func() {
+			var entries []model.StatementEntry
+			err = repo.Statement(ctx, &user, time.Time{}, withdrawalProcessedAt, func(entry *model.StatementEntry) error {
+				entries = append(entries, *entry)
+				return nil
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Type).To(Equal(model.StatementAccrual))
+			Expect(entries[1].Type).To(Equal(model.StatementWithdrawal))
+			Expect(entries[1].Balance).To(Equal(float64(400)))
+		})
+
+		It("stops on the function error", func() {
+			calls := 0
+			err = repo.Statement(ctx, &user, time.Time{}, withdrawalProcessedAt, func(entry *model.StatementEntry) error {
+				calls++
+				return errSomethingStrange
+			})
+			Expect(err).To(MatchError(errSomethingStrange))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Context("Calling ReverseWithdrawal method", func() {
+		var withdrawalColumns []string
+
+		BeforeEach(func() {
+			userLogin = "user"
+			orderNumber = "2377225624"
+			withdrawalProcessedAt = time.Now()
+			withdrawalColumns = []string{"id", "login", "order_number", "sum", "processed_at", "reversed_at", "reversal_reason"}
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("the withdrawal exists and has not been reversed", func() {
+			BeforeEach(func() {
+				reversedAt := withdrawalProcessedAt.Add(time.Hour)
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM withdrawals .+ FOR UPDATE").
+					WithArgs(orderNumber).
+					WillReturnRows(pgxmock.NewRows(withdrawalColumns).
+						AddRow(int32(7), userLogin, orderNumber, float64(100), withdrawalProcessedAt, nil, "")).
+					Times(1)
+				mockPool.ExpectQuery("UPDATE withdrawals SET reversed_at .+").
+					WithArgs(int32(7), "order cancelled").
+					WillReturnRows(pgxmock.NewRows([]string{"reversed_at"}).AddRow(&reversedAt)).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO reversals .+").
+					WithArgs(int32(7), userLogin, orderNumber, float64(100), "order cancelled", reversedAt).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1))).
+					Times(1)
+				mockPool.ExpectQuery("UPDATE balance SET withdrawn = withdrawn .+").
+					WithArgs(userLogin, float64(-100)).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(500), float64(0))).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO lots .+").
+					WithArgs(userLogin, orderNumber, float64(100), (*time.Time)(nil)).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1))).
+					Times(1)
+				expectAudit(model.AuditBalanceReversal)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+
+			It("returns the reversed withdrawal", func() {
+				withdrawal, err := repo.ReverseWithdrawal(ctx, orderNumber, "order cancelled", nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(withdrawal.Reversed()).To(BeTrue())
+				Expect(withdrawal.Login).To(Equal(userLogin))
+				Expect(withdrawal.ReversalReason).To(Equal("order cancelled"))
+			})
+		})
+
+		When("the withdrawal has already been reversed", func() {
+			BeforeEach(func() {
+				reversedAt := withdrawalProcessedAt.Add(time.Hour)
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM withdrawals .+ FOR UPDATE").
+					WithArgs(orderNumber).
+					WillReturnRows(pgxmock.NewRows(withdrawalColumns).
+						AddRow(int32(7), userLogin, orderNumber, float64(100), withdrawalProcessedAt, &reversedAt, "duplicate")).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+
+			It("returns already reversed error", func() {
+				_, err = repo.ReverseWithdrawal(ctx, orderNumber, "order cancelled", nil)
+				Expect(err).To(MatchError(repository.ErrAlreadyReversed))
+			})
+		})
+
+		When("the withdrawal doesn't exist", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("SELECT .+ FROM withdrawals .+ FOR UPDATE").
+					WithArgs(orderNumber).
+					WillReturnRows(pgxmock.NewRows(withdrawalColumns)).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+
+			It("returns not found error", func() {
+				_, err = repo.ReverseWithdrawal(ctx, orderNumber, "order cancelled", nil)
+				Expect(err).To(MatchError(repository.ErrNotFound))
+			})
+		})
+	})
+
+	Context("Calling AdjustBalance method", func() {
+		When("the balance stays positive", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+				user = model.User{Login: userLogin}
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("UPDATE balance SET accrued = accrued .+").
+					WithArgs(userLogin, float64(100)).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(600), float64(50))).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO lots .+").
+					WithArgs(userLogin, "", float64(100), (*time.Time)(nil)).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1))).
+					Times(1)
+				expectAudit(model.AuditBalanceAdjust)
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns adjusted balance", func() {
+				result, err := repo.AdjustBalance(ctx, &user, 100, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(result.Current).To(Equal(float64(550)))
+				Expect(result.Withdrawn).To(Equal(float64(50)))
+			})
+		})
+
+		When("the balance becomes negative", func() {
+			BeforeEach(func() {
+				userLogin = "user"
+				user = model.User{Login: userLogin}
+
+				mockPool.ExpectBegin()
+				mockPool.ExpectQuery("UPDATE balance SET accrued = accrued .+").
+					WithArgs(userLogin, float64(-100)).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(0), float64(50))).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+			AfterEach(func() {
+				err = mockPool.ExpectationsWereMet()
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("returns negative balance error", func() {
+				_, err = repo.AdjustBalance(ctx, &user, -100, nil)
+				Expect(err).To(Equal(repository.ErrNegativeBalance))
+			})
+		})
+	})
+
+	Context("Calling ExpireLots method", func() {
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns the number of expired lots and the expired amount", func() {
+			now := time.Now()
+
+			mockPool.ExpectBegin()
+			mockPool.ExpectQuery("SELECT login FROM balance .+ FOR UPDATE").
+				WithArgs(&now).
+				WillReturnRows(pgxmock.NewRows([]string{"login"}).AddRow("friend").AddRow("user")).
+				Times(1)
+			mockPool.ExpectQuery("WITH due AS .+").
+				WithArgs(&now).
+				WillReturnRows(pgxmock.NewRows([]string{"login", "lots", "amount", "accrued", "withdrawn"}).
+					AddRow("friend", int64(1), float64(50), float64(100), float64(0)).
+					AddRow("user", int64(1), float64(100), float64(400), float64(50))).
+				Times(1)
+			expectAudit(model.AuditBalanceExpiration, model.AuditBalanceExpiration)
+			mockPool.ExpectCommit()
+			mockPool.ExpectRollback()
+
+			lots, amount, err := repo.ExpireLots(ctx, now)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lots).To(Equal(int64(2)))
+			Expect(amount).To(Equal(float64(150)))
+		})
+
+		It("doesn't write to the audit log, when nothing has expired", func() {
+			now := time.Now()
+
+			mockPool.ExpectBegin()
+			mockPool.ExpectQuery("SELECT login FROM balance .+ FOR UPDATE").
+				WithArgs(&now).
+				WillReturnRows(pgxmock.NewRows([]string{"login"})).
+				Times(1)
+			mockPool.ExpectCommit()
+			mockPool.ExpectRollback()
+
+			lots, amount, err := repo.ExpireLots(ctx, now)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lots).To(BeZero())
+			Expect(amount).To(BeZero())
+		})
+	})
+
+	Context("Calling GetUpcomingExpirations method", func() {
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns the amounts by expiry days", func() {
+			userLogin = "user"
+			user = model.User{Login: userLogin}
+			expiresOn := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+			mockPool.ExpectQuery("SELECT .+ FROM lots .+").
+				WithArgs(userLogin, int32(5)).
+				WillReturnRows(pgxmock.NewRows([]string{"expires_on", "amount"}).
+					AddRow(expiresOn, float64(100)).
+					AddRow(expiresOn.AddDate(0, 0, 1), float64(50))).
+				Times(1)
+
+			expirations, err := repo.GetUpcomingExpirations(ctx, &user, 5)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(expirations).To(HaveLen(2))
+			Expect(expirations[0].ExpiresOn).To(Equal(expiresOn))
+			Expect(expirations[0].Amount).To(Equal(float64(100)))
+		})
+	})
+
+	Context("Calling GetAccruedTotal method", func() {
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns the total of accrued points", func() {
+			userLogin = "user"
+			user = model.User{Login: userLogin}
+			since := time.Now().AddDate(-1, 0, 0)
+
+			mockPool.ExpectQuery("SELECT .+ FROM orders .+").
+				WithArgs(userLogin, since).
+				WillReturnRows(pgxmock.NewRows([]string{"total"}).AddRow(float64(1200))).
+				Times(1)
+
+			total, err := repo.GetAccruedTotal(ctx, &user, since)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(total).To(Equal(float64(1200)))
+		})
+	})
+
+	Context("Calling SaveTier method", func() {
+		BeforeEach(func() {
+			userLogin = "user"
+			user = model.User{Login: userLogin}
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns true when the tier has been changed", func() {
+			mockPool.ExpectExec("INSERT INTO tier_history .+").
+				WithArgs(userLogin, "silver", float64(1200)).
+				WillReturnResult(pgxmock.NewResult("INSERT", 1)).
+				Times(1)
+
+			changed, err := repo.SaveTier(ctx, &user, "silver", 1200)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+		})
+
+		It("returns false when the tier is the same", func() {
+			mockPool.ExpectExec("INSERT INTO tier_history .+").
+				WithArgs(userLogin, "silver", float64(1300)).
+				WillReturnResult(pgxmock.NewResult("INSERT", 0)).
+				Times(1)
+
+			changed, err := repo.SaveTier(ctx, &user, "silver", 1300)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+		})
+	})
+
+	Context("Calling GetTierHistory method", func() {
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns the latest tier changes", func() {
+			userLogin = "user"
+			user = model.User{Login: userLogin}
+			changedAt := time.Now()
+
+			mockPool.ExpectQuery("SELECT .+ FROM tier_history .+").
+				WithArgs(userLogin, int32(10)).
+				WillReturnRows(pgxmock.NewRows([]string{"id", "login", "tier", "total", "changed_at"}).
+					AddRow(int32(2), userLogin, "silver", float64(1010), changedAt).
+					AddRow(int32(1), userLogin, "bronze", float64(10), changedAt.AddDate(0, -1, 0))).
+				Times(1)
+
+			history, err := repo.GetTierHistory(ctx, &user, 10)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Tier).To(Equal("silver"))
+		})
+	})
+
+	Context("Calling Transfer method", func() {
+		var (
+			transfer    model.Transfer
+			since       time.Time
+			userColumns []string
+		)
+
+		// expectTransferAudit expects both balances to be written to the audit log
+		expectTransferAudit := func() {
+			mockPool.ExpectExec("INSERT INTO audit_queue .+").
+				WithArgs(userLogin, string(model.AuditBalanceTransferOut),
+					[]byte(`{"current":450,"withdrawn":50}`),
+					[]byte(`{"current":350,"withdrawn":50,"sum":100,"counterparty":"friend"}`),
+					"", "", pgxmock.AnyArg()).
+				WillReturnResult(pgxmock.NewResult("INSERT", 1)).
+				Times(1)
+			mockPool.ExpectExec("INSERT INTO audit_queue .+").
+				WithArgs("friend", string(model.AuditBalanceTransferIn),
+					[]byte(`{"current":0,"withdrawn":0}`),
+					[]byte(`{"current":100,"withdrawn":0,"sum":100,"counterparty":"user"}`),
+					"", "", pgxmock.AnyArg()).
+				WillReturnResult(pgxmock.NewResult("INSERT", 1)).
+				Times(1)
+		}
+
+		// expectSender expects the sender to be selected
+		expectSender := func(disabled bool) {
+			mockPool.ExpectQuery("SELECT .+ FROM users .+").
+				WithArgs(userLogin).
+				WillReturnRows(pgxmock.NewRows(userColumns).AddRow(int32(1), userLogin, "hash", time.Now(), disabled, int32(0), "")).
+				Times(1)
+		}
+
+		BeforeEach(func() {
+			userLogin = "user"
+			since = time.Now().Truncate(24 * time.Hour)
+			userColumns = []string{"id", "login", "password", "created_at", "disabled", "session_version", "timezone"}
+			transfer = model.Transfer{
+				Sender:    userLogin,
+				Recipient: "friend",
+				Sum:       100,
+			}
+		})
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("everything is right", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				expectSender(false)
+				mockPool.ExpectQuery("SELECT .+ FROM users .+").
+					WithArgs("friend").
+					WillReturnRows(pgxmock.NewRows(userColumns).AddRow(int32(2), "friend", "hash", time.Now(), false, int32(0), "")).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs([]string{userLogin, "friend"}).
+					WillReturnRows(pgxmock.NewRows(balanceColumns).
+						AddRow(int32(2), "friend", float64(0), float64(0)).
+						AddRow(int32(1), userLogin, float64(500), float64(50))).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM transfers .+").
+					WithArgs(userLogin, since).
+					WillReturnRows(pgxmock.NewRows([]string{"total"}).AddRow(float64(800))).
+					Times(1)
+				mockPool.ExpectQuery("UPDATE balance SET accrued = accrued .+").
+					WithArgs(userLogin, float64(-100)).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(400), float64(50))).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM lots .+ FOR UPDATE").
+					WithArgs(userLogin).
+					WillReturnRows(pgxmock.NewRows(lotColumns).
+						AddRow(int32(1), userLogin, "12345678903", float64(450), float64(450), time.Now(), nil)).
+					Times(1)
+				mockPool.ExpectExec("UPDATE lots SET remaining .+").
+					WithArgs(int32(1), float64(350)).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1)).
+					Times(1)
+				mockPool.ExpectQuery("UPDATE balance SET accrued = accrued .+").
+					WithArgs("friend", float64(100)).
+					WillReturnRows(pgxmock.NewRows([]string{"accrued", "withdrawn"}).AddRow(float64(100), float64(0))).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO lots .+").
+					WithArgs("friend", "", float64(100), (*time.Time)(nil)).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(2))).
+					Times(1)
+				mockPool.ExpectQuery("INSERT INTO transfers .+").
+					WithArgs(userLogin, "friend", float64(100)).
+					WillReturnRows(pgxmock.NewRows([]string{"id", "sender", "recipient", "sum", "transferred_at"}).
+						AddRow(int32(1), userLogin, "friend", float64(100), time.Now())).
+					Times(1)
+				expectTransferAudit()
+				mockPool.ExpectCommit()
+				mockPool.ExpectRollback()
+			})
+
+			It("returns the transfer", func() {
+				created, err := repo.Transfer(ctx, &transfer, 1000, since, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(created.Sender).To(Equal(userLogin))
+				Expect(created.Recipient).To(Equal("friend"))
+				Expect(created.Sum).To(Equal(float64(100)))
+			})
+		})
+
+		When("the sender is disabled", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				expectSender(true)
+				mockPool.ExpectRollback()
+			})
+
+			It("returns sender disabled error", func() {
+				_, err := repo.Transfer(ctx, &transfer, 0, since, nil)
+				Expect(err).To(Equal(repository.ErrSenderDisabled))
+			})
+		})
+
+		When("the recipient is disabled", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				expectSender(false)
+				mockPool.ExpectQuery("SELECT .+ FROM users .+").
+					WithArgs("friend").
+					WillReturnRows(pgxmock.NewRows(userColumns).AddRow(int32(2), "friend", "hash", time.Now(), true, int32(0), "")).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+
+			It("returns disabled error", func() {
+				_, err = repo.Transfer(ctx, &transfer, 1000, since, nil)
+				Expect(err).To(MatchError(repository.ErrDisabled))
+			})
+		})
+
+		When("the recipient doesn't exist", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				expectSender(false)
+				mockPool.ExpectQuery("SELECT .+ FROM users .+").
+					WithArgs("friend").
+					WillReturnRows(pgxmock.NewRows(userColumns)).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+
+			It("returns not found error", func() {
+				_, err = repo.Transfer(ctx, &transfer, 1000, since, nil)
+				Expect(err).To(MatchError(repository.ErrNotFound))
+			})
+		})
+
+		When("the daily limit is exceeded", func() {
+			BeforeEach(func() {
+				mockPool.ExpectBegin()
+				expectSender(false)
+				mockPool.ExpectQuery("SELECT .+ FROM users .+").
+					WithArgs("friend").
+					WillReturnRows(pgxmock.NewRows(userColumns).AddRow(int32(2), "friend", "hash", time.Now(), false, int32(0), "")).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM balance .+ FOR UPDATE").
+					WithArgs([]string{userLogin, "friend"}).
+					WillReturnRows(pgxmock.NewRows(balanceColumns).
+						AddRow(int32(2), "friend", float64(0), float64(0)).
+						AddRow(int32(1), userLogin, float64(500), float64(50))).
+					Times(1)
+				mockPool.ExpectQuery("SELECT .+ FROM transfers .+").
+					WithArgs(userLogin, since).
+					WillReturnRows(pgxmock.NewRows([]string{"total"}).AddRow(float64(950))).
+					Times(1)
+				mockPool.ExpectRollback()
+			})
+
+			It("returns limit exceeded error", func() {
+				_, err = repo.Transfer(ctx, &transfer, 1000, since, nil)
+				Expect(err).To(MatchError(repository.ErrLimitExceeded))
+			})
+		})
+	})
+
+	Context("Calling GetListOfTransfers method", func() {
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns sent and received transfers", func() {
+			userLogin = "user"
+			user = model.User{Login: userLogin}
+
+			mockPool.ExpectQuery("SELECT .+ FROM transfers .+").
+				WithArgs(userLogin, int32(0), int32(0)).
+				WillReturnRows(pgxmock.NewRows([]string{"id", "sender", "recipient", "sum", "transferred_at"}).
+					AddRow(int32(2), "friend", userLogin, float64(50), time.Now()).
+					AddRow(int32(1), userLogin, "friend", float64(100), time.Now())).
+				Times(1)
+
+			transfers, total, err := repo.GetListOfTransfers(ctx, &user, model.Page{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(transfers).To(HaveLen(2))
+			Expect(transfers[0].Sender).To(Equal("friend"))
+			Expect(total).To(Equal(2))
+		})
+
+		It("counts the transfers, when a page is beyond the list", func() {
+			userLogin = "user"
+			user = model.User{Login: userLogin}
+
+			mockPool.ExpectQuery("SELECT .+ FROM transfers .+ LIMIT .+ OFFSET .+").
+				WithArgs(userLogin, int32(50), int32(10)).
+				WillReturnRows(pgxmock.NewRows([]string{"id", "sender", "recipient", "sum", "transferred_at"})).
+				Times(1)
+			mockPool.ExpectQuery("SELECT COUNT.+ FROM transfers").
+				WithArgs(userLogin).
+				WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(1))).
+				Times(1)
+
+			transfers, total, err := repo.GetListOfTransfers(ctx, &user, model.Page{Limit: 50, Offset: 10})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(transfers).To(BeEmpty())
+			Expect(total).To(Equal(1))
+		})
+	})
+
+	Context("Calling DeletePromotionRule method", func() {
+		AfterEach(func() {
+			err = mockPool.ExpectationsWereMet()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns nil error, when the rule is deleted", func() {
+			mockPool.ExpectExec("DELETE FROM promotion_rules .+").
+				WithArgs(int32(1)).
+				WillReturnResult(pgxmock.NewResult("DELETE", 1)).
+				Times(1)
+
+			err = repo.DeletePromotionRule(ctx, 1)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("returns not found error, when there is no rule", func() {
+			mockPool.ExpectExec("DELETE FROM promotion_rules .+").
+				WithArgs(int32(1)).
+				WillReturnResult(pgxmock.NewResult("DELETE", 0)).
+				Times(1)
+
+			err = repo.DeletePromotionRule(ctx, 1)
+			Expect(err).Should(MatchError(repository.ErrNotFound))
+		})
+
+		It("returns conflict error, when the rule has given bonuses", func() {
+			mockPool.ExpectExec("DELETE FROM promotion_rules .+").
+				WithArgs(int32(1)).
+				WillReturnError(&pgconn.PgError{Code: pgerrcode.ForeignKeyViolation})
+
+			err = repo.DeletePromotionRule(ctx, 1)
+			Expect(err).Should(MatchError(repository.ErrConflict))
+		})
+	})
 })
@@ -0,0 +1,139 @@
+package repository
+
+import (
+    "context"
+    "errors"
+    "expvar"
+    "time"
+
+    "github.com/RomanAgaltsev/ya_gophermart/internal/config"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+
+    "github.com/cenkalti/backoff/v4"
+    "github.com/jackc/pgerrcode"
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+// Classes of transient errors, they are the keys of retry metrics.
+const (
+    RetryClassConnection    = "connection"
+    RetryClassSerialization = "serialization_failure"
+    RetryClassDeadlock      = "deadlock"
+)
+
+// Retries counts query retries by the class of the retried error, it is published as "repository_retries" expvar.
+var Retries = expvar.NewMap("repository_retries")
+
+// RetryPolicy is the policy of query retries on transient errors.
+type RetryPolicy struct {
+    InitialInterval time.Duration // Delay before the first retry
+    MaxInterval     time.Duration // Maximal delay between retries
+    MaxElapsedTime  time.Duration // Time after which queries are not retried anymore, zero means no limit
+    MaxRetries      uint64        // Maximal number of retries of a query, zero means queries are not retried
+}
+
+// NewRetryPolicy creates the retry policy from the configuration.
+func NewRetryPolicy(cfg *config.Config) RetryPolicy {
+    return RetryPolicy{
+        InitialInterval: cfg.DBRetryInitialInterval,
+        MaxInterval:     cfg.DBRetryMaxInterval,
+        MaxElapsedTime:  cfg.DBRetryMaxElapsedTime,
+        MaxRetries:      cfg.DBRetryMaxRetries,
+    }
+}
+
+// backOff creates exponential backoff of the policy, which stops, when the context is done.
+func (p RetryPolicy) backOff(ctx context.Context) backoff.BackOff {
+    b := backoff.NewExponentialBackOff(
+        backoff.WithInitialInterval(p.InitialInterval),
+        backoff.WithMaxInterval(p.MaxInterval),
+        backoff.WithMaxElapsedTime(p.MaxElapsedTime),
+    )
+
+    return backoff.WithContext(backoff.WithMaxRetries(b, p.MaxRetries), ctx)
+}
+
+// txStartedContextKey is the context key, which marks statements executed in a transaction begun by the repository.
+type txStartedContextKey struct{}
+
+// inDBTransaction checks if statements are executed in a transaction - of a unit of work or begun by the repository.
+// The statements are not retried one by one, because a transaction is aborted by the first error.
+func inDBTransaction(ctx context.Context) bool {
+    return ctx.Value(txContextKey{}) != nil || ctx.Value(txStartedContextKey{}) != nil
+}
+
+// transientClass returns the class of the error, if the error is transient and the query may succeed, when it is retried.
+// Connection exceptions, server restarts, serialization failures and deadlocks are transient,
+// the other errors are permanent.
+func transientClass(err error) (string, bool) {
+    // Nothing can succeed, when the context is done
+    if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return "", false
+    }
+
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) {
+        switch {
+        case pgerrcode.IsConnectionException(pgErr.Code),
+            // The server is restarting
+            pgErr.Code == pgerrcode.AdminShutdown,
+            pgErr.Code == pgerrcode.CrashShutdown,
+            pgErr.Code == pgerrcode.CannotConnectNow:
+            return RetryClassConnection, true
+        case pgErr.Code == pgerrcode.SerializationFailure:
+            return RetryClassSerialization, true
+        case pgErr.Code == pgerrcode.DeadlockDetected:
+            return RetryClassDeadlock, true
+        default:
+            return "", false
+        }
+    }
+
+    // The connection has failed or the query has not been sent to the server
+    var connectErr *pgconn.ConnectError
+    if errors.As(err, &connectErr) || pgconn.SafeToRetry(err) {
+        return RetryClassConnection, true
+    }
+
+    return "", false
+}
+
+// retryWithData calls the function and retries it on transient errors according to the policy, until the context is done.
+// Permanent errors are returned at once, statements of transactions are not retried at all.
+func retryWithData[T any](ctx context.Context, policy RetryPolicy, f func() (T, error)) (T, error) {
+    if inDBTransaction(ctx) {
+        policy.MaxRetries = 0
+    }
+
+    return backoff.RetryNotifyWithData(func() (T, error) {
+        data, err := f()
+        if err == nil {
+            return data, nil
+        }
+
+        if _, ok := transientClass(err); !ok {
+            return data, backoff.Permanent(err)
+        }
+
+        return data, err
+    }, policy.backOff(ctx), retryNotify(ctx))
+}
+
+// retry calls the function and retries it on transient errors, it is retryWithData for functions without a result.
+func retry(ctx context.Context, policy RetryPolicy, f func() error) error {
+    _, err := retryWithData(ctx, policy, func() (struct{}, error) {
+        return struct{}{}, f()
+    })
+
+    return err
+}
+
+// retryNotify returns a notification of query retries, which logs them with the request-scoped logger and counts them.
+func retryNotify(ctx context.Context) backoff.Notify {
+    return func(err error, delay time.Duration) {
+        class, _ := transientClass(err)
+        Retries.Add(class, 1)
+
+        logger.FromContext(ctx).Warn("query retry", "error", err.Error(), "class", class, "delay", delay)
+    }
+}
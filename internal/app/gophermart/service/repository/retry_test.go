@@ -0,0 +1,182 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock/v4"
+)
+
+var _ = Describe("Retry policy", func() {
+	const userLogin = "user"
+
+	var (
+		ctx context.Context
+
+		mockPool pgxmock.PgxPoolIface
+		repo     *repository.Repository
+
+		userColumns = []string{"id", "login", "password", "createdat", "disabled", "sessionversion", "timezone"}
+	)
+
+	// retries returns the number of retries of the class counted so far
+	retries := func(class string) int64 {
+		if v, ok := repository.Retries.Get(class).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+
+	// expectUser expects the user query to return the error or, if the error is nil, the user
+	expectUser := func(err error) {
+		query := mockPool.ExpectQuery("SELECT .+ FROM users WHERE .+").WithArgs(userLogin)
+		if err != nil {
+			query.WillReturnError(err)
+			return
+		}
+		query.WillReturnRows(pgxmock.NewRows(userColumns).AddRow(int32(1), userLogin, "password", time.Now(), false, int32(0), ""))
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		ctx = context.Background()
+
+		mockPool, err = pgxmock.NewPool()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		repo, err = repository.New(mockPool, repository.RetryPolicy{
+			InitialInterval: time.Millisecond,
+			MaxInterval:     5 * time.Millisecond,
+			MaxElapsedTime:  time.Second,
+			MaxRetries:      2,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(mockPool.ExpectationsWereMet()).To(Succeed())
+		mockPool.Close()
+	})
+
+	It("creates the policy from the configuration", func() {
+		Expect(repository.NewRetryPolicy(&config.Config{
+			DBRetryInitialInterval: 50 * time.Millisecond,
+			DBRetryMaxInterval:     time.Second,
+			DBRetryMaxElapsedTime:  10 * time.Second,
+			DBRetryMaxRetries:      7,
+		})).To(Equal(repository.RetryPolicy{
+			InitialInterval: 50 * time.Millisecond,
+			MaxInterval:     time.Second,
+			MaxElapsedTime:  10 * time.Second,
+			MaxRetries:      7,
+		}))
+	})
+
+	DescribeTable("retries transient errors and counts the retries",
+		func(err error, class string) {
+			before := retries(class)
+
+			expectUser(err)
+			expectUser(nil)
+
+			user, err := repo.GetUser(ctx, userLogin)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(user.Login).To(Equal(userLogin))
+			Expect(retries(class)).To(Equal(before + 1))
+		},
+
+		Entry("serialization failure", &pgconn.PgError{Code: pgerrcode.SerializationFailure}, repository.RetryClassSerialization),
+		Entry("deadlock", &pgconn.PgError{Code: pgerrcode.DeadlockDetected}, repository.RetryClassDeadlock),
+		Entry("connection failure", &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, repository.RetryClassConnection),
+		Entry("server restart", &pgconn.PgError{Code: pgerrcode.CannotConnectNow}, repository.RetryClassConnection),
+	)
+
+	DescribeTable("returns permanent errors at once",
+		func(err error) {
+			expectUser(err)
+
+			_, errGet := repo.GetUser(ctx, userLogin)
+			Expect(errGet).To(MatchError(err))
+		},
+
+		Entry("unique violation", &pgconn.PgError{Code: pgerrcode.UniqueViolation}),
+		Entry("check violation", &pgconn.PgError{Code: pgerrcode.CheckViolation}),
+		Entry("undefined table", &pgconn.PgError{Code: pgerrcode.UndefinedTable}),
+		Entry("unknown error", errors.New("something strange")),
+	)
+
+	It("stops after the maximal number of retries", func() {
+		serializationFailure := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+		for i := 0; i < 3; i++ {
+			expectUser(serializationFailure)
+		}
+
+		_, err := repo.GetUser(ctx, userLogin)
+		Expect(err).To(MatchError(serializationFailure))
+	})
+
+	It("stops, when the context is done", func() {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		expectUser(&pgconn.PgError{Code: pgerrcode.SerializationFailure})
+
+		_, err := repo.GetUser(ctx, userLogin)
+		Expect(err).To(MatchError(context.Canceled))
+	})
+
+	It("retries a transaction as a whole", func() {
+		before := retries("serialization_failure")
+
+		mockPool.ExpectBegin()
+		mockPool.ExpectExec("UPDATE users SET disabled .+").
+			WithArgs(userLogin).
+			WillReturnError(&pgconn.PgError{Code: pgerrcode.SerializationFailure})
+		mockPool.ExpectRollback()
+		mockPool.ExpectBegin()
+		mockPool.ExpectExec("UPDATE users SET disabled .+").
+			WithArgs(userLogin).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+		mockPool.ExpectRollback()
+
+		err := repo.DisableUser(ctx, userLogin)
+		Expect(err).To(MatchError(repository.ErrNotFound))
+		Expect(retries("serialization_failure")).To(Equal(before + 1))
+	})
+
+	It("doesn't retry statements of a unit of work", func() {
+		mockPool.ExpectBegin()
+		mockPool.ExpectQuery("SELECT .+ FROM users WHERE .+").
+			WithArgs(userLogin).
+			WillReturnError(&pgconn.PgError{Code: pgerrcode.SerializationFailure})
+		mockPool.ExpectRollback()
+
+		err := repo.WithinTransaction(ctx, func(ctx context.Context) error {
+			_, err := repo.GetUser(ctx, userLogin)
+			return err
+		})
+		Expect(err).To(MatchError(&pgconn.PgError{Code: pgerrcode.SerializationFailure}))
+	})
+
+	It("retries beginning of a transaction", func() {
+		mockPool.ExpectBegin().WillReturnError(&pgconn.PgError{Code: pgerrcode.ConnectionException})
+		mockPool.ExpectBegin()
+		mockPool.ExpectExec("UPDATE users SET disabled .+").
+			WithArgs(userLogin).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+		mockPool.ExpectRollback()
+
+		err := repo.DisableUser(ctx, userLogin)
+		Expect(err).To(MatchError(repository.ErrNotFound))
+	})
+})
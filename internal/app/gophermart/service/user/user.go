@@ -7,6 +7,7 @@ import (
 
     "github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
     "github.com/RomanAgaltsev/ya_gophermart/internal/config"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/logger"
     "github.com/RomanAgaltsev/ya_gophermart/internal/model"
     "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
 )
@@ -17,18 +18,29 @@ var (
 
     ErrLoginIsAlreadyTaken = fmt.Errorf("login has already been taken")
     ErrWrongLoginPassword  = fmt.Errorf("wrong login/password")
+    ErrSessionRevoked      = fmt.Errorf("user session has been revoked")
 )
 
 // Service is the user service interface.
 type Service interface {
     Register(ctx context.Context, user *model.User) error
     Login(ctx context.Context, user *model.User) error
+    ValidateSession(ctx context.Context, user *model.User) error
+    ChangePassword(ctx context.Context, user *model.User, change *model.PasswordChange) error
+    SetTimezone(ctx context.Context, user *model.User, timezone string) error
+    Delete(ctx context.Context, user *model.User, password string) error
 }
 
 // Repository is the user service repository interface.
 type Repository interface {
+    WithinTransaction(ctx context.Context, f func(ctx context.Context) error) error
     CreateUser(ctx context.Context, user *model.User) error
+    CreateBalance(ctx context.Context, user *model.User) error
     GetUser(ctx context.Context, login string) (*model.User, error)
+    UpdateUserPassword(ctx context.Context, login string, password string) (int32, error)
+    UpdateUserTimezone(ctx context.Context, login string, timezone string) error
+    DeleteUser(ctx context.Context, login string, anonymize bool) error
+    AppendAudit(ctx context.Context, login string, action model.AuditAction) error
 }
 
 // NewService creates new user service.
@@ -45,7 +57,7 @@ type service struct {
     cfg        *config.Config
 }
 
-// Register creates new user.
+// Register creates new user together with the user balance.
 func (s *service) Register(ctx context.Context, user *model.User) error {
     // Replace password with hash
     hash, err := auth.HashPassword(user.Password)
@@ -54,8 +66,13 @@ func (s *service) Register(ctx context.Context, user *model.User) error {
     }
     user.Password = hash
 
-    // Create user in the repository
-    err = s.repository.CreateUser(ctx, user)
+    // Create user and balance at once - there must be no user without balance
+    err = s.repository.WithinTransaction(ctx, func(ctx context.Context) error {
+        if err := s.repository.CreateUser(ctx, user); err != nil {
+            return err
+        }
+        return s.repository.CreateBalance(ctx, user)
+    })
 
     // There is a conflict - the login is already exists in the database
     if errors.Is(err, repository.ErrConflict) {
@@ -78,10 +95,125 @@ func (s *service) Login(ctx context.Context, user *model.User) error {
         return err
     }
 
-    // If user doesn`t exist or password is wrong
-    if userInRepo == nil || !auth.CheckPasswordHash(user.Password, userInRepo.Password) {
+    // If user doesn`t exist, there is nobody to audit
+    if userInRepo == nil {
         return ErrWrongLoginPassword
     }
 
+    // Disabled user cannot log in, the reason is not disclosed
+    if !auth.CheckPasswordHash(user.Password, userInRepo.Password) || userInRepo.Disabled {
+        s.auditLogin(ctx, user.Login, model.AuditUserLoginFailed)
+        return ErrWrongLoginPassword
+    }
+
+    // New token gets the user ID and the current session version
+    user.ID = userInRepo.ID
+    user.SessionVersion = userInRepo.SessionVersion
+
+    s.auditLogin(ctx, user.Login, model.AuditUserLogin)
+
+    return nil
+}
+
+// auditLogin writes the login attempt to the audit log.
+// Logins don't change any data, so the attempt result doesn't depend on the audit log.
+func (s *service) auditLogin(ctx context.Context, login string, action model.AuditAction) {
+    if err := s.repository.AppendAudit(ctx, login, action); err != nil {
+        logger.FromContext(ctx).Error("login audit", "action", string(action), "error", err.Error())
+    }
+}
+
+// ValidateSession checks if the user token hasn't been revoked.
+// Tokens of disabled and deleted users are revoked too. The user of a valid session gets the time zone preference.
+func (s *service) ValidateSession(ctx context.Context, user *model.User) error {
+    userInRepo, err := s.repository.GetUser(ctx, user.Login)
+    if err != nil {
+        return err
+    }
+
+    // Tokens issued before user IDs are accepted by login for one release, while the session is the first one
+    // of an account created before them - new accounts start with session version 1,
+    // so such a token of a deleted user doesn't pass for a new account with the same login either
+    if userInRepo != nil && user.ID == 0 && userInRepo.SessionVersion == 0 {
+        user.ID = userInRepo.ID
+    }
+
+    // The token of a deleted user doesn't pass for a new account with the same login - the ID is different
+    if userInRepo == nil || userInRepo.Disabled || userInRepo.ID != user.ID || userInRepo.SessionVersion != user.SessionVersion {
+        return ErrSessionRevoked
+    }
+    user.Timezone = userInRepo.Timezone
+
+    return nil
+}
+
+// ChangePassword replaces the user password and revokes all user sessions.
+// The user gets the new session version to continue the current session.
+func (s *service) ChangePassword(ctx context.Context, user *model.User, change *model.PasswordChange) error {
+    // Check the current password
+    if _, err := s.checkPassword(ctx, user.Login, change.CurrentPassword); err != nil {
+        return err
+    }
+
+    // Replace password with hash
+    hash, err := auth.HashPassword(change.NewPassword)
+    if err != nil {
+        return err
+    }
+
+    // Update password, session version is incremented with it
+    sessionVersion, err := s.repository.UpdateUserPassword(ctx, user.Login, hash)
+    if errors.Is(err, repository.ErrNotFound) {
+        return ErrWrongLoginPassword
+    }
+    if err != nil {
+        return err
+    }
+    user.SessionVersion = sessionVersion
+
+    return nil
+}
+
+// SetTimezone sets the time zone, which times are rendered in for the user, the empty time zone means UTC.
+func (s *service) SetTimezone(ctx context.Context, user *model.User, timezone string) error {
+    err := s.repository.UpdateUserTimezone(ctx, user.Login, timezone)
+    if errors.Is(err, repository.ErrNotFound) {
+        return ErrSessionRevoked
+    }
+    if err != nil {
+        return err
+    }
+    user.Timezone = timezone
+
     return nil
 }
+
+// Delete deletes the user, the user data is anonymized or deleted according to the retention policy.
+func (s *service) Delete(ctx context.Context, user *model.User, password string) error {
+    // Check the password
+    if _, err := s.checkPassword(ctx, user.Login, password); err != nil {
+        return err
+    }
+
+    // Delete user
+    err := s.repository.DeleteUser(ctx, user.Login, s.cfg.AccountRetention != config.AccountRetentionDelete)
+    if errors.Is(err, repository.ErrNotFound) {
+        return ErrWrongLoginPassword
+    }
+
+    return err
+}
+
+// checkPassword returns the user, if the user exists, is enabled and has the password.
+func (s *service) checkPassword(ctx context.Context, login, password string) (*model.User, error) {
+    userInRepo, err := s.repository.GetUser(ctx, login)
+    if err != nil {
+        return nil, err
+    }
+
+    if userInRepo == nil || userInRepo.Disabled || !auth.CheckPasswordHash(password, userInRepo.Password) {
+        return nil, ErrWrongLoginPassword
+    }
+
+    return userInRepo, nil
+}
@@ -0,0 +1,78 @@
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+)
+
+// balance runs balance subcommands.
+func (c *Ctl) balance(ctx context.Context, subcommand string, args []string) error {
+	switch subcommand {
+	case "show":
+		if len(args) != 1 {
+			return ErrWrongArguments
+		}
+		return c.balanceShow(ctx, args[0])
+	case "adjust":
+		if len(args) != 2 {
+			return ErrWrongArguments
+		}
+
+		delta, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || delta == 0 {
+			return ErrWrongArguments
+		}
+
+		return c.balanceAdjust(ctx, args[0], delta)
+	default:
+		return fmt.Errorf("%w: balance %s", ErrUnknownCommand, subcommand)
+	}
+}
+
+// balanceShow prints the user balance and withdrawals.
+func (c *Ctl) balanceShow(ctx context.Context, login string) error {
+	usr := &model.User{Login: login}
+
+	userBalance, err := c.repository.GetBalance(ctx, usr)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", login, err)
+	}
+
+	withdrawals, _, err := c.repository.GetListOfWithdrawals(ctx, usr, model.Page{})
+	if err != nil {
+		return fmt.Errorf("user %s: %w", login, err)
+	}
+
+	_, _ = fmt.Fprintf(c.out, "current: %.2f\nwithdrawn: %.2f\n", userBalance.Current, userBalance.Withdrawn)
+
+	if len(withdrawals) == 0 {
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(c.out)
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ORDER\tSUM\tPROCESSED AT")
+
+	for _, withdrawal := range withdrawals {
+		_, _ = fmt.Fprintf(w, "%s\t%.2f\t%s\n", withdrawal.OrderNumber, withdrawal.Sum, withdrawal.ProcessedAt.Format(timeLayout))
+	}
+
+	return w.Flush()
+}
+
+// balanceAdjust adds the delta to the user balance.
+func (c *Ctl) balanceAdjust(ctx context.Context, login string, delta float64) error {
+	userBalance, err := c.repository.AdjustBalance(ctx, &model.User{Login: login}, delta, c.policy.ExpiresAt(time.Now()))
+	if err != nil {
+		return fmt.Errorf("user %s: %w", login, err)
+	}
+
+	_, _ = fmt.Fprintf(c.out, "current: %.2f\nwithdrawn: %.2f\n", userBalance.Current, userBalance.Withdrawn)
+	return nil
+}
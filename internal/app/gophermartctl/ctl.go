@@ -0,0 +1,143 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/database"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	_ Repository = (*repository.Repository)(nil)
+
+	ErrUnknownCommand = fmt.Errorf("unknown command")
+	ErrWrongArguments = fmt.Errorf("wrong arguments")
+)
+
+// usage contains the description of commands.
+const usage = `Usage: gophermartctl [-d database URI] <command> <subcommand> [arguments]
+
+Commands:
+  migrate up                              apply all pending migrations
+  migrate down                            roll back the last migration
+  migrate redo                            roll back the last migration and apply it again
+  migrate status                          show migrations status
+  migrate check [-repair]                 report and repair rows, which violate foreign keys and unique constraints
+  user create <login> <password>          create a user with an empty balance
+  user disable <login>                    disable a user, so the user cannot log in
+  user reset-password <login> <password>  set a new password of a user
+  orders list-stuck [-older-than 1h]      list NEW and PROCESSING orders uploaded earlier than the period ago
+  orders requeue <number>...              return NEW and PROCESSING orders to NEW status
+  balance show <login>                    show user balance and withdrawals
+  balance adjust <login> <delta>          add a positive or negative delta to user balance
+  withdrawals reverse <number> <reason>   reverse the withdrawal made for the order and return the sum to balance
+`
+
+// Repository is the control application repository interface.
+type Repository interface {
+	WithinTransaction(ctx context.Context, f func(ctx context.Context) error) error
+	CreateUser(ctx context.Context, user *model.User) error
+	DisableUser(ctx context.Context, login string) error
+	UpdateUserPassword(ctx context.Context, login string, password string) (int32, error)
+	GetListOfStuckOrders(ctx context.Context, uploadedBefore time.Time) (model.Orders, error)
+	RequeueOrder(ctx context.Context, orderNumber string) error
+	CreateBalance(ctx context.Context, user *model.User) error
+	GetBalance(ctx context.Context, user *model.User) (*model.Balance, error)
+	AdjustBalance(ctx context.Context, user *model.User, delta float64, expiresAt *time.Time) (*model.Balance, error)
+	GetListOfWithdrawals(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error)
+	ReverseWithdrawal(ctx context.Context, orderNumber string, reason string, expiresAt *time.Time) (*model.Withdrawal, error)
+}
+
+// Ctl is the operator control application.
+type Ctl struct {
+	dbpool     *pgxpool.Pool
+	repository Repository
+	policy     model.ExpiryPolicy
+	out        io.Writer
+}
+
+// New creates new control application connected to the database from the server configuration.
+func New(cfg *config.Config, out io.Writer) (*Ctl, error) {
+	// Create context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Create connection pool without running migrations - they are managed by the migrate command
+	dbpool, err := database.NewPool(ctx, cfg.DatabaseURI)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create repository
+	repo, err := repository.New(dbpool, repository.NewRetryPolicy(cfg))
+	if err != nil {
+		dbpool.Close()
+		return nil, err
+	}
+
+	ctl := NewWithRepository(repo, out)
+	ctl.dbpool = dbpool
+	ctl.policy = model.ExpiryPolicy{Lifetime: cfg.PointsLifetime}
+
+	return ctl, nil
+}
+
+// NewWithRepository creates new control application with the given repository.
+// Migrate command is not available for such application, and points added by it never expire.
+func NewWithRepository(repository Repository, out io.Writer) *Ctl {
+	return &Ctl{
+		repository: repository,
+		out:        out,
+	}
+}
+
+// Close closes database connections.
+func (c *Ctl) Close() {
+	if c.dbpool != nil {
+		c.dbpool.Close()
+	}
+}
+
+// Run runs the command given by arguments.
+func (c *Ctl) Run(ctx context.Context, args []string) error {
+	err := c.run(ctx, args)
+
+	// Remind the usage if the command is wrong
+	if errors.Is(err, ErrUnknownCommand) || errors.Is(err, ErrWrongArguments) {
+		_, _ = fmt.Fprint(c.out, usage)
+	}
+
+	return err
+}
+
+// run dispatches the command.
+func (c *Ctl) run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return ErrWrongArguments
+	}
+
+	command, subcommand, args := args[0], args[1], args[2:]
+
+	switch command {
+	case "migrate":
+		return c.migrate(ctx, subcommand, args)
+	case "user":
+		return c.user(ctx, subcommand, args)
+	case "orders":
+		return c.orders(ctx, subcommand, args)
+	case "balance":
+		return c.balance(ctx, subcommand, args)
+	case "withdrawals":
+		return c.withdrawals(ctx, subcommand, args)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+	}
+}
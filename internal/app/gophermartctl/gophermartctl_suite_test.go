@@ -0,0 +1,13 @@
+package ctl_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestGophermartctl(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gophermartctl Suite")
+}
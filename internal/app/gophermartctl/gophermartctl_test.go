@@ -0,0 +1,209 @@
+package ctl_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermartctl"
+	ctlMocks "github.com/RomanAgaltsev/ya_gophermart/internal/mocks/gophermartctl"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+)
+
+var _ = Describe("Gophermartctl", func() {
+	var (
+		errSomethingStrange error
+
+		ctx  context.Context
+		out  *bytes.Buffer
+		repo *ctlMocks.MockRepository
+		app  *ctl.Ctl
+	)
+
+	BeforeEach(func() {
+		errSomethingStrange = errors.New("something strange")
+		ctx = context.Background()
+		out = &bytes.Buffer{}
+
+		repo = ctlMocks.NewMockRepository(gomock.NewController(GinkgoT()))
+
+		// Units of work run the function with the same mocked repository
+		repo.EXPECT().WithinTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, f func(ctx context.Context) error) error { return f(ctx) }).AnyTimes()
+
+		app = ctl.NewWithRepository(repo, out)
+	})
+
+	Context("When the command is wrong", func() {
+		It("reminds the usage on unknown command", func() {
+			err := app.Run(ctx, []string{"coffee", "make"})
+			Expect(err).To(MatchError(ctl.ErrUnknownCommand))
+			Expect(out.String()).To(ContainSubstring("Usage:"))
+		})
+
+		It("reminds the usage on missing subcommand", func() {
+			err := app.Run(ctx, []string{"user"})
+			Expect(err).To(MatchError(ctl.ErrWrongArguments))
+			Expect(out.String()).To(ContainSubstring("Usage:"))
+		})
+
+		It("refuses to migrate without database connection", func() {
+			err := app.Run(ctx, []string{"migrate", "status"})
+			Expect(err).To(MatchError(ctl.ErrNoDatabase))
+		})
+
+		It("refuses to check data integrity without database connection", func() {
+			err := app.Run(ctx, []string{"migrate", "check", "-repair"})
+			Expect(err).To(MatchError(ctl.ErrNoDatabase))
+		})
+	})
+
+	Describe("User commands", func() {
+		It("creates a user with a hashed password and a balance", func() {
+			gomock.InOrder(
+				repo.EXPECT().CreateUser(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, usr *model.User) error {
+						Expect(usr.Login).To(Equal("user"))
+						Expect(auth.CheckPasswordHash("password", usr.Password)).To(BeTrue())
+						return nil
+					}),
+				repo.EXPECT().CreateBalance(gomock.Any(), gomock.Any()).Return(nil),
+			)
+
+			err := app.Run(ctx, []string{"user", "create", "user", "password"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("user user created"))
+		})
+
+		It("does not create a user with taken login", func() {
+			repo.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(repository.ErrConflict)
+
+			err := app.Run(ctx, []string{"user", "create", "user", "password"})
+			Expect(err).To(MatchError(ctl.ErrLoginIsAlreadyTaken))
+		})
+
+		It("disables a user", func() {
+			repo.EXPECT().DisableUser(gomock.Any(), "user").Return(nil)
+
+			err := app.Run(ctx, []string{"user", "disable", "user"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports unknown user on disabling", func() {
+			repo.EXPECT().DisableUser(gomock.Any(), "user").Return(repository.ErrNotFound)
+
+			err := app.Run(ctx, []string{"user", "disable", "user"})
+			Expect(err).To(MatchError(repository.ErrNotFound))
+		})
+
+		It("resets a user password", func() {
+			repo.EXPECT().UpdateUserPassword(gomock.Any(), "user", gomock.Any()).DoAndReturn(
+				func(_ context.Context, _ string, password string) (int32, error) {
+					Expect(auth.CheckPasswordHash("new password", password)).To(BeTrue())
+					return 1, nil
+				})
+
+			err := app.Run(ctx, []string{"user", "reset-password", "user", "new password"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("Orders commands", func() {
+		It("lists stuck orders older than the given period", func() {
+			repo.EXPECT().GetListOfStuckOrders(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, uploadedBefore time.Time) (model.Orders, error) {
+					Expect(uploadedBefore).To(BeTemporally("~", time.Now().Add(-2*time.Hour), time.Minute))
+					return model.Orders{{Number: "12345678903", Login: "user", Status: "PROCESSING", UploadedAt: time.Now()}}, nil
+				})
+
+			err := app.Run(ctx, []string{"orders", "list-stuck", "-older-than", "2h"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("12345678903"))
+			Expect(out.String()).To(ContainSubstring("PROCESSING"))
+		})
+
+		It("lists stuck orders older than an hour by default", func() {
+			repo.EXPECT().GetListOfStuckOrders(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, uploadedBefore time.Time) (model.Orders, error) {
+					Expect(uploadedBefore).To(BeTemporally("~", time.Now().Add(-time.Hour), time.Minute))
+					return nil, nil
+				})
+
+			err := app.Run(ctx, []string{"orders", "list-stuck"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("requeues every given order and reports failed ones", func() {
+			repo.EXPECT().RequeueOrder(gomock.Any(), "1").Return(nil)
+			repo.EXPECT().RequeueOrder(gomock.Any(), "2").Return(errSomethingStrange)
+			repo.EXPECT().RequeueOrder(gomock.Any(), "3").Return(nil)
+
+			err := app.Run(ctx, []string{"orders", "requeue", "1", "2", "3"})
+			Expect(err).To(MatchError(errSomethingStrange))
+			Expect(out.String()).To(ContainSubstring("order 1 requeued"))
+			Expect(out.String()).To(ContainSubstring("order 3 requeued"))
+		})
+	})
+
+	Describe("Balance commands", func() {
+		It("shows balance and withdrawals", func() {
+			repo.EXPECT().GetBalance(gomock.Any(), gomock.Any()).Return(&model.Balance{Current: 500, Withdrawn: 42}, nil)
+			repo.EXPECT().GetListOfWithdrawals(gomock.Any(), gomock.Any(), model.Page{}).Return(
+				model.Withdrawals{{OrderNumber: "2377225624", Sum: 42, ProcessedAt: time.Now()}}, 1, nil)
+
+			err := app.Run(ctx, []string{"balance", "show", "user"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("current: 500.00"))
+			Expect(out.String()).To(ContainSubstring("2377225624"))
+		})
+
+		It("adjusts balance by a negative delta", func() {
+			repo.EXPECT().AdjustBalance(gomock.Any(), gomock.Any(), -100.5, gomock.Any()).Return(&model.Balance{Current: 399.5}, nil)
+
+			err := app.Run(ctx, []string{"balance", "adjust", "user", "-100.5"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("current: 399.50"))
+		})
+
+		It("refuses to make balance negative", func() {
+			repo.EXPECT().AdjustBalance(gomock.Any(), gomock.Any(), -1000.0, gomock.Any()).Return(nil, repository.ErrNegativeBalance)
+
+			err := app.Run(ctx, []string{"balance", "adjust", "user", "-1000"})
+			Expect(err).To(MatchError(repository.ErrNegativeBalance))
+		})
+
+		It("refuses wrong delta", func() {
+			err := app.Run(ctx, []string{"balance", "adjust", "user", "much"})
+			Expect(err).To(MatchError(ctl.ErrWrongArguments))
+		})
+	})
+
+	Describe("Withdrawals commands", func() {
+		It("reverses a withdrawal", func() {
+			reversedAt := time.Now()
+			repo.EXPECT().ReverseWithdrawal(gomock.Any(), "2377225624", "order cancelled", gomock.Any()).Return(&model.Withdrawal{
+				Login:          "user",
+				OrderNumber:    "2377225624",
+				Sum:            100,
+				ReversedAt:     &reversedAt,
+				ReversalReason: "order cancelled",
+			}, nil)
+
+			err := app.Run(ctx, []string{"withdrawals", "reverse", "2377225624", "order cancelled"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("withdrawal 2377225624 of 100.00 reversed for user user"))
+		})
+
+		It("requires a reason", func() {
+			err := app.Run(ctx, []string{"withdrawals", "reverse", "2377225624"})
+			Expect(err).To(MatchError(ctl.ErrWrongArguments))
+		})
+	})
+})
@@ -0,0 +1,109 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/database"
+)
+
+// ErrNoDatabase - error of the commands, which need direct database connection.
+var ErrNoDatabase = fmt.Errorf("database connection is not available")
+
+// migrate runs migrate subcommands.
+func (c *Ctl) migrate(ctx context.Context, subcommand string, args []string) error {
+	if c.dbpool == nil {
+		return ErrNoDatabase
+	}
+
+	// Only the check has arguments
+	if subcommand == "check" {
+		return c.migrateCheck(ctx, args)
+	}
+
+	if len(args) != 0 {
+		return ErrWrongArguments
+	}
+
+	switch subcommand {
+	case "up":
+		return database.MigrateUp(ctx, c.dbpool)
+	case "down":
+		return database.MigrateDown(ctx, c.dbpool)
+	case "redo":
+		return database.MigrateRedo(ctx, c.dbpool)
+	case "status":
+		return c.migrateStatus(ctx)
+	default:
+		return fmt.Errorf("%w: migrate %s", ErrUnknownCommand, subcommand)
+	}
+}
+
+// migrateStatus prints statuses of all migrations.
+func (c *Ctl) migrateStatus(ctx context.Context) error {
+	statuses, err := database.MigrateStatus(ctx, c.dbpool)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tMIGRATION")
+
+	for _, status := range statuses {
+		appliedAt := "-"
+		if !status.AppliedAt.IsZero() {
+			appliedAt = status.AppliedAt.Format(timeLayout)
+		}
+
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Source.Version, status.State, appliedAt, status.Source.Path)
+	}
+
+	return w.Flush()
+}
+
+// migrateCheck prints rows, which violate the constraints of the foreign keys and indexes migration, and repairs them if asked.
+func (c *Ctl) migrateCheck(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate check", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	repair := flags.Bool("repair", false, "repair violating rows")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return ErrWrongArguments
+	}
+
+	check := database.CheckIntegrity
+	if *repair {
+		check = database.RepairIntegrity
+	}
+
+	violations, err := check(ctx, c.dbpool)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) == 0 {
+		_, _ = fmt.Fprintln(c.out, "no violations found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VIOLATION\tTABLE\tLOGIN\tROWS")
+
+	for _, v := range violations {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", v.Kind, v.Table, v.Login, v.Rows)
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if *repair {
+		_, _ = fmt.Fprintf(c.out, "%d violations repaired\n", len(violations))
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d violations found, run with -repair to repair them", database.ErrIntegrityViolation, len(violations))
+}
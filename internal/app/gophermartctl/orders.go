@@ -0,0 +1,71 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// timeLayout is the layout of times in the output.
+const timeLayout = time.RFC3339
+
+// orders runs orders subcommands.
+func (c *Ctl) orders(ctx context.Context, subcommand string, args []string) error {
+	switch subcommand {
+	case "list-stuck":
+		return c.ordersListStuck(ctx, args)
+	case "requeue":
+		if len(args) == 0 {
+			return ErrWrongArguments
+		}
+		return c.ordersRequeue(ctx, args)
+	default:
+		return fmt.Errorf("%w: orders %s", ErrUnknownCommand, subcommand)
+	}
+}
+
+// ordersListStuck prints NEW and PROCESSING orders uploaded earlier than the given period ago.
+func (c *Ctl) ordersListStuck(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("orders list-stuck", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	olderThan := flags.Duration("older-than", time.Hour, "minimal age of stuck orders")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return ErrWrongArguments
+	}
+
+	orders, err := c.repository.GetListOfStuckOrders(ctx, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NUMBER\tLOGIN\tSTATUS\tUPLOADED AT")
+
+	for _, order := range orders {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", order.Number, order.Login, order.Status, order.UploadedAt.Format(timeLayout))
+	}
+
+	return w.Flush()
+}
+
+// ordersRequeue returns the orders to NEW status.
+func (c *Ctl) ordersRequeue(ctx context.Context, orderNumbers []string) error {
+	var errs []error
+
+	// Requeue as many orders as possible
+	for _, orderNumber := range orderNumbers {
+		if err := c.repository.RequeueOrder(ctx, orderNumber); err != nil {
+			errs = append(errs, fmt.Errorf("order %s: %w", orderNumber, err))
+			continue
+		}
+
+		_, _ = fmt.Fprintf(c.out, "order %s requeued\n", orderNumber)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,103 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/repository"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+)
+
+// ErrLoginIsAlreadyTaken - user creation conflict error.
+var ErrLoginIsAlreadyTaken = fmt.Errorf("login has already been taken")
+
+// user runs user subcommands.
+func (c *Ctl) user(ctx context.Context, subcommand string, args []string) error {
+	switch subcommand {
+	case "create":
+		if len(args) != 2 {
+			return ErrWrongArguments
+		}
+		return c.userCreate(ctx, args[0], args[1])
+	case "disable":
+		if len(args) != 1 {
+			return ErrWrongArguments
+		}
+		return c.userDisable(ctx, args[0])
+	case "reset-password":
+		if len(args) != 2 {
+			return ErrWrongArguments
+		}
+		return c.userResetPassword(ctx, args[0], args[1])
+	default:
+		return fmt.Errorf("%w: user %s", ErrUnknownCommand, subcommand)
+	}
+}
+
+// userCreate creates new user with a balance.
+func (c *Ctl) userCreate(ctx context.Context, login, password string) error {
+	usr := model.User{
+		Login:    login,
+		Password: password,
+	}
+
+	// Validate user the same way as registration does
+	if err := usr.Bind(nil); err != nil {
+		return err
+	}
+
+	// Replace password with hash
+	hash, err := auth.HashPassword(usr.Password)
+	if err != nil {
+		return err
+	}
+	usr.Password = hash
+
+	// Create user and balance at once
+	err = c.repository.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := c.repository.CreateUser(ctx, &usr); err != nil {
+			return err
+		}
+		return c.repository.CreateBalance(ctx, &usr)
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		return ErrLoginIsAlreadyTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(c.out, "user %s created\n", login)
+	return nil
+}
+
+// userDisable disables the user.
+func (c *Ctl) userDisable(ctx context.Context, login string) error {
+	if err := c.repository.DisableUser(ctx, login); err != nil {
+		return fmt.Errorf("user %s: %w", login, err)
+	}
+
+	_, _ = fmt.Fprintf(c.out, "user %s disabled\n", login)
+	return nil
+}
+
+// userResetPassword sets new password of the user.
+func (c *Ctl) userResetPassword(ctx context.Context, login, password string) error {
+	if password == "" {
+		return fmt.Errorf("password is a required field")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.repository.UpdateUserPassword(ctx, login, hash); err != nil {
+		return fmt.Errorf("user %s: %w", login, err)
+	}
+
+	_, _ = fmt.Fprintf(c.out, "user %s password has been reset\n", login)
+	return nil
+}
@@ -0,0 +1,31 @@
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// withdrawals runs withdrawals subcommands.
+func (c *Ctl) withdrawals(ctx context.Context, subcommand string, args []string) error {
+	switch subcommand {
+	case "reverse":
+		if len(args) != 2 || args[1] == "" {
+			return ErrWrongArguments
+		}
+		return c.withdrawalsReverse(ctx, args[0], args[1])
+	default:
+		return fmt.Errorf("%w: withdrawals %s", ErrUnknownCommand, subcommand)
+	}
+}
+
+// withdrawalsReverse reverses the withdrawal made for the order.
+func (c *Ctl) withdrawalsReverse(ctx context.Context, orderNumber, reason string) error {
+	withdrawal, err := c.repository.ReverseWithdrawal(ctx, orderNumber, reason, c.policy.ExpiresAt(time.Now()))
+	if err != nil {
+		return fmt.Errorf("withdrawal %s: %w", orderNumber, err)
+	}
+
+	_, _ = fmt.Fprintf(c.out, "withdrawal %s of %.2f reversed for user %s\n", withdrawal.OrderNumber, withdrawal.Sum, withdrawal.Login)
+	return nil
+}
@@ -1,29 +1,148 @@
 package config
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ErrInitConfigFailed - config initialization error.
 var ErrInitConfigFailed = fmt.Errorf("failed to init config")
 
+// ErrUnknownTLSVersion - unsupported minimal TLS version error.
+var ErrUnknownTLSVersion = fmt.Errorf("unknown TLS version")
+
+// ErrWrongLoyaltyTiers - malformed loyalty tiers error.
+var ErrWrongLoyaltyTiers = fmt.Errorf("wrong loyalty tiers")
+
+// Tier bases - user totals loyalty tiers are computed from.
+const (
+	TierBasisAccrued = "accrued"
+	TierBasisSpent   = "spent"
+)
+
+// Account retention policies - what happens to the data of a deleted user.
+const (
+	AccountRetentionAnonymize = "anonymize"
+	AccountRetentionDelete    = "delete"
+)
+
+// Log encodings.
+const (
+	LogEncodingConsole = "console"
+	LogEncodingJSON    = "json"
+)
+
+// LoyaltyTier - loyalty tier configuration.
+type LoyaltyTier struct {
+	Name       string  // Tier name
+	Threshold  float64 // Rolling total needed to reach the tier
+	Multiplier float64 // Multiplier of accruals credited to users of the tier
+}
+
 // Config - application configuration structure.
 type Config struct {
 	RunAddress           string // Address and port of HTTP server
+	GRPCAddress          string // Address and port of gRPC server, gRPC server is disabled when empty
 	DatabaseURI          string // Address for database connection
 	AccrualSystemAddress string // Address of accrual system
 	SecretKey            string // Authentication secret key
+	PartnerAPIKey        string // Partner API key, partner endpoints are disabled when empty
+	AdminAPIKey          string // Admin API key, admin endpoints are disabled when empty
+	SkipMigrations       bool   // Do not run migrations on start, the schema version is checked anyway
+	ValidateResponses    bool   // Validate HTTP responses against the OpenAPI document, it is meant for tests
+
+	ServerShutdownTimeout     time.Duration // Time given to HTTP server to drain connections on shutdown
+	ProcessingShutdownTimeout time.Duration // Time given to order processing to finish in-flight jobs on shutdown
+
+	DBRetryInitialInterval time.Duration // Delay before the first retry of a query failed with a transient error
+	DBRetryMaxInterval     time.Duration // Maximal delay between query retries
+	DBRetryMaxElapsedTime  time.Duration // Time after which a query is not retried anymore, zero means no limit
+	DBRetryMaxRetries      uint64        // Maximal number of retries of a query, zero means queries are not retried
+
+	PointsLifetime     time.Duration // Time after accrual when points expire, zero means points never expire
+	ExpirationInterval time.Duration // Interval of expired points check
+
+	LoyaltyTiers []LoyaltyTier // Loyalty tiers in ascending order of thresholds, the first threshold is zero
+	TierBasis    string        // User totals loyalty tiers are computed from - accrued or spent points
+	TierPeriod   time.Duration // Rolling period of user totals
+
+	TransferDailyLimit float64 // Maximal sum of points a user can transfer per day, zero means no limit
+
+	AccountRetention string // Data of a deleted user is anonymized or deleted
+
+	AuditChainInterval time.Duration // Interval of appending queued entries to the audit log
+
+	TLSCertFile   string // Path to TLS certificate file
+	TLSKeyFile    string // Path to TLS key file
+	TLSMinVersion uint16 // Minimal TLS version
+
+	LogEncoding           string // Log encoding - console or json
+	LogLevel              string // Minimal log level - debug, info, warn or error
+	LogSamplingInitial    int    // Number of same debug and info messages logged every second before sampling, zero means no sampling
+	LogSamplingThereafter int    // Every n-th same message is logged after the initial ones
+	LogFile               string // Path to log file, logs are written to stdout when empty
+	LogMaxSize            int    // Size of log file in megabytes before rotation
+	LogMaxBackups         int    // Number of rotated log files kept
+	LogMaxAge             int    // Number of days rotated log files are kept
+	LogRequestBodies      bool   // Log HTTP request bodies, sensitive fields are redacted anyway
+}
 
+// TLSEnabled returns true if the server must be run with TLS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
 }
 
 // configBuilder - application configuration builder.
 type configBuilder struct {
 	runAddress           string `env:"RUN_ADDRESS"`
+	grpcAddress          string `env:"GRPC_ADDRESS"`
 	databaseURI          string `env:"DATABASE_URI"`
 	accrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
 	secretKey            string `env:"SECRET_KEY"`
+	partnerAPIKey        string `env:"PARTNER_API_KEY"`
+	adminAPIKey          string `env:"ADMIN_API_KEY"`
+	skipMigrations       bool   `env:"SKIP_MIGRATIONS"`
+	validateResponses    bool   `env:"VALIDATE_RESPONSES"`
+
+	serverShutdownTimeout     time.Duration `env:"SERVER_SHUTDOWN_TIMEOUT"`
+	processingShutdownTimeout time.Duration `env:"PROCESSING_SHUTDOWN_TIMEOUT"`
+
+	dbRetryInitialInterval time.Duration `env:"DB_RETRY_INITIAL_INTERVAL"`
+	dbRetryMaxInterval     time.Duration `env:"DB_RETRY_MAX_INTERVAL"`
+	dbRetryMaxElapsedTime  time.Duration `env:"DB_RETRY_MAX_ELAPSED_TIME"`
+	dbRetryMaxRetries      uint64        `env:"DB_RETRY_MAX_RETRIES"`
+
+	pointsLifetime     time.Duration `env:"POINTS_LIFETIME"`
+	expirationInterval time.Duration `env:"EXPIRATION_INTERVAL"`
+
+	loyaltyTiers []LoyaltyTier `env:"LOYALTY_TIERS"`
+	tierBasis    string        `env:"TIER_BASIS"`
+	tierPeriod   time.Duration `env:"TIER_PERIOD"`
+
+	transferDailyLimit float64 `env:"TRANSFER_DAILY_LIMIT"`
+
+	accountRetention string `env:"ACCOUNT_RETENTION"`
+
+	auditChainInterval time.Duration `env:"AUDIT_CHAIN_INTERVAL"`
+
+	tlsCertFile   string `env:"TLS_CERT_FILE"`
+	tlsKeyFile    string `env:"TLS_KEY_FILE"`
+	tlsMinVersion uint16 `env:"TLS_MIN_VERSION"`
+
+	logEncoding           string `env:"LOG_ENCODING"`
+	logLevel              string `env:"LOG_LEVEL"`
+	logSamplingInitial    int    `env:"LOG_SAMPLING_INITIAL"`
+	logSamplingThereafter int    `env:"LOG_SAMPLING_THEREAFTER"`
+	logFile               string `env:"LOG_FILE"`
+	logMaxSize            int    `env:"LOG_MAX_SIZE"`
+	logMaxBackups         int    `env:"LOG_MAX_BACKUPS"`
+	logMaxAge             int    `env:"LOG_MAX_AGE"`
+	logRequestBodies      bool   `env:"LOG_REQUEST_BODIES"`
 }
 
 // newConfigBuilder creates new application configuration builder.
@@ -34,9 +153,40 @@ func newConfigBuilder() *configBuilder {
 // setDefaults defines application configuration parameters defaults.
 func (cb *configBuilder) setDefaults() error {
 	cb.runAddress = "localhost:8080"
+	cb.grpcAddress = ""
 	cb.databaseURI = ""
 	cb.accrualSystemAddress = ""
 	cb.secretKey = "secret"
+	cb.partnerAPIKey = ""
+	cb.adminAPIKey = ""
+	cb.skipMigrations = false
+	cb.validateResponses = false
+	cb.serverShutdownTimeout = 5 * time.Second
+	cb.processingShutdownTimeout = 10 * time.Second
+	cb.dbRetryInitialInterval = 100 * time.Millisecond
+	cb.dbRetryMaxInterval = 2 * time.Second
+	cb.dbRetryMaxElapsedTime = 15 * time.Second
+	cb.dbRetryMaxRetries = 5
+	cb.pointsLifetime = 0
+	cb.expirationInterval = time.Hour
+	cb.loyaltyTiers = []LoyaltyTier{{Name: "bronze", Threshold: 0, Multiplier: 1}}
+	cb.tierBasis = TierBasisAccrued
+	cb.tierPeriod = 365 * 24 * time.Hour
+	cb.transferDailyLimit = 1000
+	cb.accountRetention = AccountRetentionAnonymize
+	cb.auditChainInterval = time.Second
+	cb.tlsCertFile = ""
+	cb.tlsKeyFile = ""
+	cb.tlsMinVersion = tls.VersionTLS12
+	cb.logEncoding = LogEncodingConsole
+	cb.logLevel = "info"
+	cb.logSamplingInitial = 0
+	cb.logSamplingThereafter = 100
+	cb.logFile = ""
+	cb.logMaxSize = 100
+	cb.logMaxBackups = 5
+	cb.logMaxAge = 30
+	cb.logRequestBodies = false
 
 	return nil
 }
@@ -46,6 +196,9 @@ func (cb *configBuilder) setFlags() error {
 	if flag.Lookup("a") == nil {
 		flag.StringVar(&cb.runAddress, "a", cb.runAddress, "HTTP server address and port")
 	}
+	if flag.Lookup("g") == nil {
+		flag.StringVar(&cb.grpcAddress, "g", cb.grpcAddress, "gRPC server address and port")
+	}
 	if flag.Lookup("d") == nil {
 		flag.StringVar(&cb.databaseURI, "d", cb.databaseURI, "database connection string")
 	}
@@ -64,6 +217,11 @@ func (cb *configBuilder) setEnvs() error {
 		cb.runAddress = ra
 	}
 
+	ga := os.Getenv("GRPC_ADDRESS")
+	if ga != "" {
+		cb.grpcAddress = ga
+	}
+
 	dbi := os.Getenv("DATABASE_URI")
 	if dbi != "" {
 		cb.databaseURI = dbi
@@ -79,16 +237,352 @@ func (cb *configBuilder) setEnvs() error {
 		cb.secretKey = sk
 	}
 
+	pak := os.Getenv("PARTNER_API_KEY")
+	if pak != "" {
+		cb.partnerAPIKey = pak
+	}
+
+	aak := os.Getenv("ADMIN_API_KEY")
+	if aak != "" {
+		cb.adminAPIKey = aak
+	}
+
+	sm := os.Getenv("SKIP_MIGRATIONS")
+	if sm != "" {
+		skip, err := strconv.ParseBool(sm)
+		if err != nil {
+			return err
+		}
+		cb.skipMigrations = skip
+	}
+
+	vr := os.Getenv("VALIDATE_RESPONSES")
+	if vr != "" {
+		validate, err := strconv.ParseBool(vr)
+		if err != nil {
+			return err
+		}
+		cb.validateResponses = validate
+	}
+
+	sst := os.Getenv("SERVER_SHUTDOWN_TIMEOUT")
+	if sst != "" {
+		timeout, err := time.ParseDuration(sst)
+		if err != nil {
+			return err
+		}
+		cb.serverShutdownTimeout = timeout
+	}
+
+	pst := os.Getenv("PROCESSING_SHUTDOWN_TIMEOUT")
+	if pst != "" {
+		timeout, err := time.ParseDuration(pst)
+		if err != nil {
+			return err
+		}
+		cb.processingShutdownTimeout = timeout
+	}
+
+	drii := os.Getenv("DB_RETRY_INITIAL_INTERVAL")
+	if drii != "" {
+		interval, err := time.ParseDuration(drii)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("wrong DB retry initial interval: %s", drii)
+		}
+		cb.dbRetryInitialInterval = interval
+	}
+
+	drmi := os.Getenv("DB_RETRY_MAX_INTERVAL")
+	if drmi != "" {
+		interval, err := time.ParseDuration(drmi)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("wrong DB retry max interval: %s", drmi)
+		}
+		cb.dbRetryMaxInterval = interval
+	}
+
+	drmet := os.Getenv("DB_RETRY_MAX_ELAPSED_TIME")
+	if drmet != "" {
+		elapsed, err := time.ParseDuration(drmet)
+		if err != nil || elapsed < 0 {
+			return fmt.Errorf("wrong DB retry max elapsed time: %s", drmet)
+		}
+		cb.dbRetryMaxElapsedTime = elapsed
+	}
+
+	drmr := os.Getenv("DB_RETRY_MAX_RETRIES")
+	if drmr != "" {
+		retries, err := strconv.ParseUint(drmr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("wrong DB retry max retries: %s", drmr)
+		}
+		cb.dbRetryMaxRetries = retries
+	}
+
+	pl := os.Getenv("POINTS_LIFETIME")
+	if pl != "" {
+		lifetime, err := time.ParseDuration(pl)
+		if err != nil {
+			return err
+		}
+		cb.pointsLifetime = lifetime
+	}
+
+	ei := os.Getenv("EXPIRATION_INTERVAL")
+	if ei != "" {
+		interval, err := time.ParseDuration(ei)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("wrong expiration interval: %s", ei)
+		}
+		cb.expirationInterval = interval
+	}
+
+	lt := os.Getenv("LOYALTY_TIERS")
+	if lt != "" {
+		tiers, err := parseLoyaltyTiers(lt)
+		if err != nil {
+			return err
+		}
+		cb.loyaltyTiers = tiers
+	}
+
+	tb := os.Getenv("TIER_BASIS")
+	if tb != "" {
+		if tb != TierBasisAccrued && tb != TierBasisSpent {
+			return fmt.Errorf("wrong tier basis: %s", tb)
+		}
+		cb.tierBasis = tb
+	}
+
+	tp := os.Getenv("TIER_PERIOD")
+	if tp != "" {
+		period, err := time.ParseDuration(tp)
+		if err != nil || period <= 0 {
+			return fmt.Errorf("wrong tier period: %s", tp)
+		}
+		cb.tierPeriod = period
+	}
+
+	tdl := os.Getenv("TRANSFER_DAILY_LIMIT")
+	if tdl != "" {
+		limit, err := strconv.ParseFloat(tdl, 64)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("wrong transfer daily limit: %s", tdl)
+		}
+		cb.transferDailyLimit = limit
+	}
+
+	ar := os.Getenv("ACCOUNT_RETENTION")
+	if ar != "" {
+		if ar != AccountRetentionAnonymize && ar != AccountRetentionDelete {
+			return fmt.Errorf("wrong account retention: %s", ar)
+		}
+		cb.accountRetention = ar
+	}
+
+	aci := os.Getenv("AUDIT_CHAIN_INTERVAL")
+	if aci != "" {
+		interval, err := time.ParseDuration(aci)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("wrong audit chain interval: %s", aci)
+		}
+		cb.auditChainInterval = interval
+	}
+
+	tcf := os.Getenv("TLS_CERT_FILE")
+	if tcf != "" {
+		cb.tlsCertFile = tcf
+	}
+
+	tkf := os.Getenv("TLS_KEY_FILE")
+	if tkf != "" {
+		cb.tlsKeyFile = tkf
+	}
+
+	tmv := os.Getenv("TLS_MIN_VERSION")
+	if tmv != "" {
+		version, err := parseTLSVersion(tmv)
+		if err != nil {
+			return err
+		}
+		cb.tlsMinVersion = version
+	}
+
+	le := os.Getenv("LOG_ENCODING")
+	if le != "" {
+		if le != LogEncodingConsole && le != LogEncodingJSON {
+			return fmt.Errorf("wrong log encoding: %s", le)
+		}
+		cb.logEncoding = le
+	}
+
+	ll := os.Getenv("LOG_LEVEL")
+	if ll != "" {
+		switch ll {
+		case "debug", "info", "warn", "error":
+			cb.logLevel = ll
+		default:
+			return fmt.Errorf("wrong log level: %s", ll)
+		}
+	}
+
+	lsi := os.Getenv("LOG_SAMPLING_INITIAL")
+	if lsi != "" {
+		initial, err := strconv.Atoi(lsi)
+		if err != nil || initial < 0 {
+			return fmt.Errorf("wrong log sampling initial: %s", lsi)
+		}
+		cb.logSamplingInitial = initial
+	}
+
+	lst := os.Getenv("LOG_SAMPLING_THEREAFTER")
+	if lst != "" {
+		thereafter, err := strconv.Atoi(lst)
+		if err != nil || thereafter < 1 {
+			return fmt.Errorf("wrong log sampling thereafter: %s", lst)
+		}
+		cb.logSamplingThereafter = thereafter
+	}
+
+	lf := os.Getenv("LOG_FILE")
+	if lf != "" {
+		cb.logFile = lf
+	}
+
+	lms := os.Getenv("LOG_MAX_SIZE")
+	if lms != "" {
+		size, err := strconv.Atoi(lms)
+		if err != nil || size < 1 {
+			return fmt.Errorf("wrong log max size: %s", lms)
+		}
+		cb.logMaxSize = size
+	}
+
+	lmb := os.Getenv("LOG_MAX_BACKUPS")
+	if lmb != "" {
+		backups, err := strconv.Atoi(lmb)
+		if err != nil || backups < 0 {
+			return fmt.Errorf("wrong log max backups: %s", lmb)
+		}
+		cb.logMaxBackups = backups
+	}
+
+	lma := os.Getenv("LOG_MAX_AGE")
+	if lma != "" {
+		age, err := strconv.Atoi(lma)
+		if err != nil || age < 0 {
+			return fmt.Errorf("wrong log max age: %s", lma)
+		}
+		cb.logMaxAge = age
+	}
+
+	lrb := os.Getenv("LOG_REQUEST_BODIES")
+	if lrb != "" {
+		logBodies, err := strconv.ParseBool(lrb)
+		if err != nil {
+			return err
+		}
+		cb.logRequestBodies = logBodies
+	}
+
 	return nil
 }
 
+// parseTLSVersion converts TLS version like "1.2" to its crypto/tls constant.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnknownTLSVersion, version)
+	}
+}
+
+// parseLoyaltyTiers converts tiers like "bronze:0:1,silver:1000:1.05" to loyalty tiers.
+// Thresholds must ascend starting from zero, multipliers must be positive.
+func parseLoyaltyTiers(tiers string) ([]LoyaltyTier, error) {
+	loyaltyTiers := make([]LoyaltyTier, 0)
+	for _, tier := range strings.Split(tiers, ",") {
+		parts := strings.Split(strings.TrimSpace(tier), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%w: %s", ErrWrongLoyaltyTiers, tier)
+		}
+
+		threshold, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrWrongLoyaltyTiers, tier)
+		}
+
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || multiplier <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrWrongLoyaltyTiers, tier)
+		}
+
+		// Every next tier needs a bigger total than the previous one
+		if len(loyaltyTiers) == 0 && threshold != 0 ||
+			len(loyaltyTiers) > 0 && threshold <= loyaltyTiers[len(loyaltyTiers)-1].Threshold {
+			return nil, fmt.Errorf("%w: %s", ErrWrongLoyaltyTiers, tier)
+		}
+
+		loyaltyTiers = append(loyaltyTiers, LoyaltyTier{
+			Name:       parts[0],
+			Threshold:  threshold,
+			Multiplier: multiplier,
+		})
+	}
+
+	return loyaltyTiers, nil
+}
+
 // build builds application cofiguration.
 func (cb *configBuilder) build() *Config {
 	return &Config{
 		RunAddress:           cb.runAddress,
+		GRPCAddress:          cb.grpcAddress,
 		DatabaseURI:          cb.databaseURI,
 		AccrualSystemAddress: cb.accrualSystemAddress,
 		SecretKey:            cb.secretKey,
+		PartnerAPIKey:        cb.partnerAPIKey,
+		AdminAPIKey:          cb.adminAPIKey,
+		SkipMigrations:       cb.skipMigrations,
+		ValidateResponses:    cb.validateResponses,
+
+		ServerShutdownTimeout:     cb.serverShutdownTimeout,
+		ProcessingShutdownTimeout: cb.processingShutdownTimeout,
+
+		DBRetryInitialInterval: cb.dbRetryInitialInterval,
+		DBRetryMaxInterval:     cb.dbRetryMaxInterval,
+		DBRetryMaxElapsedTime:  cb.dbRetryMaxElapsedTime,
+		DBRetryMaxRetries:      cb.dbRetryMaxRetries,
+
+		PointsLifetime:     cb.pointsLifetime,
+		ExpirationInterval: cb.expirationInterval,
+
+		LoyaltyTiers: cb.loyaltyTiers,
+		TierBasis:    cb.tierBasis,
+		TierPeriod:   cb.tierPeriod,
+
+		TransferDailyLimit: cb.transferDailyLimit,
+
+		AccountRetention: cb.accountRetention,
+
+		AuditChainInterval: cb.auditChainInterval,
+
+		TLSCertFile:   cb.tlsCertFile,
+		TLSKeyFile:    cb.tlsKeyFile,
+		TLSMinVersion: cb.tlsMinVersion,
+
+		LogEncoding:           cb.logEncoding,
+		LogLevel:              cb.logLevel,
+		LogSamplingInitial:    cb.logSamplingInitial,
+		LogSamplingThereafter: cb.logSamplingThereafter,
+		LogFile:               cb.logFile,
+		LogMaxSize:            cb.logMaxSize,
+		LogMaxBackups:         cb.logMaxBackups,
+		LogMaxAge:             cb.logMaxAge,
+		LogRequestBodies:      cb.logRequestBodies,
 	}
 }
 
@@ -1,8 +1,10 @@
 package config_test
 
 import (
+	"crypto/tls"
 	"flag"
 	"os"
+	"time"
 
 	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
 
@@ -65,6 +67,32 @@ var _ = Describe("Config", func() {
 		Entry(nil, "", "", "", "", ra.defVal, ra.defVal),
 	)
 
+	// gRPC address
+	ga := &testCase{
+		envNam: "GRPC_ADDRESS",
+		envVal: "localhost:3201",
+		flgNam: "-g",
+		flgVal: "localhost:3202",
+		defVal: "",
+	}
+
+	DescribeTable("gRPC address",
+		func(envName, envVal, flgName, flgVal, def, expected string) {
+			setEnv(envName, envVal)
+			setFlag(flgName, flgVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.GRPCAddress).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s, flag %s=%s and default=%s"),
+		Entry(nil, ga.envNam, ga.envVal, ga.flgNam, ga.flgVal, ga.defVal, ga.envVal),
+		Entry(nil, ga.envNam, "", ga.flgNam, ga.flgVal, ga.defVal, ga.flgVal),
+		Entry(nil, ga.envNam, "", "", "", ga.defVal, ga.defVal),
+	)
+
 	// Database URI
 	du := &testCase{
 		envNam: "DATABASE_URI",
@@ -155,6 +183,468 @@ var _ = Describe("Config", func() {
 		Entry(nil, sk.envNam, "", sk.defVal, sk.defVal),
 		Entry(nil, "", "", sk.defVal, sk.defVal),
 	)
+
+	DescribeTable("Partner API key",
+		func(envName, envVal string, expected string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.PartnerAPIKey).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "PARTNER_API_KEY", "partner key", "partner key"),
+		Entry(nil, "", "", ""),
+	)
+
+	DescribeTable("Admin API key",
+		func(envName, envVal string, expected string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.AdminAPIKey).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "ADMIN_API_KEY", "admin key", "admin key"),
+		Entry(nil, "", "", ""),
+	)
+
+	DescribeTable("Skip migrations",
+		func(envName, envVal string, expected bool) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.SkipMigrations).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "SKIP_MIGRATIONS", "true", true),
+		Entry(nil, "SKIP_MIGRATIONS", "false", false),
+		Entry(nil, "", "", false),
+	)
+
+	DescribeTable("Validate responses",
+		func(envName, envVal string, expected bool) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.ValidateResponses).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "VALIDATE_RESPONSES", "true", true),
+		Entry(nil, "VALIDATE_RESPONSES", "false", false),
+		Entry(nil, "", "", false),
+	)
+
+	// Shutdown timeouts
+	DescribeTable("Server shutdown timeout",
+		func(envName, envVal string, expected time.Duration) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.ServerShutdownTimeout).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "SERVER_SHUTDOWN_TIMEOUT", "30s", 30*time.Second),
+		Entry(nil, "SERVER_SHUTDOWN_TIMEOUT", "", 5*time.Second),
+		Entry(nil, "", "", 5*time.Second),
+	)
+
+	DescribeTable("Processing shutdown timeout",
+		func(envName, envVal string, expected time.Duration) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.ProcessingShutdownTimeout).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "PROCESSING_SHUTDOWN_TIMEOUT", "1m", time.Minute),
+		Entry(nil, "PROCESSING_SHUTDOWN_TIMEOUT", "", 10*time.Second),
+		Entry(nil, "", "", 10*time.Second),
+	)
+
+	DescribeTable("DB retry policy",
+		func(envName, envVal string, initialInterval, maxInterval, maxElapsedTime time.Duration, maxRetries uint64) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.DBRetryInitialInterval).To(Equal(initialInterval))
+			Expect(cfg.DBRetryMaxInterval).To(Equal(maxInterval))
+			Expect(cfg.DBRetryMaxElapsedTime).To(Equal(maxElapsedTime))
+			Expect(cfg.DBRetryMaxRetries).To(Equal(maxRetries))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "DB_RETRY_INITIAL_INTERVAL", "50ms", 50*time.Millisecond, 2*time.Second, 15*time.Second, uint64(5)),
+		Entry(nil, "DB_RETRY_MAX_INTERVAL", "5s", 100*time.Millisecond, 5*time.Second, 15*time.Second, uint64(5)),
+		Entry(nil, "DB_RETRY_MAX_ELAPSED_TIME", "1m", 100*time.Millisecond, 2*time.Second, time.Minute, uint64(5)),
+		Entry(nil, "DB_RETRY_MAX_ELAPSED_TIME", "0s", 100*time.Millisecond, 2*time.Second, time.Duration(0), uint64(5)),
+		Entry(nil, "DB_RETRY_MAX_RETRIES", "0", 100*time.Millisecond, 2*time.Second, 15*time.Second, uint64(0)),
+		Entry(nil, "", "", 100*time.Millisecond, 2*time.Second, 15*time.Second, uint64(5)),
+	)
+
+	DescribeTable("Points lifetime",
+		func(envName, envVal string, expected time.Duration) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.PointsLifetime).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "POINTS_LIFETIME", "8760h", 8760*time.Hour),
+		Entry(nil, "", "", time.Duration(0)),
+	)
+
+	DescribeTable("Expiration interval",
+		func(envName, envVal string, expected time.Duration) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.ExpirationInterval).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "EXPIRATION_INTERVAL", "10m", 10*time.Minute),
+		Entry(nil, "", "", time.Hour),
+	)
+
+	DescribeTable("Audit chain interval",
+		func(envName, envVal string, expected time.Duration) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.AuditChainInterval).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "AUDIT_CHAIN_INTERVAL", "5s", 5*time.Second),
+		Entry(nil, "", "", time.Second),
+	)
+
+	It("parses loyalty tiers", func() {
+		setEnv("LOYALTY_TIERS", "bronze:0:1, silver:1000:1.05,gold:5000:1.1")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(BeNil())
+		Expect(cfg.LoyaltyTiers).To(Equal([]config.LoyaltyTier{
+			{Name: "bronze", Threshold: 0, Multiplier: 1},
+			{Name: "silver", Threshold: 1000, Multiplier: 1.05},
+			{Name: "gold", Threshold: 5000, Multiplier: 1.1},
+		}))
+		Expect(cfg.TierBasis).To(Equal(config.TierBasisAccrued))
+		Expect(cfg.TierPeriod).To(Equal(365 * 24 * time.Hour))
+	})
+
+	DescribeTable("Malformed loyalty tiers",
+		func(envVal string) {
+			setEnv("LOYALTY_TIERS", envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+		},
+
+		EntryDescription("When env LOYALTY_TIERS=%s"),
+		Entry(nil, "bronze:100:1"),
+		Entry(nil, "bronze:0:1,silver:0:1.05"),
+		Entry(nil, "bronze:0:0"),
+		Entry(nil, "bronze:0"),
+		Entry(nil, ":0:1"),
+	)
+
+	DescribeTable("Tier basis",
+		func(envName, envVal string, expected string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.TierBasis).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "TIER_BASIS", "spent", config.TierBasisSpent),
+		Entry(nil, "", "", config.TierBasisAccrued),
+	)
+
+	It("fails on an unknown tier basis", func() {
+		setEnv("TIER_BASIS", "visits")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	DescribeTable("Account retention",
+		func(envName, envVal string, expected string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.AccountRetention).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "ACCOUNT_RETENTION", "delete", config.AccountRetentionDelete),
+		Entry(nil, "", "", config.AccountRetentionAnonymize),
+	)
+
+	It("fails on an unknown account retention", func() {
+		setEnv("ACCOUNT_RETENTION", "archive")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	DescribeTable("Log encoding",
+		func(envName, envVal string, expected string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.LogEncoding).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "LOG_ENCODING", "json", config.LogEncodingJSON),
+		Entry(nil, "", "", config.LogEncodingConsole),
+	)
+
+	DescribeTable("Log level",
+		func(envName, envVal string, expected string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.LogLevel).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "LOG_LEVEL", "debug", "debug"),
+		Entry(nil, "LOG_LEVEL", "error", "error"),
+		Entry(nil, "", "", "info"),
+	)
+
+	DescribeTable("Log sampling and rotation",
+		func(envName, envVal string, field func(cfg *config.Config) int, expected int) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(field(cfg)).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "LOG_SAMPLING_INITIAL", "10", func(cfg *config.Config) int { return cfg.LogSamplingInitial }, 10),
+		Entry(nil, "", "", func(cfg *config.Config) int { return cfg.LogSamplingInitial }, 0),
+		Entry(nil, "LOG_SAMPLING_THEREAFTER", "50", func(cfg *config.Config) int { return cfg.LogSamplingThereafter }, 50),
+		Entry(nil, "", "", func(cfg *config.Config) int { return cfg.LogSamplingThereafter }, 100),
+		Entry(nil, "LOG_MAX_SIZE", "10", func(cfg *config.Config) int { return cfg.LogMaxSize }, 10),
+		Entry(nil, "", "", func(cfg *config.Config) int { return cfg.LogMaxSize }, 100),
+		Entry(nil, "LOG_MAX_BACKUPS", "0", func(cfg *config.Config) int { return cfg.LogMaxBackups }, 0),
+		Entry(nil, "", "", func(cfg *config.Config) int { return cfg.LogMaxBackups }, 5),
+		Entry(nil, "LOG_MAX_AGE", "7", func(cfg *config.Config) int { return cfg.LogMaxAge }, 7),
+		Entry(nil, "", "", func(cfg *config.Config) int { return cfg.LogMaxAge }, 30),
+	)
+
+	DescribeTable("Log file",
+		func(envName, envVal string, expected string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.LogFile).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "LOG_FILE", "/var/log/gophermart.log", "/var/log/gophermart.log"),
+		Entry(nil, "", "", ""),
+	)
+
+	DescribeTable("Log request bodies",
+		func(envName, envVal string, expected bool) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.LogRequestBodies).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "LOG_REQUEST_BODIES", "true", true),
+		Entry(nil, "", "", false),
+	)
+
+	DescribeTable("fails on a wrong log option",
+		func(envName, envVal string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "LOG_ENCODING", "xml"),
+		Entry(nil, "LOG_LEVEL", "verbose"),
+		Entry(nil, "LOG_SAMPLING_INITIAL", "-1"),
+		Entry(nil, "LOG_SAMPLING_THEREAFTER", "0"),
+		Entry(nil, "LOG_MAX_SIZE", "0"),
+		Entry(nil, "LOG_MAX_BACKUPS", "many"),
+		Entry(nil, "LOG_MAX_AGE", "-1"),
+		Entry(nil, "LOG_REQUEST_BODIES", "maybe"),
+	)
+
+	DescribeTable("Transfer daily limit",
+		func(envName, envVal string, expected float64) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.TransferDailyLimit).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "TRANSFER_DAILY_LIMIT", "2500.5", 2500.5),
+		Entry(nil, "TRANSFER_DAILY_LIMIT", "0", float64(0)),
+		Entry(nil, "", "", float64(1000)),
+	)
+
+	It("fails on a negative transfer daily limit", func() {
+		setEnv("TRANSFER_DAILY_LIMIT", "-1")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	DescribeTable("TLS minimal version",
+		func(envName, envVal string, expected uint16) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(BeNil())
+			Expect(cfg.TLSMinVersion).To(Equal(expected))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "TLS_MIN_VERSION", "1.3", uint16(tls.VersionTLS13)),
+		Entry(nil, "TLS_MIN_VERSION", "1.2", uint16(tls.VersionTLS12)),
+		Entry(nil, "", "", uint16(tls.VersionTLS12)),
+	)
+
+	It("enables TLS only when both certificate and key are set", func() {
+		setEnv("TLS_CERT_FILE", "cert.pem")
+
+		cfg, err = config.Get()
+		Expect(err).Should(BeNil())
+		Expect(cfg.TLSEnabled()).To(BeFalse())
+
+		setEnv("TLS_KEY_FILE", "key.pem")
+
+		cfg, err = config.Get()
+		Expect(err).Should(BeNil())
+		Expect(cfg.TLSEnabled()).To(BeTrue())
+	})
+
+	It("fails on an unknown TLS version", func() {
+		setEnv("TLS_MIN_VERSION", "1.0")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	It("fails on a malformed skip migrations value", func() {
+		setEnv("SKIP_MIGRATIONS", "maybe")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	It("fails on a malformed validate responses value", func() {
+		setEnv("VALIDATE_RESPONSES", "maybe")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	It("fails on a non-positive audit chain interval", func() {
+		setEnv("AUDIT_CHAIN_INTERVAL", "-1s")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	It("fails on a non-positive expiration interval", func() {
+		setEnv("EXPIRATION_INTERVAL", "0s")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
+
+	DescribeTable("Malformed DB retry policy",
+		func(envName, envVal string) {
+			setEnv(envName, envVal)
+
+			cfg, err = config.Get()
+
+			Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+		},
+
+		EntryDescription("When env %s=%s"),
+		Entry(nil, "DB_RETRY_INITIAL_INTERVAL", "0s"),
+		Entry(nil, "DB_RETRY_MAX_INTERVAL", "soon"),
+		Entry(nil, "DB_RETRY_MAX_ELAPSED_TIME", "-1s"),
+		Entry(nil, "DB_RETRY_MAX_RETRIES", "-1"),
+	)
+
+	It("fails on a malformed timeout", func() {
+		setEnv("SERVER_SHUTDOWN_TIMEOUT", "soon")
+
+		cfg, err = config.Get()
+
+		Expect(err).Should(MatchError(config.ErrInitConfigFailed))
+	})
 })
 
 func setEnv(name, value string) {
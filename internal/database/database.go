@@ -2,19 +2,66 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/RomanAgaltsev/ya_gophermart/migrations"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
-// NewConnectionPool creates new pgx connection pool and runs migrations.
-func NewConnectionPool(ctx context.Context, databaseURI string) (*pgxpool.Pool, error) {
+// ErrSchemaVersionMismatch - error of the database schema, which differs from the embedded migrations.
+var ErrSchemaVersionMismatch = fmt.Errorf("database schema version mismatch")
+
+// serviceTimeZoneParam is the run-time parameter of migration sessions with the time zone of the service.
+// Migrations use it to convert the times the service has stored without time zone.
+const serviceTimeZoneParam = "gophermart.service_time_zone"
+
+// NewConnectionPool creates new pgx connection pool, runs migrations if needed and checks the schema version.
+func NewConnectionPool(ctx context.Context, databaseURI string, runMigrations bool) (*pgxpool.Pool, error) {
+	// Create new connection pool
+	dbpool, err := NewPool(ctx, databaseURI)
+	if err != nil {
+		return nil, err
+	}
+
+	// Do migrations
+	if runMigrations {
+		if err = Migrate(ctx, dbpool); err != nil {
+			dbpool.Close()
+			return nil, err
+		}
+	}
+
+	// The application must not work with a schema it doesn't know
+	if err = CheckSchemaVersion(ctx, dbpool); err != nil {
+		slog.Error("check DB schema version", slog.String("error", err.Error()))
+		dbpool.Close()
+		return nil, err
+	}
+
+	return dbpool, nil
+}
+
+// NewPool creates new pgx connection pool and checks the connection without running migrations.
+// Times are scanned in UTC whatever the time zone of the database session is.
+func NewPool(ctx context.Context, databaseURI string) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(databaseURI)
+	if err != nil {
+		slog.Error("parse DB connection string", slog.String("error", err.Error()))
+		return nil, err
+	}
+	poolConfig.AfterConnect = registerUTCTimestamptz
+
 	// Create new connection pool
-	dbpool, err := pgxpool.New(ctx, databaseURI)
+	dbpool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		slog.Error("new DB connection", slog.String("error", err.Error()))
 		return nil, err
@@ -23,35 +70,146 @@ func NewConnectionPool(ctx context.Context, databaseURI string) (*pgxpool.Pool,
 	// Ping DB
 	if err = dbpool.Ping(ctx); err != nil {
 		slog.Error("ping DB", slog.String("error", err.Error()))
+		dbpool.Close()
 		return nil, err
 	}
 
-	// Do migrations
-	Migrate(ctx, dbpool, databaseURI)
-
 	return dbpool, nil
 }
 
+// registerUTCTimestamptz makes the connection scan timestamptz values in UTC instead of the local time zone.
+func registerUTCTimestamptz(_ context.Context, conn *pgx.Conn) error {
+	conn.TypeMap().RegisterType(&pgtype.Type{
+		Name:  "timestamptz",
+		OID:   pgtype.TimestamptzOID,
+		Codec: &pgtype.TimestamptzCodec{ScanLocation: time.UTC},
+	})
+
+	return nil
+}
+
 // Migrate runs migrations.
-func Migrate(ctx context.Context, dbpool *pgxpool.Pool, databaseURI string) {
-	// Set migrations directory
-	goose.SetBaseFS(migrations.Migrations)
+func Migrate(ctx context.Context, dbpool *pgxpool.Pool) error {
+	// Up migrations
+	if err := MigrateUp(ctx, dbpool); err != nil {
+		slog.Error("goose: run migrations", slog.String("error", err.Error()))
+		return fmt.Errorf("goose: run migrations: %w", err)
+	}
 
-	// Set dialect
-	if err := goose.SetDialect("postgres"); err != nil {
-		slog.Error("goose: set dialect", slog.String("error", err.Error()))
+	return nil
+}
+
+// CheckSchemaVersion compares the database schema version with the version of the embedded migrations.
+func CheckSchemaVersion(ctx context.Context, dbpool *pgxpool.Pool) error {
+	return withMigrationProvider(dbpool, func(provider *goose.Provider) error {
+		current, target, err := provider.GetVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		if current != target {
+			return fmt.Errorf("%w: database has version %d, application expects %d", ErrSchemaVersionMismatch, current, target)
+		}
+
+		// Migrations could be applied out of order, so all of them must be applied, not only the last one
+		pending, err := provider.HasPending(ctx)
+		if err != nil {
+			return err
+		}
+
+		if pending {
+			return fmt.Errorf("%w: database has pending migrations", ErrSchemaVersionMismatch)
+		}
+
+		return nil
+	})
+}
+
+// MigrateUp applies all pending migrations.
+func MigrateUp(ctx context.Context, dbpool *pgxpool.Pool) error {
+	return withMigrationProvider(dbpool, func(provider *goose.Provider) error {
+		_, err := provider.Up(ctx)
+		return err
+	})
+}
+
+// MigrateDown rolls back the last applied migration.
+func MigrateDown(ctx context.Context, dbpool *pgxpool.Pool) error {
+	return withMigrationProvider(dbpool, func(provider *goose.Provider) error {
+		_, err := provider.Down(ctx)
+		return err
+	})
+}
+
+// MigrateRedo rolls back the last applied migration and applies it again.
+func MigrateRedo(ctx context.Context, dbpool *pgxpool.Pool) error {
+	return withMigrationProvider(dbpool, func(provider *goose.Provider) error {
+		if _, err := provider.Down(ctx); err != nil {
+			return err
+		}
+		_, err := provider.UpByOne(ctx)
+		return err
+	})
+}
+
+// MigrateStatus returns statuses of all migrations.
+func MigrateStatus(ctx context.Context, dbpool *pgxpool.Pool) ([]*goose.MigrationStatus, error) {
+	var statuses []*goose.MigrationStatus
+
+	err := withMigrationProvider(dbpool, func(provider *goose.Provider) error {
+		var err error
+		statuses, err = provider.Status(ctx)
+		return err
+	})
+
+	return statuses, err
+}
+
+// withMigrationProvider creates goose provider on the embedded migrations and calls the function with it.
+func withMigrationProvider(dbpool *pgxpool.Pool, f func(provider *goose.Provider) error) error {
+	// Open connection with the settings of db pool and the time zone of the service
+	connConfig := dbpool.Config().ConnConfig.Copy()
+	if timeZone := serviceTimeZone(); timeZone != "" {
+		connConfig.RuntimeParams[serviceTimeZoneParam] = timeZone
 	}
+	db := stdlib.OpenDB(*connConfig)
 
-	// Open connection from db pool
-	db := stdlib.OpenDBFromPool(dbpool)
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrations.Migrations)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
 
-	// Up migrations
-	if err := goose.UpContext(ctx, db, "."); err != nil {
-		slog.Error("goose: run migrations", slog.String("error", err.Error()))
+	// Closing the provider closes the connection
+	defer func() {
+		if err := provider.Close(); err != nil {
+			slog.Error("goose: close connection", slog.String("error", err.Error()))
+		}
+	}()
+
+	return f(provider)
+}
+
+// serviceTimeZone returns the IANA name of the local time zone of the service or an empty string, if it is unknown.
+func serviceTimeZone() string {
+	// The name is known, when the zone is set by TZ or the local zone is not found and UTC is used
+	name := time.Local.String()
+	if name == "Local" {
+		// Otherwise the local zone is read from /etc/localtime, which usually links to the zone file
+		path, err := filepath.EvalSymlinks("/etc/localtime")
+		if err != nil {
+			return ""
+		}
+		name = path
 	}
 
-	// Close connection
-	if err := db.Close(); err != nil {
-		slog.Error("goose: close connection", slog.String("error", err.Error()))
+	// TZ and the link may be paths of zone files
+	if _, zone, ok := strings.Cut(name, "zoneinfo/"); ok {
+		return zone
+	}
+	if filepath.IsAbs(name) {
+		return ""
 	}
+
+	return name
 }
@@ -0,0 +1,176 @@
+package database_test
+
+import (
+	"context"
+	"os"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/database"
+	"github.com/RomanAgaltsev/ya_gophermart/migrations"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pressly/goose/v3"
+)
+
+// testDatabaseURIEnv is the name of environment variable with the connection string of a disposable test database.
+// Migrations tests are skipped when the variable is not set.
+const testDatabaseURIEnv = "TEST_DATABASE_URI"
+
+var _ = Describe("Migrations", func() {
+	var (
+		ctx      context.Context
+		dbpool   *pgxpool.Pool
+		provider *goose.Provider
+	)
+
+	BeforeEach(func() {
+		databaseURI := os.Getenv(testDatabaseURIEnv)
+		if databaseURI == "" {
+			Skip(testDatabaseURIEnv + " is not set")
+		}
+
+		ctx = context.Background()
+
+		var err error
+		dbpool, err = database.NewPool(ctx, databaseURI)
+		Expect(err).NotTo(HaveOccurred())
+
+		provider, err = goose.NewProvider(goose.DialectPostgres, stdlib.OpenDBFromPool(dbpool), migrations.Migrations)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Start from the empty schema
+		_, err = provider.DownTo(ctx, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		DeferCleanup(func() {
+			_, err := provider.DownTo(ctx, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(provider.Close()).To(Succeed())
+			dbpool.Close()
+		})
+	})
+
+	It("round-trips every migration up and down", func() {
+		for _, source := range provider.ListSources() {
+			By(source.Path)
+
+			// Up, down and up again - the down migration must fully revert the up one
+			result, err := provider.UpByOne(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Source.Version).To(Equal(source.Version))
+
+			_, err = provider.Down(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = provider.UpByOne(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		// Roll everything back to make sure the whole chain goes down
+		_, err := provider.DownTo(ctx, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		version, err := provider.GetDBVersion(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(BeZero())
+	})
+
+	It("migrates the database and accepts its schema version", func() {
+		Expect(database.Migrate(ctx, dbpool)).To(Succeed())
+		Expect(database.CheckSchemaVersion(ctx, dbpool)).To(Succeed())
+	})
+
+	It("rejects the schema which is behind the migrations", func() {
+		Expect(database.Migrate(ctx, dbpool)).To(Succeed())
+		Expect(database.MigrateDown(ctx, dbpool)).To(Succeed())
+
+		Expect(database.CheckSchemaVersion(ctx, dbpool)).To(MatchError(database.ErrSchemaVersionMismatch))
+	})
+})
+
+var _ = Describe("Integrity check", func() {
+	// integrityVersion is the version of the foreign keys and indexes migration
+	const integrityVersion = 11
+
+	var (
+		ctx      context.Context
+		dbpool   *pgxpool.Pool
+		provider *goose.Provider
+	)
+
+	BeforeEach(func() {
+		databaseURI := os.Getenv(testDatabaseURIEnv)
+		if databaseURI == "" {
+			Skip(testDatabaseURIEnv + " is not set")
+		}
+
+		ctx = context.Background()
+
+		var err error
+		dbpool, err = database.NewPool(ctx, databaseURI)
+		Expect(err).NotTo(HaveOccurred())
+
+		provider, err = goose.NewProvider(goose.DialectPostgres, stdlib.OpenDBFromPool(dbpool), migrations.Migrations)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Start from the schema just before the migration
+		_, err = provider.DownTo(ctx, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = provider.UpTo(ctx, integrityVersion-1)
+		Expect(err).NotTo(HaveOccurred())
+
+		DeferCleanup(func() {
+			_, err := provider.DownTo(ctx, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(provider.Close()).To(Succeed())
+			dbpool.Close()
+		})
+
+		// Violating rows - an order of an unknown user and two balances of a user
+		_, err = dbpool.Exec(ctx, `
+INSERT INTO users (login, password) VALUES ('user', 'password');
+INSERT INTO balance (login, accrued) VALUES ('user', 100), ('user', 100);
+INSERT INTO orders (login, number) VALUES ('ghost', '12345678903');`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("finds no violations in the valid data", func() {
+		_, err := dbpool.Exec(ctx, `DELETE FROM orders; DELETE FROM balance WHERE id > (SELECT MIN(id) FROM balance)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(database.CheckIntegrity(ctx, dbpool)).To(BeEmpty())
+	})
+
+	It("reports the violations and keeps the migration from applying", func() {
+		Expect(database.CheckIntegrity(ctx, dbpool)).To(Equal([]database.Violation{
+			{Kind: database.ViolationDuplicateBalance, Table: "balance", Login: "user", Rows: 2},
+			{Kind: database.ViolationUnknownUser, Table: "orders", Login: "ghost", Rows: 1},
+		}))
+
+		_, err := provider.UpByOne(ctx)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("repairs the violations, so the migration applies", func() {
+		violations, err := database.RepairIntegrity(ctx, dbpool)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(violations).To(HaveLen(2))
+
+		Expect(database.CheckIntegrity(ctx, dbpool)).To(BeEmpty())
+
+		// The order is kept for the disabled user
+		var disabled bool
+		Expect(dbpool.QueryRow(ctx, `SELECT disabled FROM users WHERE login = 'ghost'`).Scan(&disabled)).To(Succeed())
+		Expect(disabled).To(BeTrue())
+
+		// The balance is not doubled
+		var accrued float64
+		Expect(dbpool.QueryRow(ctx, `SELECT SUM(accrued) FROM balance WHERE login = 'user'`).Scan(&accrued)).To(Succeed())
+		Expect(accrued).To(Equal(100.0))
+
+		_, err = provider.UpByOne(ctx)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Kinds of integrity violations.
+const (
+	ViolationUnknownUser      = "unknown user"
+	ViolationDuplicateBalance = "duplicate balance"
+)
+
+// ErrIntegrityViolation - error of the data, which violates the constraints of the foreign keys and indexes migration.
+var ErrIntegrityViolation = fmt.Errorf("data integrity violation")
+
+// Violation is a group of rows of a login, which violates the constraints of the foreign keys and indexes migration.
+type Violation struct {
+	Kind  string
+	Table string
+	Login string
+	Rows  int64
+}
+
+// checkIntegrity selects logins of orders, withdrawals and balances without users and logins with several balances.
+const checkIntegrity = `
+SELECT 'unknown user', 'orders', login, COUNT(*)
+FROM orders
+WHERE NOT EXISTS (SELECT FROM users WHERE users.login = orders.login)
+GROUP BY login
+UNION ALL
+SELECT 'unknown user', 'withdrawals', login, COUNT(*)
+FROM withdrawals
+WHERE NOT EXISTS (SELECT FROM users WHERE users.login = withdrawals.login)
+GROUP BY login
+UNION ALL
+SELECT 'unknown user', 'balance', login, COUNT(*)
+FROM balance
+WHERE NOT EXISTS (SELECT FROM users WHERE users.login = balance.login)
+GROUP BY login
+UNION ALL
+SELECT 'duplicate balance', 'balance', login, COUNT(*)
+FROM balance
+GROUP BY login
+HAVING COUNT(*) > 1
+ORDER BY 1, 2, 3`
+
+// deleteDuplicateBalances keeps the oldest balance of a login and deletes the others.
+// Balances are updated by login, so the duplicates have got the same updates, but the oldest one has got all of them.
+const deleteDuplicateBalances = `
+DELETE
+FROM balance
+WHERE id <> (SELECT MIN(id) FROM balance AS oldest WHERE oldest.login = balance.login)`
+
+// createUnknownUsers creates disabled users without password for logins of orders, withdrawals and balances.
+// The data is kept, but nobody can log in as such a user.
+const createUnknownUsers = `
+INSERT INTO users (login, password, disabled)
+SELECT login, '', TRUE
+FROM (SELECT login FROM orders
+      UNION
+      SELECT login FROM withdrawals
+      UNION
+      SELECT login FROM balance) AS logins
+ON CONFLICT (login) DO NOTHING`
+
+// CheckIntegrity returns rows, which must be repaired before the foreign keys and indexes migration.
+func CheckIntegrity(ctx context.Context, dbpool *pgxpool.Pool) ([]Violation, error) {
+	return listViolations(ctx, dbpool)
+}
+
+// RepairIntegrity repairs rows, which violate the constraints of the foreign keys and indexes migration, in one transaction.
+// Duplicate balances are deleted and disabled users are created for unknown logins. Repaired violations are returned.
+func RepairIntegrity(ctx context.Context, dbpool *pgxpool.Pool) ([]Violation, error) {
+	var violations []Violation
+
+	err := pgx.BeginFunc(ctx, dbpool, func(tx pgx.Tx) error {
+		var err error
+		if violations, err = listViolations(ctx, tx); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, deleteDuplicateBalances); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, createUnknownUsers)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return violations, nil
+}
+
+// listViolations selects integrity violations with the pool or in the transaction.
+func listViolations(ctx context.Context, db interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}) ([]Violation, error) {
+	rows, err := db.Query(ctx, checkIntegrity)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Violation, error) {
+		var v Violation
+		err := row.Scan(&v.Kind, &v.Table, &v.Login, &v.Rows)
+		return v, err
+	})
+}
@@ -54,6 +54,30 @@ func (ns NullOrderStatus) Value() (driver.Value, error) {
 	return string(ns.OrderStatus), nil
 }
 
+type AuditLog struct {
+	ID        int64
+	Login     string
+	Action    string
+	OldValue  []byte
+	NewValue  []byte
+	RequestID string
+	Ip        string
+	CreatedAt time.Time
+	PrevHash  string
+	Hash      string
+}
+
+type AuditQueue struct {
+	ID        int64
+	Login     string
+	Action    string
+	OldValue  []byte
+	NewValue  []byte
+	RequestID string
+	Ip        string
+	CreatedAt time.Time
+}
+
 type Balance struct {
 	ID        int32
 	Login     string
@@ -61,6 +85,33 @@ type Balance struct {
 	Withdrawn float64
 }
 
+type Bonuse struct {
+	ID          int32
+	Login       string
+	OrderNumber string
+	RuleID      int32
+	Amount      float64
+	CreatedAt   time.Time
+}
+
+type Expiration struct {
+	ID        int32
+	LotID     int32
+	Login     string
+	Amount    float64
+	ExpiredAt time.Time
+}
+
+type Lot struct {
+	ID          int32
+	Login       string
+	OrderNumber string
+	Amount      float64
+	Remaining   float64
+	AccruedAt   time.Time
+	ExpiresAt   *time.Time
+}
+
 type Order struct {
 	ID         int32
 	Login      string
@@ -70,17 +121,63 @@ type Order struct {
 	UploadedAt time.Time
 }
 
-type User struct {
+type PromotionRule struct {
+	ID          int32
+	Name        string
+	Disabled    bool
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	Weekdays    []int32
+	FirstOrder  bool
+	MinOrders   int32
+	Tier        string
+	BonusRate   float64
+	BonusPoints float64
+	CreatedAt   time.Time
+}
+
+type Reversal struct {
+	ID           int32
+	WithdrawalID int32
+	Login        string
+	OrderNumber  string
+	Sum          float64
+	Reason       string
+	ReversedAt   time.Time
+}
+
+type TierHistory struct {
 	ID        int32
 	Login     string
-	Password  string
-	CreatedAt time.Time
+	Tier      string
+	Total     float64
+	ChangedAt time.Time
+}
+
+type Transfer struct {
+	ID            int32
+	Sender        string
+	Recipient     string
+	Sum           float64
+	TransferredAt time.Time
+}
+
+type User struct {
+	ID             int32
+	Login          string
+	Password       string
+	CreatedAt      time.Time
+	Disabled       bool
+	SessionVersion int32
+	Timezone       string
 }
 
 type Withdrawal struct {
-	ID          int32
-	Login       string
-	OrderNumber string
-	Sum         float64
-	ProcessedAt time.Time
+	ID             int32
+	Login          string
+	OrderNumber    string
+	Sum            float64
+	ProcessedAt    time.Time
+	ReversedAt     *time.Time
+	ReversalReason string
 }
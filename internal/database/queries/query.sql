@@ -1,16 +1,80 @@
 -- name: CreateUser :one
-INSERT INTO users (login, password)
-VALUES ($1, $2) RETURNING id;
+-- New users start with session version 1, version 0 is left to the accounts created before tokens had user IDs
+INSERT INTO users (login, password, session_version)
+VALUES ($1, $2, 1) RETURNING id, session_version;
 
 -- name: GetUser :one
-SELECT id, login, password, created_at
+SELECT id, login, password, created_at, disabled, session_version, timezone
 FROM users
 WHERE login = $1 LIMIT 1;
 
+-- name: GetUserForUpdate :one
+SELECT id, login, password, created_at, disabled, session_version, timezone
+FROM users
+WHERE login = $1 LIMIT 1
+FOR UPDATE;
+
+-- name: DisableUser :execrows
+UPDATE users
+SET disabled        = TRUE,
+    session_version = session_version + 1
+WHERE login = $1;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+SET password        = $2,
+    session_version = session_version + 1
+WHERE login = $1 RETURNING session_version;
+
+-- name: UpdateUserTimezone :execrows
+UPDATE users
+SET timezone = $2
+WHERE login = $1;
+
+-- name: AnonymizeUser :exec
+WITH anonymized_orders AS (UPDATE orders SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login)),
+     anonymized_withdrawals AS (UPDATE withdrawals SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login)),
+     anonymized_reversals AS (UPDATE reversals SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login)),
+     anonymized_lots AS (UPDATE lots SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login)),
+     anonymized_expirations AS (UPDATE expirations SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login)),
+     anonymized_tiers AS (UPDATE tier_history SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login)),
+     anonymized_bonuses AS (UPDATE bonuses SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login)),
+     anonymized_sent AS (UPDATE transfers SET sender = sqlc.arg(pseudonym) WHERE sender = sqlc.arg(login)),
+     anonymized_received AS (UPDATE transfers SET recipient = sqlc.arg(pseudonym) WHERE recipient = sqlc.arg(login)),
+     anonymized_balance AS (UPDATE balance SET login = sqlc.arg(pseudonym) WHERE login = sqlc.arg(login))
+UPDATE users
+SET login           = sqlc.arg(pseudonym),
+    password        = '',
+    disabled        = TRUE,
+    session_version = session_version + 1,
+    timezone        = ''
+WHERE login = sqlc.arg(login);
+
+-- name: DeleteUserData :exec
+WITH deleted_expirations AS (DELETE FROM expirations WHERE login = sqlc.arg(login)),
+     deleted_lots AS (DELETE FROM lots WHERE login = sqlc.arg(login)),
+     deleted_reversals AS (DELETE FROM reversals WHERE login = sqlc.arg(login)),
+     deleted_withdrawals AS (DELETE FROM withdrawals WHERE login = sqlc.arg(login)),
+     deleted_orders AS (DELETE FROM orders WHERE login = sqlc.arg(login)),
+     deleted_tiers AS (DELETE FROM tier_history WHERE login = sqlc.arg(login)),
+     deleted_bonuses AS (DELETE FROM bonuses WHERE login = sqlc.arg(login)),
+     anonymized_sent AS (UPDATE transfers SET sender = sqlc.arg(pseudonym) WHERE sender = sqlc.arg(login)),
+     anonymized_received AS (UPDATE transfers SET recipient = sqlc.arg(pseudonym) WHERE recipient = sqlc.arg(login)),
+     deleted_balance AS (DELETE FROM balance WHERE login = sqlc.arg(login))
+DELETE
+FROM users
+WHERE login = sqlc.arg(login);
+
 -- name: CreateOrder :one
 INSERT INTO orders (login, number)
 VALUES ($1, $2) RETURNING id;
 
+-- name: CreateOrders :many
+INSERT INTO orders (login, number)
+SELECT sqlc.arg(login)::VARCHAR, unnest(sqlc.arg(numbers)::VARCHAR[])
+ON CONFLICT (number) DO NOTHING
+RETURNING number;
+
 -- name: UpdateOrder :exec
 UPDATE orders
 SET status  = $2,
@@ -22,11 +86,23 @@ SELECT id, login, number, status, accrual, uploaded_at
 FROM orders
 WHERE number = $1 LIMIT 1;
 
+-- name: ListOrdersByNumbers :many
+SELECT id, login, number, status, accrual, uploaded_at
+FROM orders
+WHERE number = ANY (sqlc.arg(numbers)::VARCHAR[]);
+
 -- name: ListOrders :many
+-- The zero limit means no limit.
 SELECT id, login, number, status, accrual, uploaded_at
 FROM orders
-WHERE login = $1
-ORDER BY uploaded_at DESC;
+WHERE login = sqlc.arg(login)
+ORDER BY uploaded_at DESC, id DESC
+LIMIT NULLIF(sqlc.arg(page_limit)::INTEGER, 0) OFFSET sqlc.arg(page_offset)::INTEGER;
+
+-- name: CountOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE login = $1;
 
 -- name: ListOrdersToProcess :many
 SELECT id, login, number, status, accrual, uploaded_at
@@ -34,31 +110,292 @@ FROM orders
 WHERE status = 'NEW'
    OR status = 'PROCESSING';
 
+-- name: ListStuckOrders :many
+SELECT id, login, number, status, accrual, uploaded_at
+FROM orders
+WHERE (status = 'NEW' OR status = 'PROCESSING')
+  AND uploaded_at < $1
+ORDER BY uploaded_at;
+
+-- name: RequeueOrder :execrows
+UPDATE orders
+SET status = 'NEW'
+WHERE number = $1
+  AND status IN ('NEW', 'PROCESSING');
+
 -- name: CreateWithdraw :one
 INSERT INTO withdrawals (login, order_number, sum)
 VALUES ($1, $2, $3) RETURNING id;
 
 -- name: ListWithdrawals :many
-SELECT id, login, order_number, sum, processed_at
+-- The zero limit means no limit.
+SELECT id, login, order_number, sum, processed_at, reversed_at, reversal_reason
 FROM withdrawals
-WHERE login = $1
-ORDER BY processed_at DESC;
+WHERE login = sqlc.arg(login)
+ORDER BY processed_at DESC, id DESC
+LIMIT NULLIF(sqlc.arg(page_limit)::INTEGER, 0) OFFSET sqlc.arg(page_offset)::INTEGER;
+
+-- name: CountWithdrawals :one
+SELECT COUNT(*)
+FROM withdrawals
+WHERE login = $1;
 
--- name: CreateBalance :one
+-- name: CreateBalance :exec
 INSERT INTO balance (login)
-VALUES ($1) RETURNING id;
+VALUES ($1)
+ON CONFLICT (login) DO NOTHING;
+
+-- name: GetWithdrawalForUpdate :one
+SELECT id, login, order_number, sum, processed_at, reversed_at, reversal_reason
+FROM withdrawals
+WHERE order_number = $1
+ORDER BY processed_at DESC LIMIT 1
+FOR UPDATE;
+
+-- name: MarkWithdrawalReversed :one
+UPDATE withdrawals
+SET reversed_at     = NOW(),
+    reversal_reason = $2
+WHERE id = $1 RETURNING reversed_at;
+
+-- name: CreateReversal :one
+INSERT INTO reversals (withdrawal_id, login, order_number, sum, reason, reversed_at)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;
 
 -- name: GetBalance :one
 SELECT id, login, accrued, withdrawn
 FROM balance
 WHERE login = $1 LIMIT 1;
 
+-- name: GetBalanceForUpdate :one
+SELECT id, login, accrued, withdrawn
+FROM balance
+WHERE login = $1
+FOR UPDATE;
+
 -- name: UpdateBalanceAccrued :one
+-- The accrual of the order is added to the accrued total
 UPDATE balance
-SET accrued = $2
+SET accrued = accrued + $2
 WHERE login = $1 RETURNING accrued, withdrawn;
 
 -- name: UpdateBalanceWithdrawn :one
 UPDATE balance
 SET withdrawn = withdrawn + $2
-WHERE login = $1 RETURNING accrued, withdrawn;
\ No newline at end of file
+WHERE login = $1 RETURNING accrued, withdrawn;
+
+-- name: CreateLot :one
+INSERT INTO lots (login, order_number, amount, remaining, expires_at)
+VALUES ($1, $2, $3, $3, $4) RETURNING id;
+
+-- name: ListLotsForUpdate :many
+SELECT id, login, order_number, amount, remaining, accrued_at, expires_at
+FROM lots
+WHERE login = $1
+  AND remaining > 0
+ORDER BY accrued_at, id
+FOR UPDATE;
+
+-- name: UpdateLotRemaining :exec
+UPDATE lots
+SET remaining = $2
+WHERE id = $1;
+
+-- name: LockBalancesWithDueLots :many
+-- Balances are locked before their lots in the order of logins like in withdrawals, so expiration cannot deadlock with them
+SELECT login
+FROM balance
+WHERE login IN (SELECT login FROM lots WHERE expires_at <= $1 AND remaining > 0)
+ORDER BY login
+    FOR UPDATE;
+
+-- name: ExpireLots :many
+WITH due AS (SELECT id, login, remaining, expires_at
+             FROM lots
+             WHERE expires_at <= $1
+               AND remaining > 0
+             FOR UPDATE SKIP LOCKED),
+     expired_lots AS (UPDATE lots
+                      SET remaining = 0
+                      FROM due
+                      WHERE lots.id = due.id),
+     expirations_entries AS (INSERT INTO expirations (lot_id, login, amount, expired_at)
+                             SELECT id, login, remaining, expires_at
+                             FROM due),
+     totals AS (SELECT login, COUNT(*) AS lots, SUM(remaining) AS amount
+                FROM due
+                GROUP BY login),
+     expired_balance AS (UPDATE balance
+                         SET accrued = balance.accrued - totals.amount
+                         FROM totals
+                         WHERE balance.login = totals.login
+                         RETURNING balance.login, balance.accrued, balance.withdrawn)
+SELECT totals.login,
+       totals.lots::BIGINT             AS lots,
+       totals.amount::DOUBLE PRECISION AS amount,
+       expired_balance.accrued,
+       expired_balance.withdrawn
+FROM totals
+         JOIN expired_balance ON expired_balance.login = totals.login
+ORDER BY totals.login;
+
+-- name: ListUpcomingExpirations :many
+SELECT date_trunc('day', expires_at, 'UTC') AS expires_on, SUM(remaining)::DOUBLE PRECISION AS amount
+FROM lots
+WHERE login = $1
+  AND remaining > 0
+  AND expires_at IS NOT NULL
+GROUP BY expires_on
+ORDER BY expires_on
+LIMIT $2;
+
+-- name: GetAccruedTotal :one
+SELECT COALESCE(SUM(accrual), 0)::DOUBLE PRECISION AS total
+FROM orders
+WHERE login = $1
+  AND status = 'PROCESSED'
+  AND uploaded_at >= $2;
+
+-- name: GetSpentTotal :one
+SELECT COALESCE(SUM(sum), 0)::DOUBLE PRECISION AS total
+FROM withdrawals
+WHERE login = $1
+  AND reversed_at IS NULL
+  AND processed_at >= $2;
+
+-- name: CreateTierChange :execrows
+INSERT INTO tier_history (login, tier, total)
+SELECT $1, $2::VARCHAR, $3
+WHERE $2::VARCHAR IS DISTINCT FROM (SELECT tier
+                                    FROM tier_history
+                                    WHERE login = $1
+                                    ORDER BY changed_at DESC, id DESC LIMIT 1);
+
+-- name: ListTierHistory :many
+SELECT id, login, tier, total, changed_at
+FROM tier_history
+WHERE login = $1
+ORDER BY changed_at DESC, id DESC
+LIMIT $2;
+
+-- name: LockBalances :many
+SELECT id, login, accrued, withdrawn
+FROM balance
+WHERE login = ANY (sqlc.arg(logins)::VARCHAR[])
+ORDER BY login
+FOR UPDATE;
+
+-- name: GetTransferredTotal :one
+SELECT COALESCE(SUM(sum), 0)::DOUBLE PRECISION AS total
+FROM transfers
+WHERE sender = $1
+  AND transferred_at >= $2;
+
+-- name: CreateTransfer :one
+INSERT INTO transfers (sender, recipient, sum)
+VALUES ($1, $2, $3) RETURNING id, sender, recipient, sum, transferred_at;
+
+-- name: ListTransfers :many
+-- The zero limit means no limit.
+SELECT id, sender, recipient, sum, transferred_at
+FROM transfers
+WHERE sender = sqlc.arg(login)
+   OR recipient = sqlc.arg(login)
+ORDER BY transferred_at DESC, id DESC
+LIMIT NULLIF(sqlc.arg(page_limit)::INTEGER, 0) OFFSET sqlc.arg(page_offset)::INTEGER;
+
+-- name: CountTransfers :one
+SELECT COUNT(*)
+FROM transfers
+WHERE sender = $1
+   OR recipient = $1;
+
+-- name: ListPromotionRules :many
+SELECT id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at
+FROM promotion_rules
+ORDER BY id;
+
+-- name: ListEnabledPromotionRules :many
+SELECT id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at
+FROM promotion_rules
+WHERE NOT disabled
+ORDER BY id;
+
+-- name: CreatePromotionRule :one
+INSERT INTO promotion_rules (name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at;
+
+-- name: UpdatePromotionRule :one
+UPDATE promotion_rules
+SET name         = $2,
+    disabled     = $3,
+    starts_at    = $4,
+    ends_at      = $5,
+    weekdays     = $6,
+    first_order  = $7,
+    min_orders   = $8,
+    tier         = $9,
+    bonus_rate   = $10,
+    bonus_points = $11
+WHERE id = $1
+RETURNING id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at;
+
+-- name: DeletePromotionRule :execrows
+DELETE
+FROM promotion_rules
+WHERE id = $1;
+
+-- name: CountProcessedOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE login = $1
+  AND status = 'PROCESSED';
+
+-- name: CreateBonus :one
+INSERT INTO bonuses (login, order_number, rule_id, amount)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_number, rule_id) DO NOTHING
+RETURNING id;
+
+-- name: CreateAuditQueueEntry :exec
+INSERT INTO audit_queue (login, action, old_value, new_value, request_id, ip, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListAuditQueue :many
+SELECT id, login, action, old_value, new_value, request_id, ip, created_at
+FROM audit_queue
+ORDER BY id LIMIT $1;
+
+-- name: DeleteAuditQueueEntries :exec
+DELETE
+FROM audit_queue
+WHERE id = ANY (sqlc.arg(ids)::BIGINT[]);
+
+-- name: LockAuditLog :exec
+-- Only the writer of the audit log takes the lock, changes queue their entries
+SELECT pg_advisory_xact_lock(hashtext('audit_log'));
+
+-- name: GetLastAuditHash :one
+SELECT hash
+FROM audit_log
+ORDER BY id DESC LIMIT 1;
+
+-- name: CreateAuditEntry :one
+INSERT INTO audit_log (login, action, old_value, new_value, request_id, ip, created_at, prev_hash, hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;
+
+-- name: ListAuditEntries :many
+-- Queued entries are not chained yet, they are returned before the audit log entries without hashes.
+SELECT id, login, action, old_value, new_value, request_id, ip, created_at, prev_hash, hash, queued
+FROM (SELECT id, login, action, old_value, new_value, request_id, ip, created_at, prev_hash, hash, FALSE AS queued
+      FROM audit_log
+      UNION ALL
+      SELECT id, login, action, old_value, new_value, request_id, ip, created_at, '' AS prev_hash, '' AS hash, TRUE AS queued
+      FROM audit_queue) AS entries
+WHERE (sqlc.arg(login)::VARCHAR = '' OR login = sqlc.arg(login)::VARCHAR)
+  AND (sqlc.arg(action)::VARCHAR = '' OR action = sqlc.arg(action)::VARCHAR)
+  AND (sqlc.narg(created_from)::TIMESTAMPTZ IS NULL OR created_at >= sqlc.narg(created_from)::TIMESTAMPTZ)
+  AND (sqlc.narg(created_to)::TIMESTAMPTZ IS NULL OR created_at < sqlc.narg(created_to)::TIMESTAMPTZ)
+ORDER BY queued DESC, id DESC
+LIMIT sqlc.arg(row_limit)::INTEGER;
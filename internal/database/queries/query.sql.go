@@ -7,15 +7,211 @@ package queries
 
 import (
 	"context"
+	"time"
 )
 
-const createBalance = `-- name: CreateBalance :one
+const anonymizeUser = `-- name: AnonymizeUser :exec
+WITH anonymized_orders AS (UPDATE orders SET login = $2 WHERE login = $1),
+     anonymized_withdrawals AS (UPDATE withdrawals SET login = $2 WHERE login = $1),
+     anonymized_reversals AS (UPDATE reversals SET login = $2 WHERE login = $1),
+     anonymized_lots AS (UPDATE lots SET login = $2 WHERE login = $1),
+     anonymized_expirations AS (UPDATE expirations SET login = $2 WHERE login = $1),
+     anonymized_tiers AS (UPDATE tier_history SET login = $2 WHERE login = $1),
+     anonymized_bonuses AS (UPDATE bonuses SET login = $2 WHERE login = $1),
+     anonymized_sent AS (UPDATE transfers SET sender = $2 WHERE sender = $1),
+     anonymized_received AS (UPDATE transfers SET recipient = $2 WHERE recipient = $1),
+     anonymized_balance AS (UPDATE balance SET login = $2 WHERE login = $1)
+UPDATE users
+SET login           = $2,
+    password        = '',
+    disabled        = TRUE,
+    session_version = session_version + 1,
+    timezone        = ''
+WHERE login = $1
+`
+
+type AnonymizeUserParams struct {
+	Login     string
+	Pseudonym string
+}
+
+func (q *Queries) AnonymizeUser(ctx context.Context, arg AnonymizeUserParams) error {
+	_, err := q.db.Exec(ctx, anonymizeUser, arg.Login, arg.Pseudonym)
+	return err
+}
+
+const countOrders = `-- name: CountOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE login = $1
+`
+
+func (q *Queries) CountOrders(ctx context.Context, login string) (int64, error) {
+	row := q.db.QueryRow(ctx, countOrders, login)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countProcessedOrders = `-- name: CountProcessedOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE login = $1
+  AND status = 'PROCESSED'
+`
+
+func (q *Queries) CountProcessedOrders(ctx context.Context, login string) (int64, error) {
+	row := q.db.QueryRow(ctx, countProcessedOrders, login)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countTransfers = `-- name: CountTransfers :one
+SELECT COUNT(*)
+FROM transfers
+WHERE sender = $1
+   OR recipient = $1
+`
+
+func (q *Queries) CountTransfers(ctx context.Context, login string) (int64, error) {
+	row := q.db.QueryRow(ctx, countTransfers, login)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countWithdrawals = `-- name: CountWithdrawals :one
+SELECT COUNT(*)
+FROM withdrawals
+WHERE login = $1
+`
+
+func (q *Queries) CountWithdrawals(ctx context.Context, login string) (int64, error) {
+	row := q.db.QueryRow(ctx, countWithdrawals, login)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAuditEntry = `-- name: CreateAuditEntry :one
+INSERT INTO audit_log (login, action, old_value, new_value, request_id, ip, created_at, prev_hash, hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+`
+
+type CreateAuditEntryParams struct {
+	Login     string
+	Action    string
+	OldValue  []byte
+	NewValue  []byte
+	RequestID string
+	Ip        string
+	CreatedAt time.Time
+	PrevHash  string
+	Hash      string
+}
+
+func (q *Queries) CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) (int64, error) {
+	row := q.db.QueryRow(ctx, createAuditEntry,
+		arg.Login,
+		arg.Action,
+		arg.OldValue,
+		arg.NewValue,
+		arg.RequestID,
+		arg.Ip,
+		arg.CreatedAt,
+		arg.PrevHash,
+		arg.Hash,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createAuditQueueEntry = `-- name: CreateAuditQueueEntry :exec
+INSERT INTO audit_queue (login, action, old_value, new_value, request_id, ip, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateAuditQueueEntryParams struct {
+	Login     string
+	Action    string
+	OldValue  []byte
+	NewValue  []byte
+	RequestID string
+	Ip        string
+	CreatedAt time.Time
+}
+
+func (q *Queries) CreateAuditQueueEntry(ctx context.Context, arg CreateAuditQueueEntryParams) error {
+	_, err := q.db.Exec(ctx, createAuditQueueEntry,
+		arg.Login,
+		arg.Action,
+		arg.OldValue,
+		arg.NewValue,
+		arg.RequestID,
+		arg.Ip,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createBalance = `-- name: CreateBalance :exec
 INSERT INTO balance (login)
-VALUES ($1) RETURNING id
+VALUES ($1)
+ON CONFLICT (login) DO NOTHING
+`
+
+func (q *Queries) CreateBalance(ctx context.Context, login string) error {
+	_, err := q.db.Exec(ctx, createBalance, login)
+	return err
+}
+
+const createBonus = `-- name: CreateBonus :one
+INSERT INTO bonuses (login, order_number, rule_id, amount)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_number, rule_id) DO NOTHING
+RETURNING id
 `
 
-func (q *Queries) CreateBalance(ctx context.Context, login string) (int32, error) {
-	row := q.db.QueryRow(ctx, createBalance, login)
+type CreateBonusParams struct {
+	Login       string
+	OrderNumber string
+	RuleID      int32
+	Amount      float64
+}
+
+func (q *Queries) CreateBonus(ctx context.Context, arg CreateBonusParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createBonus,
+		arg.Login,
+		arg.OrderNumber,
+		arg.RuleID,
+		arg.Amount,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createLot = `-- name: CreateLot :one
+INSERT INTO lots (login, order_number, amount, remaining, expires_at)
+VALUES ($1, $2, $3, $3, $4) RETURNING id
+`
+
+type CreateLotParams struct {
+	Login       string
+	OrderNumber string
+	Amount      float64
+	ExpiresAt   *time.Time
+}
+
+func (q *Queries) CreateLot(ctx context.Context, arg CreateLotParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createLot,
+		arg.Login,
+		arg.OrderNumber,
+		arg.Amount,
+		arg.ExpiresAt,
+	)
 	var id int32
 	err := row.Scan(&id)
 	return id, err
@@ -38,9 +234,166 @@ func (q *Queries) CreateOrder(ctx context.Context, arg CreateOrderParams) (int32
 	return id, err
 }
 
+const createOrders = `-- name: CreateOrders :many
+INSERT INTO orders (login, number)
+SELECT $1::VARCHAR, unnest($2::VARCHAR[])
+ON CONFLICT (number) DO NOTHING
+RETURNING number
+`
+
+type CreateOrdersParams struct {
+	Login   string
+	Numbers []string
+}
+
+func (q *Queries) CreateOrders(ctx context.Context, arg CreateOrdersParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, createOrders, arg.Login, arg.Numbers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		items = append(items, number)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const createPromotionRule = `-- name: CreatePromotionRule :one
+INSERT INTO promotion_rules (name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at
+`
+
+type CreatePromotionRuleParams struct {
+	Name        string
+	Disabled    bool
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	Weekdays    []int32
+	FirstOrder  bool
+	MinOrders   int32
+	Tier        string
+	BonusRate   float64
+	BonusPoints float64
+}
+
+func (q *Queries) CreatePromotionRule(ctx context.Context, arg CreatePromotionRuleParams) (PromotionRule, error) {
+	row := q.db.QueryRow(ctx, createPromotionRule,
+		arg.Name,
+		arg.Disabled,
+		arg.StartsAt,
+		arg.EndsAt,
+		arg.Weekdays,
+		arg.FirstOrder,
+		arg.MinOrders,
+		arg.Tier,
+		arg.BonusRate,
+		arg.BonusPoints,
+	)
+	var i PromotionRule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Disabled,
+		&i.StartsAt,
+		&i.EndsAt,
+		&i.Weekdays,
+		&i.FirstOrder,
+		&i.MinOrders,
+		&i.Tier,
+		&i.BonusRate,
+		&i.BonusPoints,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createReversal = `-- name: CreateReversal :one
+INSERT INTO reversals (withdrawal_id, login, order_number, sum, reason, reversed_at)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+`
+
+type CreateReversalParams struct {
+	WithdrawalID int32
+	Login        string
+	OrderNumber  string
+	Sum          float64
+	Reason       string
+	ReversedAt   time.Time
+}
+
+func (q *Queries) CreateReversal(ctx context.Context, arg CreateReversalParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createReversal,
+		arg.WithdrawalID,
+		arg.Login,
+		arg.OrderNumber,
+		arg.Sum,
+		arg.Reason,
+		arg.ReversedAt,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTierChange = `-- name: CreateTierChange :execrows
+INSERT INTO tier_history (login, tier, total)
+SELECT $1, $2::VARCHAR, $3
+WHERE $2::VARCHAR IS DISTINCT FROM (SELECT tier
+                                    FROM tier_history
+                                    WHERE login = $1
+                                    ORDER BY changed_at DESC, id DESC LIMIT 1)
+`
+
+type CreateTierChangeParams struct {
+	Login string
+	Tier  string
+	Total float64
+}
+
+func (q *Queries) CreateTierChange(ctx context.Context, arg CreateTierChangeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createTierChange, arg.Login, arg.Tier, arg.Total)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const createTransfer = `-- name: CreateTransfer :one
+INSERT INTO transfers (sender, recipient, sum)
+VALUES ($1, $2, $3) RETURNING id, sender, recipient, sum, transferred_at
+`
+
+type CreateTransferParams struct {
+	Sender    string
+	Recipient string
+	Sum       float64
+}
+
+func (q *Queries) CreateTransfer(ctx context.Context, arg CreateTransferParams) (Transfer, error) {
+	row := q.db.QueryRow(ctx, createTransfer, arg.Sender, arg.Recipient, arg.Sum)
+	var i Transfer
+	err := row.Scan(
+		&i.ID,
+		&i.Sender,
+		&i.Recipient,
+		&i.Sum,
+		&i.TransferredAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (login, password)
-VALUES ($1, $2) RETURNING id
+INSERT INTO users (login, password, session_version)
+VALUES ($1, $2, 1) RETURNING id, session_version
 `
 
 type CreateUserParams struct {
@@ -48,11 +401,17 @@ type CreateUserParams struct {
 	Password string
 }
 
-func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (int32, error) {
+type CreateUserRow struct {
+	ID             int32
+	SessionVersion int32
+}
+
+// New users start with session version 1, version 0 is left to the accounts created before tokens had user IDs
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
 	row := q.db.QueryRow(ctx, createUser, arg.Login, arg.Password)
-	var id int32
-	err := row.Scan(&id)
-	return id, err
+	var i CreateUserRow
+	err := row.Scan(&i.ID, &i.SessionVersion)
+	return i, err
 }
 
 const createWithdraw = `-- name: CreateWithdraw :one
@@ -73,6 +432,157 @@ func (q *Queries) CreateWithdraw(ctx context.Context, arg CreateWithdrawParams)
 	return id, err
 }
 
+const deleteAuditQueueEntries = `-- name: DeleteAuditQueueEntries :exec
+DELETE
+FROM audit_queue
+WHERE id = ANY ($1::BIGINT[])
+`
+
+func (q *Queries) DeleteAuditQueueEntries(ctx context.Context, ids []int64) error {
+	_, err := q.db.Exec(ctx, deleteAuditQueueEntries, ids)
+	return err
+}
+
+const deletePromotionRule = `-- name: DeletePromotionRule :execrows
+DELETE
+FROM promotion_rules
+WHERE id = $1
+`
+
+func (q *Queries) DeletePromotionRule(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePromotionRule, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUserData = `-- name: DeleteUserData :exec
+WITH deleted_expirations AS (DELETE FROM expirations WHERE login = $1),
+     deleted_lots AS (DELETE FROM lots WHERE login = $1),
+     deleted_reversals AS (DELETE FROM reversals WHERE login = $1),
+     deleted_withdrawals AS (DELETE FROM withdrawals WHERE login = $1),
+     deleted_orders AS (DELETE FROM orders WHERE login = $1),
+     deleted_tiers AS (DELETE FROM tier_history WHERE login = $1),
+     deleted_bonuses AS (DELETE FROM bonuses WHERE login = $1),
+     anonymized_sent AS (UPDATE transfers SET sender = $2 WHERE sender = $1),
+     anonymized_received AS (UPDATE transfers SET recipient = $2 WHERE recipient = $1),
+     deleted_balance AS (DELETE FROM balance WHERE login = $1)
+DELETE
+FROM users
+WHERE login = $1
+`
+
+type DeleteUserDataParams struct {
+	Login     string
+	Pseudonym string
+}
+
+func (q *Queries) DeleteUserData(ctx context.Context, arg DeleteUserDataParams) error {
+	_, err := q.db.Exec(ctx, deleteUserData, arg.Login, arg.Pseudonym)
+	return err
+}
+
+const disableUser = `-- name: DisableUser :execrows
+UPDATE users
+SET disabled        = TRUE,
+    session_version = session_version + 1
+WHERE login = $1
+`
+
+func (q *Queries) DisableUser(ctx context.Context, login string) (int64, error) {
+	result, err := q.db.Exec(ctx, disableUser, login)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const expireLots = `-- name: ExpireLots :many
+WITH due AS (SELECT id, login, remaining, expires_at
+             FROM lots
+             WHERE expires_at <= $1
+               AND remaining > 0
+             FOR UPDATE SKIP LOCKED),
+     expired_lots AS (UPDATE lots
+                      SET remaining = 0
+                      FROM due
+                      WHERE lots.id = due.id),
+     expirations_entries AS (INSERT INTO expirations (lot_id, login, amount, expired_at)
+                             SELECT id, login, remaining, expires_at
+                             FROM due),
+     totals AS (SELECT login, COUNT(*) AS lots, SUM(remaining) AS amount
+                FROM due
+                GROUP BY login),
+     expired_balance AS (UPDATE balance
+                         SET accrued = balance.accrued - totals.amount
+                         FROM totals
+                         WHERE balance.login = totals.login
+                         RETURNING balance.login, balance.accrued, balance.withdrawn)
+SELECT totals.login,
+       totals.lots::BIGINT             AS lots,
+       totals.amount::DOUBLE PRECISION AS amount,
+       expired_balance.accrued,
+       expired_balance.withdrawn
+FROM totals
+         JOIN expired_balance ON expired_balance.login = totals.login
+ORDER BY totals.login
+`
+
+type ExpireLotsRow struct {
+	Login     string
+	Lots      int64
+	Amount    float64
+	Accrued   float64
+	Withdrawn float64
+}
+
+func (q *Queries) ExpireLots(ctx context.Context, expiresAt *time.Time) ([]ExpireLotsRow, error) {
+	rows, err := q.db.Query(ctx, expireLots, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ExpireLotsRow
+	for rows.Next() {
+		var i ExpireLotsRow
+		if err := rows.Scan(
+			&i.Login,
+			&i.Lots,
+			&i.Amount,
+			&i.Accrued,
+			&i.Withdrawn,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAccruedTotal = `-- name: GetAccruedTotal :one
+SELECT COALESCE(SUM(accrual), 0)::DOUBLE PRECISION AS total
+FROM orders
+WHERE login = $1
+  AND status = 'PROCESSED'
+  AND uploaded_at >= $2
+`
+
+type GetAccruedTotalParams struct {
+	Login      string
+	UploadedAt time.Time
+}
+
+func (q *Queries) GetAccruedTotal(ctx context.Context, arg GetAccruedTotalParams) (float64, error) {
+	row := q.db.QueryRow(ctx, getAccruedTotal, arg.Login, arg.UploadedAt)
+	var total float64
+	err := row.Scan(&total)
+	return total, err
+}
+
 const getBalance = `-- name: GetBalance :one
 SELECT id, login, accrued, withdrawn
 FROM balance
@@ -91,6 +601,38 @@ func (q *Queries) GetBalance(ctx context.Context, login string) (Balance, error)
 	return i, err
 }
 
+const getBalanceForUpdate = `-- name: GetBalanceForUpdate :one
+SELECT id, login, accrued, withdrawn
+FROM balance
+WHERE login = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetBalanceForUpdate(ctx context.Context, login string) (Balance, error) {
+	row := q.db.QueryRow(ctx, getBalanceForUpdate, login)
+	var i Balance
+	err := row.Scan(
+		&i.ID,
+		&i.Login,
+		&i.Accrued,
+		&i.Withdrawn,
+	)
+	return i, err
+}
+
+const getLastAuditHash = `-- name: GetLastAuditHash :one
+SELECT hash
+FROM audit_log
+ORDER BY id DESC LIMIT 1
+`
+
+func (q *Queries) GetLastAuditHash(ctx context.Context) (string, error) {
+	row := q.db.QueryRow(ctx, getLastAuditHash)
+	var hash string
+	err := row.Scan(&hash)
+	return hash, err
+}
+
 const getOrder = `-- name: GetOrder :one
 SELECT id, login, number, status, accrual, uploaded_at
 FROM orders
@@ -111,8 +653,47 @@ func (q *Queries) GetOrder(ctx context.Context, number string) (Order, error) {
 	return i, err
 }
 
+const getSpentTotal = `-- name: GetSpentTotal :one
+SELECT COALESCE(SUM(sum), 0)::DOUBLE PRECISION AS total
+FROM withdrawals
+WHERE login = $1
+  AND reversed_at IS NULL
+  AND processed_at >= $2
+`
+
+type GetSpentTotalParams struct {
+	Login       string
+	ProcessedAt time.Time
+}
+
+func (q *Queries) GetSpentTotal(ctx context.Context, arg GetSpentTotalParams) (float64, error) {
+	row := q.db.QueryRow(ctx, getSpentTotal, arg.Login, arg.ProcessedAt)
+	var total float64
+	err := row.Scan(&total)
+	return total, err
+}
+
+const getTransferredTotal = `-- name: GetTransferredTotal :one
+SELECT COALESCE(SUM(sum), 0)::DOUBLE PRECISION AS total
+FROM transfers
+WHERE sender = $1
+  AND transferred_at >= $2
+`
+
+type GetTransferredTotalParams struct {
+	Sender        string
+	TransferredAt time.Time
+}
+
+func (q *Queries) GetTransferredTotal(ctx context.Context, arg GetTransferredTotalParams) (float64, error) {
+	row := q.db.QueryRow(ctx, getTransferredTotal, arg.Sender, arg.TransferredAt)
+	var total float64
+	err := row.Scan(&total)
+	return total, err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, login, password, created_at
+SELECT id, login, password, created_at, disabled, session_version, timezone
 FROM users
 WHERE login = $1 LIMIT 1
 `
@@ -125,19 +706,296 @@ func (q *Queries) GetUser(ctx context.Context, login string) (User, error) {
 		&i.Login,
 		&i.Password,
 		&i.CreatedAt,
+		&i.Disabled,
+		&i.SessionVersion,
+		&i.Timezone,
+	)
+	return i, err
+}
+
+const getUserForUpdate = `-- name: GetUserForUpdate :one
+SELECT id, login, password, created_at, disabled, session_version, timezone
+FROM users
+WHERE login = $1 LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetUserForUpdate(ctx context.Context, login string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserForUpdate, login)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Login,
+		&i.Password,
+		&i.CreatedAt,
+		&i.Disabled,
+		&i.SessionVersion,
+		&i.Timezone,
+	)
+	return i, err
+}
+
+const getWithdrawalForUpdate = `-- name: GetWithdrawalForUpdate :one
+SELECT id, login, order_number, sum, processed_at, reversed_at, reversal_reason
+FROM withdrawals
+WHERE order_number = $1
+ORDER BY processed_at DESC LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetWithdrawalForUpdate(ctx context.Context, orderNumber string) (Withdrawal, error) {
+	row := q.db.QueryRow(ctx, getWithdrawalForUpdate, orderNumber)
+	var i Withdrawal
+	err := row.Scan(
+		&i.ID,
+		&i.Login,
+		&i.OrderNumber,
+		&i.Sum,
+		&i.ProcessedAt,
+		&i.ReversedAt,
+		&i.ReversalReason,
 	)
 	return i, err
 }
 
+const listAuditEntries = `-- name: ListAuditEntries :many
+SELECT id, login, action, old_value, new_value, request_id, ip, created_at, prev_hash, hash, queued
+FROM (SELECT id, login, action, old_value, new_value, request_id, ip, created_at, prev_hash, hash, FALSE AS queued
+      FROM audit_log
+      UNION ALL
+      SELECT id, login, action, old_value, new_value, request_id, ip, created_at, '' AS prev_hash, '' AS hash, TRUE AS queued
+      FROM audit_queue) AS entries
+WHERE ($1::VARCHAR = '' OR login = $1::VARCHAR)
+  AND ($2::VARCHAR = '' OR action = $2::VARCHAR)
+  AND ($3::TIMESTAMPTZ IS NULL OR created_at >= $3::TIMESTAMPTZ)
+  AND ($4::TIMESTAMPTZ IS NULL OR created_at < $4::TIMESTAMPTZ)
+ORDER BY queued DESC, id DESC
+LIMIT $5::INTEGER
+`
+
+type ListAuditEntriesParams struct {
+	Login       string
+	Action      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	RowLimit    int32
+}
+
+type ListAuditEntriesRow struct {
+	ID        int64
+	Login     string
+	Action    string
+	OldValue  []byte
+	NewValue  []byte
+	RequestID string
+	Ip        string
+	CreatedAt time.Time
+	PrevHash  string
+	Hash      string
+	Queued    bool
+}
+
+// Queued entries are not chained yet, they are returned before the audit log entries without hashes.
+func (q *Queries) ListAuditEntries(ctx context.Context, arg ListAuditEntriesParams) ([]ListAuditEntriesRow, error) {
+	rows, err := q.db.Query(ctx, listAuditEntries,
+		arg.Login,
+		arg.Action,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAuditEntriesRow
+	for rows.Next() {
+		var i ListAuditEntriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Action,
+			&i.OldValue,
+			&i.NewValue,
+			&i.RequestID,
+			&i.Ip,
+			&i.CreatedAt,
+			&i.PrevHash,
+			&i.Hash,
+			&i.Queued,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditQueue = `-- name: ListAuditQueue :many
+SELECT id, login, action, old_value, new_value, request_id, ip, created_at
+FROM audit_queue
+ORDER BY id LIMIT $1
+`
+
+func (q *Queries) ListAuditQueue(ctx context.Context, limit int32) ([]AuditQueue, error) {
+	rows, err := q.db.Query(ctx, listAuditQueue, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditQueue
+	for rows.Next() {
+		var i AuditQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Action,
+			&i.OldValue,
+			&i.NewValue,
+			&i.RequestID,
+			&i.Ip,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEnabledPromotionRules = `-- name: ListEnabledPromotionRules :many
+SELECT id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at
+FROM promotion_rules
+WHERE NOT disabled
+ORDER BY id
+`
+
+func (q *Queries) ListEnabledPromotionRules(ctx context.Context) ([]PromotionRule, error) {
+	rows, err := q.db.Query(ctx, listEnabledPromotionRules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PromotionRule
+	for rows.Next() {
+		var i PromotionRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Disabled,
+			&i.StartsAt,
+			&i.EndsAt,
+			&i.Weekdays,
+			&i.FirstOrder,
+			&i.MinOrders,
+			&i.Tier,
+			&i.BonusRate,
+			&i.BonusPoints,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLotsForUpdate = `-- name: ListLotsForUpdate :many
+SELECT id, login, order_number, amount, remaining, accrued_at, expires_at
+FROM lots
+WHERE login = $1
+  AND remaining > 0
+ORDER BY accrued_at, id
+FOR UPDATE
+`
+
+func (q *Queries) ListLotsForUpdate(ctx context.Context, login string) ([]Lot, error) {
+	rows, err := q.db.Query(ctx, listLotsForUpdate, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Lot
+	for rows.Next() {
+		var i Lot
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.OrderNumber,
+			&i.Amount,
+			&i.Remaining,
+			&i.AccruedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listOrders = `-- name: ListOrders :many
 SELECT id, login, number, status, accrual, uploaded_at
 FROM orders
 WHERE login = $1
-ORDER BY uploaded_at DESC
+ORDER BY uploaded_at DESC, id DESC
+LIMIT NULLIF($2::INTEGER, 0) OFFSET $3::INTEGER
+`
+
+type ListOrdersParams struct {
+	Login      string
+	PageLimit  int32
+	PageOffset int32
+}
+
+// The zero limit means no limit.
+func (q *Queries) ListOrders(ctx context.Context, arg ListOrdersParams) ([]Order, error) {
+	rows, err := q.db.Query(ctx, listOrders, arg.Login, arg.PageLimit, arg.PageOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Order
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Number,
+			&i.Status,
+			&i.Accrual,
+			&i.UploadedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrdersByNumbers = `-- name: ListOrdersByNumbers :many
+SELECT id, login, number, status, accrual, uploaded_at
+FROM orders
+WHERE number = ANY ($1::VARCHAR[])
 `
 
-func (q *Queries) ListOrders(ctx context.Context, login string) ([]Order, error) {
-	rows, err := q.db.Query(ctx, listOrders, login)
+func (q *Queries) ListOrdersByNumbers(ctx context.Context, numbers []string) ([]Order, error) {
+	rows, err := q.db.Query(ctx, listOrdersByNumbers, numbers)
 	if err != nil {
 		return nil, err
 	}
@@ -197,15 +1055,219 @@ func (q *Queries) ListOrdersToProcess(ctx context.Context) ([]Order, error) {
 	return items, nil
 }
 
+const listPromotionRules = `-- name: ListPromotionRules :many
+SELECT id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at
+FROM promotion_rules
+ORDER BY id
+`
+
+func (q *Queries) ListPromotionRules(ctx context.Context) ([]PromotionRule, error) {
+	rows, err := q.db.Query(ctx, listPromotionRules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PromotionRule
+	for rows.Next() {
+		var i PromotionRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Disabled,
+			&i.StartsAt,
+			&i.EndsAt,
+			&i.Weekdays,
+			&i.FirstOrder,
+			&i.MinOrders,
+			&i.Tier,
+			&i.BonusRate,
+			&i.BonusPoints,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStuckOrders = `-- name: ListStuckOrders :many
+SELECT id, login, number, status, accrual, uploaded_at
+FROM orders
+WHERE (status = 'NEW' OR status = 'PROCESSING')
+  AND uploaded_at < $1
+ORDER BY uploaded_at
+`
+
+func (q *Queries) ListStuckOrders(ctx context.Context, uploadedAt time.Time) ([]Order, error) {
+	rows, err := q.db.Query(ctx, listStuckOrders, uploadedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Order
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Number,
+			&i.Status,
+			&i.Accrual,
+			&i.UploadedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTierHistory = `-- name: ListTierHistory :many
+SELECT id, login, tier, total, changed_at
+FROM tier_history
+WHERE login = $1
+ORDER BY changed_at DESC, id DESC
+LIMIT $2
+`
+
+type ListTierHistoryParams struct {
+	Login string
+	Limit int32
+}
+
+func (q *Queries) ListTierHistory(ctx context.Context, arg ListTierHistoryParams) ([]TierHistory, error) {
+	rows, err := q.db.Query(ctx, listTierHistory, arg.Login, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TierHistory
+	for rows.Next() {
+		var i TierHistory
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Tier,
+			&i.Total,
+			&i.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTransfers = `-- name: ListTransfers :many
+SELECT id, sender, recipient, sum, transferred_at
+FROM transfers
+WHERE sender = $1
+   OR recipient = $1
+ORDER BY transferred_at DESC, id DESC
+LIMIT NULLIF($2::INTEGER, 0) OFFSET $3::INTEGER
+`
+
+type ListTransfersParams struct {
+	Login      string
+	PageLimit  int32
+	PageOffset int32
+}
+
+// The zero limit means no limit.
+func (q *Queries) ListTransfers(ctx context.Context, arg ListTransfersParams) ([]Transfer, error) {
+	rows, err := q.db.Query(ctx, listTransfers, arg.Login, arg.PageLimit, arg.PageOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transfer
+	for rows.Next() {
+		var i Transfer
+		if err := rows.Scan(
+			&i.ID,
+			&i.Sender,
+			&i.Recipient,
+			&i.Sum,
+			&i.TransferredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUpcomingExpirations = `-- name: ListUpcomingExpirations :many
+SELECT date_trunc('day', expires_at, 'UTC') AS expires_on, SUM(remaining)::DOUBLE PRECISION AS amount
+FROM lots
+WHERE login = $1
+  AND remaining > 0
+  AND expires_at IS NOT NULL
+GROUP BY expires_on
+ORDER BY expires_on
+LIMIT $2
+`
+
+type ListUpcomingExpirationsParams struct {
+	Login string
+	Limit int32
+}
+
+type ListUpcomingExpirationsRow struct {
+	ExpiresOn time.Time
+	Amount    float64
+}
+
+func (q *Queries) ListUpcomingExpirations(ctx context.Context, arg ListUpcomingExpirationsParams) ([]ListUpcomingExpirationsRow, error) {
+	rows, err := q.db.Query(ctx, listUpcomingExpirations, arg.Login, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUpcomingExpirationsRow
+	for rows.Next() {
+		var i ListUpcomingExpirationsRow
+		if err := rows.Scan(&i.ExpiresOn, &i.Amount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listWithdrawals = `-- name: ListWithdrawals :many
-SELECT id, login, order_number, sum, processed_at
+SELECT id, login, order_number, sum, processed_at, reversed_at, reversal_reason
 FROM withdrawals
 WHERE login = $1
-ORDER BY processed_at DESC
+ORDER BY processed_at DESC, id DESC
+LIMIT NULLIF($2::INTEGER, 0) OFFSET $3::INTEGER
 `
 
-func (q *Queries) ListWithdrawals(ctx context.Context, login string) ([]Withdrawal, error) {
-	rows, err := q.db.Query(ctx, listWithdrawals, login)
+type ListWithdrawalsParams struct {
+	Login      string
+	PageLimit  int32
+	PageOffset int32
+}
+
+// The zero limit means no limit.
+func (q *Queries) ListWithdrawals(ctx context.Context, arg ListWithdrawalsParams) ([]Withdrawal, error) {
+	rows, err := q.db.Query(ctx, listWithdrawals, arg.Login, arg.PageLimit, arg.PageOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -219,6 +1281,8 @@ func (q *Queries) ListWithdrawals(ctx context.Context, login string) ([]Withdraw
 			&i.OrderNumber,
 			&i.Sum,
 			&i.ProcessedAt,
+			&i.ReversedAt,
+			&i.ReversalReason,
 		); err != nil {
 			return nil, err
 		}
@@ -230,9 +1294,115 @@ func (q *Queries) ListWithdrawals(ctx context.Context, login string) ([]Withdraw
 	return items, nil
 }
 
+const lockAuditLog = `-- name: LockAuditLog :exec
+SELECT pg_advisory_xact_lock(hashtext('audit_log'))
+`
+
+// Only the writer of the audit log takes the lock, changes queue their entries
+func (q *Queries) LockAuditLog(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockAuditLog)
+	return err
+}
+
+const lockBalances = `-- name: LockBalances :many
+SELECT id, login, accrued, withdrawn
+FROM balance
+WHERE login = ANY ($1::VARCHAR[])
+ORDER BY login
+FOR UPDATE
+`
+
+func (q *Queries) LockBalances(ctx context.Context, logins []string) ([]Balance, error) {
+	rows, err := q.db.Query(ctx, lockBalances, logins)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Balance
+	for rows.Next() {
+		var i Balance
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Accrued,
+			&i.Withdrawn,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockBalancesWithDueLots = `-- name: LockBalancesWithDueLots :many
+SELECT login
+FROM balance
+WHERE login IN (SELECT login FROM lots WHERE expires_at <= $1 AND remaining > 0)
+ORDER BY login
+    FOR UPDATE
+`
+
+// Balances are locked before their lots in the order of logins like in withdrawals, so expiration cannot deadlock with them
+func (q *Queries) LockBalancesWithDueLots(ctx context.Context, expiresAt *time.Time) ([]string, error) {
+	rows, err := q.db.Query(ctx, lockBalancesWithDueLots, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var login string
+		if err := rows.Scan(&login); err != nil {
+			return nil, err
+		}
+		items = append(items, login)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markWithdrawalReversed = `-- name: MarkWithdrawalReversed :one
+UPDATE withdrawals
+SET reversed_at     = NOW(),
+    reversal_reason = $2
+WHERE id = $1 RETURNING reversed_at
+`
+
+type MarkWithdrawalReversedParams struct {
+	ID             int32
+	ReversalReason string
+}
+
+func (q *Queries) MarkWithdrawalReversed(ctx context.Context, arg MarkWithdrawalReversedParams) (*time.Time, error) {
+	row := q.db.QueryRow(ctx, markWithdrawalReversed, arg.ID, arg.ReversalReason)
+	var reversed_at *time.Time
+	err := row.Scan(&reversed_at)
+	return reversed_at, err
+}
+
+const requeueOrder = `-- name: RequeueOrder :execrows
+UPDATE orders
+SET status = 'NEW'
+WHERE number = $1
+  AND status IN ('NEW', 'PROCESSING')
+`
+
+func (q *Queries) RequeueOrder(ctx context.Context, number string) (int64, error) {
+	result, err := q.db.Exec(ctx, requeueOrder, number)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateBalanceAccrued = `-- name: UpdateBalanceAccrued :one
 UPDATE balance
-SET accrued = $2
+SET accrued = accrued + $2
 WHERE login = $1 RETURNING accrued, withdrawn
 `
 
@@ -246,6 +1416,7 @@ type UpdateBalanceAccruedRow struct {
 	Withdrawn float64
 }
 
+// The accrual of the order is added to the accrued total
 func (q *Queries) UpdateBalanceAccrued(ctx context.Context, arg UpdateBalanceAccruedParams) (UpdateBalanceAccruedRow, error) {
 	row := q.db.QueryRow(ctx, updateBalanceAccrued, arg.Login, arg.Accrued)
 	var i UpdateBalanceAccruedRow
@@ -276,6 +1447,22 @@ func (q *Queries) UpdateBalanceWithdrawn(ctx context.Context, arg UpdateBalanceW
 	return i, err
 }
 
+const updateLotRemaining = `-- name: UpdateLotRemaining :exec
+UPDATE lots
+SET remaining = $2
+WHERE id = $1
+`
+
+type UpdateLotRemainingParams struct {
+	ID        int32
+	Remaining float64
+}
+
+func (q *Queries) UpdateLotRemaining(ctx context.Context, arg UpdateLotRemainingParams) error {
+	_, err := q.db.Exec(ctx, updateLotRemaining, arg.ID, arg.Remaining)
+	return err
+}
+
 const updateOrder = `-- name: UpdateOrder :exec
 UPDATE orders
 SET status  = $2,
@@ -293,3 +1480,103 @@ func (q *Queries) UpdateOrder(ctx context.Context, arg UpdateOrderParams) error
 	_, err := q.db.Exec(ctx, updateOrder, arg.Number, arg.Status, arg.Accrual)
 	return err
 }
+
+const updatePromotionRule = `-- name: UpdatePromotionRule :one
+UPDATE promotion_rules
+SET name         = $2,
+    disabled     = $3,
+    starts_at    = $4,
+    ends_at      = $5,
+    weekdays     = $6,
+    first_order  = $7,
+    min_orders   = $8,
+    tier         = $9,
+    bonus_rate   = $10,
+    bonus_points = $11
+WHERE id = $1
+RETURNING id, name, disabled, starts_at, ends_at, weekdays, first_order, min_orders, tier, bonus_rate, bonus_points, created_at
+`
+
+type UpdatePromotionRuleParams struct {
+	ID          int32
+	Name        string
+	Disabled    bool
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	Weekdays    []int32
+	FirstOrder  bool
+	MinOrders   int32
+	Tier        string
+	BonusRate   float64
+	BonusPoints float64
+}
+
+func (q *Queries) UpdatePromotionRule(ctx context.Context, arg UpdatePromotionRuleParams) (PromotionRule, error) {
+	row := q.db.QueryRow(ctx, updatePromotionRule,
+		arg.ID,
+		arg.Name,
+		arg.Disabled,
+		arg.StartsAt,
+		arg.EndsAt,
+		arg.Weekdays,
+		arg.FirstOrder,
+		arg.MinOrders,
+		arg.Tier,
+		arg.BonusRate,
+		arg.BonusPoints,
+	)
+	var i PromotionRule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Disabled,
+		&i.StartsAt,
+		&i.EndsAt,
+		&i.Weekdays,
+		&i.FirstOrder,
+		&i.MinOrders,
+		&i.Tier,
+		&i.BonusRate,
+		&i.BonusPoints,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :one
+UPDATE users
+SET password        = $2,
+    session_version = session_version + 1
+WHERE login = $1 RETURNING session_version
+`
+
+type UpdateUserPasswordParams struct {
+	Login    string
+	Password string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (int32, error) {
+	row := q.db.QueryRow(ctx, updateUserPassword, arg.Login, arg.Password)
+	var session_version int32
+	err := row.Scan(&session_version)
+	return session_version, err
+}
+
+const updateUserTimezone = `-- name: UpdateUserTimezone :execrows
+UPDATE users
+SET timezone = $2
+WHERE login = $1
+`
+
+type UpdateUserTimezoneParams struct {
+	Login    string
+	Timezone string
+}
+
+func (q *Queries) UpdateUserTimezone(ctx context.Context, arg UpdateUserTimezoneParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserTimezone, arg.Login, arg.Timezone)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// RequestIDHeader is the header of request ID, it is accepted from clients and sent to the accrual system.
+	RequestIDHeader = "X-Request-ID"
+
+	// RequestIDAttr is the log attribute of request ID.
+	RequestIDAttr = "request_id"
+
+	// LoginAttr is the log attribute of user login.
+	LoginAttr = "login"
+
+	// maxRequestIDLength is the maximal length of client request ID, longer IDs are replaced.
+	maxRequestIDLength = 100
+)
+
+// loggerContextKey is the context key of request-scoped logger.
+type loggerContextKey struct{}
+
+// WithContext returns a copy of the context with the logger.
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, l)
+}
+
+// FromContext returns the logger of the context, or the default logger, if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return l
+	}
+
+	return slog.Default()
+}
+
+// With returns a copy of the context with the logger enriched by the attributes.
+func With(ctx context.Context, args ...any) context.Context {
+	return WithContext(ctx, FromContext(ctx).With(args...))
+}
+
+// NewRequestID generates new request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of the context with the request ID and the logger enriched by it.
+// The ID is stored with chi key, so middleware.GetReqID returns it too.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, requestID)
+
+	return With(ctx, RequestIDAttr, requestID)
+}
+
+// RequestIDFromContext returns the request ID of the context, it is empty, if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+// RequestID takes the request ID from the header or generates new one, puts it to the context and to the response header.
+// It replaces chi RequestID middleware and must be used before request logger.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := EnsureRequestID(r.Header.Get(RequestIDHeader))
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// EnsureRequestID returns the client request ID, if it is valid, or new request ID.
+func EnsureRequestID(requestID string) string {
+	if !isValidRequestID(requestID) {
+		return NewRequestID()
+	}
+
+	return requestID
+}
+
+// isValidRequestID checks that the client request ID is not too long and has only visible ASCII characters,
+// so it can be safely logged and sent further.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < '!' || requestID[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
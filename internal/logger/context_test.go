@@ -0,0 +1,92 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+
+	"github.com/go-chi/chi/v5/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request-scoped logging", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+		DeferCleanup(func() { slog.SetDefault(defaultLogger) })
+	})
+
+	// serve serves the request with RequestID middleware, the handler logs a message with the context logger
+	serve := func(request *http.Request) (*httptest.ResponseRecorder, string) {
+		var requestID string
+
+		handler := logger.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID = middleware.GetReqID(r.Context())
+			logger.FromContext(logger.With(r.Context(), logger.LoginAttr, "user")).Info("handled")
+		}))
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		return recorder, requestID
+	}
+
+	logged := func() map[string]any {
+		var record map[string]any
+		Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+		return record
+	}
+
+	It("accepts the request ID of the client", func() {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set(logger.RequestIDHeader, "client-request-1")
+
+		recorder, requestID := serve(request)
+
+		Expect(requestID).To(Equal("client-request-1"))
+		Expect(recorder.Header().Get(logger.RequestIDHeader)).To(Equal("client-request-1"))
+		Expect(logged()).To(And(
+			HaveKeyWithValue(logger.RequestIDAttr, "client-request-1"),
+			HaveKeyWithValue(logger.LoginAttr, "user"),
+		))
+	})
+
+	It("generates the request ID, if the client has not sent it", func() {
+		recorder, requestID := serve(httptest.NewRequest(http.MethodGet, "/", nil))
+
+		Expect(requestID).NotTo(BeEmpty())
+		Expect(recorder.Header().Get(logger.RequestIDHeader)).To(Equal(requestID))
+		Expect(logged()).To(HaveKeyWithValue(logger.RequestIDAttr, requestID))
+	})
+
+	DescribeTable("replaces invalid request ID of the client",
+		func(clientRequestID string) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set(logger.RequestIDHeader, clientRequestID)
+
+			_, requestID := serve(request)
+
+			Expect(requestID).NotTo(BeEmpty())
+			Expect(requestID).NotTo(Equal(clientRequestID))
+		},
+		Entry("too long", strings.Repeat("a", 101)),
+		Entry("with spaces", "request 1"),
+		Entry("with non-ASCII characters", "запрос-1"),
+	)
+
+	It("returns the default logger without request context", func() {
+		Expect(logger.FromContext(context.Background())).To(BeIdenticalTo(slog.Default()))
+		Expect(logger.RequestIDFromContext(context.Background())).To(BeEmpty())
+	})
+})
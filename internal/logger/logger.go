@@ -1,17 +1,24 @@
 package logger
 
 import (
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
 
 	"github.com/samber/slog-chi"
 	"go.uber.org/zap"
 	"go.uber.org/zap/exp/zapslog"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// Initialize initializes slog+zap logger.
-func Initialize() error {
+// Initialize initializes slog+zap logger with the encoding, level, sampling and output of the configuration.
+// All records pass through the redaction layer, so secrets never reach the output.
+func Initialize(cfg *config.Config) error {
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "timestamp",
 		LevelKey:       "level",
@@ -25,36 +32,65 @@ func Initialize() error {
 		EncodeDuration: zapcore.StringDurationEncoder,
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
-	config := zap.Config{
-		Level:            zap.NewAtomicLevelAt(zap.InfoLevel),
-		Development:      true,
-		Encoding:         "console",
-		EncoderConfig:    encoderConfig,
-		OutputPaths:      []string{"stdout"},
-		ErrorOutputPaths: []string{"stderr"},
+
+	encoder := zapcore.NewConsoleEncoder(encoderConfig)
+	if cfg.LogEncoding == config.LogEncodingJSON {
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
 	}
 
-	logger, err := config.Build()
+	level, err := zapcore.ParseLevel(cfg.LogLevel)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = logger.Sync() }()
 
-	slog.SetDefault(slog.New(zapslog.NewHandler(logger.Core())))
+	ws := zapcore.AddSync(output(cfg))
+	core := zapcore.NewCore(encoder, ws, level)
+
+	// High-volume messages, like accrual system requests, are sampled by their text.
+	// Only debug and info messages are sampled, repeated warnings and errors are all written.
+	if cfg.LogSamplingInitial > 0 {
+		sampled := zapcore.NewSamplerWithOptions(zapcore.NewCore(encoder, ws, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return level.Enabled(l) && l < zapcore.WarnLevel
+		})), time.Second, cfg.LogSamplingInitial, cfg.LogSamplingThereafter)
+
+		core = zapcore.NewTee(sampled, zapcore.NewCore(encoder, ws, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return level.Enabled(l) && l >= zapcore.WarnLevel
+		})))
+	}
+
+	slog.SetDefault(slog.New(NewRedactHandler(zapslog.NewHandler(core))))
 
 	return nil
 }
 
-// NewRequestLogger creates new slog request logger for the chi router. 
-func NewRequestLogger() func(handler http.Handler) http.Handler {
+// output returns the log output - stdout or the file rotated by size.
+func output(cfg *config.Config) io.Writer {
+	if cfg.LogFile == "" {
+		return zapcore.Lock(os.Stdout)
+	}
+
+	return &lumberjack.Logger{
+		Filename:   cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxBackups: cfg.LogMaxBackups,
+		MaxAge:     cfg.LogMaxAge,
+	}
+}
+
+// NewRequestLogger creates new slog request logger for the chi router.
+// Request ID is logged with the same attribute as in request-scoped logs.
+// Request bodies are logged only on demand, sensitive fields are redacted by the default logger.
+func NewRequestLogger(withRequestBody bool) func(handler http.Handler) http.Handler {
+	slogchi.RequestIDKey = RequestIDAttr
+
 	return slogchi.NewWithConfig(slog.Default(), slogchi.Config{
 		DefaultLevel:     slog.LevelInfo,
 		ClientErrorLevel: slog.LevelWarn,
 		ServerErrorLevel: slog.LevelError,
 
 		WithUserAgent:      false,
-		WithRequestID:      false,
-		WithRequestBody:    false,
+		WithRequestID:      true,
+		WithRequestBody:    withRequestBody,
 		WithRequestHeader:  false,
 		WithResponseBody:   false,
 		WithResponseHeader: false,
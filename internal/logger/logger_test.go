@@ -0,0 +1,92 @@
+package logger_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/config"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Initialize", func() {
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			LogEncoding:           config.LogEncodingJSON,
+			LogLevel:              "warn",
+			LogSamplingThereafter: 100,
+			LogFile:               filepath.Join(GinkgoT().TempDir(), "gophermart.log"),
+			LogMaxSize:            1,
+		}
+
+		defaultLogger := slog.Default()
+		DeferCleanup(func() { slog.SetDefault(defaultLogger) })
+	})
+
+	records := func() []map[string]any {
+		data, err := os.ReadFile(cfg.LogFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		var result []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var record map[string]any
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			result = append(result, record)
+		}
+		return result
+	}
+
+	It("writes redacted JSON records of the level to the file", func() {
+		Expect(logger.Initialize(cfg)).To(Succeed())
+
+		slog.Info("hidden")
+		slog.Warn("login failed", "login", "user", "password", "secret")
+
+		Expect(records()).To(ConsistOf(And(
+			HaveKeyWithValue("message", "login failed"),
+			HaveKeyWithValue("login", "user"),
+			HaveKeyWithValue("password", logger.Redacted),
+		)))
+	})
+
+	It("samples repeated info messages", func() {
+		cfg.LogLevel = "info"
+		cfg.LogSamplingInitial = 2
+
+		Expect(logger.Initialize(cfg)).To(Succeed())
+
+		for range 10 {
+			slog.Info("accrual system request")
+		}
+		slog.Info("order processed")
+
+		Expect(records()).To(HaveLen(3))
+	})
+
+	It("doesn't sample warnings and errors", func() {
+		cfg.LogSamplingInitial = 2
+
+		Expect(logger.Initialize(cfg)).To(Succeed())
+
+		for range 5 {
+			slog.Warn("accrual system is unavailable")
+			slog.Error("orders processing")
+		}
+		slog.Info("hidden")
+
+		Expect(records()).To(HaveLen(10))
+	})
+
+	It("fails on an unknown level", func() {
+		cfg.LogLevel = "verbose"
+
+		Expect(logger.Initialize(cfg)).NotTo(Succeed())
+	})
+})
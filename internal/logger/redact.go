@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces sensitive values in logs.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys, which values are never logged, the keys are compared in lower case.
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"token":         true,
+	"jwt":           true,
+	"secret":        true,
+	"secret_key":    true,
+	"api_key":       true,
+	"x-api-key":     true,
+}
+
+var (
+	// jsonSecretPattern matches JSON string fields like password, old_password or token, e.g. in logged request bodies.
+	jsonSecretPattern = regexp.MustCompile(`(?i)("[^"]*(?:password|token|secret)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+	// jwtPattern matches JSON Web Tokens anywhere in the text.
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+// RedactHandler is a slog handler, which redacts passwords, tokens and other secrets before passing records further.
+type RedactHandler struct {
+	next slog.Handler
+}
+
+// NewRedactHandler creates new redaction layer over the handler.
+func NewRedactHandler(next slog.Handler) *RedactHandler {
+	return &RedactHandler{next: next}
+}
+
+// Enabled reports whether the next handler handles records of the level.
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle redacts the message and the attributes of the record and passes it to the next handler.
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+// WithAttrs returns new handler with the redacted attributes.
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, redactAttr(a))
+	}
+
+	return &RedactHandler{next: h.next.WithAttrs(redacted)}
+}
+
+// WithGroup returns new handler with the group.
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{next: h.next.WithGroup(name)}
+}
+
+// redactAttr redacts the attribute, groups are redacted recursively.
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, ga := range group {
+			redacted = append(redacted, redactAttr(ga))
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindString:
+		return slog.String(a.Key, redactString(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, redactString(err.Error()))
+		}
+	}
+
+	return a
+}
+
+// isSensitiveKey checks if the attribute key names a secret.
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+
+	return sensitiveKeys[key] || strings.Contains(key, "password")
+}
+
+// redactString redacts secret fields of JSON and tokens in the text.
+func redactString(s string) string {
+	s = jsonSecretPattern.ReplaceAllString(s, `$1"`+Redacted+`"`)
+
+	return jwtPattern.ReplaceAllString(s, Redacted)
+}
@@ -0,0 +1,86 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/audit (interfaces: Repository)
+//
+// Generated by this command:
+//
+//	mockgen -destination=internal/mocks/audit/mock_repository.go -package=audit github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/audit Repository
+//
+
+// Package audit is a generated GoMock package.
+package audit
+
+import (
+	context "context"
+	reflect "reflect"
+
+	model "github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AuditChain mocks base method.
+func (m *MockRepository) AuditChain(ctx context.Context, f func(*model.AuditEntry) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuditChain", ctx, f)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AuditChain indicates an expected call of AuditChain.
+func (mr *MockRepositoryMockRecorder) AuditChain(ctx, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuditChain", reflect.TypeOf((*MockRepository)(nil).AuditChain), ctx, f)
+}
+
+// ChainAuditLog mocks base method.
+func (m *MockRepository) ChainAuditLog(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChainAuditLog", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChainAuditLog indicates an expected call of ChainAuditLog.
+func (mr *MockRepositoryMockRecorder) ChainAuditLog(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChainAuditLog", reflect.TypeOf((*MockRepository)(nil).ChainAuditLog), ctx)
+}
+
+// GetAuditEntries mocks base method.
+func (m *MockRepository) GetAuditEntries(ctx context.Context, filter *model.AuditFilter) (model.AuditEntries, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAuditEntries", ctx, filter)
+	ret0, _ := ret[0].(model.AuditEntries)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAuditEntries indicates an expected call of GetAuditEntries.
+func (mr *MockRepositoryMockRecorder) GetAuditEntries(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuditEntries", reflect.TypeOf((*MockRepository)(nil).GetAuditEntries), ctx, filter)
+}
@@ -12,6 +12,7 @@ package balance
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	model "github.com/RomanAgaltsev/ya_gophermart/internal/model"
 	gomock "go.uber.org/mock/gomock"
@@ -55,6 +56,37 @@ func (mr *MockRepositoryMockRecorder) CreateBalance(ctx, user any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBalance", reflect.TypeOf((*MockRepository)(nil).CreateBalance), ctx, user)
 }
 
+// ExpireLots mocks base method.
+func (m *MockRepository) ExpireLots(ctx context.Context, now time.Time) (int64, float64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExpireLots", ctx, now)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(float64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ExpireLots indicates an expected call of ExpireLots.
+func (mr *MockRepositoryMockRecorder) ExpireLots(ctx, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireLots", reflect.TypeOf((*MockRepository)(nil).ExpireLots), ctx, now)
+}
+
+// GetAccruedTotal mocks base method.
+func (m *MockRepository) GetAccruedTotal(ctx context.Context, user *model.User, since time.Time) (float64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccruedTotal", ctx, user, since)
+	ret0, _ := ret[0].(float64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccruedTotal indicates an expected call of GetAccruedTotal.
+func (mr *MockRepositoryMockRecorder) GetAccruedTotal(ctx, user, since any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccruedTotal", reflect.TypeOf((*MockRepository)(nil).GetAccruedTotal), ctx, user, since)
+}
+
 // GetBalance mocks base method.
 func (m *MockRepository) GetBalance(ctx context.Context, user *model.User) (*model.Balance, error) {
 	m.ctrl.T.Helper()
@@ -70,6 +102,21 @@ func (mr *MockRepositoryMockRecorder) GetBalance(ctx, user any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockRepository)(nil).GetBalance), ctx, user)
 }
 
+// GetEnabledPromotionRules mocks base method.
+func (m *MockRepository) GetEnabledPromotionRules(ctx context.Context) (model.PromotionRules, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEnabledPromotionRules", ctx)
+	ret0, _ := ret[0].(model.PromotionRules)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEnabledPromotionRules indicates an expected call of GetEnabledPromotionRules.
+func (mr *MockRepositoryMockRecorder) GetEnabledPromotionRules(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEnabledPromotionRules", reflect.TypeOf((*MockRepository)(nil).GetEnabledPromotionRules), ctx)
+}
+
 // GetListOfOrdersToProcess mocks base method.
 func (m *MockRepository) GetListOfOrdersToProcess(ctx context.Context) (model.Orders, error) {
 	m.ctrl.T.Helper()
@@ -85,33 +132,154 @@ func (mr *MockRepositoryMockRecorder) GetListOfOrdersToProcess(ctx any) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfOrdersToProcess", reflect.TypeOf((*MockRepository)(nil).GetListOfOrdersToProcess), ctx)
 }
 
+// GetListOfTransfers mocks base method.
+func (m *MockRepository) GetListOfTransfers(ctx context.Context, user *model.User, page model.Page) (model.Transfers, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetListOfTransfers", ctx, user, page)
+	ret0, _ := ret[0].(model.Transfers)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetListOfTransfers indicates an expected call of GetListOfTransfers.
+func (mr *MockRepositoryMockRecorder) GetListOfTransfers(ctx, user, page any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfTransfers", reflect.TypeOf((*MockRepository)(nil).GetListOfTransfers), ctx, user, page)
+}
+
 // GetListOfWithdrawals mocks base method.
-func (m *MockRepository) GetListOfWithdrawals(ctx context.Context, user *model.User) (model.Withdrawals, error) {
+func (m *MockRepository) GetListOfWithdrawals(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetListOfWithdrawals", ctx, user)
+	ret := m.ctrl.Call(m, "GetListOfWithdrawals", ctx, user, page)
 	ret0, _ := ret[0].(model.Withdrawals)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetListOfWithdrawals indicates an expected call of GetListOfWithdrawals.
+func (mr *MockRepositoryMockRecorder) GetListOfWithdrawals(ctx, user, page any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfWithdrawals", reflect.TypeOf((*MockRepository)(nil).GetListOfWithdrawals), ctx, user, page)
+}
+
+// GetSpentTotal mocks base method.
+func (m *MockRepository) GetSpentTotal(ctx context.Context, user *model.User, since time.Time) (float64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSpentTotal", ctx, user, since)
+	ret0, _ := ret[0].(float64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetListOfWithdrawals indicates an expected call of GetListOfWithdrawals.
-func (mr *MockRepositoryMockRecorder) GetListOfWithdrawals(ctx, user any) *gomock.Call {
+// GetSpentTotal indicates an expected call of GetSpentTotal.
+func (mr *MockRepositoryMockRecorder) GetSpentTotal(ctx, user, since any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSpentTotal", reflect.TypeOf((*MockRepository)(nil).GetSpentTotal), ctx, user, since)
+}
+
+// GetTierHistory mocks base method.
+func (m *MockRepository) GetTierHistory(ctx context.Context, user *model.User, limit int) (model.TierChanges, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTierHistory", ctx, user, limit)
+	ret0, _ := ret[0].(model.TierChanges)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTierHistory indicates an expected call of GetTierHistory.
+func (mr *MockRepositoryMockRecorder) GetTierHistory(ctx, user, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTierHistory", reflect.TypeOf((*MockRepository)(nil).GetTierHistory), ctx, user, limit)
+}
+
+// GetUpcomingExpirations mocks base method.
+func (m *MockRepository) GetUpcomingExpirations(ctx context.Context, user *model.User, limit int) (model.Expirations, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUpcomingExpirations", ctx, user, limit)
+	ret0, _ := ret[0].(model.Expirations)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUpcomingExpirations indicates an expected call of GetUpcomingExpirations.
+func (mr *MockRepositoryMockRecorder) GetUpcomingExpirations(ctx, user, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUpcomingExpirations", reflect.TypeOf((*MockRepository)(nil).GetUpcomingExpirations), ctx, user, limit)
+}
+
+// ReverseWithdrawal mocks base method.
+func (m *MockRepository) ReverseWithdrawal(ctx context.Context, orderNumber, reason string, expiresAt *time.Time) (*model.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseWithdrawal", ctx, orderNumber, reason, expiresAt)
+	ret0, _ := ret[0].(*model.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReverseWithdrawal indicates an expected call of ReverseWithdrawal.
+func (mr *MockRepositoryMockRecorder) ReverseWithdrawal(ctx, orderNumber, reason, expiresAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseWithdrawal", reflect.TypeOf((*MockRepository)(nil).ReverseWithdrawal), ctx, orderNumber, reason, expiresAt)
+}
+
+// SaveTier mocks base method.
+func (m *MockRepository) SaveTier(ctx context.Context, user *model.User, tier string, total float64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveTier", ctx, user, tier, total)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveTier indicates an expected call of SaveTier.
+func (mr *MockRepositoryMockRecorder) SaveTier(ctx, user, tier, total any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveTier", reflect.TypeOf((*MockRepository)(nil).SaveTier), ctx, user, tier, total)
+}
+
+// Statement mocks base method.
+func (m *MockRepository) Statement(ctx context.Context, user *model.User, from, to time.Time, f func(*model.StatementEntry) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Statement", ctx, user, from, to, f)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Statement indicates an expected call of Statement.
+func (mr *MockRepositoryMockRecorder) Statement(ctx, user, from, to, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Statement", reflect.TypeOf((*MockRepository)(nil).Statement), ctx, user, from, to, f)
+}
+
+// Transfer mocks base method.
+func (m *MockRepository) Transfer(ctx context.Context, transfer *model.Transfer, dailyLimit float64, since time.Time, expiresAt *time.Time) (*model.Transfer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Transfer", ctx, transfer, dailyLimit, since, expiresAt)
+	ret0, _ := ret[0].(*model.Transfer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Transfer indicates an expected call of Transfer.
+func (mr *MockRepositoryMockRecorder) Transfer(ctx, transfer, dailyLimit, since, expiresAt any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfWithdrawals", reflect.TypeOf((*MockRepository)(nil).GetListOfWithdrawals), ctx, user)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transfer", reflect.TypeOf((*MockRepository)(nil).Transfer), ctx, transfer, dailyLimit, since, expiresAt)
 }
 
 // UpdateBalanceAccrued mocks base method.
-func (m *MockRepository) UpdateBalanceAccrued(ctx context.Context, order *model.Order, accrual *model.OrderAccrual) error {
+func (m *MockRepository) UpdateBalanceAccrued(ctx context.Context, order *model.Order, accrual *model.OrderAccrual, bonuses model.BonusesFunc, expiresAt *time.Time) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateBalanceAccrued", ctx, order, accrual)
+	ret := m.ctrl.Call(m, "UpdateBalanceAccrued", ctx, order, accrual, bonuses, expiresAt)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateBalanceAccrued indicates an expected call of UpdateBalanceAccrued.
-func (mr *MockRepositoryMockRecorder) UpdateBalanceAccrued(ctx, order, accrual any) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) UpdateBalanceAccrued(ctx, order, accrual, bonuses, expiresAt any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBalanceAccrued", reflect.TypeOf((*MockRepository)(nil).UpdateBalanceAccrued), ctx, order, accrual)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBalanceAccrued", reflect.TypeOf((*MockRepository)(nil).UpdateBalanceAccrued), ctx, order, accrual, bonuses, expiresAt)
 }
 
 // WithdrawFromBalance mocks base method.
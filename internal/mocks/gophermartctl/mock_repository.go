@@ -0,0 +1,204 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermartctl (interfaces: Repository)
+//
+// Generated by this command:
+//
+//	mockgen -destination=internal/mocks/gophermartctl/mock_repository.go -package=gophermartctl github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermartctl Repository
+//
+
+// Package gophermartctl is a generated GoMock package.
+package gophermartctl
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	model "github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AdjustBalance mocks base method.
+func (m *MockRepository) AdjustBalance(ctx context.Context, user *model.User, delta float64, expiresAt *time.Time) (*model.Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdjustBalance", ctx, user, delta, expiresAt)
+	ret0, _ := ret[0].(*model.Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AdjustBalance indicates an expected call of AdjustBalance.
+func (mr *MockRepositoryMockRecorder) AdjustBalance(ctx, user, delta, expiresAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdjustBalance", reflect.TypeOf((*MockRepository)(nil).AdjustBalance), ctx, user, delta, expiresAt)
+}
+
+// CreateBalance mocks base method.
+func (m *MockRepository) CreateBalance(ctx context.Context, user *model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBalance", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateBalance indicates an expected call of CreateBalance.
+func (mr *MockRepositoryMockRecorder) CreateBalance(ctx, user any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBalance", reflect.TypeOf((*MockRepository)(nil).CreateBalance), ctx, user)
+}
+
+// CreateUser mocks base method.
+func (m *MockRepository) CreateUser(ctx context.Context, user *model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUser", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUser indicates an expected call of CreateUser.
+func (mr *MockRepositoryMockRecorder) CreateUser(ctx, user any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockRepository)(nil).CreateUser), ctx, user)
+}
+
+// DisableUser mocks base method.
+func (m *MockRepository) DisableUser(ctx context.Context, login string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableUser", ctx, login)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableUser indicates an expected call of DisableUser.
+func (mr *MockRepositoryMockRecorder) DisableUser(ctx, login any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableUser", reflect.TypeOf((*MockRepository)(nil).DisableUser), ctx, login)
+}
+
+// GetBalance mocks base method.
+func (m *MockRepository) GetBalance(ctx context.Context, user *model.User) (*model.Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalance", ctx, user)
+	ret0, _ := ret[0].(*model.Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBalance indicates an expected call of GetBalance.
+func (mr *MockRepositoryMockRecorder) GetBalance(ctx, user any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockRepository)(nil).GetBalance), ctx, user)
+}
+
+// GetListOfStuckOrders mocks base method.
+func (m *MockRepository) GetListOfStuckOrders(ctx context.Context, uploadedBefore time.Time) (model.Orders, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetListOfStuckOrders", ctx, uploadedBefore)
+	ret0, _ := ret[0].(model.Orders)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetListOfStuckOrders indicates an expected call of GetListOfStuckOrders.
+func (mr *MockRepositoryMockRecorder) GetListOfStuckOrders(ctx, uploadedBefore any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfStuckOrders", reflect.TypeOf((*MockRepository)(nil).GetListOfStuckOrders), ctx, uploadedBefore)
+}
+
+// GetListOfWithdrawals mocks base method.
+func (m *MockRepository) GetListOfWithdrawals(ctx context.Context, user *model.User, page model.Page) (model.Withdrawals, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetListOfWithdrawals", ctx, user, page)
+	ret0, _ := ret[0].(model.Withdrawals)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetListOfWithdrawals indicates an expected call of GetListOfWithdrawals.
+func (mr *MockRepositoryMockRecorder) GetListOfWithdrawals(ctx, user, page any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfWithdrawals", reflect.TypeOf((*MockRepository)(nil).GetListOfWithdrawals), ctx, user, page)
+}
+
+// RequeueOrder mocks base method.
+func (m *MockRepository) RequeueOrder(ctx context.Context, orderNumber string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequeueOrder", ctx, orderNumber)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RequeueOrder indicates an expected call of RequeueOrder.
+func (mr *MockRepositoryMockRecorder) RequeueOrder(ctx, orderNumber any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequeueOrder", reflect.TypeOf((*MockRepository)(nil).RequeueOrder), ctx, orderNumber)
+}
+
+// ReverseWithdrawal mocks base method.
+func (m *MockRepository) ReverseWithdrawal(ctx context.Context, orderNumber, reason string, expiresAt *time.Time) (*model.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseWithdrawal", ctx, orderNumber, reason, expiresAt)
+	ret0, _ := ret[0].(*model.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReverseWithdrawal indicates an expected call of ReverseWithdrawal.
+func (mr *MockRepositoryMockRecorder) ReverseWithdrawal(ctx, orderNumber, reason, expiresAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseWithdrawal", reflect.TypeOf((*MockRepository)(nil).ReverseWithdrawal), ctx, orderNumber, reason, expiresAt)
+}
+
+// UpdateUserPassword mocks base method.
+func (m *MockRepository) UpdateUserPassword(ctx context.Context, login, password string) (int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserPassword", ctx, login, password)
+	ret0, _ := ret[0].(int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateUserPassword indicates an expected call of UpdateUserPassword.
+func (mr *MockRepositoryMockRecorder) UpdateUserPassword(ctx, login, password any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserPassword", reflect.TypeOf((*MockRepository)(nil).UpdateUserPassword), ctx, login, password)
+}
+
+// WithinTransaction mocks base method.
+func (m *MockRepository) WithinTransaction(ctx context.Context, f func(context.Context) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithinTransaction", ctx, f)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WithinTransaction indicates an expected call of WithinTransaction.
+func (mr *MockRepositoryMockRecorder) WithinTransaction(ctx, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithinTransaction", reflect.TypeOf((*MockRepository)(nil).WithinTransaction), ctx, f)
+}
@@ -56,17 +56,34 @@ func (mr *MockRepositoryMockRecorder) CreateOrder(ctx, order any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrder", reflect.TypeOf((*MockRepository)(nil).CreateOrder), ctx, order)
 }
 
+// CreateOrders mocks base method.
+func (m *MockRepository) CreateOrders(ctx context.Context, login string, numbers []string) ([]string, model.Orders, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOrders", ctx, login, numbers)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(model.Orders)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateOrders indicates an expected call of CreateOrders.
+func (mr *MockRepositoryMockRecorder) CreateOrders(ctx, login, numbers any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrders", reflect.TypeOf((*MockRepository)(nil).CreateOrders), ctx, login, numbers)
+}
+
 // GetListOfOrders mocks base method.
-func (m *MockRepository) GetListOfOrders(ctx context.Context, user *model.User) (model.Orders, error) {
+func (m *MockRepository) GetListOfOrders(ctx context.Context, user *model.User, page model.Page) (model.Orders, int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetListOfOrders", ctx, user)
+	ret := m.ctrl.Call(m, "GetListOfOrders", ctx, user, page)
 	ret0, _ := ret[0].(model.Orders)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // GetListOfOrders indicates an expected call of GetListOfOrders.
-func (mr *MockRepositoryMockRecorder) GetListOfOrders(ctx, user any) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) GetListOfOrders(ctx, user, page any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfOrders", reflect.TypeOf((*MockRepository)(nil).GetListOfOrders), ctx, user)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetListOfOrders", reflect.TypeOf((*MockRepository)(nil).GetListOfOrders), ctx, user, page)
 }
@@ -0,0 +1,101 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/promotion (interfaces: Repository)
+//
+// Generated by this command:
+//
+//	mockgen -destination=internal/mocks/promotion/mock_repository.go -package=promotion github.com/RomanAgaltsev/ya_gophermart/internal/app/gophermart/service/promotion Repository
+//
+
+// Package promotion is a generated GoMock package.
+package promotion
+
+import (
+	context "context"
+	reflect "reflect"
+
+	model "github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreatePromotionRule mocks base method.
+func (m *MockRepository) CreatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePromotionRule", ctx, rule)
+	ret0, _ := ret[0].(*model.PromotionRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreatePromotionRule indicates an expected call of CreatePromotionRule.
+func (mr *MockRepositoryMockRecorder) CreatePromotionRule(ctx, rule any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePromotionRule", reflect.TypeOf((*MockRepository)(nil).CreatePromotionRule), ctx, rule)
+}
+
+// DeletePromotionRule mocks base method.
+func (m *MockRepository) DeletePromotionRule(ctx context.Context, id int32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePromotionRule", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePromotionRule indicates an expected call of DeletePromotionRule.
+func (mr *MockRepositoryMockRecorder) DeletePromotionRule(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePromotionRule", reflect.TypeOf((*MockRepository)(nil).DeletePromotionRule), ctx, id)
+}
+
+// GetPromotionRules mocks base method.
+func (m *MockRepository) GetPromotionRules(ctx context.Context) (model.PromotionRules, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPromotionRules", ctx)
+	ret0, _ := ret[0].(model.PromotionRules)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPromotionRules indicates an expected call of GetPromotionRules.
+func (mr *MockRepositoryMockRecorder) GetPromotionRules(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPromotionRules", reflect.TypeOf((*MockRepository)(nil).GetPromotionRules), ctx)
+}
+
+// UpdatePromotionRule mocks base method.
+func (m *MockRepository) UpdatePromotionRule(ctx context.Context, rule *model.PromotionRule) (*model.PromotionRule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePromotionRule", ctx, rule)
+	ret0, _ := ret[0].(*model.PromotionRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdatePromotionRule indicates an expected call of UpdatePromotionRule.
+func (mr *MockRepositoryMockRecorder) UpdatePromotionRule(ctx, rule any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePromotionRule", reflect.TypeOf((*MockRepository)(nil).UpdatePromotionRule), ctx, rule)
+}
@@ -41,6 +41,34 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AppendAudit mocks base method.
+func (m *MockRepository) AppendAudit(ctx context.Context, login string, action model.AuditAction) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AppendAudit", ctx, login, action)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AppendAudit indicates an expected call of AppendAudit.
+func (mr *MockRepositoryMockRecorder) AppendAudit(ctx, login, action any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendAudit", reflect.TypeOf((*MockRepository)(nil).AppendAudit), ctx, login, action)
+}
+
+// CreateBalance mocks base method.
+func (m *MockRepository) CreateBalance(ctx context.Context, user *model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBalance", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateBalance indicates an expected call of CreateBalance.
+func (mr *MockRepositoryMockRecorder) CreateBalance(ctx, user any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBalance", reflect.TypeOf((*MockRepository)(nil).CreateBalance), ctx, user)
+}
+
 // CreateUser mocks base method.
 func (m *MockRepository) CreateUser(ctx context.Context, user *model.User) error {
 	m.ctrl.T.Helper()
@@ -55,6 +83,20 @@ func (mr *MockRepositoryMockRecorder) CreateUser(ctx, user any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockRepository)(nil).CreateUser), ctx, user)
 }
 
+// DeleteUser mocks base method.
+func (m *MockRepository) DeleteUser(ctx context.Context, login string, anonymize bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUser", ctx, login, anonymize)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUser indicates an expected call of DeleteUser.
+func (mr *MockRepositoryMockRecorder) DeleteUser(ctx, login, anonymize any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockRepository)(nil).DeleteUser), ctx, login, anonymize)
+}
+
 // GetUser mocks base method.
 func (m *MockRepository) GetUser(ctx context.Context, login string) (*model.User, error) {
 	m.ctrl.T.Helper()
@@ -69,3 +111,46 @@ func (mr *MockRepositoryMockRecorder) GetUser(ctx, login any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockRepository)(nil).GetUser), ctx, login)
 }
+
+// UpdateUserPassword mocks base method.
+func (m *MockRepository) UpdateUserPassword(ctx context.Context, login, password string) (int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserPassword", ctx, login, password)
+	ret0, _ := ret[0].(int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateUserPassword indicates an expected call of UpdateUserPassword.
+func (mr *MockRepositoryMockRecorder) UpdateUserPassword(ctx, login, password any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserPassword", reflect.TypeOf((*MockRepository)(nil).UpdateUserPassword), ctx, login, password)
+}
+
+// UpdateUserTimezone mocks base method.
+func (m *MockRepository) UpdateUserTimezone(ctx context.Context, login, timezone string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserTimezone", ctx, login, timezone)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUserTimezone indicates an expected call of UpdateUserTimezone.
+func (mr *MockRepositoryMockRecorder) UpdateUserTimezone(ctx, login, timezone any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserTimezone", reflect.TypeOf((*MockRepository)(nil).UpdateUserTimezone), ctx, login, timezone)
+}
+
+// WithinTransaction mocks base method.
+func (m *MockRepository) WithinTransaction(ctx context.Context, f func(context.Context) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithinTransaction", ctx, f)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WithinTransaction indicates an expected call of WithinTransaction.
+func (mr *MockRepositoryMockRecorder) WithinTransaction(ctx, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithinTransaction", reflect.TypeOf((*MockRepository)(nil).WithinTransaction), ctx, f)
+}
@@ -1,26 +1,58 @@
 package model
 
 import (
-	"fmt"
+	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/RomanAgaltsev/ya_gophermart/internal/database/queries"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/timezone"
 )
 
+// ValidationError is an error of a request field.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+// Error returns the field name followed by the message.
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// DeletedLoginPrefix is a prefix of pseudonyms, which replace logins of deleted users.
+// It cannot be used in logins of new users.
+const DeletedLoginPrefix = "deleted-"
+
 // User is a user structure.
 type User struct {
+	// ID is never reused, tokens are bound to it, so they don't pass for a new account with the same login
+	ID int32 `db:"id" json:"-"`
+
 	Login    string `db:"login" json:"login"`
 	Password string `db:"password" json:"password"`
+	Disabled bool   `db:"disabled" json:"-"`
+
+	// SessionVersion is changed to revoke all issued tokens of the user
+	SessionVersion int32 `db:"session_version" json:"-"`
+
+	// Timezone is the IANA time zone, which times are rendered in for the user, empty means UTC
+	Timezone string `db:"timezone" json:"-"`
 }
 
 // Bind validates user structure.
 func (u *User) Bind(r *http.Request) error {
 	if u.Login == "" {
-		return fmt.Errorf("login is a required field")
+		return &ValidationError{Field: "login", Message: "is a required field"}
+	}
+	if strings.HasPrefix(u.Login, DeletedLoginPrefix) {
+		return &ValidationError{Field: "login", Message: "must not start with " + strconv.Quote(DeletedLoginPrefix)}
 	}
 	if u.Password == "" {
-		return fmt.Errorf("password is a required field")
+		return &ValidationError{Field: "password", Message: "is a required field"}
 	}
 	return nil
 }
@@ -30,6 +62,86 @@ func (u *User) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// PasswordChange is a request to change the user password.
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Bind validates password change structure.
+func (pc *PasswordChange) Bind(r *http.Request) error {
+	if pc.CurrentPassword == "" {
+		return &ValidationError{Field: "current_password", Message: "is a required field"}
+	}
+	if pc.NewPassword == "" {
+		return &ValidationError{Field: "new_password", Message: "is a required field"}
+	}
+	if pc.NewPassword == pc.CurrentPassword {
+		return &ValidationError{Field: "new_password", Message: "must differ from current_password"}
+	}
+	return nil
+}
+
+// TimezonePreference is the time zone, which times are rendered in for the user.
+type TimezonePreference struct {
+	Timezone string `json:"timezone"`
+}
+
+// Bind validates time zone preference structure, the empty time zone resets the preference to UTC.
+func (tp *TimezonePreference) Bind(r *http.Request) error {
+	if _, err := timezone.Load(tp.Timezone); err != nil {
+		return &ValidationError{Field: "timezone", Message: "must be an IANA time zone name, like Europe/Moscow"}
+	}
+	return nil
+}
+
+// Render tunes rendering of time zone preference.
+func (*TimezonePreference) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// AccountDeletion is a request to delete the user account.
+// Deletion must be confirmed explicitly - the data should be exported before.
+type AccountDeletion struct {
+	Password string `json:"password"`
+	Confirm  bool   `json:"confirm"`
+}
+
+// Bind validates account deletion structure.
+func (ad *AccountDeletion) Bind(r *http.Request) error {
+	if ad.Password == "" {
+		return &ValidationError{Field: "password", Message: "is a required field"}
+	}
+	if !ad.Confirm {
+		return &ValidationError{Field: "confirm", Message: "must be true, export the data with GET /api/user/export before the deletion"}
+	}
+	return nil
+}
+
+// UserExport contains all data of the user.
+type UserExport struct {
+	Login       string      `json:"login"`
+	ExportedAt  time.Time   `json:"exported_at"`
+	Balance     *Balance    `json:"balance"`
+	Orders      Orders      `json:"orders"`
+	Withdrawals Withdrawals `json:"withdrawals"`
+	Transfers   Transfers   `json:"transfers"`
+}
+
+// Render tunes rendering of user export, times are rendered in the requested time zone.
+// The balance and the lists are rendered by their own methods.
+func (ue *UserExport) Render(w http.ResponseWriter, r *http.Request) error {
+	ue.ExportedAt = ue.ExportedAt.In(timezone.FromContext(r.Context()))
+	return nil
+}
+
+// Page is a part of a list - no more than Limit items after the first Offset items.
+// The zero Limit means the whole list after the Offset.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
 // Order is an order structure.
 type Order struct {
 	Login      string              `db:"login" json:"-"`
@@ -41,8 +153,49 @@ type Order struct {
 
 type Orders []*Order
 
-// Render tunes rendering of orders.
-func (Orders) Render(w http.ResponseWriter, r *http.Request) error {
+// Render tunes rendering of orders, times are rendered in the requested time zone.
+func (os Orders) Render(w http.ResponseWriter, r *http.Request) error {
+	loc := timezone.FromContext(r.Context())
+	for _, o := range os {
+		o.UploadedAt = o.UploadedAt.In(loc)
+	}
+	return nil
+}
+
+// OrderUpload is an order number upload request structure of API v2.
+type OrderUpload struct {
+	Number string `json:"number"`
+}
+
+// Bind validates order upload structure.
+func (ou *OrderUpload) Bind(r *http.Request) error {
+	if ou.Number == "" {
+		return &ValidationError{Field: "number", Message: "is a required field"}
+	}
+
+	return nil
+}
+
+// OrderUploadStatus is a result status of an order number upload in a batch.
+type OrderUploadStatus string
+
+const (
+	OrderUploadAccepted       OrderUploadStatus = "accepted"
+	OrderUploadDuplicateOwn   OrderUploadStatus = "duplicate-own"
+	OrderUploadDuplicateOther OrderUploadStatus = "duplicate-other"
+	OrderUploadInvalid        OrderUploadStatus = "invalid"
+)
+
+// OrderUploadResult is a result of an order number upload in a batch.
+type OrderUploadResult struct {
+	Number string            `json:"number"`
+	Status OrderUploadStatus `json:"status"`
+}
+
+type OrderUploadResults []*OrderUploadResult
+
+// Render tunes rendering of order upload results.
+func (OrderUploadResults) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
@@ -57,10 +210,39 @@ type OrderAccrual struct {
 type Balance struct {
 	Current   float64 `json:"current"`
 	Withdrawn float64 `json:"withdrawn"`
+
+	Expiring Expirations `json:"expiring,omitempty"`
 }
 
-// Render tunes rendering of balance.
-func (*Balance) Render(w http.ResponseWriter, r *http.Request) error {
+// Expiration is an amount of user points, which expire on the day.
+type Expiration struct {
+	Amount    float64   `json:"amount"`
+	ExpiresOn time.Time `json:"expires_on"`
+}
+
+type Expirations []*Expiration
+
+// ExpiryPolicy defines when accrued points expire.
+type ExpiryPolicy struct {
+	Lifetime time.Duration // Zero lifetime means points never expire
+}
+
+// ExpiresAt returns expiry time of points accrued at the given time, nil means never.
+func (p ExpiryPolicy) ExpiresAt(accruedAt time.Time) *time.Time {
+	if p.Lifetime <= 0 {
+		return nil
+	}
+
+	expiresAt := accruedAt.Add(p.Lifetime)
+	return &expiresAt
+}
+
+// Render tunes rendering of balance, times are rendered in the requested time zone.
+func (b *Balance) Render(w http.ResponseWriter, r *http.Request) error {
+	loc := timezone.FromContext(r.Context())
+	for _, e := range b.Expiring {
+		e.ExpiresOn = e.ExpiresOn.In(loc)
+	}
 	return nil
 }
 
@@ -70,15 +252,31 @@ type Withdrawal struct {
 	OrderNumber string    `db:"order" json:"order"`
 	Sum         float64   `db:"sum" json:"sum"`
 	ProcessedAt time.Time `db:"processed_at" json:"processed_at,omitempty"`
+
+	ReversedAt     *time.Time `db:"reversed_at" json:"reversed_at,omitempty"`
+	ReversalReason string     `db:"reversal_reason" json:"reversal_reason,omitempty"`
+}
+
+// Render tunes rendering of withdrawal, times are rendered in the requested time zone.
+func (w *Withdrawal) Render(_ http.ResponseWriter, r *http.Request) error {
+	loc := timezone.FromContext(r.Context())
+	w.ProcessedAt = w.ProcessedAt.In(loc)
+	w.ReversedAt = inLocation(w.ReversedAt, loc)
+	return nil
+}
+
+// Reversed returns true if the withdrawal has been reversed.
+func (w *Withdrawal) Reversed() bool {
+	return w.ReversedAt != nil
 }
 
 // Bind validates withdrawal structure.
 func (w *Withdrawal) Bind(r *http.Request) error {
 	if w.OrderNumber == "" {
-		return fmt.Errorf("order is a required field")
+		return &ValidationError{Field: "order", Message: "is a required field"}
 	}
-	if w.Sum == 0 {
-		return fmt.Errorf("sum cannot be equal zero")
+	if w.Sum <= 0 {
+		return &ValidationError{Field: "sum", Message: "must be positive"}
 	}
 
 	return nil
@@ -86,7 +284,317 @@ func (w *Withdrawal) Bind(r *http.Request) error {
 
 type Withdrawals []*Withdrawal
 
-// Render tunes rendering of withdrawals.
-func (Withdrawals) Render(w http.ResponseWriter, r *http.Request) error {
+// Render tunes rendering of withdrawals, times are rendered in the requested time zone.
+func (ws Withdrawals) Render(w http.ResponseWriter, r *http.Request) error {
+	for _, withdrawal := range ws {
+		if err := withdrawal.Render(w, r); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// Transfer is a transfer of points between users.
+type Transfer struct {
+	Sender        string    `db:"sender" json:"from"`
+	Recipient     string    `db:"recipient" json:"to"`
+	Sum           float64   `db:"sum" json:"sum"`
+	TransferredAt time.Time `db:"transferred_at" json:"transferred_at,omitempty"`
+}
+
+// Bind validates transfer structure.
+func (t *Transfer) Bind(r *http.Request) error {
+	if t.Recipient == "" {
+		return &ValidationError{Field: "to", Message: "is a required field"}
+	}
+	if t.Sum <= 0 {
+		return &ValidationError{Field: "sum", Message: "must be positive"}
+	}
+
+	return nil
+}
+
+// Render tunes rendering of transfer, times are rendered in the requested time zone.
+func (t *Transfer) Render(w http.ResponseWriter, r *http.Request) error {
+	t.TransferredAt = t.TransferredAt.In(timezone.FromContext(r.Context()))
+	return nil
+}
+
+type Transfers []*Transfer
+
+// Render tunes rendering of transfers, times are rendered in the requested time zone.
+func (ts Transfers) Render(w http.ResponseWriter, r *http.Request) error {
+	for _, t := range ts {
+		if err := t.Render(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StatementEntryType is a type of account statement entry.
+type StatementEntryType string
+
+const (
+	StatementAccrual     StatementEntryType = "accrual"
+	StatementWithdrawal  StatementEntryType = "withdrawal"
+	StatementReversal    StatementEntryType = "reversal"
+	StatementExpiration  StatementEntryType = "expiration"
+	StatementTransferIn  StatementEntryType = "transfer_in"
+	StatementTransferOut StatementEntryType = "transfer_out"
+	StatementBonus       StatementEntryType = "bonus"
+)
+
+// StatementEntry is an account statement entry - an accrual or a withdrawal with running balance.
+type StatementEntry struct {
+	Type        StatementEntryType `json:"type"`
+	OrderNumber string             `json:"order"`
+	Amount      float64            `json:"amount"`
+	Balance     float64            `json:"balance"`
+	ProcessedAt time.Time          `json:"processed_at"`
+}
+
+// Reversal is a withdrawal reversal request structure.
+type Reversal struct {
+	Reason string `json:"reason"`
+}
+
+// Bind validates reversal structure.
+func (rv *Reversal) Bind(r *http.Request) error {
+	if rv.Reason == "" {
+		return &ValidationError{Field: "reason", Message: "is a required field"}
+	}
+	if len(rv.Reason) > 255 {
+		return &ValidationError{Field: "reason", Message: "is too long"}
+	}
+
+	return nil
+}
+
+// Tier is a loyalty tier.
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  float64 `json:"threshold"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// Apply returns the accrual multiplied by the tier multiplier and rounded to hundredths.
+func (t *Tier) Apply(accrual float64) float64 {
+	if t == nil {
+		return accrual
+	}
+
+	return math.Round(accrual*t.Multiplier*100) / 100
+}
+
+// Tiers is a list of loyalty tiers in ascending order of thresholds.
+type Tiers []*Tier
+
+// Find returns the tier reached with the total and the next tier, which is nil for the top tier.
+// The current tier is nil, if the total doesn't reach any tier.
+func (ts Tiers) Find(total float64) (current *Tier, next *Tier) {
+	for _, tier := range ts {
+		if total < tier.Threshold {
+			return current, tier
+		}
+		current = tier
+	}
+
+	return current, nil
+}
+
+// TierChange is a loyalty tier history record.
+type TierChange struct {
+	Tier      string    `json:"tier"`
+	Total     float64   `json:"total"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type TierChanges []*TierChange
+
+// TierStatus is a user loyalty tier with the progress to the next tier.
+type TierStatus struct {
+	Tier       string    `json:"tier"`
+	Multiplier float64   `json:"multiplier"`
+	Basis      string    `json:"basis"`
+	Total      float64   `json:"total"`
+	Since      time.Time `json:"since"`
+
+	NextTier      string  `json:"next_tier,omitempty"`
+	NextThreshold float64 `json:"next_threshold,omitempty"`
+	Remaining     float64 `json:"remaining,omitempty"`
+
+	History TierChanges `json:"history,omitempty"`
+}
+
+// Render tunes rendering of tier status, times are rendered in the requested time zone.
+func (ts *TierStatus) Render(w http.ResponseWriter, r *http.Request) error {
+	loc := timezone.FromContext(r.Context())
+	ts.Since = ts.Since.In(loc)
+	for _, change := range ts.History {
+		change.ChangedAt = change.ChangedAt.In(loc)
+	}
+	return nil
+}
+
+// PromotionRule is a promotion rule, which gives bonus points for processed orders.
+// An order gets the bonus, if it meets all set conditions of the rule.
+type PromotionRule struct {
+	ID       int32  `json:"id"`
+	Name     string `json:"name"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	// Conditions
+	StartsAt   *time.Time     `json:"starts_at,omitempty"`   // Orders uploaded before are not rewarded
+	EndsAt     *time.Time     `json:"ends_at,omitempty"`     // Orders uploaded since are not rewarded
+	Weekdays   []time.Weekday `json:"weekdays,omitempty"`    // Days of week, when orders are uploaded, 0 is Sunday
+	FirstOrder bool           `json:"first_order,omitempty"` // The order is the first processed order of the user
+	MinOrders  int32          `json:"min_orders,omitempty"`  // Number of processed orders the user has had before
+	Tier       string         `json:"tier,omitempty"`        // Loyalty tier of the user
+
+	// Actions
+	BonusRate   float64 `json:"bonus_rate,omitempty"`   // Share of the order accrual given as bonus, 1 doubles the accrual
+	BonusPoints float64 `json:"bonus_points,omitempty"` // Fixed bonus
+}
+
+// Bind validates promotion rule structure.
+func (pr *PromotionRule) Bind(r *http.Request) error {
+	if pr.Name == "" {
+		return &ValidationError{Field: "name", Message: "is a required field"}
+	}
+	if len(pr.Name) > 100 {
+		return &ValidationError{Field: "name", Message: "is too long"}
+	}
+	if pr.StartsAt != nil && pr.EndsAt != nil && !pr.EndsAt.After(*pr.StartsAt) {
+		return &ValidationError{Field: "ends_at", Message: "must be after starts_at"}
+	}
+	for _, weekday := range pr.Weekdays {
+		if weekday < time.Sunday || weekday > time.Saturday {
+			return &ValidationError{Field: "weekdays", Message: "must be from 0 to 6"}
+		}
+	}
+	if pr.MinOrders < 0 {
+		return &ValidationError{Field: "min_orders", Message: "cannot be negative"}
+	}
+	if pr.BonusRate < 0 || pr.BonusPoints < 0 {
+		return &ValidationError{Field: "bonus_rate", Message: "and bonus_points cannot be negative"}
+	}
+	if pr.BonusRate == 0 && pr.BonusPoints == 0 {
+		return &ValidationError{Field: "bonus_rate", Message: "or bonus_points is required"}
+	}
+
+	return nil
+}
+
+// Render tunes rendering of promotion rule, times are rendered in the requested time zone.
+func (pr *PromotionRule) Render(w http.ResponseWriter, r *http.Request) error {
+	loc := timezone.FromContext(r.Context())
+	pr.StartsAt = inLocation(pr.StartsAt, loc)
+	pr.EndsAt = inLocation(pr.EndsAt, loc)
+	return nil
+}
+
+type PromotionRules []*PromotionRule
+
+// Render tunes rendering of promotion rules, times are rendered in the requested time zone.
+func (prs PromotionRules) Render(w http.ResponseWriter, r *http.Request) error {
+	for _, pr := range prs {
+		if err := pr.Render(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bonus is bonus points given for an order by a promotion rule.
+type Bonus struct {
+	RuleID      int32   `json:"rule_id"`
+	OrderNumber string  `json:"order"`
+	Amount      float64 `json:"amount"`
+}
+
+type Bonuses []*Bonus
+
+// BonusesFunc evaluates bonuses of an order by the number of processed orders the user has had before the order.
+type BonusesFunc func(previousOrders int64) Bonuses
+
+// AuditAction is an action written to the audit log.
+type AuditAction string
+
+const (
+	AuditUserRegister       AuditAction = "user.register"
+	AuditUserLogin          AuditAction = "user.login"
+	AuditUserLoginFailed    AuditAction = "user.login_failed"
+	AuditUserDisable        AuditAction = "user.disable"
+	AuditUserPasswordChange AuditAction = "user.password_change"
+	AuditUserDelete         AuditAction = "user.delete"
+	AuditBalanceAccrual     AuditAction = "balance.accrual"
+	AuditBalanceBonus       AuditAction = "balance.bonus"
+	AuditBalanceWithdraw    AuditAction = "balance.withdraw"
+	AuditBalanceReversal    AuditAction = "balance.reversal"
+	AuditBalanceAdjust      AuditAction = "balance.adjust"
+	AuditBalanceExpiration  AuditAction = "balance.expiration"
+	AuditBalanceTransferOut AuditAction = "balance.transfer_out"
+	AuditBalanceTransferIn  AuditAction = "balance.transfer_in"
+)
+
+// AuditEntry is an audit log entry.
+// Every entry contains the hash of the previous one, so a changed or removed entry breaks the chain.
+// A queued entry is not chained yet, it has no ID and hashes.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Login     string          `json:"login"`
+	Action    AuditAction     `json:"action"`
+	OldValue  json.RawMessage `json:"old_value,omitempty"`
+	NewValue  json.RawMessage `json:"new_value,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+	Queued    bool            `json:"queued,omitempty"`
+}
+
+type AuditEntries []*AuditEntry
+
+// Render tunes rendering of audit log entries, times are rendered in the requested time zone.
+// Hashes don't depend on the time zone - they are calculated over times in UTC.
+func (aes AuditEntries) Render(w http.ResponseWriter, r *http.Request) error {
+	loc := timezone.FromContext(r.Context())
+	for _, entry := range aes {
+		entry.CreatedAt = entry.CreatedAt.In(loc)
+	}
+	return nil
+}
+
+// AuditFilter selects audit log entries, empty fields do not filter.
+// Entries are selected by creation time in the period [From, To).
+type AuditFilter struct {
+	Login  string
+	Action AuditAction
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+}
+
+// AuditVerification is a result of the audit log hash chain verification.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	BrokenID int64  `json:"broken_id,omitempty"` // The first entry, which does not match the chain
+	LastHash string `json:"last_hash,omitempty"` // Can be saved outside to detect removal of the last entries
+}
+
+// Render tunes rendering of audit log verification result.
+func (*AuditVerification) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// inLocation returns the optional time in the location.
+func inLocation(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(loc)
+	return &local
+}
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxRequestIDLength is the length of request ID column, client request IDs are cut to it.
+const maxRequestIDLength = 100
+
+// GenesisHash is the previous hash of the first audit log entry.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Source is the source of an audited action.
+type Source struct {
+	RequestID string
+	IP        string
+}
+
+// sourceContextKey is the context key of the action source.
+type sourceContextKey struct{}
+
+// WithSource returns a copy of the context with the action source.
+func WithSource(ctx context.Context, source Source) context.Context {
+	if len(source.RequestID) > maxRequestIDLength {
+		source.RequestID = source.RequestID[:maxRequestIDLength]
+	}
+	return context.WithValue(ctx, sourceContextKey{}, source)
+}
+
+// SourceFromContext returns the action source from the context.
+// Actions of background processing have no source.
+func SourceFromContext(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceContextKey{}).(Source)
+	return source
+}
+
+// Middleware puts the request ID and the client IP to the request context.
+// It must be used after chi RequestID middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithSource(r.Context(), Source{
+			RequestID: middleware.GetReqID(r.Context()),
+			IP:        HostIP(r.RemoteAddr),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// HostIP returns the host part of the address.
+func HostIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Hash returns the hash of the entry, which includes the hash of the previous entry.
+// Creation time is hashed in UTC with microseconds - as it is stored in the database.
+func Hash(entry *model.AuditEntry) string {
+	h := sha256.New()
+	for _, field := range []string{
+		entry.PrevHash,
+		entry.Login,
+		string(entry.Action),
+		string(entry.OldValue),
+		string(entry.NewValue),
+		entry.RequestID,
+		entry.IP,
+		entry.CreatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+	} {
+		// Fields are separated with zero byte, which cannot be stored in text columns
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Chain checks audit log entries one by one in the order they were appended.
+type Chain struct {
+	lastHash string
+}
+
+// NewChain creates new chain, which starts from the first entry.
+func NewChain() *Chain {
+	return &Chain{lastHash: GenesisHash}
+}
+
+// Append checks if the entry follows the previous one and has not been changed.
+func (c *Chain) Append(entry *model.AuditEntry) bool {
+	if entry.PrevHash != c.lastHash || Hash(entry) != entry.Hash {
+		return false
+	}
+	c.lastHash = entry.Hash
+
+	return true
+}
+
+// LastHash returns the hash of the last checked entry.
+func (c *Chain) LastHash() string {
+	return c.lastHash
+}
@@ -0,0 +1,93 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/audit"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+var _ = Describe("Audit", func() {
+	var entries model.AuditEntries
+
+	BeforeEach(func() {
+		createdAt := time.Date(2026, 10, 19, 12, 0, 0, 123456000, time.UTC)
+
+		entries = model.AuditEntries{
+			{ID: 1, Login: "user", Action: model.AuditUserRegister, CreatedAt: createdAt},
+			{ID: 2, Login: "user", Action: model.AuditBalanceWithdraw, CreatedAt: createdAt,
+				OldValue:  json.RawMessage(`{"current":100,"withdrawn":0}`),
+				NewValue:  json.RawMessage(`{"current":50,"withdrawn":50,"order":"2377225624","sum":50}`),
+				RequestID: "host/abc-000001", IP: "127.0.0.1"},
+			{ID: 3, Login: "user", Action: model.AuditUserLogin, CreatedAt: createdAt},
+		}
+
+		// Chain the entries
+		prevHash := audit.GenesisHash
+		for _, entry := range entries {
+			entry.PrevHash = prevHash
+			entry.Hash = audit.Hash(entry)
+			prevHash = entry.Hash
+		}
+	})
+
+	verify := func() int64 {
+		chain := audit.NewChain()
+		for _, entry := range entries {
+			if !chain.Append(entry) {
+				return entry.ID
+			}
+		}
+		return 0
+	}
+
+	It("accepts the untouched chain", func() {
+		Expect(verify()).To(BeZero())
+	})
+
+	It("detects a changed value", func() {
+		entries[1].NewValue = json.RawMessage(`{"current":500,"withdrawn":50,"order":"2377225624","sum":50}`)
+		Expect(verify()).To(Equal(int64(2)))
+	})
+
+	It("detects a removed entry", func() {
+		entries = append(entries[:1], entries[2:]...)
+		Expect(verify()).To(Equal(int64(3)))
+	})
+
+	It("detects a rehashed entry", func() {
+		entries[1].Login = "another"
+		entries[1].Hash = audit.Hash(entries[1])
+		Expect(verify()).To(Equal(int64(3)))
+	})
+
+	It("hashes the creation time as it is stored", func() {
+		local := *entries[0]
+		local.CreatedAt = entries[0].CreatedAt.In(time.FixedZone("MSK", 3*60*60)).Add(999 * time.Nanosecond)
+		Expect(audit.Hash(&local)).To(Equal(entries[0].Hash))
+	})
+
+	It("puts the request source to the context", func() {
+		var source audit.Source
+		handler := middleware.RequestID(audit.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			source = audit.SourceFromContext(r.Context())
+		})))
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = "192.0.2.1:54321"
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		Expect(source.IP).To(Equal("192.0.2.1"))
+		Expect(source.RequestID).NotTo(BeEmpty())
+		Expect(audit.SourceFromContext(context.Background())).To(Equal(audit.Source{}))
+	})
+})
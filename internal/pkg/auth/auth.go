@@ -1,10 +1,15 @@
 package auth
 
 import (
+    "context"
+    "crypto/subtle"
     "fmt"
     "net/http"
 
+    "github.com/RomanAgaltsev/ya_gophermart/internal/logger"
     "github.com/RomanAgaltsev/ya_gophermart/internal/model"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+    "github.com/RomanAgaltsev/ya_gophermart/internal/pkg/timezone"
 
     "github.com/go-chi/jwtauth/v5"
     "github.com/lestrrat-go/jwx/v2/jwt"
@@ -28,6 +33,15 @@ const (
 
     // UserLoginClaimName contains key name of user login in a context.
     UserLoginClaimName UserLogin = "login"
+
+    // UserIDClaimName contains key name of user ID in a token.
+    UserIDClaimName = "uid"
+
+    // SessionVersionClaimName contains key name of user session version in a token.
+    SessionVersionClaimName = "session"
+
+    // APIKeyHeader contains name of the header with partner API key.
+    APIKeyHeader = "X-API-Key"
 )
 
 var ErrInvalidUser = fmt.Errorf("absent or invalid user in request")
@@ -37,18 +51,31 @@ func NewAuth(secretKey string) *jwtauth.JWTAuth {
     return jwtauth.New(JWTSignAlgorithm, []byte(secretKey), nil)
 }
 
-// NewJWTToken creates new JWT token.
-func NewJWTToken(ja *jwtauth.JWTAuth, login string) (token jwt.Token, tokenString string, err error) {
-    return ja.Encode(map[string]interface{}{string(UserLoginClaimName): login})
+// NewJWTToken creates new JWT token of the user.
+// Token is valid until the user session version is changed or the user account is deleted.
+func NewJWTToken(ja *jwtauth.JWTAuth, user *model.User) (token jwt.Token, tokenString string, err error) {
+    return ja.Encode(map[string]interface{}{
+        string(UserLoginClaimName): user.Login,
+        UserIDClaimName:            user.ID,
+        SessionVersionClaimName:    user.SessionVersion,
+    })
 }
 
 // NewCookieWithDefaults creates new cookie with defaults and parameter value.
 func NewCookieWithDefaults(value string) *http.Cookie {
+    return NewCookie(value, false)
+}
+
+// NewCookie creates new cookie with defaults and parameter value.
+// The cookie is always hidden from scripts and is sent only over TLS when secure is true.
+func NewCookie(value string, secure bool) *http.Cookie {
     return &http.Cookie{
         Name:     DefaultCookieName,
         Value:    value,
         Path:     DefaultCookiePath,
         MaxAge:   DefaultCookieMaxAge,
+        Secure:   secure,
+        HttpOnly: true,
         SameSite: http.SameSiteDefaultMode,
     }
 }
@@ -67,15 +94,21 @@ func CheckPasswordHash(password, hash string) bool {
 
 // UserFromRequest extracts user (login) from the given HTTP request.
 func UserFromRequest(r *http.Request, secretKey string) (*model.User, error) {
-    // Create new JWT auth
-    ja := NewAuth(secretKey)
-
     // Get JWT token from the cookie
     tokenString := jwtauth.TokenFromCookie(r)
+
+    return UserFromToken(tokenString, secretKey)
+}
+
+// UserFromToken extracts user (login) from the given JWT token string.
+func UserFromToken(tokenString string, secretKey string) (*model.User, error) {
     if tokenString == "" {
         return nil, ErrInvalidUser
     }
 
+    // Create new JWT auth
+    ja := NewAuth(secretKey)
+
     // Decode token string
     token, err := ja.Decode(tokenString)
     if err != nil {
@@ -97,8 +130,97 @@ func UserFromRequest(r *http.Request, secretKey string) (*model.User, error) {
         return nil, ErrInvalidUser
     }
 
+    // Tokens issued before user IDs have the zero ID, which no user has
+    userID, err := int32Claim(claims, UserIDClaimName)
+    if err != nil {
+        return nil, err
+    }
+
+    // Tokens issued before session versions have the zero version
+    sessionVersion, err := int32Claim(claims, SessionVersionClaimName)
+    if err != nil {
+        return nil, err
+    }
+
     // Return user structure
     return &model.User{
-        Login: login,
+        ID:             userID,
+        Login:          login,
+        SessionVersion: sessionVersion,
     }, nil
 }
+
+// int32Claim returns the numeric claim of the token, an absent claim is zero.
+func int32Claim(claims map[string]interface{}, name string) (int32, error) {
+    valueInterface, ok := claims[name]
+    if !ok {
+        return 0, nil
+    }
+
+    // Numbers are decoded from JSON as float64
+    value, ok := valueInterface.(float64)
+    if !ok {
+        return 0, ErrInvalidUser
+    }
+
+    return int32(value), nil
+}
+
+// Authenticator is a middleware, which lets through only requests with a verified JWT token.
+// It must be used after JWT verifier.
+func Authenticator(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token, _, err := jwtauth.FromContext(r.Context())
+        if err != nil || token == nil {
+            problem.Write(w, r, problem.ErrUnauthorized)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// SessionValidator checks if the user session hasn't been revoked.
+type SessionValidator interface {
+    ValidateSession(ctx context.Context, user *model.User) error
+}
+
+// SessionAuthenticator returns a middleware, which lets through only requests with a valid user session.
+// The login is added to the request-scoped logger, times are rendered in the time zone chosen by the user.
+// It must be used after JWT authenticator.
+func SessionAuthenticator(secretKey string, validator SessionValidator) func(next http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            usr, err := UserFromRequest(r, secretKey)
+            if err == nil {
+                err = validator.ValidateSession(r.Context(), usr)
+            }
+            if err != nil {
+                problem.Write(w, r, problem.ErrUnauthorized)
+                return
+            }
+
+            ctx := logger.With(r.Context(), logger.LoginAttr, usr.Login)
+
+            // The time zone has been checked, when the user chose it
+            if loc, err := timezone.Load(usr.Timezone); err == nil {
+                ctx = timezone.WithLocation(ctx, loc)
+            }
+
+            next.ServeHTTP(w, r.WithContext(ctx))
+        })
+    }
+}
+
+// APIKeyAuthenticator returns a middleware, which lets through only requests with the given API key.
+func APIKeyAuthenticator(apiKey string) func(next http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            key := r.Header.Get(APIKeyHeader)
+            if apiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) != 1 {
+                problem.Write(w, r, problem.ErrUnauthorized)
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
@@ -1,10 +1,13 @@
 package auth_test
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 
 	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
 	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/auth"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/timezone"
 
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/lestrrat-go/jwx/v2/jwt"
@@ -32,7 +35,7 @@ var _ = Describe("Auth", func() {
 			})
 
 			It("can create new valid JWT token", func() {
-				token, tokenString, err := auth.NewJWTToken(ja, login)
+				token, tokenString, err := auth.NewJWTToken(ja, &model.User{Login: login})
 				Expect(err).NotTo(HaveOccurred())
 				Expect(tokenString).NotTo(BeEmpty())
 
@@ -48,7 +51,7 @@ var _ = Describe("Auth", func() {
 			})
 
 			It("cannot create new JWT token", func() {
-				token, tokenString, err := auth.NewJWTToken(ja, login)
+				token, tokenString, err := auth.NewJWTToken(ja, &model.User{Login: login})
 				Expect(err).To(HaveOccurred())
 				Expect(tokenString).To(BeEmpty())
 
@@ -94,6 +97,22 @@ var _ = Describe("Auth", func() {
 				Expect(cookie.SameSite).To(Equal(http.SameSiteDefaultMode))
 			})
 		})
+
+		Context("When the server is serving over TLS", func() {
+			It("creates secure cookie hidden from scripts", func() {
+				cookie = auth.NewCookie("value", true)
+				Expect(cookie.Secure).To(BeTrue())
+				Expect(cookie.HttpOnly).To(BeTrue())
+			})
+		})
+
+		Context("When the server is serving cleartext HTTP", func() {
+			It("creates non-secure cookie hidden from scripts", func() {
+				cookie = auth.NewCookie("value", false)
+				Expect(cookie.Secure).To(BeFalse())
+				Expect(cookie.HttpOnly).To(BeTrue())
+			})
+		})
 	})
 
 	Describe("Hashing password", func() {
@@ -167,7 +186,7 @@ var _ = Describe("Auth", func() {
 			ja = auth.NewAuth(secretKeyEnc)
 			Expect(ja).ShouldNot(BeNil())
 
-			_, tokenString, err = auth.NewJWTToken(ja, login)
+			_, tokenString, err = auth.NewJWTToken(ja, &model.User{ID: 5, Login: login, SessionVersion: 3})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(tokenString).NotTo(BeEmpty())
 
@@ -188,7 +207,9 @@ var _ = Describe("Auth", func() {
 			It("can get user from request", func() {
 				user, err = auth.UserFromRequest(request, secretKey)
 				Expect(err).NotTo(HaveOccurred())
+				Expect(user.ID).To(Equal(int32(5)))
 				Expect(user.Login).To(Equal(login))
+				Expect(user.SessionVersion).To(Equal(int32(3)))
 			})
 		})
 
@@ -217,4 +238,101 @@ var _ = Describe("Auth", func() {
 			})
 		})
 	})
+
+	Describe("Authenticating partner requests with API key", func() {
+		var handler http.Handler
+
+		serve := func(key string) int {
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			if key != "" {
+				request.Header.Set(auth.APIKeyHeader, key)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			return recorder.Code
+		}
+
+		BeforeEach(func() {
+			handler = auth.APIKeyAuthenticator("partner key")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+		})
+
+		It("lets through requests with the right key", func() {
+			Expect(serve("partner key")).To(Equal(http.StatusOK))
+		})
+
+		It("rejects requests with a wrong key", func() {
+			Expect(serve("wrong key")).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects requests without a key", func() {
+			Expect(serve("")).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects all requests when the key is not configured", func() {
+			handler = auth.APIKeyAuthenticator("")(handler)
+			Expect(serve("")).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("Authenticating requests with user session", func() {
+		const secretKey = "secret"
+
+		var handler http.Handler
+
+		serve := func(sessionVersion int32) int {
+			_, tokenString, err := auth.NewJWTToken(auth.NewAuth(secretKey), &model.User{Login: "user", SessionVersion: sessionVersion})
+			Expect(err).NotTo(HaveOccurred())
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.AddCookie(auth.NewCookieWithDefaults(tokenString))
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			return recorder.Code
+		}
+
+		BeforeEach(func() {
+			handler = auth.SessionAuthenticator(secretKey, sessionValidator{current: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+		})
+
+		It("lets through requests of the current session", func() {
+			Expect(serve(2)).To(Equal(http.StatusOK))
+		})
+
+		It("rejects requests of revoked sessions", func() {
+			Expect(serve(1)).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("renders times in the time zone chosen by the user", func() {
+			var location string
+			handler = auth.SessionAuthenticator(secretKey, sessionValidator{current: 2, timezone: "Europe/Moscow"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				location = timezone.FromContext(r.Context()).String()
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			Expect(serve(2)).To(Equal(http.StatusOK))
+			Expect(location).To(Equal("Europe/Moscow"))
+		})
+	})
 })
+
+// sessionValidator accepts sessions of the current version only and sets the time zone of the user.
+type sessionValidator struct {
+	current  int32
+	timezone string
+}
+
+func (v sessionValidator) ValidateSession(ctx context.Context, user *model.User) error {
+	if user.SessionVersion != v.current {
+		return auth.ErrInvalidUser
+	}
+	user.Timezone = v.timezone
+	return nil
+}
@@ -0,0 +1,229 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+const msgResponseValidation = "response validation"
+
+func init() {
+	// Statements are streamed as CSV or JSON Lines, their bodies are checked as strings
+	openapi3filter.RegisterBodyDecoder("text/csv", openapi3filter.FileBodyDecoder)
+	openapi3filter.RegisterBodyDecoder("application/jsonl", openapi3filter.FileBodyDecoder)
+}
+
+// Validator validates requests and, optionally, responses against the OpenAPI document.
+type Validator struct {
+	doc    *openapi3.T
+	router routers.Router
+
+	validateResponses bool
+}
+
+// NewValidator creates new validator of the OpenAPI document.
+// Responses have to be buffered to be validated, so it should be enabled only in tests.
+func NewValidator(spec []byte, validateResponses bool) (*Validator, error) {
+	loader := openapi3.NewLoader()
+
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: load document: %w", err)
+	}
+
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("openapi: validate document: %w", err)
+	}
+
+	// The API is served on any host, so the servers of the document are not matched
+	doc.Servers = nil
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: create router: %w", err)
+	}
+
+	return &Validator{
+		doc:               doc,
+		router:            router,
+		validateResponses: validateResponses,
+	}, nil
+}
+
+// Document returns the OpenAPI document.
+func (v *Validator) Document() *openapi3.T {
+	return v.doc
+}
+
+// Middleware validates requests of the routes described by the document.
+// Authentication is done by other middleware, so security requirements are not checked.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := v.router.FindRoute(r)
+		if err != nil {
+			// Routes missing from the document are let through
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			problem.Write(w, r, requestProblem(err))
+			return
+		}
+
+		if !v.validateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := newResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 recorder.status,
+			Header:                 recorder.header,
+			Body:                   io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+			Options: &openapi3filter.Options{
+				MultiError:            true,
+				IncludeResponseStatus: true,
+			},
+		})
+		if err != nil {
+			problem.Write(w, r, problem.Internal(msgResponseValidation, err))
+			return
+		}
+
+		recorder.writeTo(w)
+	})
+}
+
+// requestProblem creates problem of the request, which doesn't match the document.
+func requestProblem(err error) *problem.Problem {
+	fieldErrs, ok := fieldErrors(err)
+	if !ok {
+		return problem.New(http.StatusBadRequest, problem.CodeMalformedRequest, "Request body is malformed")
+	}
+
+	// A value can break several keywords of a schema, the first error of a field is enough
+	seen := make(map[string]bool, len(fieldErrs))
+	unique := fieldErrs[:0]
+	for _, fieldErr := range fieldErrs {
+		if !seen[fieldErr.Field] {
+			seen[fieldErr.Field] = true
+			unique = append(unique, fieldErr)
+		}
+	}
+
+	return problem.Invalid(unique...)
+}
+
+// fieldErrors converts request validation errors to field errors.
+// It fails, if the request body cannot be decoded, so there are no fields.
+func fieldErrors(err error) ([]problem.FieldError, bool) {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var fieldErrs []problem.FieldError
+		for _, me := range e {
+			errs, ok := fieldErrors(me)
+			if !ok {
+				return nil, false
+			}
+			fieldErrs = append(fieldErrs, errs...)
+		}
+		return fieldErrs, true
+	case *openapi3filter.RequestError:
+		if e.Parameter != nil {
+			return []problem.FieldError{{Field: e.Parameter.Name, Message: parameterMessage(e)}}, true
+		}
+		return fieldErrors(e.Err)
+	case *openapi3.SchemaError:
+		return []problem.FieldError{schemaFieldError(e)}, true
+	}
+
+	return nil, false
+}
+
+// schemaFieldError converts schema error of the request body to field error.
+func schemaFieldError(err *openapi3.SchemaError) problem.FieldError {
+	field := strings.Join(err.JSONPointer(), ".")
+	if field == "" {
+		field = "body"
+	}
+
+	message := err.Reason
+	if err.SchemaField == "required" || (err.SchemaField == "minLength" && err.Value == "") {
+		message = "is a required field"
+	}
+
+	return problem.FieldError{Field: field, Message: message}
+}
+
+// parameterMessage returns message of the parameter error.
+func parameterMessage(err *openapi3filter.RequestError) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err.Err, &schemaErr) {
+		return schemaErr.Reason
+	}
+	if err.Reason != "" {
+		return err.Reason
+	}
+
+	return "has invalid value"
+}
+
+// responseRecorder buffers the response, so it can be validated before sending.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		header: w.Header().Clone(),
+		status: http.StatusOK,
+	}
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	return rr.body.Write(b)
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+}
+
+// writeTo writes the buffered response.
+func (rr *responseRecorder) writeTo(w http.ResponseWriter) {
+	for key, values := range rr.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(rr.status)
+	_, _ = w.Write(rr.body.Bytes())
+}
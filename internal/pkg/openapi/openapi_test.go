@@ -0,0 +1,156 @@
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	openapispec "github.com/RomanAgaltsev/ya_gophermart/api"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/openapi"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const contentTypeJSON = "application/json"
+
+var _ = Describe("Validator", func() {
+	var (
+		validator *openapi.Validator
+
+		handled bool
+		handler http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		var err error
+		validator, err = openapi.NewValidator(openapispec.Spec, true)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		handled = false
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			handled = true
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+
+		recorder := httptest.NewRecorder()
+		validator.Middleware(handler).ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	decode := func(recorder *httptest.ResponseRecorder) problem.Problem {
+		var details problem.Problem
+		Expect(json.NewDecoder(recorder.Body).Decode(&details)).To(Succeed())
+		return details
+	}
+
+	It("fails on an invalid document", func() {
+		_, err := openapi.NewValidator([]byte("openapi: 3.0.3\npaths: {}"), false)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("lets valid requests through with the body", func() {
+		body, err := json.Marshal(model.User{Login: "user", Password: "password"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			handled = true
+			received, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(received).To(MatchJSON(body))
+			w.WriteHeader(http.StatusOK)
+		}
+
+		recorder := serve(http.MethodPost, "/api/user/register", contentTypeJSON, string(body))
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(handled).To(BeTrue())
+	})
+
+	It("lets routes missing from the document through", func() {
+		recorder := serve(http.MethodGet, "/api/unknown", "", "")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(handled).To(BeTrue())
+	})
+
+	It("returns all invalid fields of the request body", func() {
+		recorder := serve(http.MethodPost, "/api/user/balance/transfer", contentTypeJSON, `{"sum": -1}`)
+
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(handled).To(BeFalse())
+
+		details := decode(recorder)
+		Expect(details.Code).To(Equal(problem.CodeValidationFailed))
+		Expect(details.Errors).To(HaveLen(2))
+		Expect(details.Errors).To(ContainElement(problem.FieldError{Field: "to", Message: "is a required field"}))
+		Expect(details.Errors).To(ContainElement(HaveField("Field", "sum")))
+	})
+
+	It("returns invalid query parameters", func() {
+		recorder := serve(http.MethodGet, "/api/user/statement?format=xml", "", "")
+
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(handled).To(BeFalse())
+
+		details := decode(recorder)
+		Expect(details.Code).To(Equal(problem.CodeValidationFailed))
+		Expect(details.Errors).To(ConsistOf(HaveField("Field", "format")))
+	})
+
+	It("reports malformed request bodies", func() {
+		recorder := serve(http.MethodPost, "/api/user/login", contentTypeJSON, `{"login": `)
+
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(handled).To(BeFalse())
+		Expect(decode(recorder).Code).To(Equal(problem.CodeMalformedRequest))
+	})
+
+	It("lets valid responses through", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentTypeJSON)
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(model.Balance{Current: 500.5, Withdrawn: 42})
+		}
+
+		recorder := serve(http.MethodGet, "/api/user/balance", "", "")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"current": 500.5, "withdrawn": 42}`))
+	})
+
+	It("replaces responses, which don't match the document", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentTypeJSON)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"current": "500.5"}`))
+		}
+
+		recorder := serve(http.MethodGet, "/api/user/balance", "", "")
+
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(decode(recorder).Code).To(Equal(problem.CodeInternal))
+	})
+
+	It("replaces responses with statuses missing from the document", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}
+
+		recorder := serve(http.MethodGet, "/api/user/balance", "", "")
+
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+	})
+})
@@ -0,0 +1,117 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/logger"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+)
+
+// ContentType is the media type of problem details (RFC 7807).
+const ContentType = "application/problem+json"
+
+// Code is a stable machine-readable error code, clients should rely on it instead of messages.
+type Code string
+
+// Common error codes.
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeMalformedRequest Code = "malformed_request"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeInternal         Code = "internal_error"
+)
+
+// Common problems.
+var (
+	ErrUnauthorized     = New(http.StatusUnauthorized, CodeUnauthorized, "Absent or invalid authentication")
+	ErrNotFound         = New(http.StatusNotFound, CodeNotFound, "Resource not found")
+	ErrMethodNotAllowed = New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+)
+
+// FieldError is a validation error of a request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is a problem details response.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+
+	// Internal error is logged, but never sent to clients
+	err error
+	msg string
+}
+
+// New creates new problem with the status, code and a message for clients.
+func New(status int, code Code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// BadRequest creates new problem of a wrong request parameter, the error is shown to clients.
+func BadRequest(err error) *Problem {
+	return New(http.StatusBadRequest, CodeBadRequest, err.Error())
+}
+
+// Validation creates new problem of a request body, which cannot be bound.
+// Field errors are returned in details, other errors mean the body is malformed.
+func Validation(err error) *Problem {
+	var validationErr *model.ValidationError
+	if !errors.As(err, &validationErr) {
+		return New(http.StatusBadRequest, CodeMalformedRequest, "Request body is malformed")
+	}
+
+	return Invalid(FieldError{Field: validationErr.Field, Message: validationErr.Message})
+}
+
+// Invalid creates new problem of a request, which fields are invalid.
+func Invalid(errs ...FieldError) *Problem {
+	p := New(http.StatusBadRequest, CodeValidationFailed, "Request validation failed")
+	p.Errors = errs
+
+	return p
+}
+
+// Internal creates new problem of an internal error.
+// Clients get a generic message, the error is logged with the message.
+func Internal(msg string, err error) *Problem {
+	p := New(http.StatusInternalServerError, CodeInternal, "Internal server error")
+	p.err = err
+	p.msg = msg
+
+	return p
+}
+
+// Write writes the problem to the response with the request ID and path.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	// Problems are shared, so the copy gets request details
+	resp := *p
+	resp.Instance = r.URL.Path
+	resp.RequestID = logger.RequestIDFromContext(r.Context())
+
+	if resp.err != nil {
+		logger.FromContext(r.Context()).Error(resp.msg, "error", resp.err.Error())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(resp.Status)
+	_ = json.NewEncoder(w).Encode(&resp)
+}
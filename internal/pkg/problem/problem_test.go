@@ -0,0 +1,79 @@
+package problem_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/problem"
+
+	"github.com/go-chi/chi/v5/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Problem", func() {
+	write := func(p *problem.Problem) (*httptest.ResponseRecorder, problem.Problem) {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+		request.Header.Set(middleware.RequestIDHeader, "request-1")
+
+		recorder := httptest.NewRecorder()
+		middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			problem.Write(w, r, p)
+		})).ServeHTTP(recorder, request)
+
+		var written problem.Problem
+		Expect(json.NewDecoder(recorder.Body).Decode(&written)).To(Succeed())
+
+		return recorder, written
+	}
+
+	It("writes problem details with the request ID and path", func() {
+		recorder, written := write(problem.ErrNotFound)
+
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal(problem.ContentType))
+		Expect(written.Status).To(Equal(http.StatusNotFound))
+		Expect(written.Title).To(Equal("Not Found"))
+		Expect(written.Code).To(Equal(problem.CodeNotFound))
+		Expect(written.Instance).To(Equal("/api/user/orders"))
+		Expect(written.RequestID).To(Equal("request-1"))
+	})
+
+	It("doesn't change shared problems", func() {
+		_, _ = write(problem.ErrNotFound)
+
+		Expect(problem.ErrNotFound.RequestID).To(BeEmpty())
+		Expect(problem.ErrNotFound.Instance).To(BeEmpty())
+	})
+
+	It("hides internal errors from clients", func() {
+		recorder, written := write(problem.Internal("order upload", errors.New("pgx: connection refused")))
+
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(written.Code).To(Equal(problem.CodeInternal))
+		Expect(recorder.Body.String()).NotTo(ContainSubstring("pgx"))
+		Expect(written.Detail).NotTo(ContainSubstring("pgx"))
+	})
+
+	It("returns field details of validation errors", func() {
+		err := fmt.Errorf("bind: %w", &model.ValidationError{Field: "login", Message: "is a required field"})
+
+		recorder, written := write(problem.Validation(err))
+
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(written.Code).To(Equal(problem.CodeValidationFailed))
+		Expect(written.Errors).To(ConsistOf(problem.FieldError{Field: "login", Message: "is a required field"}))
+	})
+
+	It("reports malformed request bodies without field details", func() {
+		recorder, written := write(problem.Validation(errors.New("unexpected EOF")))
+
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(written.Code).To(Equal(problem.CodeMalformedRequest))
+		Expect(written.Errors).To(BeEmpty())
+	})
+})
@@ -0,0 +1,68 @@
+package promotion
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+)
+
+// Facts are the facts about a processed order, which promotion rules are evaluated against.
+type Facts struct {
+	OrderNumber    string
+	UploadedAt     time.Time // Order time
+	Accrual        float64   // Accrual credited for the order
+	PreviousOrders int64     // Number of processed orders the user has had before the order
+	Tier           string    // Loyalty tier of the user
+}
+
+// Matches checks if the order meets all set conditions of the rule.
+func Matches(rule *model.PromotionRule, facts *Facts) bool {
+	if rule.Disabled {
+		return false
+	}
+	if rule.StartsAt != nil && facts.UploadedAt.Before(*rule.StartsAt) {
+		return false
+	}
+	if rule.EndsAt != nil && !facts.UploadedAt.Before(*rule.EndsAt) {
+		return false
+	}
+	if len(rule.Weekdays) > 0 && !slices.Contains(rule.Weekdays, facts.UploadedAt.Weekday()) {
+		return false
+	}
+	if rule.FirstOrder && facts.PreviousOrders > 0 {
+		return false
+	}
+	if facts.PreviousOrders < int64(rule.MinOrders) {
+		return false
+	}
+	if rule.Tier != "" && rule.Tier != facts.Tier {
+		return false
+	}
+
+	return true
+}
+
+// Evaluate returns bonuses given for the order by the matching rules, rounded to hundredths.
+func Evaluate(rules model.PromotionRules, facts *Facts) model.Bonuses {
+	var bonuses model.Bonuses
+	for _, rule := range rules {
+		if !Matches(rule, facts) {
+			continue
+		}
+
+		amount := math.Round((facts.Accrual*rule.BonusRate+rule.BonusPoints)*100) / 100
+		if amount <= 0 {
+			continue
+		}
+
+		bonuses = append(bonuses, &model.Bonus{
+			RuleID:      rule.ID,
+			OrderNumber: facts.OrderNumber,
+			Amount:      amount,
+		})
+	}
+
+	return bonuses
+}
@@ -0,0 +1,74 @@
+package promotion_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/model"
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/promotion"
+)
+
+var _ = Describe("Promotion", func() {
+	// Saturday
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	// Monday
+	monday := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	weekends := &model.PromotionRule{ID: 1, Name: "double points on weekends", Weekdays: []time.Weekday{time.Saturday, time.Sunday}, BonusRate: 1}
+	firstOrder := &model.PromotionRule{ID: 2, Name: "+100 points on first order", FirstOrder: true, BonusPoints: 100}
+	goldTier := &model.PromotionRule{ID: 3, Name: "gold bonus", Tier: "gold", BonusRate: 0.1}
+	loyal := &model.PromotionRule{ID: 4, Name: "tenth order", MinOrders: 9, BonusPoints: 50}
+	october := &model.PromotionRule{
+		ID:          5,
+		Name:        "october",
+		StartsAt:    ptr(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)),
+		EndsAt:      ptr(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)),
+		BonusPoints: 10,
+	}
+	disabled := &model.PromotionRule{ID: 6, Name: "disabled", Disabled: true, BonusPoints: 1000}
+
+	DescribeTable("Checking if an order meets the rule conditions",
+		func(rule *model.PromotionRule, facts promotion.Facts, expected bool) {
+			Expect(promotion.Matches(rule, &facts)).To(Equal(expected))
+		},
+
+		Entry("weekend order on weekends", weekends, promotion.Facts{UploadedAt: saturday}, true),
+		Entry("weekday order on weekends", weekends, promotion.Facts{UploadedAt: monday}, false),
+		Entry("first order", firstOrder, promotion.Facts{UploadedAt: monday}, true),
+		Entry("second order", firstOrder, promotion.Facts{UploadedAt: monday, PreviousOrders: 1}, false),
+		Entry("gold user", goldTier, promotion.Facts{UploadedAt: monday, Tier: "gold"}, true),
+		Entry("silver user", goldTier, promotion.Facts{UploadedAt: monday, Tier: "silver"}, false),
+		Entry("tenth order", loyal, promotion.Facts{UploadedAt: monday, PreviousOrders: 9}, true),
+		Entry("ninth order", loyal, promotion.Facts{UploadedAt: monday, PreviousOrders: 8}, false),
+		Entry("order within the period", october, promotion.Facts{UploadedAt: saturday}, true),
+		Entry("order after the period", october, promotion.Facts{UploadedAt: monday}, false),
+		Entry("disabled rule", disabled, promotion.Facts{UploadedAt: monday}, false),
+	)
+
+	It("gives bonuses of all matching rules", func() {
+		rules := model.PromotionRules{weekends, firstOrder, goldTier, disabled}
+
+		bonuses := promotion.Evaluate(rules, &promotion.Facts{
+			OrderNumber: "12345678903",
+			UploadedAt:  saturday,
+			Accrual:     123.45,
+		})
+
+		Expect(bonuses).To(Equal(model.Bonuses{
+			{RuleID: 1, OrderNumber: "12345678903", Amount: 123.45},
+			{RuleID: 2, OrderNumber: "12345678903", Amount: 100},
+		}))
+	})
+
+	It("gives no bonus for zero amount", func() {
+		bonuses := promotion.Evaluate(model.PromotionRules{weekends}, &promotion.Facts{UploadedAt: saturday})
+
+		Expect(bonuses).To(BeEmpty())
+	})
+})
+
+func ptr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,48 @@
+package timezone
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	// The time zone database is embedded, so zones are known even if the system has no tzdata
+	_ "time/tzdata"
+)
+
+// QueryParameter is the request query parameter with the time zone, which times are rendered in.
+const QueryParameter = "tz"
+
+// ErrUnknownTimezone - error of a time zone, which is not in the time zone database.
+var ErrUnknownTimezone = fmt.Errorf("unknown time zone")
+
+// Load returns the location of the IANA time zone name, the empty name is UTC.
+// The local time zone of the server is not accepted, because clients don't know it.
+func Load(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTimezone, name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTimezone, name)
+	}
+
+	return loc, nil
+}
+
+// locationContextKey is the context key of the location.
+type locationContextKey struct{}
+
+// WithLocation returns a copy of the context with the location, which times are rendered in.
+func WithLocation(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, locationContextKey{}, loc)
+}
+
+// FromContext returns the location, which times are rendered in, from the context.
+// Times are rendered in UTC, if neither the request nor the user has chosen a time zone.
+func FromContext(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(locationContextKey{}).(*time.Location); ok && loc != nil {
+		return loc
+	}
+	return time.UTC
+}
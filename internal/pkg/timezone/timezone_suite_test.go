@@ -0,0 +1,13 @@
+package timezone_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTimezone(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Timezone Suite")
+}
@@ -0,0 +1,47 @@
+package timezone_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/RomanAgaltsev/ya_gophermart/internal/pkg/timezone"
+)
+
+var _ = Describe("Timezone", func() {
+	DescribeTable("loads known time zones",
+		func(name string, expected string) {
+			loc, err := timezone.Load(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loc.String()).To(Equal(expected))
+		},
+
+		Entry("empty name", "", "UTC"),
+		Entry("UTC", "UTC", "UTC"),
+		Entry("IANA name", "Europe/Moscow", "Europe/Moscow"),
+	)
+
+	DescribeTable("rejects unknown time zones",
+		func(name string) {
+			_, err := timezone.Load(name)
+			Expect(err).To(MatchError(timezone.ErrUnknownTimezone))
+		},
+
+		Entry("server local zone", "Local"),
+		Entry("misspelled name", "Europe/Moskow"),
+		Entry("offset", "+03:00"),
+	)
+
+	It("renders times in UTC by default", func() {
+		Expect(timezone.FromContext(context.Background())).To(Equal(time.UTC))
+	})
+
+	It("renders times in the location from the context", func() {
+		loc, err := timezone.Load("Asia/Tokyo")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(timezone.FromContext(timezone.WithLocation(context.Background(), loc))).To(Equal(loc))
+	})
+})
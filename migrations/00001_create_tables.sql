@@ -43,6 +43,6 @@ CREATE TABLE balance
 DROP TABLE balance;
 DROP TABLE withdrawals;
 DROP TABLE orders;
-DROP TYPE order_status;
+DROP TYPE order_status_type;
 DROP TABLE users;
 -- +goose StatementEnd
\ No newline at end of file
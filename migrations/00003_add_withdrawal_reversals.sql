@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE withdrawals
+    ADD COLUMN reversed_at     TIMESTAMP,
+    ADD COLUMN reversal_reason VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE TABLE reversals
+(
+    id            SERIAL PRIMARY KEY,
+    withdrawal_id INTEGER          NOT NULL REFERENCES withdrawals (id),
+    login         VARCHAR(20)      NOT NULL,
+    order_number  VARCHAR(100)     NOT NULL,
+    sum           DOUBLE PRECISION NOT NULL,
+    reason        VARCHAR(255)     NOT NULL,
+    reversed_at   TIMESTAMP        NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE reversals;
+
+ALTER TABLE withdrawals
+    DROP COLUMN reversal_reason,
+    DROP COLUMN reversed_at;
+-- +goose StatementEnd
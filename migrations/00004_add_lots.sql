@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE lots
+(
+    id           SERIAL PRIMARY KEY,
+    login        VARCHAR(20)      NOT NULL,
+    order_number VARCHAR(100)     NOT NULL,
+    amount       DOUBLE PRECISION NOT NULL,
+    remaining    DOUBLE PRECISION NOT NULL,
+    accrued_at   TIMESTAMP        NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMP
+);
+
+CREATE TABLE expirations
+(
+    id         SERIAL PRIMARY KEY,
+    lot_id     INTEGER          NOT NULL REFERENCES lots (id),
+    login      VARCHAR(20)      NOT NULL,
+    amount     DOUBLE PRECISION NOT NULL,
+    expired_at TIMESTAMP        NOT NULL
+);
+
+-- Points accrued before lots tracking never expire
+INSERT INTO lots (login, order_number, amount, remaining)
+SELECT login, '', accrued - withdrawn, accrued - withdrawn
+FROM balance
+WHERE accrued - withdrawn > 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE expirations;
+DROP TABLE lots;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE tier_history
+(
+    id         SERIAL PRIMARY KEY,
+    login      VARCHAR(20)      NOT NULL,
+    tier       VARCHAR(50)      NOT NULL,
+    total      DOUBLE PRECISION NOT NULL,
+    changed_at TIMESTAMP        NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE tier_history;
+-- +goose StatementEnd
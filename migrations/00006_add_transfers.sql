@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE transfers
+(
+    id             SERIAL PRIMARY KEY,
+    sender         VARCHAR(20)      NOT NULL,
+    recipient      VARCHAR(20)      NOT NULL,
+    sum            DOUBLE PRECISION NOT NULL,
+    transferred_at TIMESTAMP        NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE transfers;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE promotion_rules
+(
+    id           SERIAL PRIMARY KEY,
+    name         VARCHAR(100)     NOT NULL,
+    disabled     BOOLEAN          NOT NULL DEFAULT FALSE,
+    starts_at    TIMESTAMP,
+    ends_at      TIMESTAMP,
+    weekdays     INTEGER[]        NOT NULL DEFAULT '{}',
+    first_order  BOOLEAN          NOT NULL DEFAULT FALSE,
+    min_orders   INTEGER          NOT NULL DEFAULT 0,
+    tier         VARCHAR(50)      NOT NULL DEFAULT '',
+    bonus_rate   DOUBLE PRECISION NOT NULL DEFAULT 0,
+    bonus_points DOUBLE PRECISION NOT NULL DEFAULT 0,
+    created_at   TIMESTAMP        NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE bonuses
+(
+    id           SERIAL PRIMARY KEY,
+    login        VARCHAR(20)      NOT NULL,
+    order_number VARCHAR(100)     NOT NULL,
+    rule_id      INTEGER          NOT NULL REFERENCES promotion_rules (id),
+    amount       DOUBLE PRECISION NOT NULL,
+    created_at   TIMESTAMP        NOT NULL DEFAULT NOW(),
+    UNIQUE (order_number, rule_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE bonuses;
+DROP TABLE promotion_rules;
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE audit_log
+(
+    id         BIGSERIAL PRIMARY KEY,
+    login      VARCHAR(20)  NOT NULL,
+    action     VARCHAR(50)  NOT NULL,
+    old_value  JSON,
+    new_value  JSON,
+    request_id VARCHAR(100) NOT NULL DEFAULT '',
+    ip         VARCHAR(45)  NOT NULL DEFAULT '',
+    created_at TIMESTAMP    NOT NULL,
+    prev_hash  CHAR(64)     NOT NULL,
+    hash       CHAR(64)     NOT NULL UNIQUE
+);
+
+CREATE INDEX audit_log_login_idx ON audit_log (login, id);
+
+CREATE FUNCTION audit_log_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_delete
+    BEFORE UPDATE OR DELETE
+    ON audit_log
+    FOR EACH ROW
+EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE
+    ON audit_log
+    FOR EACH STATEMENT
+EXECUTE FUNCTION audit_log_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE audit_log;
+DROP FUNCTION audit_log_append_only();
+-- +goose StatementEnd
@@ -0,0 +1,68 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The constraints cannot be added to the violating rows, they are reported and repaired by "gophermartctl migrate check"
+DO
+$$
+BEGIN
+    IF EXISTS (SELECT login FROM balance GROUP BY login HAVING COUNT(*) > 1) THEN
+        RAISE EXCEPTION 'balance has duplicate logins'
+            USING HINT = 'run "gophermartctl migrate check -repair" before the migration';
+    END IF;
+
+    IF EXISTS (SELECT login FROM orders WHERE login NOT IN (SELECT login FROM users)
+               UNION ALL
+               SELECT login FROM withdrawals WHERE login NOT IN (SELECT login FROM users)
+               UNION ALL
+               SELECT login FROM balance WHERE login NOT IN (SELECT login FROM users)) THEN
+        RAISE EXCEPTION 'orders, withdrawals or balance have logins of unknown users'
+            USING HINT = 'run "gophermartctl migrate check -repair" before the migration';
+    END IF;
+END;
+$$;
+
+ALTER TABLE balance
+    ADD CONSTRAINT balance_login_key UNIQUE (login);
+
+-- The checks are deferred to the end of transaction,
+-- because users are anonymized and deleted by a single statement together with their data
+ALTER TABLE orders
+    ADD CONSTRAINT orders_login_fkey FOREIGN KEY (login) REFERENCES users (login) DEFERRABLE INITIALLY DEFERRED;
+ALTER TABLE withdrawals
+    ADD CONSTRAINT withdrawals_login_fkey FOREIGN KEY (login) REFERENCES users (login) DEFERRABLE INITIALLY DEFERRED;
+ALTER TABLE balance
+    ADD CONSTRAINT balance_login_fkey FOREIGN KEY (login) REFERENCES users (login) DEFERRABLE INITIALLY DEFERRED;
+
+-- Orders to process are a small part of all orders
+CREATE INDEX orders_pending_idx ON orders (uploaded_at) WHERE status = 'NEW' OR status = 'PROCESSING';
+
+-- Per-user listings
+CREATE INDEX orders_login_uploaded_at_idx ON orders (login, uploaded_at DESC);
+CREATE INDEX withdrawals_login_processed_at_idx ON withdrawals (login, processed_at DESC);
+CREATE INDEX withdrawals_order_number_processed_at_idx ON withdrawals (order_number, processed_at DESC);
+CREATE INDEX lots_login_accrued_at_idx ON lots (login, accrued_at, id) WHERE remaining > 0;
+CREATE INDEX tier_history_login_changed_at_idx ON tier_history (login, changed_at DESC, id DESC);
+CREATE INDEX transfers_sender_transferred_at_idx ON transfers (sender, transferred_at DESC, id DESC);
+CREATE INDEX transfers_recipient_transferred_at_idx ON transfers (recipient, transferred_at DESC, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX transfers_recipient_transferred_at_idx;
+DROP INDEX transfers_sender_transferred_at_idx;
+DROP INDEX tier_history_login_changed_at_idx;
+DROP INDEX lots_login_accrued_at_idx;
+DROP INDEX withdrawals_order_number_processed_at_idx;
+DROP INDEX withdrawals_login_processed_at_idx;
+DROP INDEX orders_login_uploaded_at_idx;
+DROP INDEX orders_pending_idx;
+
+ALTER TABLE balance
+    DROP CONSTRAINT balance_login_fkey;
+ALTER TABLE withdrawals
+    DROP CONSTRAINT withdrawals_login_fkey;
+ALTER TABLE orders
+    DROP CONSTRAINT orders_login_fkey;
+
+ALTER TABLE balance
+    DROP CONSTRAINT balance_login_key;
+-- +goose StatementEnd
@@ -0,0 +1,71 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Times written with NOW() are in the time zone of the database session, the migration session connects the same way
+-- as the service. Expiry times of lots and periods of promotions are written by the service in its own time zone,
+-- which is passed to the migration session by the service. Audit log entries have always been created in UTC.
+ALTER TABLE users
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE orders
+    ALTER COLUMN uploaded_at TYPE TIMESTAMPTZ USING uploaded_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE withdrawals
+    ALTER COLUMN processed_at TYPE TIMESTAMPTZ USING processed_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN reversed_at TYPE TIMESTAMPTZ USING reversed_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE reversals
+    ALTER COLUMN reversed_at TYPE TIMESTAMPTZ USING reversed_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE lots
+    ALTER COLUMN accrued_at TYPE TIMESTAMPTZ USING accrued_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone'));
+-- Expiration times are copied from the lots
+ALTER TABLE expirations
+    ALTER COLUMN expired_at TYPE TIMESTAMPTZ USING expired_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone'));
+ALTER TABLE tier_history
+    ALTER COLUMN changed_at TYPE TIMESTAMPTZ USING changed_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE transfers
+    ALTER COLUMN transferred_at TYPE TIMESTAMPTZ USING transferred_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE promotion_rules
+    ALTER COLUMN starts_at TYPE TIMESTAMPTZ USING starts_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone')),
+    ALTER COLUMN ends_at TYPE TIMESTAMPTZ USING ends_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone')),
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE bonuses
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE audit_log
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE audit_log
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC';
+ALTER TABLE bonuses
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE promotion_rules
+    ALTER COLUMN starts_at TYPE TIMESTAMP USING starts_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone')),
+    ALTER COLUMN ends_at TYPE TIMESTAMP USING ends_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone')),
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE transfers
+    ALTER COLUMN transferred_at TYPE TIMESTAMP USING transferred_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE tier_history
+    ALTER COLUMN changed_at TYPE TIMESTAMP USING changed_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE expirations
+    ALTER COLUMN expired_at TYPE TIMESTAMP USING expired_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone'));
+ALTER TABLE lots
+    ALTER COLUMN accrued_at TYPE TIMESTAMP USING accrued_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE
+        COALESCE(NULLIF(current_setting('gophermart.service_time_zone', TRUE), ''), current_setting('TimeZone'));
+ALTER TABLE reversals
+    ALTER COLUMN reversed_at TYPE TIMESTAMP USING reversed_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE withdrawals
+    ALTER COLUMN processed_at TYPE TIMESTAMP USING processed_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN reversed_at TYPE TIMESTAMP USING reversed_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE orders
+    ALTER COLUMN uploaded_at TYPE TIMESTAMP USING uploaded_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE users
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone');
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Changes queue their audit entries without locking the audit log,
+-- the entries are appended to the hash chain by a single writer.
+CREATE TABLE audit_queue
+(
+    id         BIGSERIAL PRIMARY KEY,
+    login      VARCHAR(20)  NOT NULL,
+    action     VARCHAR(50)  NOT NULL,
+    old_value  JSON,
+    new_value  JSON,
+    request_id VARCHAR(100) NOT NULL DEFAULT '',
+    ip         VARCHAR(45)  NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ  NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE audit_queue;
+-- +goose StatementEnd